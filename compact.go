@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// compact merges consecutive record sets smaller than minRecs received from
+// in, so that sparse record sets, such as the ones left after filtering, do
+// not end up as a multitude of tiny insert requests. Record sets that are
+// large enough are passed through as is. The records accumulated at the end
+// of the stream are sent even if there are fewer than minRecs of them.
+func compact(ctx context.Context, in <-chan []era5.Record, minRecs int) <-chan []era5.Record {
+	out := make(chan []era5.Record)
+	go func() {
+		defer close(out)
+		var pending []era5.Record
+		send := func(recs []era5.Record) bool {
+			select {
+			case out <- recs:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for recs := range in {
+			if len(pending) == 0 && len(recs) >= minRecs {
+				if !send(recs) {
+					return
+				}
+				continue
+			}
+			pending = append(pending, recs...)
+			if len(pending) >= minRecs {
+				if !send(pending) {
+					return
+				}
+				pending = nil
+			}
+		}
+		if len(pending) > 0 {
+			send(pending)
+		}
+	}()
+	return out
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/rtm0/era5/internal/vm"
+)
+
+// city is a location that gets its own series in the generated dashboards.
+type city struct {
+	name string
+	la   float64
+	lo   float64
+}
+
+var dashboardCities = []city{
+	{"Berlin", 52.52, 13.40},
+	{"London", 51.51, -0.13},
+	{"Madrid", 40.42, -3.70},
+	{"Moscow", 55.76, 37.62},
+	{"New York", 40.71, -74.01},
+	{"Sao Paulo", -23.55, -46.63},
+	{"Cairo", 30.04, 31.24},
+	{"Mumbai", 19.08, 72.88},
+	{"Beijing", 39.90, 116.41},
+	{"Tokyo", 35.68, 139.69},
+	{"Sydney", -33.87, 151.21},
+}
+
+// runDashboards implements the dashboards command that writes Grafana
+// dashboards for the exported metrics into a directory.
+func runDashboards(args []string) error {
+	fs := flag.NewFlagSet("dashboards", flag.ExitOnError)
+	metricPrefix := fs.String("metricPrefix", "era5", "the metric prefix the data has been exported with")
+	outDir := fs.String("o", "dashboards", "directory to write the dashboard JSON files to")
+	gridStep := fs.Float64("gridStep", 0.25, "step of the exported latitude/longitude grid in degrees. City coordinates are snapped to it")
+	lon360 := fs.Bool("lon360", true, "whether the exported longitudes are in the [0, 360) range as in the ERA5 files from CDS. Otherwise [-180, 180) is assumed")
+	fs.Parse(args)
+
+	if err := vm.CheckMetricPrefix(*metricPrefix); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		return err
+	}
+	g := &dashboardGen{prefix: *metricPrefix, gridStep: *gridStep, lon360: *lon360}
+	dashboards := map[string]map[string]any{
+		"era5-overview.json":      g.overview(),
+		"era5-cities.json":        g.cities(),
+		"era5-precipitation.json": g.precipitation(),
+	}
+	for name, d := range dashboards {
+		data, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(*outDir, name), append(data, '\n'), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dashboardGen builds Grafana dashboards as JSON-ready maps.
+type dashboardGen struct {
+	prefix   string
+	gridStep float64
+	lon360   bool
+}
+
+func (g *dashboardGen) metric(varName string) string {
+	return g.prefix + "_" + varName
+}
+
+// selector returns the series selector of the variable at the grid point
+// closest to the city.
+func (g *dashboardGen) selector(varName string, c city) string {
+	la := math.Round(c.la/g.gridStep) * g.gridStep
+	lo := math.Round(c.lo/g.gridStep) * g.gridStep
+	if g.lon360 && lo < 0 {
+		lo += 360
+	}
+	return fmt.Sprintf(`%s{la=%q,lo=%q}`, g.metric(varName), vm.FormatCoord(float32(la)), vm.FormatCoord(float32(lo)))
+}
+
+func (g *dashboardGen) overview() map[string]any {
+	return dashboard("era5-overview", "ERA5 overview", []map[string]any{
+		geomapPanel(1, "2m temperature", g.metric("t2m"), gridPos(0, 0, 12, 14)),
+		geomapPanel(2, "Total precipitation", g.metric("tp"), gridPos(12, 0, 12, 14)),
+		geomapPanel(3, "Total cloud cover", g.metric("tcc"), gridPos(0, 14, 12, 14)),
+		geomapPanel(4, "10m zonal wind", g.metric("u10"), gridPos(12, 14, 12, 14)),
+	})
+}
+
+func (g *dashboardGen) cities() map[string]any {
+	var t2m, tp []map[string]any
+	for i, c := range dashboardCities {
+		t2m = append(t2m, target(refID(i), g.selector("t2m", c), c.name))
+		tp = append(tp, target(refID(i), g.selector("tp", c), c.name))
+	}
+	return dashboard("era5-cities", "ERA5 cities", []map[string]any{
+		timeSeriesPanel(1, "2m temperature", t2m, gridPos(0, 0, 24, 12)),
+		timeSeriesPanel(2, "Total precipitation", tp, gridPos(0, 12, 24, 12)),
+	})
+}
+
+func (g *dashboardGen) precipitation() map[string]any {
+	tp := g.metric("tp")
+	sf := g.metric("sf")
+	return dashboard("era5-precipitation", "ERA5 precipitation", []map[string]any{
+		heatmapPanel(1, "Total precipitation distribution", tp, true, gridPos(0, 0, 24, 12)),
+		heatmapPanel(2, "Total precipitation by latitude", fmt.Sprintf("avg by (la) (%s)", tp), false, gridPos(0, 12, 24, 12)),
+		heatmapPanel(3, "Snowfall distribution", sf, true, gridPos(0, 24, 24, 12)),
+	})
+}
+
+func dashboard(uid, title string, panels []map[string]any) map[string]any {
+	return map[string]any{
+		"uid":           uid,
+		"title":         title,
+		"tags":          []string{"era5"},
+		"timezone":      "utc",
+		"schemaVersion": 39,
+		"editable":      true,
+		"time":          map[string]any{"from": "now-30d", "to": "now"},
+		"templating": map[string]any{
+			"list": []map[string]any{{
+				"name":  "datasource",
+				"label": "Data source",
+				"type":  "datasource",
+				"query": "prometheus",
+			}},
+		},
+		"panels": panels,
+	}
+}
+
+var datasource = map[string]any{"type": "prometheus", "uid": "${datasource}"}
+
+func gridPos(x, y, w, h int) map[string]any {
+	return map[string]any{"x": x, "y": y, "w": w, "h": h}
+}
+
+func refID(i int) string {
+	return string(rune('A' + i))
+}
+
+func target(refID, expr, legend string) map[string]any {
+	return map[string]any{
+		"refId":        refID,
+		"datasource":   datasource,
+		"expr":         expr,
+		"legendFormat": legend,
+	}
+}
+
+// geomapPanel shows the latest values of a metric at the grid points they
+// belong to.
+func geomapPanel(id int, title, expr string, pos map[string]any) map[string]any {
+	t := target("A", expr, "")
+	t["instant"] = true
+	t["format"] = "table"
+	return map[string]any{
+		"id":         id,
+		"type":       "geomap",
+		"title":      title,
+		"datasource": datasource,
+		"gridPos":    pos,
+		"targets":    []map[string]any{t},
+		"fieldConfig": map[string]any{
+			"defaults": map[string]any{
+				"color": map[string]any{"mode": "continuous-BlYlRd"},
+			},
+		},
+		"options": map[string]any{
+			"view": map[string]any{"id": "zero", "zoom": 1},
+			"layers": []map[string]any{{
+				"type": "markers",
+				"name": title,
+				"location": map[string]any{
+					"mode":      "coords",
+					"latitude":  "la",
+					"longitude": "lo",
+				},
+				"config": map[string]any{
+					"style": map[string]any{
+						"size":  map[string]any{"fixed": 3},
+						"color": map[string]any{"field": "Value"},
+					},
+				},
+			}},
+		},
+	}
+}
+
+func timeSeriesPanel(id int, title string, targets []map[string]any, pos map[string]any) map[string]any {
+	return map[string]any{
+		"id":         id,
+		"type":       "timeseries",
+		"title":      title,
+		"datasource": datasource,
+		"gridPos":    pos,
+		"targets":    targets,
+	}
+}
+
+// heatmapPanel shows how the values of expr are distributed over time. If
+// calculate is true the buckets are calculated from the values, otherwise
+// every series is a row of the heatmap.
+func heatmapPanel(id int, title, expr string, calculate bool, pos map[string]any) map[string]any {
+	legend := ""
+	if !calculate {
+		legend = "{{la}}"
+	}
+	return map[string]any{
+		"id":         id,
+		"type":       "heatmap",
+		"title":      title,
+		"datasource": datasource,
+		"gridPos":    pos,
+		"targets":    []map[string]any{target("A", expr, legend)},
+		"options": map[string]any{
+			"calculate": calculate,
+			"color":     map[string]any{"mode": "scheme", "scheme": "Blues"},
+		},
+	}
+}
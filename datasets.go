@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// dataset holds the defaults of the flags for the files of a CDS dataset or
+// of a public copy of ERA5.
+type dataset struct {
+	// cdsName is the name of the dataset in the CDS API, empty for the
+	// copies.
+	cdsName string
+	// variables are the variables exported by default and cdsVariables are
+	// their CDS names, which the download command retrieves, in the same
+	// order.
+	variables    []string
+	cdsVariables []string
+	// accumulated are the variables accumulated since 00 UTC, which are
+	// de-accumulated by default.
+	accumulated []string
+	// missing is the default -missing policy.
+	missing string
+	// flags holds the defaults of other flags by their names, e.g. the
+	// -file of the copies published as a single store.
+	flags map[string]string
+	// monthlyFile returns the path of the file holding a variable in a
+	// month for the copies split into a file per month and variable, whose
+	// -file list is made of the files of the -variables from -from to -to
+	// unless set.
+	monthlyFile func(month time.Time, fileVar string) string
+	// needsRange tells that -from and -to must be set, since the copy holds
+	// decades of hours.
+	needsRange bool
+}
+
+// arcoStore is the Zarr store of ARCO-ERA5, the copy of ERA5 in Google
+// Cloud Storage chunked by hour, which holds the single-level and the
+// pressure-level variables on the 0.25° grid.
+const arcoStore = "gs://gcp-public-data-arco-era5/ar/full_37-1h-0p25deg-chunk-1.zarr-v3"
+
+// pdsVariables are the variables of the ERA5 files on AWS, which are named
+// by the CF standard names, and pdsNames are their ERA5 names in the same
+// order.
+var (
+	pdsVariables = []string{
+		"eastward_wind_at_10_metres",
+		"northward_wind_at_10_metres",
+		"air_temperature_at_2_metres",
+		"dew_point_temperature_at_2_metres",
+		"surface_air_pressure",
+		"air_pressure_at_mean_sea_level",
+		"precipitation_amount_1hour_Accumulation",
+		"eastward_wind_at_100_metres",
+		"northward_wind_at_100_metres",
+		"sea_surface_temperature",
+		"air_temperature_at_2_metres_1hour_Maximum",
+		"air_temperature_at_2_metres_1hour_Minimum",
+		"lwe_thickness_of_surface_snow_amount",
+	}
+	pdsNames = []string{"u10", "v10", "t2m", "d2m", "sp", "msl", "tp", "u100", "v100", "sst", "mx2t", "mn2t", "sd"}
+)
+
+// renames returns the -varMap value renaming the fileNames to the names in
+// the same order.
+func renames(fileNames, names []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fileNames[i] + "=" + name
+	}
+	return strings.Join(pairs, ",")
+}
+
+// datasets are the supported -dataset values.
+var datasets = map[string]dataset{
+	"era5": {
+		cdsName:      "reanalysis-era5-single-levels",
+		variables:    era5.VarNames,
+		cdsVariables: cdsVariables,
+		missing:      era5.MissingKeep,
+	},
+	// ERA5-Land has a 0.1° grid without values over the oceans, which are
+	// left out instead of exporting the fill values of most of the grid.
+	"era5-land": {
+		cdsName:   "reanalysis-era5-land",
+		variables: []string{"u10", "v10", "t2m", "d2m", "skt", "swvl1", "sp", "tp", "sf"},
+		cdsVariables: []string{
+			"10m_u_component_of_wind",
+			"10m_v_component_of_wind",
+			"2m_temperature",
+			"2m_dewpoint_temperature",
+			"skin_temperature",
+			"volumetric_soil_water_layer_1",
+			"surface_pressure",
+			"total_precipitation",
+			"snowfall",
+		},
+		accumulated: []string{"tp", "sf", "ssrd", "strd", "ssr", "str", "e", "ro", "sro", "ssro", "pev", "slhf", "sshf", "smlt", "es"},
+		missing:     era5.MissingSkip,
+	},
+	// ARCO-ERA5 names the variables like the CDS API, e.g. 2m_temperature.
+	// Its time axis runs years past the latest data, whose chunks are
+	// missing.
+	"arco-era5": {
+		variables: era5.VarNames,
+		missing:   era5.MissingKeep,
+		flags: map[string]string{
+			"file":   arcoStore,
+			"varMap": renames(cdsVariables, era5.VarNames),
+		},
+		needsRange: true,
+	},
+	// The ERA5 files on AWS (the era5-pds bucket) hold a variable each, so
+	// the files of the variables cover the same hours and the variables
+	// absent from a file are the norm.
+	"era5-pds": {
+		variables: []string{"u10", "v10", "t2m", "d2m", "sp", "msl", "tp"},
+		missing:   era5.MissingKeep,
+		flags: map[string]string{
+			"varMap":              renames(pdsVariables, pdsNames),
+			"skipAbsentVariables": "true",
+			"dedupTimestamps":     "false",
+		},
+		monthlyFile: func(month time.Time, fileVar string) string {
+			return fmt.Sprintf("s3://era5-pds/%s/data/%s.nc", month.Format("2006/01"), fileVar)
+		},
+		needsRange: true,
+	},
+}
+
+// datasetNames returns the supported -dataset values in the sorted order.
+func datasetNames() []string {
+	var names []string
+	for name := range datasets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// lookupDataset returns the dataset by its -dataset value or by its CDS name.
+func lookupDataset(name string) (dataset, error) {
+	for n, ds := range datasets {
+		if n == name || ds.cdsName != "" && ds.cdsName == name {
+			return ds, nil
+		}
+	}
+	return dataset{}, fmt.Errorf("unsupported dataset %q, want one of %s", name, strings.Join(datasetNames(), ", "))
+}
+
+// setDefaults sets the flags of fs that are not set on the command line to
+// the values by their names.
+func setDefaults(fs *flag.FlagSet, values map[string]string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, v := range values {
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, v); err != nil {
+			return fmt.Errorf("could not set -%s to %q: %w", name, v, err)
+		}
+	}
+	return nil
+}
+
+// monthlyFiles returns the -file list of the copy ds split into a file per
+// month and variable: the files of the variables fileVars, named as in the
+// files, for every month from from to to.
+func monthlyFiles(ds dataset, from, to time.Time, fileVars []string) string {
+	var files []string
+	for month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); month.Before(to); month = month.AddDate(0, 1, 0) {
+		for _, v := range fileVars {
+			files = append(files, ds.monthlyFile(month, v))
+		}
+	}
+	return strings.Join(files, ",")
+}
+
+// isFlagSet tells whether the flag name of fs is set on the command line.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rtm0/era5/internal/cds"
+)
+
+// cdsVariables are the CDS names of the variables the exporter reads, in
+// the order of era5.VarNames.
+var cdsVariables = []string{
+	"10m_u_component_of_wind",
+	"10m_v_component_of_wind",
+	"2m_temperature",
+	"snowfall",
+	"total_cloud_cover",
+	"total_precipitation",
+}
+
+// runDownload implements the download command that retrieves ERA5 data
+// from the Copernicus Climate Data Store into one NetCDF file per month.
+// The arguments after -- are the flags of an export of the downloaded files
+// that is run once they are all downloaded.
+func runDownload(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	dataset := fs.String("dataset", "reanalysis-era5-single-levels", "CDS dataset to retrieve, e.g. reanalysis-era5-land, whose default -variables are the ones the export of its files reads")
+	variables := fs.String("variables", strings.Join(cdsVariables, ","), "comma-separated CDS names of the variables to retrieve. The other surface variables the exporter supports are 100m_u_component_of_wind, 100m_v_component_of_wind, 2m_dewpoint_temperature, surface_pressure and mean_sea_level_pressure, exported as u100, v100, d2m, sp and msl")
+	start := fs.String("start", "", "first day to retrieve, e.g. 2023-01-01")
+	end := fs.String("end", "", "last day to retrieve. Default: -start")
+	hoursFlag := fs.String("hoursOfDay", "", "comma-separated UTC hours to retrieve, e.g. 0,6,12,18. Default: all hours")
+	area := fs.String("area", "", "north/west/south/east bounds of the area to retrieve in degrees, e.g. 72/-25/35/45. Default: the whole globe")
+	outDir := fs.String("o", ".", "directory to write the files to. The files are named era5_YYYY_MM.nc and the months whose file exists are not retrieved again")
+	cdsURL := fs.String("cdsUrl", "", "URL of the CDS API. Default: CDSAPI_URL, the url in ~/.cdsapirc or "+cds.DefaultURL)
+	cdsKey := fs.String("cdsKey", "", "personal access token of the CDS API. Default: CDSAPI_KEY or the key in ~/.cdsapirc")
+	pollInterval := fs.Duration("pollInterval", 10*time.Second, "how often the status of a submitted request is checked")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s download -start 2023-01-01 -end 2023-03-31 [flags] [-- export flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	var exportArgs []string
+	if i := slices.Index(args, "--"); i >= 0 {
+		args, exportArgs = args[:i], args[i+1:]
+	}
+	fs.Parse(args)
+	for name, ds := range datasets {
+		if ds.cdsName != *dataset {
+			continue
+		}
+		if err := setDefaults(fs, map[string]string{"variables": strings.Join(ds.cdsVariables, ",")}); err != nil {
+			return err
+		}
+		// The export reads the files with the defaults of the dataset
+		// unless told otherwise.
+		isDataset := func(arg string) bool {
+			flagName, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+			return strings.HasPrefix(arg, "-") && flagName == "dataset"
+		}
+		if len(exportArgs) > 0 && !slices.ContainsFunc(exportArgs, isDataset) {
+			exportArgs = append([]string{"-dataset=" + name}, exportArgs...)
+		}
+	}
+
+	if *start == "" {
+		return fmt.Errorf("-start must be set")
+	}
+	from, err := time.Parse(time.DateOnly, *start)
+	if err != nil {
+		return fmt.Errorf("could not parse -start: %w", err)
+	}
+	to := from
+	if *end != "" {
+		to, err = time.Parse(time.DateOnly, *end)
+		if err != nil {
+			return fmt.Errorf("could not parse -end: %w", err)
+		}
+	}
+	if to.Before(from) {
+		return fmt.Errorf("-end %s is before -start %s", *end, *start)
+	}
+	times, err := cdsTimes(*hoursFlag)
+	if err != nil {
+		return err
+	}
+	var areaBounds []float64
+	if *area != "" {
+		for _, s := range strings.Split(*area, "/") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return fmt.Errorf("could not parse -area: %w", err)
+			}
+			areaBounds = append(areaBounds, v)
+		}
+		if len(areaBounds) != 4 {
+			return fmt.Errorf("-area must have 4 bounds, north/west/south/east, got %q", *area)
+		}
+	}
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		return err
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	c, err := cds.NewClient(logger, *cdsURL, *cdsKey)
+	if err != nil {
+		return err
+	}
+	c.PollInterval = *pollInterval
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var files []string
+	// The data is requested a month at a time, which is the granularity the
+	// CDS recommends for ERA5 requests.
+	for month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); !month.After(to); month = month.AddDate(0, 1, 0) {
+		path := filepath.Join(*outDir, fmt.Sprintf("era5_%d_%02d.nc", month.Year(), month.Month()))
+		files = append(files, path)
+		if _, err := os.Stat(path); err == nil {
+			logger.Info("Skipping the downloaded month", "path", path)
+			continue
+		}
+		var days []string
+		for d := month; d.Month() == month.Month(); d = d.AddDate(0, 0, 1) {
+			if !d.Before(from) && !d.After(to) {
+				days = append(days, fmt.Sprintf("%02d", d.Day()))
+			}
+		}
+		request := map[string]any{
+			"product_type":    []string{"reanalysis"},
+			"variable":        strings.Split(*variables, ","),
+			"year":            []string{strconv.Itoa(month.Year())},
+			"month":           []string{fmt.Sprintf("%02d", month.Month())},
+			"day":             days,
+			"time":            times,
+			"data_format":     "netcdf",
+			"download_format": "unarchived",
+		}
+		if areaBounds != nil {
+			request["area"] = areaBounds
+		}
+		logger.Info("Retrieving ERA5 data", "dataset", *dataset, "month", month.Format("2006-01"), "days", len(days))
+		if err := c.Retrieve(ctx, *dataset, request, path); err != nil {
+			return fmt.Errorf("could not retrieve %s: %w", month.Format("2006-01"), err)
+		}
+		logger.Info("ERA5 data downloaded", "path", path)
+	}
+
+	if len(exportArgs) == 0 {
+		return nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, self, append(exportArgs, "-file", strings.Join(files, ","))...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	logger.Info("Exporting the downloaded files", "files", len(files))
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("export failed: %w", err)
+	}
+	return nil
+}
+
+// cdsTimes returns the times of the day to retrieve in the CDS form, HH:00.
+func cdsTimes(hours string) ([]string, error) {
+	var times []string
+	if hours == "" {
+		for h := 0; h < 24; h++ {
+			times = append(times, fmt.Sprintf("%02d:00", h))
+		}
+		return times, nil
+	}
+	for _, s := range strings.Split(hours, ",") {
+		h, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || h < 0 || h > 23 {
+			return nil, fmt.Errorf("invalid hour %q in -hoursOfDay, want 0..23", s)
+		}
+		times = append(times, fmt.Sprintf("%02d:00", h))
+	}
+	return times, nil
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rtm0/era5/internal/aggregate"
+	"github.com/rtm0/era5/internal/filter"
+	"github.com/rtm0/era5/internal/points"
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// exporter exports files one after another reusing the same loader, so the
+// insert workers, the throttling and the -maxFailedRows budget are shared
+// by all the files of a run.
+type exporter struct {
+	logger *slog.Logger
+	l      *loader
+	hours  []int
+	// from and to limit the exported timestamps to [from, to). They are
+	// zero if there is no limit.
+	from, to time.Time
+	// bbox limits the exported grid points to a region. It is nil if the
+	// whole grid is exported.
+	bbox       *era5.BBox
+	filter     *filter.Filter
+	transforms *transform.Set
+	// varNames are the names of the exported variables and varMap maps
+	// the names of the variables in the files to them.
+	varNames []string
+	varMap   map[string]string
+	// quantiles enable the aggregation mode. They are nil if it is
+	// disabled.
+	quantiles []float64
+	// exportInfo enables writing the export info of every file labeled
+	// with runID.
+	exportInfo bool
+	runID      string
+	// provenance holds the names of the provenance labels attached to the
+	// series and dataset is the value of the dataset label.
+	provenance []string
+	dataset    string
+	// points are the locations the values are estimated at instead of
+	// exporting the grid. They are nil if the grid is exported.
+	points []points.Location
+	// deaccumulate are the names of the variables exported as the values
+	// of every time step instead of the accumulations over the cycles that
+	// end at the accumulationResets hours.
+	deaccumulate       []string
+	accumulationResets []int
+	// exported holds the timestamps whose records the previous files of the
+	// run have all inserted by the target, which the next files skip. It is
+	// nil if the overlapping files are exported in full.
+	exported map[string]map[int64]bool
+}
+
+// exportJob is a file and the sink it is exported to.
+type exportJob struct {
+	file string
+	// target identifies the sink in the reports, e.g. the insert URL.
+	target string
+	ins    sink.Inserter
+}
+
+// exportReport is the outcome of exporting a file.
+type exportReport struct {
+	file, target     string
+	inserted, failed int64
+	filtered         int64
+	rawBytes, sent   int64
+	duration         time.Duration
+	// err is the error that prevented the file from being exported.
+	err error
+}
+
+// logArgs returns the report in the form suitable for logging.
+func (r *exportReport) logArgs() []any {
+	secs := r.duration.Seconds()
+	return []any{
+		"insertedRows", r.inserted,
+		"failedRows", r.failed,
+		"filteredRows", r.filtered,
+		"duration", r.duration.Round(time.Millisecond),
+		"rowsPerSec", int64(float64(r.inserted) / secs),
+		"rawMB", fmt.Sprintf("%.2f", mb(r.rawBytes)),
+		"sentMB", fmt.Sprintf("%.2f", mb(r.sent)),
+		"MBps", fmt.Sprintf("%.2f", mb(r.sent)/secs),
+	}
+}
+
+// export exports a single file. The returned report has err set if the file
+// could not be read, including partway through.
+func (e *exporter) export(ctx context.Context, job exportJob, scanners int) *exportReport {
+	rep := &exportReport{file: job.file, target: job.target}
+	logger := e.logger
+	if job.file != "" {
+		logger = logger.With("file", job.file)
+	}
+	var done map[int64]bool
+	if e.l.markers {
+		var err error
+		done, err = doneTimestamps(ctx, job.ins.(markerStore), job.file)
+		if err != nil {
+			rep.err = fmt.Errorf("could not read the completion markers: %w", err)
+			return rep
+		}
+		if len(done) > 0 {
+			logger.Info("Skipping the timestamps with completion markers", "timestamps", len(done))
+		}
+	}
+	exported := e.exported[job.target]
+	if e.exported != nil && exported == nil {
+		exported = make(map[int64]bool)
+		e.exported[job.target] = exported
+	}
+	overlap := make(map[int64]bool)
+	skip := func(ts int64) bool {
+		if exported[ts] {
+			overlap[ts] = true
+			return true
+		}
+		return done[ts]
+	}
+	path := job.file
+	if !era5.IsRemote(path) {
+		comp, err := era5.Compression(path)
+		if err != nil {
+			rep.err = err
+			return rep
+		}
+		if comp != "" {
+			logger.Info("Decompressing the file", "compression", comp)
+			start := time.Now()
+			var remove func()
+			path, remove, err = decompressFile(job.file)
+			if err != nil {
+				rep.err = fmt.Errorf("could not decompress the file: %w", err)
+				return rep
+			}
+			defer remove()
+			logger.Info("File decompressed", "path", path, "duration", time.Since(start).Round(time.Millisecond))
+		}
+	}
+	ss := make([]*era5.Scanner, scanners)
+	for i := range ss {
+		s, err := era5.NewScannerContext(ctx, path, era5.Options{
+			HourIndexes:         e.hours,
+			LimitHours:          *limitHours,
+			From:                e.from,
+			To:                  e.to,
+			Every:               *every,
+			Concurrency:         *scanConcurrency,
+			ScanAhead:           *scanAhead,
+			ScanRows:            *scanRows,
+			Part:                i,
+			Parts:               scanners,
+			ReadAhead:           *readAhead,
+			ChunkCacheSize:      *chunkCacheSize,
+			ChunkTimeSteps:      *chunkTimeSteps,
+			Group:               *group,
+			Strict:              *strict,
+			Skip:                skip,
+			Variables:           e.varNames,
+			VarMap:              e.varMap,
+			Missing:             *missing,
+			SkipAbsentVariables: *skipAbsentVariables,
+			Deaccumulate:        e.deaccumulate,
+			AccumulationResets:  e.accumulationResets,
+			BBox:                e.bbox,
+			SpatialStride:       *spatialStride,
+			LatitudeOrder:       *latitudeOrder,
+			Lon180:              *lon180,
+		})
+		if err != nil {
+			rep.err = fmt.Errorf("could not create an ERA5 scanner: %w", err)
+			return rep
+		}
+		defer s.Close()
+		if *startHour > 0 {
+			if err := s.Seek(*startHour); err != nil {
+				rep.err = fmt.Errorf("could not resume at -startHour: %w", err)
+				return rep
+			}
+		}
+		ss[i] = s
+	}
+	if len(overlap) > 0 {
+		logger.Info("Skipping the timestamps exported from the previous files", "timestamps", len(overlap))
+	}
+	logger.Info("ERA5 summary", ss[0].Summary()...)
+	var ext *points.Extractor
+	switch {
+	case e.points != nil:
+		var err error
+		ext, err = points.New(e.points, ss[0].Latitudes(), ss[0].Longitudes(), *pointsMethod, e.varNames, ss[0].FillValues())
+		if err != nil {
+			rep.err = fmt.Errorf("could not locate -points on the grid: %w", err)
+			return rep
+		}
+	case *targetResolution > 0:
+		var err error
+		ext, err = points.NewRegridder(ss[0].Latitudes(), ss[0].Longitudes(), *targetResolution, *regridMethod, e.varNames, ss[0].FillValues())
+		if err != nil {
+			rep.err = fmt.Errorf("could not regrid to -targetResolution: %w", err)
+			return rep
+		}
+	}
+	levels := ss[0].Levels()
+	if len(levels) > 0 {
+		if _, ok := job.ins.(levelsSetter); !ok {
+			rep.err = errors.New("the sink does not support the level dimension of the file")
+			return rep
+		}
+		if e.quantiles != nil {
+			rep.err = errors.New("-aggregate does not support the level dimension of the file")
+			return rep
+		}
+	}
+	for _, w := range ss[0].Warnings() {
+		logger.Warn("The file deviates from the ERA5 layout", "warning", w)
+	}
+	if g, ok := job.ins.(gridSetter); ok {
+		if ext != nil {
+			g.SetGrid(ext.Latitudes(), ext.Longitudes())
+		} else {
+			g.SetGrid(ss[0].Latitudes(), ss[0].Longitudes())
+		}
+	}
+	// The transformations are shared by the files, which may be packed
+	// differently. The scanners of a file share its packing, which comes
+	// from the attributes of the variables alone.
+	e.transforms.SetPacking(ss[0].Packing())
+	if l, ok := job.ins.(levelsSetter); ok {
+		// The levels of the previous file exported to the same sink are
+		// reset as well.
+		l.SetLevels(levels)
+	}
+	if f, ok := job.ins.(fillValuesSetter); ok {
+		f.SetFillValues(ss[0].FillValues())
+	}
+	if m, ok := job.ins.(varMetaSetter); ok {
+		m.SetVarMeta(ss[0].VarMeta())
+	}
+	if e.exportInfo {
+		e.writeExportInfo(ctx, logger, job)
+	}
+	names, values := e.seriesLabels(job.file, ss)
+	if t, ok := job.ins.(timeLabelsSetter); ok {
+		// The labels of the previous file exported to the same sink are
+		// reset as well.
+		t.SetTimeLabels(names, values)
+	} else if len(names) > 0 {
+		logger.Warn("The sink does not support time labels, ignoring them", "labels", names)
+	}
+	l := e.l
+	l.logger, l.ins, l.file, l.agg = logger, job.ins, job.file, nil
+	if e.quantiles != nil {
+		l.agg = aggregate.New(e.quantiles, e.varNames, e.transforms, ss[0].FillValues())
+	}
+	// The records are handed over to the sink column by column unless a
+	// stage of the pipeline works on records.
+	_, columnar := job.ins.(sink.BatchInserter)
+	columnar = columnar && ext == nil && e.filter == nil && e.quantiles == nil && !l.sortBySeries && *minBatchRecs == 0
+	var loaded <-chan loadResult
+	var filtered atomic.Int64
+	progress := newScanProgress()
+	if columnar {
+		loaded = l.runBatches(ctx, scanBatches(ctx, ss, progress))
+	} else {
+		extracted := e.scanRecords(ctx, ss, ext, &filtered, progress)
+		var batches <-chan []era5.Record = extracted
+		if *minBatchRecs > 0 {
+			batches = compact(ctx, extracted, *minBatchRecs)
+		}
+		loaded = l.run(ctx, batches)
+	}
+	var processed, failed, total float64
+	for _, s := range ss {
+		// The timestamps before -startHour are not counted.
+		n := len(s.RemainingTimestamps()) * max(len(levels), 1)
+		if ext != nil {
+			total += float64(n * ext.Len())
+		} else {
+			total += float64(n * len(s.Latitudes()) * len(s.Longitudes()))
+		}
+	}
+	start := time.Now()
+	// The records of a timestamp may be loaded in parts.
+	inserted := make(map[int64]int)
+	failedTs := make(map[int64]bool)
+	for r := range loaded {
+		processed += float64(r.rows)
+		failed += float64(r.failedRows)
+		rep.rawBytes += r.rawBytes
+		rep.sent += r.sentBytes
+		for ts, n := range r.timestamps {
+			inserted[ts] += n
+			if r.failedRows > 0 {
+				failedTs[ts] = true
+			}
+		}
+		percent := fmt.Sprintf("%.2f%%", 100*(processed+float64(filtered.Load()))/total)
+		elapsed := time.Since(start)
+		logger.Info("inserted", "rows", percent, "in", elapsed.Round(1*time.Second),
+			"rowsPerSec", int64((processed-failed)/elapsed.Seconds()),
+			"MBps", fmt.Sprintf("%.2f", mb(rep.sent)/elapsed.Seconds()))
+	}
+	if exported != nil {
+		// The timestamps that failed are left to the next files that
+		// overlap them. The ones whose records were all filtered out are
+		// done.
+		for ts, sent := range progress.scanned() {
+			if !failedTs[ts] && inserted[ts] == sent {
+				exported[ts] = true
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		logger.Warn("Export interrupted", "err", context.Cause(ctx))
+	} else {
+		var errs []error
+		for _, s := range ss {
+			errs = append(errs, s.Error())
+		}
+		if err := errors.Join(errs...); err != nil {
+			rep.err = fmt.Errorf("could not read ERA5 records: %w", err)
+		}
+	}
+	rep.inserted = int64(processed - failed)
+	rep.failed = int64(failed)
+	rep.filtered = filtered.Load()
+	rep.duration = time.Since(start)
+	return rep
+}
+
+// scanRecords scans the files with the scanners in parallel and sends the
+// records of every timestamp to the returned channel, which is closed once
+// all the scanners are done. The errors of the scanners are left to their
+// Error methods. The records are estimated at the points of ext
+// unless it is nil and filtered by the -where condition, counting the
+// records left out in filtered. The records sent are counted in progress.
+func (e *exporter) scanRecords(ctx context.Context, ss []*era5.Scanner, ext *points.Extractor, filtered *atomic.Int64, progress *scanProgress) <-chan []era5.Record {
+	extracted := make(chan []era5.Record)
+	var scanning sync.WaitGroup
+	for _, s := range ss {
+		scanning.Add(1)
+		go func() {
+			defer scanning.Done()
+			st := progress.scanner()
+			for s.ScanContext(ctx) {
+				recs := s.Records()
+				if len(recs) == 0 {
+					continue
+				}
+				ts := recs[0].Timestamp
+				if ext != nil {
+					recs = ext.Extract(recs)
+				}
+				if e.filter != nil {
+					n := len(recs)
+					recs = e.filter.Apply(recs)
+					filtered.Add(int64(n - len(recs)))
+				}
+				st.add(ts, len(recs))
+				if len(recs) == 0 {
+					continue
+				}
+				select {
+				case extracted <- recs:
+				case <-ctx.Done():
+					return
+				}
+			}
+			st.finish(ctx, s)
+		}()
+	}
+	go func() {
+		scanning.Wait()
+		close(extracted)
+	}()
+	return extracted
+}
+
+// scanBatches scans the files with the scanners in parallel and sends the
+// records of every timestamp column by column to the returned channel, which
+// is closed once all the scanners are done. The errors of the scanners are
+// left to their Error methods. The records sent are counted in progress.
+func scanBatches(ctx context.Context, ss []*era5.Scanner, progress *scanProgress) <-chan *era5.RecordBatch {
+	extracted := make(chan *era5.RecordBatch)
+	var scanning sync.WaitGroup
+	for _, s := range ss {
+		scanning.Add(1)
+		go func() {
+			defer scanning.Done()
+			st := progress.scanner()
+			for s.ScanContext(ctx) {
+				b := s.Batch()
+				st.add(b.Timestamp, b.Len())
+				select {
+				case extracted <- b:
+				case <-ctx.Done():
+					return
+				}
+			}
+			st.finish(ctx, s)
+		}()
+	}
+	go func() {
+		scanning.Wait()
+		close(extracted)
+	}()
+	return extracted
+}
+
+// scanProgress counts the records of every timestamp the scanners send to
+// the loader, which may send them in parts, e.g. with -scanRows.
+type scanProgress struct {
+	mu   sync.Mutex
+	sent map[int64]int
+	// complete holds the timestamps whose records have all been read.
+	complete map[int64]bool
+}
+
+func newScanProgress() *scanProgress {
+	return &scanProgress{sent: make(map[int64]int), complete: make(map[int64]bool)}
+}
+
+// scanned returns the number of records sent of every timestamp whose
+// records have all been read, including the ones filtered out.
+func (p *scanProgress) scanned() map[int64]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m := make(map[int64]int, len(p.complete))
+	for ts := range p.complete {
+		m[ts] = p.sent[ts]
+	}
+	return m
+}
+
+// scanner returns the tracker of the timestamps of a single scanner.
+func (p *scanProgress) scanner() *scannerProgress {
+	return &scannerProgress{p: p}
+}
+
+// scannerProgress tracks the timestamps of a scanner, which reads them in
+// order, so a timestamp is complete once the next one is read.
+type scannerProgress struct {
+	p      *scanProgress
+	ts     int64
+	active bool
+}
+
+// add counts n records of the timestamp sent, which may be zero.
+func (st *scannerProgress) add(ts int64, n int) {
+	st.p.mu.Lock()
+	defer st.p.mu.Unlock()
+	if st.active && ts != st.ts {
+		st.p.complete[st.ts] = true
+	}
+	st.ts, st.active = ts, true
+	st.p.sent[ts] += n
+}
+
+// finish completes the last timestamp of the scanner unless it stopped
+// before reading all its records.
+func (st *scannerProgress) finish(ctx context.Context, s *era5.Scanner) {
+	if !st.active || ctx.Err() != nil || s.Error() != nil {
+		return
+	}
+	st.p.mu.Lock()
+	defer st.p.mu.Unlock()
+	st.p.complete[st.ts] = true
+}
+
+// seriesLabels returns the names of the labels that depend on the file and
+// the timestamp only and their values at every timestamp: the provenance
+// labels followed by the auxiliary text coordinates of the time axis.
+func (e *exporter) seriesLabels(file string, ss []*era5.Scanner) ([]string, map[int64][]string) {
+	names := slices.Clone(e.provenance)
+	timeNames, _ := ss[0].TimeLabels()
+	names = append(names, timeNames...)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	prov := e.provenanceLabels(file)
+	values := make(map[int64][]string)
+	for _, s := range ss {
+		_, timeValues := s.TimeLabels()
+		for _, ts := range s.Timestamps() {
+			values[ts] = append(slices.Clone(prov), timeValues[ts]...)
+		}
+	}
+	return names, values
+}
+
+// logReports logs the outcome of every file and the totals of the run.
+func logReports(logger *slog.Logger, reports []*exportReport) {
+	total := &exportReport{}
+	failedFiles := 0
+	for _, r := range reports {
+		if r.err != nil {
+			logger.Error("File report", "file", r.file, "target", r.target, "err", r.err)
+			failedFiles++
+			continue
+		}
+		logger.Info("File report", append([]any{"file", r.file, "target", r.target}, r.logArgs()...)...)
+		total.inserted += r.inserted
+		total.failed += r.failed
+		total.filtered += r.filtered
+		total.rawBytes += r.rawBytes
+		total.sent += r.sent
+		total.duration += r.duration
+	}
+	logger.Info("All exports finished", append([]any{"files", len(reports), "failedFiles", failedFiles}, total.logArgs()...)...)
+}
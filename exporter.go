@@ -1,28 +1,144 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
-	"github.com/rtm0/era5/internal/era5"
+	"github.com/rtm0/era5/internal/aggregate"
+	"github.com/rtm0/era5/internal/clickhouse"
+	"github.com/rtm0/era5/internal/datadog"
+	"github.com/rtm0/era5/internal/enrich"
+	"github.com/rtm0/era5/internal/filter"
+	"github.com/rtm0/era5/internal/graphite"
+	"github.com/rtm0/era5/internal/journal"
+	"github.com/rtm0/era5/internal/kafka"
+	"github.com/rtm0/era5/internal/metrics"
+	"github.com/rtm0/era5/internal/nats"
+	"github.com/rtm0/era5/internal/points"
+	"github.com/rtm0/era5/internal/remotewrite"
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/internal/textfile"
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/internal/tsdb"
 	"github.com/rtm0/era5/internal/vm"
+	"github.com/rtm0/era5/pkg/era5"
 )
 
 var (
-	file          = flag.String("file", "", "path to an ERA5 file in NetCDF format")
-	concurrency   = flag.Int("concurrency", runtime.NumCPU(), "number of concurrent requests to Victoria Metrics")
-	recsPerInsert = flag.Int("recsPerInsert", 500, "number of records sent to VM in one batch")
-	vmInsertURL   = flag.String("vmInsertUrl", "http://localhost:8428/write", "Victoria Metrics insert API URL. Default: InfluxDB line protocol v2")
-	metricPrefix  = flag.String("metricPrefix", "era5", "a prefix that will be added to the metric names (cannot be empty)")
-	hours         = flag.String("hours", "", "comma-separated set of hours to import. Takes presedence over -limitHours")
-	limitHours    = flag.Int("limitHours", 0, "export only this many hours of data. Default: 0 (no limit)")
+	file                 = flag.String("file", "", "comma-separated paths, glob patterns or URLs of the ERA5 files to export, or '-' for stdin")
+	group                = flag.String("group", "", "path of the NetCDF group holding the variables, e.g. /era5/surface. Default: the first group holding them")
+	variables            = flag.String("variables", strings.Join(era5.VarNames, ","), "comma-separated names of the variables to export, or 'auto' for all of them")
+	varMapFlag           = flag.String("varMap", "", "comma-separated renames of the file variables as name_in_file=name, e.g. 2t=t2m,10u=u10")
+	skipAbsentVariables  = flag.Bool("skipAbsentVariables", false, "export the files lacking some of the -variables instead of failing")
+	strict               = flag.Bool("strict", false, "abort on any deviation of the file from the ERA5 layout instead of logging a warning")
+	concurrency          = flag.Int("concurrency", 0, "deprecated: use -insertConcurrency")
+	scanConcurrency      = flag.Int("scanConcurrency", runtime.NumCPU(), "number of goroutines decoding the file variables in parallel, each with its own file handle")
+	scanAhead            = flag.Int("scanAhead", 1, "number of timestamps decoded in parallel ahead of the insert workers")
+	scanRows             = flag.Int("scanRows", 0, "number of latitude rows scanned at once, exporting larger timestamps in parts. Default: 0 (whole timestamps)")
+	scanners             = flag.Int("scanners", 1, "number of scanners reading disjoint contiguous time ranges of the file in parallel")
+	insertConcurrency    = flag.Int("insertConcurrency", 2*runtime.NumCPU(), "number of concurrent requests to Victoria Metrics")
+	readAhead            = flag.Int("readAhead", 0, "size of the file read-ahead buffer in bytes. Default: 0 (disabled, or 8MiB for files in object storage)")
+	chunkCacheSize       = flag.Int("chunkCacheSize", 0, "number of decoded chunks of time steps to keep in memory per scanner. Default: 0 (disabled)")
+	chunkTimeSteps       = flag.Int("chunkTimeSteps", 24, "number of time steps read at once into a cached chunk. Ignored if -chunkCacheSize is 0")
+	inflightPerLoader    = flag.Int("inflightPerLoader", 1, "max number of requests each insert goroutine keeps in flight")
+	insertTimeout        = flag.Duration("insertTimeout", 0, "max duration of a single insert request. Default: 0 (no limit)")
+	maxRetries           = flag.Int("maxRetries", 3, "max number of times a failed batch is retried")
+	retryBackoff         = flag.Duration("retryBackoff", time.Second, "delay before the first retry of a failed batch, doubled with every retry")
+	maxFailedRows        = flag.Int64("maxFailedRows", -1, "abort the export once this many rows failed to be inserted. Default: -1 (never abort)")
+	journalPath          = flag.String("journal", "", "path to a JSON lines file the outcome of every inserted batch is appended to. Default: no journal")
+	httpListenAddr       = flag.String("httpListenAddr", "", "address to serve the exporter self-metrics at /metrics, e.g. :8490. Default: disabled")
+	minBatchRecs         = flag.Int("minBatchRecs", 0, "merge consecutive timestamps with fewer records than this into one batch. Default: 0 (disabled)")
+	maxInsertRequestSize = flag.Int("maxInsertRequestSize", 32*1024*1024, "max size of an uncompressed insert request body, larger batches are split")
+	sortBySeries         = flag.Bool("sortBySeries", false, "order the records of every timestamp by geohash before splitting them into batches")
+	streamInserts        = flag.Bool("streamInserts", false, "encode the records while sending them in a chunked request body")
+	encodeArena          = flag.Int("encodeArena", 0, "initial size in bytes of the per-connection arena batches are encoded into. Default: 0 (disabled)")
+	throttleMetricsURLs  = flag.String("throttleMetricsUrls", "", "comma-separated /metrics URLs of the target Victoria Metrics to throttle the inserts by. Default: disabled")
+	throttleInterval     = flag.Duration("throttleInterval", 10*time.Second, "how often the target health is checked if -throttleMetricsUrls is set")
+	throttleSlowInserts  = flag.Float64("throttleMaxSlowInsertsRatio", 0.05, "share of slow inserts above which the target is under pressure. 0 disables the check")
+	throttleMemoryUsage  = flag.Float64("throttleMaxMemoryUsage", 0.8, "share of the available memory used by the target above which it is under pressure. 0 disables the check")
+	throttlePendingRows  = flag.Float64("throttleMaxPendingRows", 0, "number of rows pending a flush above which the target is under pressure. Default: 0 (disabled)")
+	transforms           = flag.String("transform", "", "semicolon-separated transformations of the values, e.g. 't2m: x - 273.15'")
+	rawValues            = flag.Bool("rawValues", false, "export the packed integers stored in the file instead of the physical values")
+	missing              = flag.String("missing", era5.MissingKeep, "what the fill values are exported as: keep, skip, nan or zero")
+	valuePrecision       = flag.Int("valuePrecision", -1, "number of decimal digits the values are rounded to. Default: -1 (the resolution of their packing)")
+	where                = flag.String("where", "", "condition a record must meet to be exported, e.g. 'la > 35 && t2m < 253'. Default: all records")
+	aggregateGrid        = flag.Bool("aggregate", false, "export the quantiles of every variable over the grid instead of the records")
+	aggregateQuantiles   = flag.String("aggregateQuantiles", "0,0.01,0.05,0.25,0.5,0.75,0.95,0.99,1", "comma-separated quantiles exported if -aggregate is set")
+	tenantMap            = flag.String("tenantMap", "", "path to a file mapping the files to their tenants, one file and insert URL or tenant ID per line")
+	enrichPath           = flag.String("enrich", "", "path to a CSV file with extra labels of the grid points. Default: no extra labels")
+	exportInfo           = flag.Bool("exportInfo", true, "write the <metricPrefix>_export_info metric describing every exported file")
+	runID                = flag.String("runId", "", "ID of the export run written to the <metricPrefix>_export_info metric. Default: a random ID")
+	provenance           = flag.String("provenanceLabels", "", "comma-separated labels attached to every series: source_file, dataset or run_id. Default: no labels")
+	datasetLabel         = flag.String("datasetLabel", "", "value of the dataset label if -provenanceLabels includes it")
+	pointsPath           = flag.String("points", "", "path to a CSV file with the locations to export the values at instead of the grid")
+	pointsMethod         = flag.String("pointsMethod", points.Nearest, "how the values at the -points locations are estimated: nearest, bilinear or idw")
+	targetResolution     = flag.Float64("targetResolution", 0, "resolution in degrees of a coarser grid the values are regridded to. Default: 0 (the grid of the file)")
+	regridMethod         = flag.String("regridMethod", points.Mean, "how the values at the -targetResolution grid points are estimated: mean or nearest")
+	markers              = flag.Bool("markers", false, "write a completion marker per exported timestamp and skip the timestamps marked already")
+	vmSelectURL          = flag.String("vmSelectUrl", "", "base URL of the Victoria Metrics query APIs used by -markers. Default: derived from -vmInsertUrl")
+	recsPerInsert        = flag.Int("recsPerInsert", 500, "number of records sent to VM in one batch")
+	sinkType             = flag.String("sink", "vm", "where the records are written to: vm, tsdb, openmetrics, m3, remotewrite, graphite, datadog, kafka, nats, clickhouse, file or file://<path>")
+	tsdbDir              = flag.String("tsdbDir", "data", "directory to write the TSDB blocks or OpenMetrics files to")
+	tsdbBlockDuration    = flag.Duration("tsdbBlockDuration", 2*time.Hour, "time range of a TSDB block or an OpenMetrics file")
+	tsdbMaxOpenBlocks    = flag.Int("tsdbMaxOpenBlocks", 4, "max number of TSDB blocks or OpenMetrics files kept in memory")
+	fileDir              = flag.String("fileDir", "data", "directory to write the files to if -sink=file")
+	fileFormat           = flag.String("fileFormat", textfile.FormatInflux, "format of the files if -sink=file: influx, csv or jsonl")
+	fileTimestamps       = flag.String("fileTimestamps", textfile.TimestampUnixMs, "format of the csv and jsonl timestamps if -sink=file: unixms or rfc3339")
+	fileCompression      = flag.String("fileCompression", textfile.CompressionGzip, "compression of the files if -sink=file: none, gzip or zstd")
+	fileMaxSize          = flag.Int64("fileMaxSize", 0, "size in bytes after which a file is closed and a new one is started if -sink=file. Default: 0 (no limit)")
+	fileRotateInterval   = flag.Duration("fileRotateInterval", 0, "duration of the time windows the records are split into files by if -sink=file. Default: 0 (no split)")
+	fileMaxOpenFiles     = flag.Int("fileMaxOpenFiles", 4, "max number of time windows written at the same time if -sink=file")
+	remoteWriteMissing   = flag.String("remoteWriteMissing", "keep", "what the fill values are sent as if -sink=m3 or -sink=remotewrite: keep, stale or nan")
+	remoteWriteURL       = flag.String("remoteWriteUrl", "http://localhost:9090/api/v1/write", "Prometheus remote write API URL if -sink=remotewrite")
+	remoteWriteHeaders   = flag.String("remoteWriteHeaders", "", "comma-separated name:value HTTP headers of the remote write requests, e.g. X-Scope-OrgID:tenant1")
+	graphiteAddr         = flag.String("graphiteAddr", "localhost:2003", "host:port of the Graphite plaintext listener if -sink=graphite")
+	datadogURL           = flag.String("datadogUrl", datadog.DefaultURL, "Datadog series API v2 URL if -sink=datadog. The API key is read from DD_API_KEY")
+	kafkaBrokers         = flag.String("kafkaBrokers", "localhost:9092", "comma-separated host:port of the Kafka brokers if -sink=kafka")
+	kafkaTopic           = flag.String("kafkaTopic", "era5", "Kafka topic the records are published to if -sink=kafka")
+	kafkaFormat          = flag.String("kafkaFormat", textfile.FormatJSONL, "format of the Kafka messages if -sink=kafka: jsonl or influx")
+	kafkaPartitionBy     = flag.String("kafkaPartitionBy", kafka.PartitionByCell, "key of the Kafka messages if -sink=kafka: cell or timestamp")
+	natsURL              = flag.String("natsUrl", nats.DefaultURL, "URL of the NATS server if -sink=nats")
+	natsSubject          = flag.String("natsSubject", "era5", "NATS JetStream subject the records are published to if -sink=nats")
+	natsFormat           = flag.String("natsFormat", textfile.FormatJSONL, "format of the lines of the NATS messages if -sink=nats: jsonl or influx")
+	clickhouseURL        = flag.String("clickhouseUrl", clickhouse.DefaultURL, "URL of the HTTP interface of the ClickHouse server if -sink=clickhouse")
+	clickhouseTable      = flag.String("clickhouseTable", "era5", "ClickHouse table the records are inserted into if -sink=clickhouse, created unless it exists")
+	clickhouseEngine     = flag.String("clickhouseEngine", "", "engine clause of the ClickHouse table created if -sink=clickhouse. Default: a monthly partitioned MergeTree")
+	m3URL                = flag.String("m3Url", "http://localhost:7201/api/v1/prom/remote/write", "M3 coordinator remote write API URL if -sink=m3")
+	m3MetricsType        = flag.String("m3MetricsType", "unaggregated", "M3 namespace type the data is written to if -sink=m3: unaggregated or aggregated")
+	m3StoragePolicy      = flag.String("m3StoragePolicy", "", "resolution:retention of the aggregated M3 namespace, e.g. 1h:87600h. Required if -m3MetricsType=aggregated")
+	vmInsertURL          = flag.String("vmInsertUrl", "http://localhost:8428/write", "Victoria Metrics insert API URL: /write, /api/v1/import/csv, /api/v1/import or /api/v1/import/native. Default: InfluxDB line protocol v2")
+	compression          = flag.String("compression", vm.CompressionNone, "compression of request bodies: none, gzip, zstd or auto")
+	metricPrefix         = flag.String("metricPrefix", "era5", "a prefix that will be added to the metric names (cannot be empty)")
+	hours                = flag.String("hours", "", "comma-separated set of hours to import. Takes presedence over -limitHours")
+	limitHours           = flag.Int("limitHours", 0, "export only this many hours of data. Default: 0 (no limit)")
+	datasetName          = flag.String("dataset", "era5", "CDS dataset the files come from, which sets the flag defaults: era5, era5-land, arco-era5 or era5-pds")
+	deaccumulate         = flag.String("deaccumulate", "", "comma-separated accumulated -variables to export the per time step values of. Default: the ones of -dataset")
+	accumulationResets   = flag.String("accumulationResets", "0", "comma-separated UTC hours the accumulation cycles of the -deaccumulate variables end at")
+	dedupTimestamps      = flag.Bool("dedupTimestamps", true, "export every timestamp once when the -file list covers overlapping periods")
+	startHour            = flag.Int("startHour", 0, "index of the time axis of a single file to resume an interrupted export at. Default: 0 (from the beginning)")
+	fromTime             = flag.String("from", "", "export only the timestamps at or after this RFC3339 time or date. Default: no limit")
+	bbox                 = flag.String("bbox", "", "export only the grid points within minLat,minLon,maxLat,maxLon, e.g. 34,-25,72,45. Default: the whole grid")
+	latitudeOrder        = flag.String("latitudeOrder", "", "order the latitudes are exported in: descending or ascending. Default: the order of the file")
+	lon180               = flag.Bool("lon180", false, "export the longitudes in the [-180, 180) range instead of [0, 360)")
+	spatialStride        = flag.Int("spatialStride", 1, "export every Nth latitude and longitude of the grid")
+	every                = flag.Duration("every", 0, "export only the timestamps that are multiples of this duration, e.g. 6h. Default: 0 (every timestamp)")
+	toTime               = flag.String("to", "", "export only the timestamps at or before this RFC3339 time or date. Default: no limit")
 )
 
 func parseHours(str string) ([]int, error) {
@@ -41,76 +157,857 @@ func parseHours(str string) ([]int, error) {
 	return hrs, nil
 }
 
+// parseTimeBound parses the -from or the -to flag value, which is either an
+// RFC3339 time or a date. It returns the zero time for an empty value. The
+// end of the window is exclusive, so the end of a time is a millisecond after
+// it and the end of a date is the midnight after it.
+func parseTimeBound(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want an RFC3339 time or a date, got %q", s)
+	}
+	if end {
+		t = t.Add(time.Millisecond)
+	}
+	return t, nil
+}
+
+// autoVariables is the -variables value that discovers the variables in the
+// file.
+const autoVariables = "auto"
+
+// parseVariables parses the comma-separated names of the variables. It
+// returns nil for autoVariables.
+func parseVariables(s string) ([]string, error) {
+	if strings.TrimSpace(s) == autoVariables {
+		return nil, nil
+	}
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if !variableNameRE.MatchString(name) {
+			return nil, fmt.Errorf("invalid variable name %q, it must match %q", name, variableNameRE)
+		}
+		if slices.Contains(names, name) {
+			return nil, fmt.Errorf("duplicate variable %q", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// parseVarMap parses the comma-separated name_in_file=name renames of the
+// variables of the files.
+func parseVarMap(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		fileName, name, ok := strings.Cut(pair, "=")
+		fileName, name = strings.TrimSpace(fileName), strings.TrimSpace(name)
+		if !ok || fileName == "" {
+			return nil, fmt.Errorf("rename %q must be of the form name_in_file=name", pair)
+		}
+		if !variableNameRE.MatchString(name) {
+			return nil, fmt.Errorf("invalid variable name %q, it must match %q", name, variableNameRE)
+		}
+		if _, ok := m[fileName]; ok {
+			return nil, fmt.Errorf("variable %s is renamed more than once", fileName)
+		}
+		for f, n := range m {
+			if n == name {
+				return nil, fmt.Errorf("variables %s and %s are both renamed to %s", f, fileName, name)
+			}
+		}
+		m[fileName] = name
+	}
+	return m, nil
+}
+
+// parseHeaders parses the comma-separated name:value HTTP headers.
+func parseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, header := range strings.Split(s, ",") {
+		if strings.TrimSpace(header) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(header, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("header %q must be of the form name:value", header)
+		}
+		headers[name] = value
+	}
+	return headers, nil
+}
+
+// fileVarNames returns the names of the variables in the files given the
+// renames of varMap.
+func fileVarNames(names []string, varMap map[string]string) []string {
+	fileNames := slices.Clone(names)
+	for i, name := range names {
+		for fileName, mapped := range varMap {
+			if mapped == name {
+				fileNames[i] = fileName
+			}
+		}
+	}
+	return fileNames
+}
+
+// variableNameRE matches the variable names that make valid metric names.
+var variableNameRE = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// subcommands maps the names of the auxiliary commands to their
+// implementations. A command receives the arguments that follow its name.
+var subcommands = map[string]func(args []string) error{
+	"dashboards": runDashboards,
+	"download":   runDownload,
+	"inspect":    runInspect,
+	"rules":      runRules,
+	"validate":   runValidate,
+}
+
 func main() {
-	flag.Parse()
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				logger.Error("Could not run command", "cmd", os.Args[1], "err", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+
+	if *httpListenAddr != "" {
+		go serveMetrics(logger, *httpListenAddr)
+	}
+
+	if *concurrency > 0 {
+		logger.Warn("-concurrency flag is deprecated, use -insertConcurrency instead")
+		*insertConcurrency = *concurrency
+	}
 
-	vmCli, err := vm.NewClient(logger, *vmInsertURL, *concurrency, *metricPrefix)
+	if *inflightPerLoader < 1 {
+		logger.Error("-inflightPerLoader must be positive", "value", *inflightPerLoader)
+		os.Exit(1)
+	}
+
+	// -sink=file://<path> writes a single file if the extension of the path
+	// names a format and into the directory at the path otherwise.
+	var fileName string
+	if path, ok := strings.CutPrefix(*sinkType, "file://"); ok {
+		if path == "" {
+			logger.Error("-sink=file:// must be followed by a path")
+			os.Exit(1)
+		}
+		*sinkType = "file"
+		*fileDir = path
+		if format, compression, ok := textfile.FormatOfName(path); ok {
+			*fileDir, fileName = filepath.Dir(path), filepath.Base(path)
+			*fileFormat, *fileCompression = format, compression
+		}
+	}
+
+	ds, err := lookupDataset(*datasetName)
 	if err != nil {
-		logger.Error("Could not create new VM client", "err", err)
+		logger.Error("Unsupported -dataset", "err", err)
+		os.Exit(1)
+	}
+	defaults := map[string]string{
+		"variables": strings.Join(ds.variables, ","),
+		"missing":   ds.missing,
+	}
+	maps.Copy(defaults, ds.flags)
+	if err := setDefaults(flag.CommandLine, defaults); err != nil {
+		logger.Error("Could not apply the defaults of -dataset", "dataset", *datasetName, "err", err)
+		os.Exit(1)
+	}
+	if ds.needsRange && (*fromTime == "" || *toTime == "") {
+		logger.Error("-from and -to must be set for the -dataset, which holds decades of hours", "dataset", *datasetName)
 		os.Exit(1)
 	}
 
-	hrs, err := parseHours(*hours)
+	if *latitudeOrder != "" && !slices.Contains(era5.LatitudeOrders, *latitudeOrder) {
+		logger.Error("Unsupported -latitudeOrder", "value", *latitudeOrder)
+		os.Exit(1)
+	}
+	if !slices.Contains(era5.MissingPolicies, *missing) {
+		logger.Error("Unsupported -missing", "value", *missing)
+		os.Exit(1)
+	}
+
+	var labels *enrich.Table
+	varNames, err := parseVariables(*variables)
 	if err != nil {
-		logger.Error("Could not parse -hours flag value", "err", err)
+		logger.Error("Could not parse -variables", "err", err)
+		os.Exit(1)
+	}
+	varMap, err := parseVarMap(*varMapFlag)
+	if err != nil {
+		logger.Error("Could not parse -varMap", "err", err)
+		os.Exit(1)
+	}
+	provenanceNames, err := parseProvenanceLabels(*provenance)
+	if err != nil {
+		logger.Error("Could not parse -provenanceLabels", "err", err)
+		os.Exit(1)
+	}
+	if slices.Contains(provenanceNames, "dataset") && *datasetLabel == "" {
+		logger.Error("-datasetLabel must be set if -provenanceLabels includes dataset")
+		os.Exit(1)
+	}
+	if *enrichPath != "" {
+		labels, err = enrich.Load(*enrichPath)
+		if err != nil {
+			logger.Error("Could not load -enrich file", "err", err)
+			os.Exit(1)
+		}
+	}
+	var locs []points.Location
+	if *pointsPath != "" {
+		if labels != nil {
+			logger.Error("-enrich cannot be combined with -points, add the labels to the -points file instead")
+			os.Exit(1)
+		}
+		locs, labels, err = loadPoints(*pointsPath)
+		if err != nil {
+			logger.Error("Could not load -points file", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// The files lacking some of the variables are ordered as well if they
+	// are skipped.
+	fileVars := fileVarNames(varNames, varMap)
+	if *skipAbsentVariables {
+		fileVars = nil
+	}
+	if ds.monthlyFile != nil && *file == "" && *tenantMap == "" {
+		if varNames == nil {
+			logger.Error("-variables must be listed to find the files of the -dataset", "dataset", *datasetName)
+			os.Exit(1)
+		}
+		from, err := parseTimeBound(*fromTime, false)
+		if err != nil {
+			logger.Error("Could not parse -from", "err", err)
+			os.Exit(1)
+		}
+		to, err := parseTimeBound(*toTime, true)
+		if err != nil {
+			logger.Error("Could not parse -to", "err", err)
+			os.Exit(1)
+		}
+		*file = monthlyFiles(ds, from, to, fileVarNames(varNames, varMap))
+	}
+	files, err := expandFiles(*file, *group, fileVars)
+	if err != nil {
+		logger.Error("Could not expand -file", "err", err)
+		os.Exit(1)
+	}
+	if len(files) == 0 && *tenantMap == "" {
+		logger.Error("-file must be set")
+		os.Exit(1)
+	}
+	var mappings []tenantMapping
+	for _, f := range files {
+		mappings = append(mappings, tenantMapping{file: f, insertURL: *vmInsertURL})
+	}
+	if *tenantMap != "" {
+		if *sinkType != "vm" {
+			logger.Error("-tenantMap is only supported by the vm sink", "sink", *sinkType)
+			os.Exit(1)
+		}
+		mappings, err = loadTenantMap(*tenantMap, *vmInsertURL)
+		if err != nil {
+			logger.Error("Could not load -tenantMap", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	if *startHour < 0 {
+		logger.Error("-startHour must not be negative", "startHour", *startHour)
+		os.Exit(1)
+	}
+	if *startHour > 0 && len(mappings) != 1 {
+		logger.Error("-startHour requires a single file to export", "files", len(mappings))
 		os.Exit(1)
 	}
 
-	s, err := era5.NewScanner(*file, hrs, *limitHours)
+	removeStdin := func() {}
+	if varNames == nil && len(mappings) > 0 {
+		// The variables are discovered in the first file, so stdin is
+		// spooled before the rest of the setup in this mode.
+		if mappings[0].file == stdinFile {
+			path, remove, err := spoolStdin()
+			if err != nil {
+				logger.Error("Could not spool stdin", "err", err)
+				os.Exit(1)
+			}
+			removeStdin = remove
+			logger.Info("Spooled stdin", "path", path)
+			mappings[0].file = path
+			if len(files) > 0 {
+				files[0] = path
+			}
+		}
+		varNames, err = discoverVariables(mappings[0].file, *group)
+		if err != nil {
+			removeStdin()
+			logger.Error("Could not discover variables", "file", mappings[0].file, "err", err)
+			os.Exit(1)
+		}
+		for i, name := range varNames {
+			if mapped, ok := varMap[name]; ok {
+				varNames[i] = mapped
+			}
+		}
+		logger.Info("Discovered variables", "file", mappings[0].file, "variables", varNames)
+	}
+	var deaccVars []string
+	if !isFlagSet(flag.CommandLine, "deaccumulate") {
+		for _, name := range ds.accumulated {
+			if slices.Contains(varNames, name) {
+				deaccVars = append(deaccVars, name)
+			}
+		}
+	}
+	for _, name := range strings.Split(*deaccumulate, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !slices.Contains(varNames, name) {
+			logger.Error("-deaccumulate must name the -variables", "variable", name, "variables", varNames)
+			os.Exit(1)
+		}
+		deaccVars = append(deaccVars, name)
+	}
+	resetHours, err := parseHours(*accumulationResets)
+	if err != nil || slices.ContainsFunc(resetHours, func(h int) bool { return h < 0 || h > 23 }) {
+		logger.Error("-accumulationResets must be comma-separated hours 0..23", "value", *accumulationResets)
+		os.Exit(1)
+	}
+	missingPolicy := *missing
+	if *skipAbsentVariables && missingPolicy == era5.MissingKeep {
+		// The samples of the absent variables are NaN whatever
+		// the policy, and keeping them would export NaN as a value.
+		missingPolicy = era5.MissingSkip
+	}
+	tfs, err := transform.Parse(*transforms, varNames, *valuePrecision, !*rawValues, missingPolicy)
 	if err != nil {
-		logger.Error("Could not create an ERA5 scanner", "err", err)
-		os.Exit(1)
-	}
-	defer s.Close()
-	logger.Info("ERA5 summary", s.Summary()...)
-	extracted := make(chan []era5.Record)
-	go func() {
-		for s.Scan() {
-			extracted <- s.Records()
-		}
-		if s.Error() != nil {
-			logger.Error("could not read ERA5 records", "err", s.Error())
-		}
-		close(extracted)
-	}()
-
-	loaded := make(chan int)
-	var loaders sync.WaitGroup
-	for _ = range *concurrency {
-		loaders.Add(1)
-		go func() {
-			for recs := range extracted {
-				n := len(recs)
-				for i := 0; i < n; i += *recsPerInsert {
-					begin := i
-					limit := begin + *recsPerInsert
-					if limit > n {
-						limit = n
-					}
-					vmCli.Insert(recs[begin:limit])
+		logger.Error("Could not parse -transform", "err", err)
+		os.Exit(1)
+	}
+	var flt *filter.Filter
+	if *where != "" {
+		flt, err = filter.Parse(*where, varNames, tfs)
+		if err != nil {
+			logger.Error("Could not parse -where", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	var (
+		jobs      []exportJob
+		closeSink = func() error { return nil }
+	)
+	sinkOpts := sink.Options{
+		MaxRetries:   *maxRetries,
+		RetryBackoff: *retryBackoff,
+		Transforms:   tfs,
+		Variables:    varNames,
+	}
+	switch *sinkType {
+	case "vm":
+		// Files mapped to the same insert URL share the client.
+		clients := make(map[string]*vm.Client)
+		for _, m := range mappings {
+			vmCli := clients[m.insertURL]
+			if vmCli == nil {
+				vmCli, err = vm.NewClient(logger, m.insertURL, vm.Options{
+					Options:      sinkOpts,
+					MaxConns:     *insertConcurrency * *inflightPerLoader,
+					MetricPrefix: *metricPrefix,
+					Compression:  *compression,
+
+					MaxRequestSize: *maxInsertRequestSize,
+					Streaming:      *streamInserts,
+
+					EncodeArenaSize: *encodeArena,
+					Labels:          labels,
+					SelectURL:       *vmSelectURL,
+				})
+				if err != nil {
+					logger.Error("Could not create new VM client", "url", m.insertURL, "err", err)
+					os.Exit(1)
 				}
-				loaded <- n
+				clients[m.insertURL] = vmCli
 			}
-			loaders.Done()
-		}()
-	}
-	done := make(chan bool)
-	go func() {
-		var inserted, total float64
-		total = float64(s.TotalRecCount())
-		start := time.Now()
-		for n := range loaded {
-			inserted += float64(n)
-			percent := fmt.Sprintf("%.2f%%", 100*inserted/total)
-			duration := time.Since(start).Round(1 * time.Second)
-			logger.Info("inserted", "rows", percent, "in", duration)
-		}
-		done <- true
-	}()
-
-	loaders.Wait()
-	close(loaded)
-	<-done
-	close(done)
+			jobs = append(jobs, exportJob{file: m.file, target: m.insertURL, ins: vmCli})
+		}
+	case "tsdb", "openmetrics":
+		if labels != nil {
+			logger.Error("-enrich is not supported by the sink", "sink", *sinkType)
+			os.Exit(1)
+		}
+		newWriter := tsdb.NewWriter
+		if *sinkType == "openmetrics" {
+			newWriter = tsdb.NewOpenMetricsWriter
+		}
+		w, err := newWriter(*tsdbDir, tsdb.Options{
+			MetricPrefix:  *metricPrefix,
+			BlockDuration: *tsdbBlockDuration,
+			MaxOpenBlocks: *tsdbMaxOpenBlocks,
+			Transforms:    tfs,
+			Variables:     varNames,
+		})
+		if err != nil {
+			logger.Error("Could not create TSDB writer", "err", err)
+			os.Exit(1)
+		}
+		jobs = fileJobs(files, *tsdbDir, w)
+		closeSink = w.Close
+	case "file":
+		if labels != nil {
+			logger.Error("-enrich is not supported by the sink", "sink", *sinkType)
+			os.Exit(1)
+		}
+		w, err := textfile.NewWriter(*fileDir, textfile.Options{
+			MetricPrefix:   *metricPrefix,
+			Format:         *fileFormat,
+			Timestamps:     *fileTimestamps,
+			Compression:    *fileCompression,
+			MaxFileSize:    *fileMaxSize,
+			RotateInterval: *fileRotateInterval,
+			MaxOpenFiles:   *fileMaxOpenFiles,
+			Transforms:     tfs,
+			Variables:      varNames,
+			FileName:       fileName,
+		})
+		if err != nil {
+			logger.Error("Could not create file writer", "err", err)
+			os.Exit(1)
+		}
+		jobs = fileJobs(files, *fileDir, w)
+		closeSink = w.Close
+	case "m3":
+		headers := map[string]string{"M3-Metrics-Type": *m3MetricsType}
+		switch {
+		case *m3MetricsType == "aggregated" && *m3StoragePolicy == "":
+			logger.Error("-m3StoragePolicy must be set for the aggregated namespace")
+			os.Exit(1)
+		case *m3MetricsType != "aggregated" && *m3MetricsType != "unaggregated":
+			logger.Error("Unsupported -m3MetricsType", "value", *m3MetricsType)
+			os.Exit(1)
+		case *m3StoragePolicy != "":
+			headers["M3-Storage-Policy"] = *m3StoragePolicy
+		}
+		m3Cli, err := remotewrite.NewClient(logger, *m3URL, remotewrite.Options{
+			Options:      sinkOpts,
+			MaxConns:     *insertConcurrency * *inflightPerLoader,
+			MetricPrefix: *metricPrefix,
+			Headers:      headers,
+			Metadata:     true,
+			Missing:      *remoteWriteMissing,
+			Labels:       labels,
+		})
+		if err != nil {
+			logger.Error("Could not create M3 client", "err", err)
+			os.Exit(1)
+		}
+		jobs = fileJobs(files, *m3URL, m3Cli)
+	case "remotewrite":
+		headers, err := parseHeaders(*remoteWriteHeaders)
+		if err != nil {
+			logger.Error("Could not parse -remoteWriteHeaders", "err", err)
+			os.Exit(1)
+		}
+		u, err := url.Parse(*remoteWriteURL)
+		if err != nil {
+			logger.Error("Could not parse -remoteWriteUrl", "err", err)
+			os.Exit(1)
+		}
+		rwCli, err := remotewrite.NewClient(logger, *remoteWriteURL, remotewrite.Options{
+			Options:      sinkOpts,
+			MaxConns:     *insertConcurrency * *inflightPerLoader,
+			MetricPrefix: *metricPrefix,
+			Headers:      headers,
+			Metadata:     true,
+			Missing:      *remoteWriteMissing,
+			Labels:       labels,
+		})
+		if err != nil {
+			logger.Error("Could not create remote write client", "err", err)
+			os.Exit(1)
+		}
+		// The reports tell the target without the credentials.
+		jobs = fileJobs(files, u.Redacted(), rwCli)
+	case "graphite":
+		if labels != nil {
+			logger.Error("-enrich is not supported by the sink", "sink", *sinkType)
+			os.Exit(1)
+		}
+		gCli, err := graphite.NewClient(logger, *graphiteAddr, graphite.Options{
+			Options:      sinkOpts,
+			MaxConns:     *insertConcurrency * *inflightPerLoader,
+			MetricPrefix: *metricPrefix,
+		})
+		if err != nil {
+			logger.Error("Could not create Graphite client", "err", err)
+			os.Exit(1)
+		}
+		jobs = fileJobs(files, *graphiteAddr, gCli)
+		closeSink = gCli.Close
+	case "datadog":
+		if labels != nil {
+			logger.Error("-enrich is not supported by the sink", "sink", *sinkType)
+			os.Exit(1)
+		}
+		apiKey := os.Getenv("DD_API_KEY")
+		if apiKey == "" {
+			logger.Error("The DD_API_KEY environment variable must hold the Datadog API key")
+			os.Exit(1)
+		}
+		ddCli, err := datadog.NewClient(logger, *datadogURL, datadog.Options{
+			Options:      sinkOpts,
+			APIKey:       apiKey,
+			MaxConns:     *insertConcurrency * *inflightPerLoader,
+			MetricPrefix: *metricPrefix,
+		})
+		if err != nil {
+			logger.Error("Could not create Datadog client", "err", err)
+			os.Exit(1)
+		}
+		jobs = fileJobs(files, *datadogURL, ddCli)
+	case "kafka":
+		if labels != nil {
+			logger.Error("-enrich is not supported by the sink", "sink", *sinkType)
+			os.Exit(1)
+		}
+		brokers := strings.Split(*kafkaBrokers, ",")
+		for i := range brokers {
+			brokers[i] = strings.TrimSpace(brokers[i])
+		}
+		producer, err := kafka.NewProducer(context.Background(), logger, brokers, *kafkaTopic, kafka.Options{
+			Options:      sinkOpts,
+			Format:       *kafkaFormat,
+			PartitionBy:  *kafkaPartitionBy,
+			MetricPrefix: *metricPrefix,
+		})
+		if err != nil {
+			logger.Error("Could not create Kafka producer", "err", err)
+			os.Exit(1)
+		}
+		jobs = fileJobs(files, *kafkaTopic, producer)
+		closeSink = producer.Close
+	case "nats":
+		if labels != nil {
+			logger.Error("-enrich is not supported by the sink", "sink", *sinkType)
+			os.Exit(1)
+		}
+		publisher, err := nats.NewPublisher(context.Background(), logger, *natsURL, *natsSubject, nats.Options{
+			Options:      sinkOpts,
+			Format:       *natsFormat,
+			MetricPrefix: *metricPrefix,
+		})
+		if err != nil {
+			logger.Error("Could not create NATS publisher", "err", err)
+			os.Exit(1)
+		}
+		jobs = fileJobs(files, *natsSubject, publisher)
+		closeSink = publisher.Close
+	case "clickhouse":
+		if labels != nil {
+			logger.Error("-enrich is not supported by the sink", "sink", *sinkType)
+			os.Exit(1)
+		}
+		chCli, err := clickhouse.NewClient(context.Background(), logger, *clickhouseURL, *clickhouseTable, clickhouse.Options{
+			Options:  sinkOpts,
+			MaxConns: *insertConcurrency * *inflightPerLoader,
+			Engine:   *clickhouseEngine,
+		})
+		if err != nil {
+			logger.Error("Could not create ClickHouse client", "err", err)
+			os.Exit(1)
+		}
+		jobs = fileJobs(files, *clickhouseTable, chCli)
+	default:
+		logger.Error("Unsupported -sink", "value", *sinkType)
+		os.Exit(1)
+	}
+
+	var jrnl *journal.Journal
+	if *journalPath != "" {
+		jrnl, err = journal.Open(*journalPath)
+		if err != nil {
+			logger.Error("Could not open journal", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	hrs, err := parseHours(*hours)
+	if err != nil {
+		logger.Error("Could not parse -hours flag value", "err", err)
+		os.Exit(1)
+	}
+	from, err := parseTimeBound(*fromTime, false)
+	if err != nil {
+		logger.Error("Could not parse -from", "err", err)
+		os.Exit(1)
+	}
+	to, err := parseTimeBound(*toTime, true)
+	if err != nil {
+		logger.Error("Could not parse -to", "err", err)
+		os.Exit(1)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		logger.Error("-to must not be before -from", "from", *fromTime, "to", *toTime)
+		os.Exit(1)
+	}
+	if *every < 0 || *every%time.Millisecond != 0 {
+		logger.Error("-every must be a non-negative whole number of milliseconds", "value", *every)
+		os.Exit(1)
+	}
+	if *scanAhead < 1 {
+		logger.Error("-scanAhead must be positive", "value", *scanAhead)
+		os.Exit(1)
+	}
+	if *scanRows < 0 {
+		logger.Error("-scanRows must not be negative", "value", *scanRows)
+		os.Exit(1)
+	}
+	if *scanRows > 0 && (*pointsPath != "" || *targetResolution > 0 || *aggregateGrid || *markers) {
+		logger.Error("-scanRows cannot be combined with -points, -targetResolution, -aggregate or -markers")
+		os.Exit(1)
+	}
+	if *targetResolution < 0 {
+		logger.Error("-targetResolution must not be negative", "value", *targetResolution)
+		os.Exit(1)
+	}
+	if *targetResolution > 0 && *pointsPath != "" {
+		logger.Error("-targetResolution cannot be combined with -points")
+		os.Exit(1)
+	}
+	if *regridMethod != points.Mean && *regridMethod != points.Nearest {
+		logger.Error("Unsupported -regridMethod", "value", *regridMethod)
+		os.Exit(1)
+	}
+	if *spatialStride < 1 {
+		logger.Error("-spatialStride must be positive", "value", *spatialStride)
+		os.Exit(1)
+	}
+	var region *era5.BBox
+	if *bbox != "" {
+		region, err = era5.ParseBBox(*bbox)
+		if err != nil {
+			logger.Error("Could not parse -bbox", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	l := &loader{
+		jrnl:          jrnl,
+		concurrency:   *insertConcurrency,
+		inflight:      *inflightPerLoader,
+		recsPerInsert: *recsPerInsert,
+		sortBySeries:  *sortBySeries,
+		insertTimeout: *insertTimeout,
+		maxFailedRows: *maxFailedRows,
+		abort:         abort,
+	}
+	if *throttleMetricsURLs != "" {
+		maxInserts := *insertConcurrency * *inflightPerLoader
+		l.throttle = newThrottle(logger, *throttleMetricsURLs, maxInserts)
+		l.throttle.interval = *throttleInterval
+		l.throttle.maxSlowInsertsRatio = *throttleSlowInserts
+		l.throttle.maxMemoryUsage = *throttleMemoryUsage
+		l.throttle.maxPendingRows = *throttlePendingRows
+		go l.throttle.run(ctx)
+	}
+	e := &exporter{
+		logger:             logger,
+		l:                  l,
+		hours:              hrs,
+		from:               from,
+		to:                 to,
+		bbox:               region,
+		filter:             flt,
+		transforms:         tfs,
+		varNames:           varNames,
+		exportInfo:         *exportInfo,
+		runID:              *runID,
+		provenance:         provenanceNames,
+		dataset:            *datasetLabel,
+		points:             locs,
+		varMap:             varMap,
+		deaccumulate:       deaccVars,
+		accumulationResets: resetHours,
+	}
+	if *dedupTimestamps {
+		e.exported = make(map[string]map[int64]bool)
+	}
+	if e.runID == "" {
+		e.runID = newRunID()
+	}
+	if *markers {
+		if _, ok := jobInserter(jobs).(markerStore); !ok {
+			logger.Error("-markers is not supported by the sink", "sink", *sinkType)
+			os.Exit(1)
+		}
+		l.markers = true
+	}
+	if *aggregateGrid {
+		if _, ok := jobInserter(jobs).(summaryInserter); !ok {
+			logger.Error("-aggregate is not supported by the sink", "sink", *sinkType)
+			os.Exit(1)
+		}
+		e.quantiles, err = aggregate.ParseQuantiles(*aggregateQuantiles)
+		if err != nil {
+			logger.Error("Could not parse -aggregateQuantiles", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	if i := slices.IndexFunc(jobs, func(j exportJob) bool { return j.file == stdinFile }); i >= 0 {
+		// Stdin is read only once the setup has succeeded, so that a bad flag
+		// does not consume the input. At most one job reads it.
+		path, remove, err := spoolStdin()
+		if err != nil {
+			logger.Error("Could not spool stdin", "err", err)
+			os.Exit(1)
+		}
+		removeStdin = remove
+		logger.Info("Spooled stdin", "path", path)
+		jobs[i].file = path
+	}
+
+	var reports []*exportReport
+	failed := false
+	for _, job := range jobs {
+		rep := e.export(ctx, job, *scanners)
+		reports = append(reports, rep)
+		if rep.err != nil {
+			logger.Error("Could not export file", "file", job.file, "err", rep.err)
+			failed = true
+			continue
+		}
+		logger.Info("Export finished", append(append([]any{"file", job.file}, rep.logArgs()...),
+			"connReuseRatio", fmt.Sprintf("%.2f", vm.ConnReuseRatio()))...)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if len(jobs) > 1 {
+		logReports(logger, reports)
+	}
+	if err := closeSink(); err != nil {
+		logger.Error("Could not close sink", "err", err)
+	}
+	if jrnl != nil {
+		if err := jrnl.Close(); err != nil {
+			logger.Error("Could not close journal", "err", err)
+		}
+	}
+	removeStdin()
+	if ctx.Err() != nil || failed {
+		os.Exit(1)
+	}
+}
+
+// jobInserter returns the sink of the export jobs, which share its type. It
+// is nil if there are no jobs.
+func jobInserter(jobs []exportJob) sink.Inserter {
+	if len(jobs) == 0 {
+		return nil
+	}
+	return jobs[0].ins
+}
+
+// loadPoints reads the -points file. The returned table holds the labels of
+// the locations and is nil if the file has no label columns.
+func loadPoints(path string) ([]points.Location, *enrich.Table, error) {
+	t, err := enrich.Load(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	las, los, ok := t.Coordinates()
+	if !ok {
+		return nil, nil, errors.New("the locations must be latitudes and longitudes")
+	}
+	if len(las) == 0 {
+		return nil, nil, errors.New("no locations")
+	}
+	locs := make([]points.Location, len(las))
+	for i := range locs {
+		locs[i] = points.Location{La: las[i], Lo: los[i]}
+	}
+	if len(t.Names) == 0 {
+		t = nil
+	}
+	return locs, t, nil
+}
+
+// gridSetter is implemented by the sinks that pre-format the coordinates of
+// the dataset grid.
+type gridSetter interface {
+	SetGrid(latitudes, longitudes []float32)
+}
+
+// fillValuesSetter is implemented by the sinks that treat the fill values of
+// the variables specially.
+type fillValuesSetter interface {
+	SetFillValues(fill map[string]float64)
+}
+
+// varMetaSetter is implemented by the sinks that describe the metrics with
+// the units and the long names of the variables.
+type varMetaSetter interface {
+	SetVarMeta(meta map[string]era5.VarMeta)
+}
+
+// timeLabelsSetter is implemented by the sinks that label the records with
+// the auxiliary text coordinates of the time axis, such as expver.
+type timeLabelsSetter interface {
+	SetTimeLabels(names []string, values map[int64][]string)
+}
+
+// levelsSetter is implemented by the sinks that label the records with the
+// vertical level of the pressure-level datasets.
+type levelsSetter interface {
+	SetLevels(levels []float32)
+}
+
+// serveMetrics serves the exporter self-metrics in the Prometheus text format.
+func serveMetrics(logger *slog.Logger, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.WritePrometheus(w)
+	})
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.Error("Could not serve self-metrics", "addr", addr, "err", err)
+	}
+}
+
+// mb converts bytes to megabytes.
+func mb(bytes int64) float64 {
+	return float64(bytes) / (1 << 20)
 }
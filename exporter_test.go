@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// mainArgsEnv holds the newline-separated arguments the test binary runs main
+// with instead of the tests, so the tests can check how main exits.
+const mainArgsEnv = "ERA5_TEST_MAIN_ARGS"
+
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv(mainArgsEnv); ok {
+		os.Args = append([]string{os.Args[0]}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs main with the arguments in a child process and returns its
+// output and exit code.
+func runMain(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	return runMainStdin(t, nil, args...)
+}
+
+// runMainStdin is runMain with stdin read from the reader.
+func runMainStdin(t *testing.T, stdin io.Reader, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), mainArgsEnv+"="+strings.Join(args, "\n"))
+	cmd.Stdin = stdin
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return string(out), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("could not run main: %s", err)
+	}
+	return string(out), 0
+}
+
+func TestTenantMapWithoutFile(t *testing.T) {
+	dir := t.TempDir()
+	tenantMap := filepath.Join(dir, "tenants.txt")
+	missing := filepath.Join(dir, "missing.nc")
+	if err := os.WriteFile(tenantMap, []byte(missing+" 1:0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, code := runMain(t,
+		"-tenantMap", tenantMap,
+		"-vmInsertUrl", "http://localhost:8480/insert/{tenant}/influx/write",
+		"-exportInfo=false",
+	)
+	if strings.Contains(out, "panic") {
+		t.Fatalf("main panicked:\n%s", out)
+	}
+	if code != 1 {
+		t.Fatalf("unexpected exit code %d, want 1:\n%s", code, out)
+	}
+	if !strings.Contains(out, "Could not export file") || !strings.Contains(out, missing) {
+		t.Fatalf("the file of the tenant map is not exported:\n%s", out)
+	}
+}
+
+func TestDatasetNeedsRange(t *testing.T) {
+	out, code := runMain(t, "-dataset", "arco-era5", "-exportInfo=false")
+	if code != 1 {
+		t.Fatalf("unexpected exit code %d, want 1:\n%s", code, out)
+	}
+	if !strings.Contains(out, "-from and -to must be set") {
+		t.Fatalf("the missing time range is not reported:\n%s", out)
+	}
+}
+
+func TestMonthlyFiles(t *testing.T) {
+	from := time.Date(2023, 12, 15, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	got := monthlyFiles(datasets["era5-pds"], from, to, fileVarNames([]string{"t2m", "tp"}, mustParseVarMap(t, datasets["era5-pds"].flags["varMap"])))
+	want := strings.Join([]string{
+		"s3://era5-pds/2023/12/data/air_temperature_at_2_metres.nc",
+		"s3://era5-pds/2023/12/data/precipitation_amount_1hour_Accumulation.nc",
+		"s3://era5-pds/2024/01/data/air_temperature_at_2_metres.nc",
+		"s3://era5-pds/2024/01/data/precipitation_amount_1hour_Accumulation.nc",
+	}, ",")
+	if got != want {
+		t.Fatalf("got the files\n%s\nwant\n%s", got, want)
+	}
+}
+
+func mustParseVarMap(t *testing.T, s string) map[string]string {
+	t.Helper()
+	m, err := parseVarMap(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestTenantMapStdinTwice(t *testing.T) {
+	tenantMap := filepath.Join(t.TempDir(), "tenants.txt")
+	if err := os.WriteFile(tenantMap, []byte("- 1:0\n- 2:0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, code := runMainStdin(t, strings.NewReader("not read"),
+		"-tenantMap", tenantMap,
+		"-vmInsertUrl", "http://localhost:8480/insert/{tenant}/influx/write",
+		"-exportInfo=false",
+	)
+	if code != 1 || !strings.Contains(out, "already read by line 1") {
+		t.Fatalf("unexpected exit code %d, want 1 and the second - rejected:\n%s", code, out)
+	}
+}
+
+// TestTenantMapStdin checks that stdin may be read by any line of
+// -tenantMap and that the other lines keep their files.
+func TestTenantMapStdin(t *testing.T) {
+	file := writeFloatFile(t)
+	var mu sync.Mutex
+	inserts := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		inserts[r.URL.Path]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	tenantMap := filepath.Join(t.TempDir(), "tenants.txt")
+	if err := os.WriteFile(tenantMap, []byte(file+" 1:0\n- 2:0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	out, code := runMainStdin(t, stdin,
+		"-tenantMap", tenantMap,
+		"-vmInsertUrl", srv.URL+"/insert/{tenant}/influx/write",
+		"-variables", "t2m",
+		"-exportInfo=false",
+	)
+	if code != 0 {
+		t.Fatalf("unexpected exit code %d, want 0:\n%s", code, out)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, path := range []string{"/insert/1:0/influx/write", "/insert/2:0/influx/write"} {
+		if inserts[path] == 0 {
+			t.Errorf("got no inserts to %s, got %v:\n%s", path, inserts, out)
+		}
+	}
+}
+
+func TestScanProgress(t *testing.T) {
+	p := newScanProgress()
+	// A timestamp read in parts, one filtered out and one left unfinished.
+	st := p.scanner()
+	st.add(1, 3)
+	st.add(1, 2)
+	st.add(2, 0)
+	st.add(3, 4)
+	st.finish(context.Background(), &era5.Scanner{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	st = p.scanner()
+	st.add(4, 1)
+	st.add(5, 1)
+	st.finish(ctx, &era5.Scanner{})
+
+	want := map[int64]int{1: 5, 2: 0, 3: 4, 4: 1}
+	if got := p.scanned(); !maps.Equal(got, want) {
+		t.Fatalf("got the scanned timestamps %v, want %v", got, want)
+	}
+}
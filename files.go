@@ -0,0 +1,105 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// discoverVariables returns the data variables of a file like
+// era5.DiscoverVariables. Compressed files are decompressed to a temporary
+// file first.
+func discoverVariables(filePath, group string) ([]string, error) {
+	if comp, _ := era5.Compression(filePath); comp != "" {
+		path, remove, err := decompressFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		defer remove()
+		filePath = path
+	}
+	return era5.DiscoverVariables(filePath, group)
+}
+
+// expandFiles returns the files given by -file, which is a comma-separated
+// list of paths and glob patterns, ordered by the first timestamp they hold,
+// so that e.g. a year of monthly files is exported in the chronological
+// order. The files are ordered by name if any of them is compressed.
+// stdinFile is kept as is and cannot be combined with other files. The
+// variables varNames, or any data variables if it is nil, are looked up in
+// group to read the time ranges.
+func expandFiles(spec, group string, varNames []string) ([]string, error) {
+	if strings.TrimSpace(spec) == stdinFile {
+		return []string{stdinFile}, nil
+	}
+	var files []string
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if p == stdinFile {
+			return nil, fmt.Errorf("%s (stdin) cannot be combined with other files", stdinFile)
+		}
+		if era5.IsRemote(p) {
+			// Remote storages are not listed, so the URLs are taken as is.
+			if !slices.Contains(files, p) {
+				files = append(files, p)
+			}
+			continue
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		if matches == nil {
+			if !strings.ContainsAny(p, `*?[\`) {
+				// A missing file is reported when it is exported.
+				matches = []string{p}
+			} else {
+				return nil, fmt.Errorf("no files match %q", p)
+			}
+		}
+		for _, m := range matches {
+			if !slices.Contains(files, m) {
+				files = append(files, m)
+			}
+		}
+	}
+	if len(files) < 2 {
+		return files, nil
+	}
+	first := make(map[string]int64, len(files))
+	for _, f := range files {
+		if comp, _ := era5.Compression(f); comp != "" {
+			// Reading the time range of a compressed file takes
+			// decompressing it, so the files are ordered by name instead,
+			// which is chronological for the usual date-stamped names.
+			slices.Sort(files)
+			return files, nil
+		}
+		ts, _, err := era5.TimeRange(f, group, varNames)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the time range of %s: %w", f, err)
+		}
+		first[f] = ts
+	}
+	slices.SortStableFunc(files, func(a, b string) int {
+		return cmp.Or(cmp.Compare(first[a], first[b]), cmp.Compare(a, b))
+	})
+	return files, nil
+}
+
+// fileJobs returns the jobs exporting every file to the same sink.
+func fileJobs(files []string, target string, ins sink.Inserter) []exportJob {
+	jobs := make([]exportJob, len(files))
+	for i, f := range files {
+		jobs[i] = exportJob{file: f, target: target, ins: ins}
+	}
+	return jobs
+}
@@ -1,7 +1,10 @@
 module github.com/rtm0/era5
 
-go 1.22.2
+go 1.23
 
-require github.com/batchatco/go-native-netcdf v0.0.0-20230103061018-5849c1f424b1
+require (
+	github.com/batchatco/go-native-netcdf v0.0.0-20230103061018-5849c1f424b1
+	github.com/klauspost/compress v1.17.11
+)
 
 require github.com/batchatco/go-thrower v0.0.0-20200827035905-5cb7337f6be6 // indirect
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// runInspect implements the inspect command that prints the metadata of
+// files without exporting anything.
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	group := fs.String("group", "", "path of the NetCDF group holding the ERA5 variables. Default: the first group holding data variables, searched from the root")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s inspect [flags] file...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("no files given")
+	}
+	for i, path := range fs.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := inspectFile(os.Stdout, path, *group); err != nil {
+			return fmt.Errorf("could not inspect %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// inspectFile writes the metadata of a file to w: its dimensions, variables
+// with their attributes, time range, grid and the number of records and
+// series an export would produce.
+func inspectFile(w io.Writer, path, group string) error {
+	groupPath, infos, err := era5.Inspect(path, group)
+	if err != nil {
+		return err
+	}
+	varNames, err := era5.DiscoverVariables(path, group)
+	if err != nil {
+		return err
+	}
+	s, err := era5.NewScanner(path, era5.Options{Group: group, Variables: varNames})
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	fmt.Fprintf(w, "File: %s\n", path)
+	fmt.Fprintf(w, "Group: %s\n", groupPath)
+	fmt.Fprintln(w, "Dimensions:")
+	for _, info := range infos {
+		// The coordinates are the variables named after their dimension.
+		if len(info.Dimensions) == 1 && info.Dimensions[0] == info.Name {
+			fmt.Fprintf(w, "  %s = %d\n", info.Name, info.Len)
+		}
+	}
+	fmt.Fprintln(w, "Variables:")
+	for _, info := range infos {
+		fmt.Fprintf(w, "  %s %s(%s)\n", info.Type, info.Name, strings.Join(info.Dimensions, ", "))
+		for _, attr := range info.Attributes {
+			fmt.Fprintf(w, "    %s = %s\n", attr[0], attr[1])
+		}
+	}
+	fmt.Fprintf(w, "Data variables: %s\n", strings.Join(varNames, ", "))
+
+	ts := s.Timestamps()
+	if len(ts) > 0 {
+		first, last := time.UnixMilli(ts[0]).UTC(), time.UnixMilli(ts[len(ts)-1]).UTC()
+		step := ""
+		if len(ts) > 1 {
+			step = fmt.Sprintf(", step %s", time.Duration(ts[1]-ts[0])*time.Millisecond)
+		}
+		fmt.Fprintf(w, "Time range: %s .. %s (%d timestamps%s)\n", first.Format(time.RFC3339), last.Format(time.RFC3339), len(ts), step)
+	}
+	la, lo := s.Latitudes(), s.Longitudes()
+	fmt.Fprintf(w, "Latitudes: %d from %g to %g%s, %s\n", len(la), la[0], la[len(la)-1], resolution(la), s.LatitudeOrder())
+	fmt.Fprintf(w, "Longitudes: %d from %g to %g%s\n", len(lo), lo[0], lo[len(lo)-1], resolution(lo))
+	levels := s.Levels()
+	if len(levels) > 0 {
+		formatted := make([]string, len(levels))
+		for i, l := range levels {
+			formatted[i] = era5.FormatLevel(l)
+		}
+		fmt.Fprintf(w, "Levels: %d (%s)\n", len(levels), strings.Join(formatted, ", "))
+	}
+	series := max(len(levels), 1) * len(la) * len(lo)
+	fmt.Fprintf(w, "Series: %d per variable, %d in total\n", series, series*len(varNames))
+	fmt.Fprintf(w, "Records: %d, %d samples\n", s.TotalRecCount(), s.TotalRecCount()*len(varNames))
+	for _, warning := range s.Warnings() {
+		fmt.Fprintf(w, "Warning: %s\n", warning)
+	}
+	return nil
+}
+
+// resolution formats the spacing of evenly spaced coordinates. It is empty
+// for fewer than two coordinates.
+func resolution(coords []float32) string {
+	if len(coords) < 2 {
+		return ""
+	}
+	d := coords[1] - coords[0]
+	if d < 0 {
+		d = -d
+	}
+	return fmt.Sprintf(", resolution %g°", d)
+}
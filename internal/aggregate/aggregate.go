@@ -0,0 +1,123 @@
+// Package aggregate summarizes the values of the variables over the grid, so
+// that a few summary series per timestamp can be exported instead of a series
+// per grid point.
+package aggregate
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// Summary is a quantile of the values of every variable over the grid at a
+// timestamp.
+type Summary struct {
+	Timestamp int64
+	// Quantile is the level of the quantile, from 0 (the minimum) to 1 (the
+	// maximum).
+	Quantile float64
+	// Values holds the quantiles of the variables in the order of the record
+	// values. A value is NaN if the variable is missing at all the grid
+	// points.
+	Values []float64
+}
+
+// Aggregator computes the summaries of the records of a timestamp. It is safe
+// for concurrent use.
+type Aggregator struct {
+	quantiles  []float64
+	transforms *transform.Set
+	fill       []float64
+	hasFill    []bool
+}
+
+// ParseQuantiles parses a comma-separated list of quantile levels.
+func ParseQuantiles(s string) ([]float64, error) {
+	var qs []float64
+	for _, f := range strings.Split(s, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return nil, err
+		}
+		if q < 0 || q > 1 {
+			return nil, fmt.Errorf("quantile %g is out of range [0, 1]", q)
+		}
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
+
+// New creates an aggregator of the given quantiles of the variables varNames,
+// which are in the order of the record values. The quantiles are computed over
+// the values transformed by tfs. The values equal to the fill value of their
+// variable and the missing ones are skipped.
+func New(quantiles []float64, varNames []string, tfs *transform.Set, fill map[string]float64) *Aggregator {
+	a := &Aggregator{
+		quantiles:  quantiles,
+		transforms: tfs,
+		fill:       make([]float64, len(varNames)),
+		hasFill:    make([]bool, len(varNames)),
+	}
+	for i, name := range varNames {
+		a.fill[i], a.hasFill[i] = fill[name]
+	}
+	return a
+}
+
+// Summarize returns the summaries of every timestamp of the records.
+func (a *Aggregator) Summarize(recs []era5.Record) []Summary {
+	var sums []Summary
+	for len(recs) > 0 {
+		n := 1
+		for n < len(recs) && recs[n].Timestamp == recs[0].Timestamp {
+			n++
+		}
+		sums = a.summarize(sums, recs[:n])
+		recs = recs[n:]
+	}
+	return sums
+}
+
+// summarize appends the summaries of the records of a single timestamp to
+// dst.
+func (a *Aggregator) summarize(dst []Summary, recs []era5.Record) []Summary {
+	n := len(dst)
+	for _, q := range a.quantiles {
+		dst = append(dst, Summary{Timestamp: recs[0].Timestamp, Quantile: q, Values: make([]float64, len(a.fill))})
+	}
+	values := make([]float64, 0, len(recs))
+	for i := range a.fill {
+		values = values[:0]
+		for j := range recs {
+			v := recs[j].Values[i]
+			if era5.IsMissing(v) || a.hasFill[i] && v == a.fill[i] {
+				continue
+			}
+			values = append(values, a.transforms.Value(i, v))
+		}
+		slices.Sort(values)
+		for k, q := range a.quantiles {
+			dst[n+k].Values[i] = quantile(values, q)
+		}
+	}
+	return dst
+}
+
+// quantile returns the q-quantile of sorted values interpolating between the
+// closest ranks.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(len(sorted)-1)
+	lo := int(rank)
+	if lo >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lo] + (rank-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
@@ -0,0 +1,240 @@
+// Package cds retrieves data from the Copernicus Climate Data Store with its
+// API: a request is submitted as a job, the job is polled until the data is
+// ready and the result is downloaded.
+package cds
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultURL is the URL of the CDS API.
+const DefaultURL = "https://cds.climate.copernicus.eu/api"
+
+// Client submits requests to the CDS API.
+type Client struct {
+	logger *slog.Logger
+	url    string
+	key    string
+	hc     *http.Client
+	// PollInterval is how often the status of a job is checked.
+	PollInterval time.Duration
+}
+
+// NewClient creates a client of the API at url authorized with the personal
+// access token key. If key is empty, the URL and the key are read from the
+// CDSAPI_URL and CDSAPI_KEY environment variables or the ~/.cdsapirc file
+// of the cdsapi Python package.
+func NewClient(logger *slog.Logger, url, key string) (*Client, error) {
+	if key == "" {
+		var err error
+		url, key, err = loadCredentials(url)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if url == "" {
+		url = DefaultURL
+	}
+	if strings.Contains(key, ":") {
+		return nil, fmt.Errorf("the CDS key has the legacy UID:KEY form; use the personal access token from your CDS profile instead")
+	}
+	return &Client{
+		logger:       logger,
+		url:          strings.TrimSuffix(url, "/"),
+		key:          key,
+		hc:           &http.Client{},
+		PollInterval: 10 * time.Second,
+	}, nil
+}
+
+// loadCredentials reads the URL and the key of the API from the environment
+// or ~/.cdsapirc. url is returned as is if it is set.
+func loadCredentials(url string) (string, string, error) {
+	if key := os.Getenv("CDSAPI_KEY"); key != "" {
+		if url == "" {
+			url = os.Getenv("CDSAPI_URL")
+		}
+		return url, key, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", fmt.Errorf("CDSAPI_KEY is not set and the home directory is unknown: %w", err)
+	}
+	path := filepath.Join(home, ".cdsapirc")
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", fmt.Errorf("CDSAPI_KEY is not set and %s cannot be read: %w", path, err)
+	}
+	defer f.Close()
+	var rcURL, key string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "url":
+			rcURL = strings.TrimSpace(v)
+		case "key":
+			key = strings.TrimSpace(v)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", "", err
+	}
+	if key == "" {
+		return "", "", fmt.Errorf("%s has no key", path)
+	}
+	if url == "" {
+		url = rcURL
+	}
+	return url, key, nil
+}
+
+// job is the status of a submitted request.
+type job struct {
+	ID     string `json:"jobID"`
+	Status string `json:"status"`
+}
+
+// Retrieve submits the request for the dataset, waits for the result and
+// downloads it to path. The result is written to a temporary file first, so
+// path only appears once it is complete.
+func (c *Client) Retrieve(ctx context.Context, dataset string, request map[string]any, path string) error {
+	body, err := json.Marshal(map[string]any{"inputs": request})
+	if err != nil {
+		return err
+	}
+	var j job
+	if err := c.call(ctx, http.MethodPost, "/retrieve/v1/processes/"+dataset+"/execution", body, &j); err != nil {
+		return fmt.Errorf("could not submit the request: %w", err)
+	}
+	c.logger.Info("CDS request submitted", "jobId", j.ID, "status", j.Status)
+	status := j.Status
+	for status != "successful" {
+		switch status {
+		case "failed", "rejected", "dismissed", "deleted":
+			return fmt.Errorf("CDS job %s is %s: %s", j.ID, status, c.jobError(ctx, j.ID))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.PollInterval):
+		}
+		if err := c.call(ctx, http.MethodGet, "/retrieve/v1/jobs/"+j.ID, nil, &j); err != nil {
+			return fmt.Errorf("could not get the status of job %s: %w", j.ID, err)
+		}
+		if j.Status != status {
+			c.logger.Info("CDS job status changed", "jobId", j.ID, "status", j.Status)
+			status = j.Status
+		}
+	}
+	var res struct {
+		Asset struct {
+			Value struct {
+				Href string `json:"href"`
+				Size int64  `json:"file:size"`
+			} `json:"value"`
+		} `json:"asset"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/retrieve/v1/jobs/"+j.ID+"/results", nil, &res); err != nil {
+		return fmt.Errorf("could not get the results of job %s: %w", j.ID, err)
+	}
+	href := res.Asset.Value.Href
+	if href == "" {
+		return fmt.Errorf("the results of job %s have no download link", j.ID)
+	}
+	c.logger.Info("Downloading the CDS result", "jobId", j.ID, "url", href, "bytes", res.Asset.Value.Size)
+	return c.download(ctx, href, path)
+}
+
+// jobError returns the reason of a failed job as reported by its results.
+func (c *Client) jobError(ctx context.Context, id string) string {
+	var res struct {
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	}
+	err := c.call(ctx, http.MethodGet, "/retrieve/v1/jobs/"+id+"/results", nil, &res)
+	if err != nil {
+		return err.Error()
+	}
+	return strings.TrimSpace(res.Title + " " + res.Detail)
+}
+
+// call sends a request to the API and decodes the JSON response into dst.
+func (c *Client) call(ctx context.Context, method, path string, body []byte, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("PRIVATE-TOKEN", c.key)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 {
+		var apiErr struct {
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Title != "" {
+			return fmt.Errorf("unexpected status code %d: %s", res.StatusCode, strings.TrimSpace(apiErr.Title+" "+apiErr.Detail))
+		}
+		return fmt.Errorf("unexpected status code %d: %s", res.StatusCode, bytes.TrimSpace(data))
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return fmt.Errorf("could not parse the response: %w", err)
+	}
+	return nil
+}
+
+// download writes the contents at url to path.
+func (c *Client) download(ctx context.Context, url, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not download %s: unexpected status code %d", url, res.StatusCode)
+	}
+	tmp := path + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, res.Body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("could not download %s: %w", url, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,134 @@
+package cds
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeCDS serves a job that runs for the given number of polls and then ends
+// with the final status.
+func fakeCDS(t *testing.T, polls int, final string) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/download/j1.nc" && r.Header.Get("PRIVATE-TOKEN") != "token" {
+			http.Error(w, `{"title":"Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "POST /retrieve/v1/processes/reanalysis-era5-single-levels/execution":
+			var body struct {
+				Inputs map[string]any `json:"inputs"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Inputs["variable"] != "2m_temperature" {
+				t.Errorf("got the request body %v, %v", body, err)
+			}
+			w.Write([]byte(`{"jobID":"j1","status":"accepted"}`))
+		case "GET /retrieve/v1/jobs/j1":
+			status := "running"
+			if polls--; polls < 0 {
+				status = final
+			}
+			w.Write([]byte(`{"jobID":"j1","status":"` + status + `"}`))
+		case "GET /retrieve/v1/jobs/j1/results":
+			if final != "successful" {
+				http.Error(w, `{"title":"The job failed","detail":"no data for 1939"}`, http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"asset":{"value":{"href":"` + srv.URL + `/download/j1.nc","file:size":5}}}`))
+		case "GET /download/j1.nc":
+			w.Write([]byte("CDF\x01\x00"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestClient(t *testing.T, url string) *Client {
+	c, err := NewClient(slog.New(slog.NewTextHandler(io.Discard, nil)), url, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.PollInterval = time.Millisecond
+	return c
+}
+
+func TestRetrieve(t *testing.T) {
+	srv := fakeCDS(t, 2, "successful")
+	path := filepath.Join(t.TempDir(), "era5.nc")
+	err := newTestClient(t, srv.URL+"/").Retrieve(context.Background(), "reanalysis-era5-single-levels", map[string]any{"variable": "2m_temperature"}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "CDF\x01\x00" {
+		t.Fatalf("got the file %q", data)
+	}
+	if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Fatalf("the temporary file is left: %v", err)
+	}
+}
+
+func TestRetrieveErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "era5.nc")
+	request := map[string]any{"variable": "2m_temperature"}
+
+	srv := fakeCDS(t, 1, "failed")
+	err := newTestClient(t, srv.URL).Retrieve(context.Background(), "reanalysis-era5-single-levels", request, path)
+	if err == nil || !strings.Contains(err.Error(), "CDS job j1 is failed: unexpected status code 400: The job failed no data for 1939") {
+		t.Fatalf("got the error %v, want the reason of the failed job", err)
+	}
+
+	c := newTestClient(t, srv.URL)
+	c.key = "wrong"
+	err = c.Retrieve(context.Background(), "reanalysis-era5-single-levels", request, path)
+	if err == nil || !strings.Contains(err.Error(), "could not submit the request: unexpected status code 401: Unauthorized") {
+		t.Fatalf("got the error %v, want status 401", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	srv = fakeCDS(t, 1000, "successful")
+	if err := newTestClient(t, srv.URL).Retrieve(ctx, "reanalysis-era5-single-levels", request, path); err == nil {
+		t.Fatal("got no error for a canceled context")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("got a file for the failed retrievals: %v", err)
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("CDSAPI_KEY", "")
+	if err := os.WriteFile(filepath.Join(home, ".cdsapirc"), []byte("url: https://cds.example/api\nkey: rc-key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if url, key, err := loadCredentials(""); err != nil || url != "https://cds.example/api" || key != "rc-key" {
+		t.Fatalf("got %q, %q, %v from .cdsapirc", url, key, err)
+	}
+	if url, _, _ := loadCredentials("https://other/api"); url != "https://other/api" {
+		t.Fatalf("got the URL %q, want the one given", url)
+	}
+	t.Setenv("CDSAPI_KEY", "env-key")
+	t.Setenv("CDSAPI_URL", "https://env/api")
+	if url, key, err := loadCredentials(""); err != nil || url != "https://env/api" || key != "env-key" {
+		t.Fatalf("got %q, %q, %v from the environment", url, key, err)
+	}
+	if _, err := NewClient(slog.Default(), "", "123:abc"); err == nil || !strings.Contains(err.Error(), "legacy UID:KEY") {
+		t.Fatalf("got the error %v for a legacy key", err)
+	}
+}
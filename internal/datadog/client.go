@@ -0,0 +1,240 @@
+// Package datadog sends ERA5 records to the series submission API v2 of
+// Datadog.
+package datadog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rtm0/era5/internal/metrics"
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/internal/vm"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+var (
+	requestsSent     = metrics.NewCounter("era5_datadog_requests_total", "Datadog series requests sent, including retries")
+	requestsFailed   = metrics.NewCounter("era5_datadog_requests_failed_total", "Datadog series requests that failed with a network error or an unexpected status")
+	requestBytesSent = metrics.NewCounter("era5_datadog_request_bytes_total", "Compressed Datadog series request body bytes sent")
+)
+
+// DefaultURL is the series API of the US1 Datadog site.
+const DefaultURL = "https://api.datadoghq.com/api/v2/series"
+
+// maxPayloadSize limits the size of an uncompressed request body. Datadog
+// accepts up to 5 MB uncompressed and 512 KB compressed, which the JSON of
+// the series stays within when compressed at this size.
+const maxPayloadSize = 2 << 20
+
+// gaugeType is the type of the series in the payload.
+const gaugeType = 3
+
+// Client sends records to the Datadog series API. Every value of a record is
+// a gauge series with a single point tagged with the coordinates:
+//
+//	{"metric":"era5.t2m","type":3,"points":[{"timestamp":1672531200,"value":281.5}],"tags":["la:45.00","lo:7.25"]}
+type Client struct {
+	logger       *slog.Logger
+	poster       *sink.HTTPPoster
+	url          string
+	apiKey       string
+	names        [][]byte
+	transforms   *transform.Set
+	encoders     chan *encoder
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// Options controls how a Client encodes and sends records. Network errors,
+// 5xx and 429 responses are retried.
+type Options struct {
+	sink.Options
+
+	// APIKey authenticates the requests.
+	APIKey string
+
+	// MaxConns is the max number of concurrent connections.
+	MaxConns int
+
+	// MetricPrefix starts the names of all metrics, which are the names of
+	// the variables separated by a dot, as is customary in Datadog.
+	MetricPrefix string
+}
+
+var _ sink.Inserter = (*Client)(nil)
+
+// encoder holds the buffers of a single request.
+type encoder struct {
+	raw  []byte
+	body bytes.Buffer
+	zw   *gzip.Writer
+}
+
+// NewClient creates a new Datadog client of the series API at url.
+func NewClient(logger *slog.Logger, url string, opts Options) (*Client, error) {
+	if err := vm.CheckMetricPrefix(opts.MetricPrefix); err != nil {
+		return nil, err
+	}
+	if opts.APIKey == "" {
+		return nil, errors.New("the API key is not set")
+	}
+	maxConns := max(opts.MaxConns, 1)
+	varNames := opts.Variables
+	if len(varNames) == 0 {
+		varNames = era5.VarNames
+	}
+	c := &Client{
+		logger: logger,
+		poster: &sink.HTTPPoster{
+			Client: &http.Client{
+				Transport: &http.Transport{
+					Proxy: http.ProxyFromEnvironment,
+					DialContext: (&net.Dialer{
+						Timeout:   30 * time.Second,
+						KeepAlive: 30 * time.Second,
+					}).DialContext,
+					MaxIdleConns:        maxConns,
+					IdleConnTimeout:     30 * time.Second,
+					MaxIdleConnsPerHost: maxConns,
+					MaxConnsPerHost:     maxConns,
+					DisableCompression:  true,
+				},
+			},
+			RequestsSent:   requestsSent,
+			RequestsFailed: requestsFailed,
+		},
+		url:          url,
+		apiKey:       opts.APIKey,
+		names:        make([][]byte, len(varNames)),
+		transforms:   opts.Transforms,
+		encoders:     make(chan *encoder, maxConns),
+		maxRetries:   opts.MaxRetries,
+		retryBackoff: opts.RetryBackoff,
+	}
+	for i, name := range varNames {
+		c.names[i] = []byte(`{"metric":"` + opts.MetricPrefix + "." + name + `","type":` + strconv.Itoa(gaugeType) + `,"points":[{"timestamp":`)
+	}
+	for range maxConns {
+		c.encoders <- &encoder{zw: gzip.NewWriter(io.Discard)}
+	}
+	return c, nil
+}
+
+// InsertContext sends the records in as many requests as the payload size
+// limit of the API requires.
+func (c *Client) InsertContext(ctx context.Context, recs []era5.Record) (sink.Result, error) {
+	start := time.Now()
+	result := sink.Result{Rows: len(recs)}
+	err := c.insert(ctx, recs, &result)
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+func (c *Client) insert(ctx context.Context, recs []era5.Record, result *sink.Result) error {
+	var enc *encoder
+	select {
+	case enc = <-c.encoders:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { c.encoders <- enc }()
+
+	for len(recs) > 0 {
+		var n int
+		enc.raw, n = c.encode(enc.raw[:0], recs)
+		recs = recs[n:]
+		if err := c.send(ctx, enc, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode converts the records into a payload and appends it to dst. It
+// returns the number of records encoded, which are fewer than given if the
+// payload reaches maxPayloadSize. The samples whose values are not finite
+// are left out, since JSON cannot hold them.
+func (c *Client) encode(dst []byte, recs []era5.Record) ([]byte, int) {
+	dst = append(dst, `{"series":[`...)
+	sep := false
+	n := 0
+	for n < len(recs) && (n == 0 || len(dst) < maxPayloadSize) {
+		r := &recs[n]
+		n++
+		for i, v := range r.Values {
+			if c.transforms.Skip(v) {
+				continue
+			}
+			if x := c.transforms.Value(i, v); math.IsNaN(x) || math.IsInf(x, 0) {
+				continue
+			}
+			if sep {
+				dst = append(dst, ',')
+			}
+			sep = true
+			dst = append(dst, c.names[i]...)
+			// The timestamps are in seconds.
+			dst = strconv.AppendInt(dst, r.Timestamp/1000, 10)
+			dst = append(dst, `,"value":`...)
+			dst = c.transforms.AppendValue(dst, i, v)
+			dst = append(dst, `}],"tags":["la:`...)
+			dst = append(dst, vm.FormatCoord(r.Latitude)...)
+			dst = append(dst, `","lo:`...)
+			dst = append(dst, vm.FormatCoord(r.Longitude)...)
+			dst = append(dst, `"]}`...)
+		}
+	}
+	return append(dst, "]}"...), n
+}
+
+// send compresses the payload of enc and sends it in a single request,
+// retrying the retriable failures.
+func (c *Client) send(ctx context.Context, enc *encoder, result *sink.Result) error {
+	enc.body.Reset()
+	enc.zw.Reset(&enc.body)
+	if _, err := enc.zw.Write(enc.raw); err != nil {
+		return fmt.Errorf("could not compress data: %w", err)
+	}
+	if err := enc.zw.Close(); err != nil {
+		return fmt.Errorf("could not compress data: %w", err)
+	}
+	body := enc.body.Bytes()
+	result.RawBytes += len(enc.raw)
+
+	backoff := c.retryBackoff
+	for attempt := 1; ; attempt++ {
+		result.Attempts++
+		result.Bytes += len(body)
+		requestBytesSent.Add(len(body))
+		err := c.post(ctx, body, result)
+		if err == nil || attempt > c.maxRetries || !sink.IsRetriable(err) {
+			return err
+		}
+		c.logger.Warn("Retrying Datadog series request", "attempt", attempt, "in", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) post(ctx context.Context, body []byte, result *sink.Result) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Encoding", "gzip")
+	header.Set("DD-API-KEY", c.apiKey)
+	return c.poster.Post(ctx, c.url, header, bytes.NewReader(body), result)
+}
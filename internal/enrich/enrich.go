@@ -0,0 +1,233 @@
+// Package enrich joins extra labels to the grid points of a dataset.
+package enrich
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// Table holds the extra labels of locations read from a CSV file. The
+// locations are either coordinates, which are matched to the closest grid
+// point, or geohash cells, which match all the grid points inside them.
+type Table struct {
+	// Names are the names of the extra labels, in the order of the CSV
+	// columns.
+	Names []string
+	rows  []row
+	// byGeohash is true if the locations are geohash cells.
+	byGeohash bool
+}
+
+type row struct {
+	la, lo  float64
+	geohash string
+	values  []string
+}
+
+// Point is a grid point.
+type Point struct {
+	La, Lo float32
+}
+
+var labelNameRE = regexp.MustCompile(`^[a-z][a-zA-Z0-9_]*$`)
+
+// keyColumns lists the accepted names of the latitude and longitude
+// columns.
+var keyColumns = [][2]string{{"la", "lo"}, {"lat", "lon"}, {"latitude", "longitude"}}
+
+// Load reads the table from a CSV file with a header row. The file must have
+// either a geohash column or a pair of latitude and longitude columns named
+// la/lo, lat/lon or latitude/longitude. All other columns become labels.
+func Load(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	t := &Table{}
+	laCol, loCol, ghCol := -1, -1, slices.Index(header, "geohash")
+	t.byGeohash = ghCol >= 0
+	if !t.byGeohash {
+		for _, k := range keyColumns {
+			laCol, loCol = slices.Index(header, k[0]), slices.Index(header, k[1])
+			if laCol >= 0 && loCol >= 0 {
+				break
+			}
+		}
+		if laCol < 0 || loCol < 0 {
+			return nil, errors.New("neither geohash nor latitude and longitude columns are found")
+		}
+	}
+	var labelCols []int
+	for i, name := range header {
+		if i == laCol || i == loCol || i == ghCol {
+			continue
+		}
+		if !labelNameRE.MatchString(name) || name == "la" || name == "lo" {
+			return nil, fmt.Errorf("column %q cannot be used as a label name", name)
+		}
+		t.Names = append(t.Names, name)
+		labelCols = append(labelCols, i)
+	}
+
+	for line := 2; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var rw row
+		if t.byGeohash {
+			rw.geohash = strings.ToLower(strings.TrimSpace(rec[ghCol]))
+			if _, err := parseGeohash(rw.geohash); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		} else {
+			rw.la, err = strconv.ParseFloat(strings.TrimSpace(rec[laCol]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid latitude: %w", line, err)
+			}
+			rw.lo, err = strconv.ParseFloat(strings.TrimSpace(rec[loCol]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid longitude: %w", line, err)
+			}
+		}
+		for _, i := range labelCols {
+			rw.values = append(rw.values, strings.TrimSpace(rec[i]))
+		}
+		t.rows = append(t.rows, rw)
+	}
+	return t, nil
+}
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// parseGeohash returns the bits of a geohash in the base32 text form.
+func parseGeohash(s string) (uint64, error) {
+	if len(s) == 0 || len(s) > 12 {
+		return 0, fmt.Errorf("geohash %q must have 1 to 12 characters", s)
+	}
+	var h uint64
+	for _, c := range []byte(s) {
+		n := strings.IndexByte(geohashAlphabet, c)
+		if n < 0 {
+			return 0, fmt.Errorf("invalid geohash %q", s)
+		}
+		h = h<<5 | uint64(n)
+	}
+	return h, nil
+}
+
+// Coordinates returns the latitudes and longitudes of the locations in the
+// order of the CSV rows. ok is false if the locations are geohash cells.
+func (t *Table) Coordinates() (latitudes, longitudes []float64, ok bool) {
+	if t.byGeohash {
+		return nil, nil, false
+	}
+	for _, rw := range t.rows {
+		latitudes = append(latitudes, rw.la)
+		longitudes = append(longitudes, rw.lo)
+	}
+	return latitudes, longitudes, true
+}
+
+// Match returns the label values of the grid points that have them. The
+// values are in the order of Names and empty for the labels the point does
+// not have.
+func (t *Table) Match(latitudes, longitudes []float32) map[Point][]string {
+	m := make(map[Point][]string)
+	if t.byGeohash {
+		t.matchGeohashes(m, latitudes, longitudes)
+		return m
+	}
+	// A location is only matched if the closest grid point is no further
+	// than a grid step from it, so locations outside of the dataset area
+	// are not attached to its edge. The tolerance covers the float32
+	// rounding of a grid made of the locations themselves.
+	const tolerance = 1e-4
+	laStep, loStep := gridStep(latitudes)+tolerance, gridStep(longitudes)+tolerance
+	for _, rw := range t.rows {
+		la, laDist := closest(latitudes, rw.la, false)
+		lo, loDist := closest(longitudes, rw.lo, true)
+		if laDist > laStep || loDist > loStep {
+			continue
+		}
+		m[Point{La: la, Lo: lo}] = rw.values
+	}
+	return m
+}
+
+// matchGeohashes attaches to every grid point the labels of the longest
+// geohash cell it belongs to.
+func (t *Table) matchGeohashes(m map[Point][]string, latitudes, longitudes []float32) {
+	cells := make(map[int]map[uint64][]string)
+	maxLen := 0
+	for _, rw := range t.rows {
+		h, _ := parseGeohash(rw.geohash)
+		n := len(rw.geohash)
+		if cells[n] == nil {
+			cells[n] = make(map[uint64][]string)
+		}
+		cells[n][h] = rw.values
+		maxLen = max(maxLen, n)
+	}
+	for _, la := range latitudes {
+		for _, lo := range longitudes {
+			h := era5.Geohash(la, lo)
+			for n := maxLen; n > 0; n-- {
+				if values, ok := cells[n][h>>(64-5*n)]; ok {
+					m[Point{La: la, Lo: lo}] = values
+					break
+				}
+			}
+		}
+	}
+}
+
+// gridStep returns the largest distance between the neighbour coordinates.
+func gridStep(coords []float32) float64 {
+	step := 0.0
+	for i := 1; i < len(coords); i++ {
+		step = max(step, math.Abs(float64(coords[i]-coords[i-1])))
+	}
+	return step
+}
+
+// closest returns the coordinate closest to v and the distance to it.
+// Longitudes are compared modulo 360 degrees.
+func closest(coords []float32, v float64, wrap bool) (float32, float64) {
+	var best float32
+	bestDist := math.Inf(1)
+	for _, c := range coords {
+		d := math.Abs(float64(c) - v)
+		if wrap {
+			d = math.Mod(d, 360)
+			d = min(d, 360-d)
+		}
+		if d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best, bestDist
+}
@@ -0,0 +1,364 @@
+// Package expr implements the small expression language used to transform
+// and filter the exported values.
+//
+// An expression is an arithmetic formula of named variables, e.g.
+// "(x - 273.15)*9/5 + 32". It may use numbers, the operators + - * / ^,
+// parentheses and the functions abs, ceil, exp, floor, log, max, min, pow,
+// round and sqrt. The comparison operators == != < <= > >= and the logical
+// operators && || ! evaluate to 1 for true and 0 for false, and any non-zero
+// value is true.
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Func evaluates an expression in the environment E, which holds the values
+// of the variables.
+type Func[E any] func(env E) float64
+
+// Vars resolves the name of a variable to the function that reads its value
+// from the environment. It returns false for unknown variables.
+type Vars[E any] func(name string) (Func[E], bool)
+
+// Compile parses an expression into a Func.
+func Compile[E any](src string, vars Vars[E]) (Func[E], error) {
+	p := &parser[E]{src: src, vars: vars}
+	p.next()
+	fn, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != tokEOF {
+		return nil, p.errorf("unexpected %s", p.text)
+	}
+	return fn, nil
+}
+
+type token int
+
+const (
+	tokEOF token = iota
+	tokNum
+	tokIdent
+	tokOp
+)
+
+// parser is a recursive descent parser that turns an expression into a tree
+// of closures, so evaluating it does not need to interpret the source.
+type parser[E any] struct {
+	src  string
+	vars Vars[E]
+	pos  int
+	// start is the position of the current token.
+	start int
+	tok   token
+	text  string
+	num   float64
+	err   error
+}
+
+func (p *parser[E]) errorf(format string, args ...any) error {
+	return fmt.Errorf("at position %d: %s", p.start+1, fmt.Sprintf(format, args...))
+}
+
+// twoCharOps lists the operators made of two characters.
+var twoCharOps = []string{"&&", "||", "==", "!=", "<=", ">="}
+
+// next reads the next token.
+func (p *parser[E]) next() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+	start := p.pos
+	p.start = start
+	if p.pos == len(p.src) {
+		p.tok, p.text = tokEOF, "end of expression"
+		return
+	}
+	c := p.src[p.pos]
+	switch {
+	case isDigit(c) || c == '.':
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.' ||
+			(p.src[p.pos] == 'e' || p.src[p.pos] == 'E') ||
+			(p.src[p.pos] == '-' || p.src[p.pos] == '+') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E')) {
+			p.pos++
+		}
+		p.tok, p.text = tokNum, p.src[start:p.pos]
+		var err error
+		if p.num, err = strconv.ParseFloat(p.text, 64); err != nil && p.err == nil {
+			p.err = p.errorf("invalid number %s", p.text)
+		}
+	case isLetter(c):
+		for p.pos < len(p.src) && (isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok, p.text = tokIdent, p.src[start:p.pos]
+	default:
+		p.pos++
+		for _, op := range twoCharOps {
+			if op[0] == c && p.pos < len(p.src) && op[1] == p.src[p.pos] {
+				p.pos++
+				break
+			}
+		}
+		p.tok, p.text = tokOp, p.src[start:p.pos]
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func (p *parser[E]) isOp(ops ...string) bool {
+	if p.tok != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if p.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func truth(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// or parses a disjunction of conjunctions.
+func (p *parser[E]) or() (Func[E], error) {
+	fn, err := p.and()
+	for err == nil && p.isOp("||") {
+		p.next()
+		var rhs Func[E]
+		if rhs, err = p.and(); err != nil {
+			break
+		}
+		lhs := fn
+		fn = func(env E) float64 { return truth(lhs(env) != 0 || rhs(env) != 0) }
+	}
+	return fn, err
+}
+
+// and parses a conjunction of comparisons.
+func (p *parser[E]) and() (Func[E], error) {
+	fn, err := p.comparison()
+	for err == nil && p.isOp("&&") {
+		p.next()
+		var rhs Func[E]
+		if rhs, err = p.comparison(); err != nil {
+			break
+		}
+		lhs := fn
+		fn = func(env E) float64 { return truth(lhs(env) != 0 && rhs(env) != 0) }
+	}
+	return fn, err
+}
+
+// comparison parses a sum optionally compared to another one.
+func (p *parser[E]) comparison() (Func[E], error) {
+	lhs, err := p.sum()
+	if err != nil || !p.isOp("==", "!=", "<", "<=", ">", ">=") {
+		return lhs, err
+	}
+	op := p.text
+	p.next()
+	rhs, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "==":
+		return func(env E) float64 { return truth(lhs(env) == rhs(env)) }, nil
+	case "!=":
+		return func(env E) float64 { return truth(lhs(env) != rhs(env)) }, nil
+	case "<":
+		return func(env E) float64 { return truth(lhs(env) < rhs(env)) }, nil
+	case "<=":
+		return func(env E) float64 { return truth(lhs(env) <= rhs(env)) }, nil
+	case ">":
+		return func(env E) float64 { return truth(lhs(env) > rhs(env)) }, nil
+	default:
+		return func(env E) float64 { return truth(lhs(env) >= rhs(env)) }, nil
+	}
+}
+
+// sum parses a sum of terms.
+func (p *parser[E]) sum() (Func[E], error) {
+	fn, err := p.term()
+	for err == nil && p.isOp("+", "-") {
+		op := p.text
+		p.next()
+		var rhs Func[E]
+		if rhs, err = p.term(); err != nil {
+			break
+		}
+		lhs := fn
+		if op == "+" {
+			fn = func(env E) float64 { return lhs(env) + rhs(env) }
+		} else {
+			fn = func(env E) float64 { return lhs(env) - rhs(env) }
+		}
+	}
+	return fn, err
+}
+
+// term parses a product of factors.
+func (p *parser[E]) term() (Func[E], error) {
+	fn, err := p.unary()
+	for err == nil && p.isOp("*", "/") {
+		op := p.text
+		p.next()
+		var rhs Func[E]
+		if rhs, err = p.unary(); err != nil {
+			break
+		}
+		lhs := fn
+		if op == "*" {
+			fn = func(env E) float64 { return lhs(env) * rhs(env) }
+		} else {
+			fn = func(env E) float64 { return lhs(env) / rhs(env) }
+		}
+	}
+	return fn, err
+}
+
+// unary parses a factor with optional signs and negations.
+func (p *parser[E]) unary() (Func[E], error) {
+	if !p.isOp("-", "+", "!") {
+		return p.power()
+	}
+	op := p.text
+	p.next()
+	fn, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "-":
+		return func(env E) float64 { return -fn(env) }, nil
+	case "!":
+		return func(env E) float64 { return truth(fn(env) == 0) }, nil
+	default:
+		return fn, nil
+	}
+}
+
+// power parses a primary raised to an optional right-associative power.
+func (p *parser[E]) power() (Func[E], error) {
+	base, err := p.primary()
+	if err != nil || !p.isOp("^") {
+		return base, err
+	}
+	p.next()
+	exp, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return func(env E) float64 { return math.Pow(base(env), exp(env)) }, nil
+}
+
+// functions lists the functions an expression may call. The type of a
+// function tells its number of arguments.
+var functions = map[string]any{
+	"abs":   math.Abs,
+	"ceil":  math.Ceil,
+	"exp":   math.Exp,
+	"floor": math.Floor,
+	"log":   math.Log,
+	"round": math.Round,
+	"sqrt":  math.Sqrt,
+	"max":   math.Max,
+	"min":   math.Min,
+	"pow":   math.Pow,
+}
+
+func (p *parser[E]) primary() (Func[E], error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	switch p.tok {
+	case tokNum:
+		v := p.num
+		p.next()
+		return func(E) float64 { return v }, nil
+	case tokIdent:
+		name := p.text
+		if v, ok := p.vars(name); ok {
+			p.next()
+			return v, nil
+		}
+		f, ok := functions[name]
+		if !ok {
+			return nil, p.errorf("unknown identifier %s", name)
+		}
+		p.next()
+		args, err := p.args(name)
+		if err != nil {
+			return nil, err
+		}
+		switch f := f.(type) {
+		case func(float64) float64:
+			if len(args) != 1 {
+				return nil, fmt.Errorf("%s takes 1 argument", name)
+			}
+			a := args[0]
+			return func(env E) float64 { return f(a(env)) }, nil
+		case func(float64, float64) float64:
+			if len(args) != 2 {
+				return nil, fmt.Errorf("%s takes 2 arguments", name)
+			}
+			a, b := args[0], args[1]
+			return func(env E) float64 { return f(a(env), b(env)) }, nil
+		}
+	case tokOp:
+		if p.text == "(" {
+			p.next()
+			fn, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if !p.isOp(")") {
+				return nil, p.errorf("expected ) instead of %s", p.text)
+			}
+			p.next()
+			return fn, nil
+		}
+	}
+	return nil, p.errorf("unexpected %s", p.text)
+}
+
+// args parses the parenthesized arguments of a function call.
+func (p *parser[E]) args(name string) ([]Func[E], error) {
+	if !p.isOp("(") {
+		return nil, p.errorf("expected ( after %s", name)
+	}
+	p.next()
+	var args []Func[E]
+	for {
+		a, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		switch {
+		case p.isOp(","):
+			p.next()
+		case p.isOp(")"):
+			p.next()
+			return args, nil
+		default:
+			return nil, p.errorf("expected , or ) instead of %s", p.text)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package expr
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// vars resolves x to the environment and y to twice its value.
+func vars(name string) (Func[float64], bool) {
+	switch name {
+	case "x":
+		return func(x float64) float64 { return x }, true
+	case "y":
+		return func(x float64) float64 { return 2 * x }, true
+	}
+	return nil, false
+}
+
+func TestEval(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		x    float64
+		want float64
+	}{
+		{"42", 0, 42},
+		{"1.5e3 + .5", 0, 1500.5},
+		{"2.5E-1", 0, 0.25},
+		{"x", 7, 7},
+		{"(x - 273.15)*9/5 + 32", 273.15, 32},
+		{"x + y", 3, 9},
+
+		// Precedence and associativity.
+		{"2 + 3*4", 0, 14},
+		{"(2 + 3)*4", 0, 20},
+		{"10 - 2 - 3", 0, 5},
+		{"8/4/2", 0, 1},
+		{"2^3^2", 0, 512},
+		{"2*3^2", 0, 18},
+		{"1 + 2 < 4", 0, 1},
+		{"1 || 0 && 0", 0, 1},
+		{"(1 || 0) && 0", 0, 0},
+		{"x > 1 && x < 3", 2, 1},
+		{"x > 1 && x < 3", 3, 0},
+
+		// Unary operators.
+		{"-x", 3, -3},
+		{"- -3", 0, 3},
+		{"+3", 0, 3},
+		{"-2^2", 0, -4},
+		{"2^-1", 0, 0.5},
+		{"2 * -3", 0, -6},
+		{"!0 + 1", 0, 2},
+		{"!x", 5, 0},
+		{"!!x", 5, 1},
+
+		// Comparisons.
+		{"1 == 1", 0, 1},
+		{"1 != 1", 0, 0},
+		{"1 <= 1", 0, 1},
+		{"1 >= 2", 0, 0},
+		{"2 > 1", 0, 1},
+
+		// Functions.
+		{"abs(-2)", 0, 2},
+		{"ceil(1.2) + floor(1.8)", 0, 3},
+		{"round(2.5)", 0, 3},
+		{"sqrt(16)", 0, 4},
+		{"exp(0) + log(1)", 0, 1},
+		{"max(x, 3) - min(x, 3)", 5, 2},
+		{"pow(2, 1 + 2)", 0, 8},
+
+		// Division by zero follows IEEE 754.
+		{"1/0", 0, math.Inf(1)},
+		{"-1/0", 0, math.Inf(-1)},
+	} {
+		fn, err := Compile(tc.src, vars)
+		if err != nil {
+			t.Errorf("Compile(%q): %s", tc.src, err)
+			continue
+		}
+		if got := fn(tc.x); got != tc.want {
+			t.Errorf("%q with x = %v is %v, want %v", tc.src, tc.x, got, tc.want)
+		}
+	}
+	fn, err := Compile("0/0", vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fn(0); !math.IsNaN(got) {
+		t.Errorf("0/0 is %v, want NaN", got)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"", "at position 1: unexpected end of expression"},
+		{"z + 1", "at position 1: unknown identifier z"},
+		{"x + zz", "at position 5: unknown identifier zz"},
+		{"1 +", "at position 4: unexpected end of expression"},
+		{"(1 + 2", "at position 7: expected ) instead of end of expression"},
+		{"1 2", "at position 3: unexpected 2"},
+		{"1 + 2)", "at position 6: unexpected )"},
+		{"1..2", "at position 1: invalid number 1..2"},
+		{"1 $ 2", "at position 3: unexpected $"},
+		{"1 < 2 == 1", "at position 7: unexpected =="},
+		{"abs 1", "at position 5: expected ( after abs"},
+		{"abs(1, 2)", "abs takes 1 argument"},
+		{"max(1)", "max takes 2 arguments"},
+		{"max(1; 2)", "at position 6: expected , or ) instead of ;"},
+	} {
+		_, err := Compile(tc.src, vars)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Compile(%q) = %v, want %q", tc.src, err, tc.want)
+		}
+	}
+}
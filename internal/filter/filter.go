@@ -0,0 +1,65 @@
+// Package filter selects the exported records with the conditions written in
+// the language of the expr package, e.g. "la > 35 && la < 72 && t2m < 253".
+package filter
+
+import (
+	"time"
+
+	"github.com/rtm0/era5/internal/expr"
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// Filter keeps the records a condition is true for.
+type Filter struct {
+	cond expr.Func[*era5.Record]
+}
+
+// coords lists the variables that describe where and when a record is.
+var coords = map[string]expr.Func[*era5.Record]{
+	"la":    func(r *era5.Record) float64 { return float64(r.Latitude) },
+	"lo":    func(r *era5.Record) float64 { return float64(r.Longitude) },
+	"level": func(r *era5.Record) float64 { return float64(r.Level) },
+	"hour": func(r *era5.Record) float64 {
+		return float64(time.UnixMilli(r.Timestamp).UTC().Hour())
+	},
+	"month": func(r *era5.Record) float64 {
+		return float64(time.UnixMilli(r.Timestamp).UTC().Month())
+	},
+}
+
+// Parse parses a condition. It may refer to the coordinates la, lo and level,
+// which is zero if the file has no vertical dimension, the UTC hour and month
+// of the record, and the variables by their names in varNames, which are in
+// the order of the record values. The values of the variables are the
+// exported ones, i.e. the ones transformed by tfs.
+func Parse(cond string, varNames []string, tfs *transform.Set) (*Filter, error) {
+	fn, err := expr.Compile(cond, func(name string) (expr.Func[*era5.Record], bool) {
+		if fn, ok := coords[name]; ok {
+			return fn, true
+		}
+		for i, n := range varNames {
+			if n == name {
+				return func(r *era5.Record) float64 { return tfs.Value(i, r.Values[i]) }, true
+			}
+		}
+		return nil, false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{cond: fn}, nil
+}
+
+// Apply removes the records the condition is false for from recs in place
+// and returns the remaining ones.
+func (f *Filter) Apply(recs []era5.Record) []era5.Record {
+	n := 0
+	for i := range recs {
+		if f.cond(&recs[i]) != 0 {
+			recs[n] = recs[i]
+			n++
+		}
+	}
+	return recs[:n]
+}
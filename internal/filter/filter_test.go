@@ -0,0 +1,78 @@
+package filter
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+func TestApply(t *testing.T) {
+	ts := time.Date(2024, 7, 1, 6, 0, 0, 0, time.UTC).UnixMilli()
+	recs := []era5.Record{
+		{Timestamp: ts, Latitude: 40, Longitude: -3, Values: []float64{250, 1}},
+		{Timestamp: ts, Latitude: 30, Longitude: -3, Values: []float64{250, 2}},
+		{Timestamp: ts + 3600e3, Latitude: 50, Longitude: 10, Level: 850, Values: []float64{300, 3}},
+		{Timestamp: ts, Latitude: 60, Longitude: 20, Values: []float64{240, 4}},
+	}
+	tfs, err := transform.Parse("t2m: x - 273.15", []string{"t2m", "tp"}, -1, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		cond string
+		tfs  *transform.Set
+		want []float64
+	}{
+		{"la > 35 && la < 72", nil, []float64{1, 3, 4}},
+		{"la > 35 && t2m < 253", nil, []float64{1, 4}},
+		{"t2m < -20", tfs, []float64{1, 2, 4}},
+		{"lo < 0 || tp == 4", nil, []float64{1, 2, 4}},
+		{"level == 850", nil, []float64{3}},
+		{"hour == 7", nil, []float64{3}},
+		{"month == 7 && !(hour == 7)", nil, []float64{1, 2, 4}},
+		{"0", nil, nil},
+	} {
+		f, err := Parse(tc.cond, []string{"t2m", "tp"}, tc.tfs)
+		if err != nil {
+			t.Fatalf("Parse(%q): %s", tc.cond, err)
+		}
+		got := f.Apply(append([]era5.Record(nil), recs...))
+		var tps []float64
+		for _, r := range got {
+			tps = append(tps, r.Values[1])
+		}
+		if !slices.Equal(tps, tc.want) {
+			t.Errorf("%q kept the records %v, want %v", tc.cond, tps, tc.want)
+		}
+	}
+}
+
+func TestApplyInPlace(t *testing.T) {
+	f, err := Parse("la > 0", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs := []era5.Record{{Latitude: -1}, {Latitude: 1}, {Latitude: 2}}
+	got := f.Apply(recs)
+	if len(got) != 2 || &got[0] != &recs[0] || recs[0].Latitude != 1 || recs[1].Latitude != 2 {
+		t.Fatalf("got the records %v in %v, want the ones at 1 and 2 moved to the front", got, recs)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		cond, want string
+	}{
+		{"sp > 1000", "unknown identifier sp"},
+		{"la >", "unexpected end of expression"},
+		{"la > 35 &&", "unexpected end of expression"},
+	} {
+		if _, err := Parse(tc.cond, []string{"t2m"}, nil); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Parse(%q) = %v, want %q", tc.cond, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Package graphite sends ERA5 records with the Graphite plaintext protocol
+// over TCP, which is accepted by Graphite and by the Graphite listener of
+// Victoria Metrics.
+package graphite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/rtm0/era5/internal/metrics"
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/internal/vm"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+var (
+	writesFailed = metrics.NewCounter("era5_graphite_writes_failed_total", "Graphite writes that failed, after which the connection is dialed again")
+	bytesSent    = metrics.NewCounter("era5_graphite_bytes_total", "Graphite plaintext bytes written, including retries")
+)
+
+// Client sends records to a Graphite plaintext listener. Every value of a
+// record is a line of a tagged series with the coordinates as tags:
+//
+//	era5_t2m;la=45.00;lo=7.25 281.5 1672531200
+//
+// The connections are dialed when they are first needed and dialed again
+// after a failed write.
+type Client struct {
+	logger       *slog.Logger
+	addr         string
+	dialer       net.Dialer
+	timeout      time.Duration
+	names        []string
+	transforms   *transform.Set
+	conns        chan *conn
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// Options controls how a Client encodes and sends records. A failed write is
+// repeated on a new connection.
+type Options struct {
+	sink.Options
+
+	// MaxConns is the max number of concurrent connections.
+	MaxConns int
+
+	// MetricPrefix is added to the names of all metrics.
+	MetricPrefix string
+
+	// Timeout limits dialing and every write. Zero means 30 seconds.
+	Timeout time.Duration
+}
+
+var _ sink.Inserter = (*Client)(nil)
+
+// conn is a connection and the buffer the records written to it are
+// encoded into.
+type conn struct {
+	c   net.Conn
+	buf []byte
+}
+
+// NewClient creates a new Graphite client of the plaintext listener at addr,
+// e.g. localhost:2003.
+func NewClient(logger *slog.Logger, addr string, opts Options) (*Client, error) {
+	if err := vm.CheckMetricPrefix(opts.MetricPrefix); err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	varNames := opts.Variables
+	if len(varNames) == 0 {
+		varNames = era5.VarNames
+	}
+	c := &Client{
+		logger:       logger,
+		addr:         addr,
+		timeout:      opts.Timeout,
+		names:        make([]string, len(varNames)),
+		transforms:   opts.Transforms,
+		conns:        make(chan *conn, max(opts.MaxConns, 1)),
+		maxRetries:   opts.MaxRetries,
+		retryBackoff: opts.RetryBackoff,
+	}
+	if c.timeout <= 0 {
+		c.timeout = 30 * time.Second
+	}
+	c.dialer.Timeout = c.timeout
+	for i, name := range varNames {
+		c.names[i] = opts.MetricPrefix + "_" + name
+	}
+	for range cap(c.conns) {
+		c.conns <- &conn{}
+	}
+	return c, nil
+}
+
+// InsertContext writes the records to one of the connections, repeating the
+// write on a new connection if it fails. A failed write may have been
+// partially received, so its retries may duplicate some of the samples,
+// which Graphite and Victoria Metrics store only once.
+func (c *Client) InsertContext(ctx context.Context, recs []era5.Record) (sink.Result, error) {
+	start := time.Now()
+	result := sink.Result{Rows: len(recs)}
+	err := c.insert(ctx, recs, &result)
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+func (c *Client) insert(ctx context.Context, recs []era5.Record, result *sink.Result) error {
+	var cn *conn
+	select {
+	case cn = <-c.conns:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { c.conns <- cn }()
+
+	cn.buf = c.encode(cn.buf[:0], recs)
+	result.RawBytes = len(cn.buf)
+
+	backoff := c.retryBackoff
+	for attempt := 1; ; attempt++ {
+		result.Attempts++
+		n, err := c.write(ctx, cn)
+		result.Bytes += n
+		bytesSent.Add(n)
+		if err == nil {
+			return nil
+		}
+		writesFailed.Inc()
+		if n > 0 {
+			result.AmbiguousAttempts++
+		}
+		if attempt > c.maxRetries || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		c.logger.Warn("Retrying Graphite write", "attempt", attempt, "in", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// write writes the buffer of cn to its connection, dialing it first if
+// needed. The connection is closed if the write fails, so the next write
+// dials a new one.
+func (c *Client) write(ctx context.Context, cn *conn) (int, error) {
+	if cn.c == nil {
+		nc, err := c.dialer.DialContext(ctx, "tcp", c.addr)
+		if err != nil {
+			return 0, fmt.Errorf("could not connect to %s: %w", c.addr, err)
+		}
+		cn.c = nc
+	}
+	if err := cn.c.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	n, err := cn.c.Write(cn.buf)
+	if err != nil {
+		cn.c.Close()
+		cn.c = nil
+		return n, fmt.Errorf("could not write to %s: %w", c.addr, err)
+	}
+	return n, nil
+}
+
+// encode converts the records into the plaintext lines and appends them to
+// dst. The samples whose values are not finite are left out, since Graphite
+// cannot store them.
+func (c *Client) encode(dst []byte, recs []era5.Record) []byte {
+	for i := range recs {
+		r := &recs[i]
+		for j, v := range r.Values {
+			if c.transforms.Skip(v) {
+				continue
+			}
+			if x := c.transforms.Value(j, v); math.IsNaN(x) || math.IsInf(x, 0) {
+				continue
+			}
+			dst = append(dst, c.names[j]...)
+			dst = append(dst, ";la="...)
+			dst = append(dst, vm.FormatCoord(r.Latitude)...)
+			dst = append(dst, ";lo="...)
+			dst = append(dst, vm.FormatCoord(r.Longitude)...)
+			dst = append(dst, ' ')
+			dst = c.transforms.AppendValue(dst, j, v)
+			dst = append(dst, ' ')
+			// The plaintext timestamps are in seconds.
+			dst = strconv.AppendInt(dst, r.Timestamp/1000, 10)
+			dst = append(dst, '\n')
+		}
+	}
+	return dst
+}
+
+// Close closes the connections. It must not be called concurrently with
+// InsertContext.
+func (c *Client) Close() error {
+	var errs []error
+	for range cap(c.conns) {
+		cn := <-c.conns
+		if cn.c != nil {
+			errs = append(errs, cn.c.Close())
+			cn.c = nil
+		}
+		c.conns <- cn
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,76 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Batch outcomes.
+const (
+	// StatusOK means the batch has been inserted.
+	StatusOK = "ok"
+	// StatusFailed means the batch has not been inserted.
+	StatusFailed = "failed"
+)
+
+// Entry describes the outcome of inserting a single batch of records.
+type Entry struct {
+	// Batch is the deterministic identity of the batch. The same batch of
+	// the same file gets the same identity in every run.
+	Batch string `json:"batch"`
+
+	File      string  `json:"file"`
+	Timestamp int64   `json:"ts"`
+	LaFrom    float32 `json:"laFrom"`
+	LaTo      float32 `json:"laTo"`
+	Rows      int     `json:"rows"`
+
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// AmbiguousAttempts is the number of attempts that failed after the
+	// whole request had been sent. The target may or may not have stored
+	// the data of such attempts, so a successful batch with ambiguous
+	// attempts may have been stored more than once and a failed one may
+	// have been stored anyway.
+	AmbiguousAttempts int    `json:"ambiguousAttempts,omitempty"`
+	Err               string `json:"err,omitempty"`
+}
+
+// Journal is an append-only log of batch outcomes written as JSON lines. It
+// is safe for concurrent use.
+type Journal struct {
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// Open opens the journal file for appending, creating it if necessary.
+func Open(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &Journal{f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// Record appends an entry to the journal.
+func (j *Journal) Record(e *Entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.enc.Encode(e)
+}
+
+// Close flushes the pending entries and closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.w.Flush(); err != nil {
+		j.f.Close()
+		return err
+	}
+	return j.f.Close()
+}
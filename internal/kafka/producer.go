@@ -0,0 +1,444 @@
+// Package kafka publishes ERA5 records to a Kafka topic with the Kafka
+// protocol, one message per record.
+package kafka
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rtm0/era5/internal/metrics"
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/internal/textfile"
+	"github.com/rtm0/era5/internal/vm"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+var (
+	requestsSent     = metrics.NewCounter("era5_kafka_produce_requests_total", "Kafka produce requests sent, including retries")
+	requestsFailed   = metrics.NewCounter("era5_kafka_produce_requests_failed_total", "Kafka produce requests that failed with a network error or a partition error")
+	requestBytesSent = metrics.NewCounter("era5_kafka_produce_request_bytes_total", "Kafka produce request bytes sent")
+)
+
+// The ways the records are assigned to the partitions of the topic.
+const (
+	// PartitionByCell keys the messages with the coordinates of the grid
+	// cell, so the records of a cell stay in order in a single partition.
+	PartitionByCell = "cell"
+	// PartitionByTimestamp keys the messages with the timestamp, so the
+	// records of a timestamp go to a single partition.
+	PartitionByTimestamp = "timestamp"
+)
+
+// maxBatchSize limits the size of the record batch of a partition in a
+// produce request to stay below the default 1 MiB message.max.bytes of the
+// brokers.
+const maxBatchSize = 900 << 10
+
+// Producer publishes records to a Kafka topic. Every record is a message
+// holding the record encoded as a line of the format, keyed by its grid cell
+// or its timestamp, which picks the partition by its FNV-1a hash. The
+// messages are timestamped with the time they are published, since the old
+// timestamps of the records would make the brokers delete them right away by
+// their retention time. The messages are acknowledged by all the in-sync
+// replicas of the partitions. The inserts are serialized, since a produce
+// request holds the records of all the partitions already.
+type Producer struct {
+	logger       *slog.Logger
+	brokers      []string
+	topic        string
+	clientID     string
+	timeout      time.Duration
+	partitionBy  string
+	enc          *textfile.Encoder
+	maxRetries   int
+	retryBackoff time.Duration
+
+	mu sync.Mutex
+	md *metadata
+	// conns holds the connections to the brokers by their node IDs.
+	conns         map[int32]*brokerConn
+	correlationID int32
+	buf, req      []byte
+}
+
+// Options controls how a Producer encodes and publishes records. The records
+// of a failed request are sent again on network errors and the retriable
+// Kafka errors, such as a partition leader change.
+type Options struct {
+	sink.Options
+
+	// Format is the format of the messages, textfile.FormatJSONL or
+	// textfile.FormatInflux.
+	Format string
+
+	// PartitionBy is PartitionByCell or PartitionByTimestamp. Empty means
+	// PartitionByCell.
+	PartitionBy string
+
+	// ClientID identifies the producer in the logs and the quotas of the
+	// brokers. Empty means "era5".
+	ClientID string
+
+	// MetricPrefix is added to the names of all metrics.
+	MetricPrefix string
+
+	// Timeout limits dialing, every request and the time the brokers wait
+	// for the replicas to acknowledge the records. Zero means 30 seconds.
+	Timeout time.Duration
+}
+
+var _ sink.Inserter = (*Producer)(nil)
+
+// brokerConn is a connection to the broker at addr.
+type brokerConn struct {
+	addr string
+	c    net.Conn
+	r    *bufio.Reader
+}
+
+// NewProducer creates a producer of the topic, whose partitions are looked
+// up with one of the brokers given as host:port.
+func NewProducer(ctx context.Context, logger *slog.Logger, brokers []string, topic string, opts Options) (*Producer, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("no brokers")
+	}
+	if topic == "" {
+		return nil, errors.New("the topic is empty")
+	}
+	enc, err := textfile.NewEncoder(opts.Format, opts.MetricPrefix, opts.Variables, opts.Transforms)
+	if err != nil {
+		return nil, err
+	}
+	switch opts.PartitionBy {
+	case "":
+		opts.PartitionBy = PartitionByCell
+	case PartitionByCell, PartitionByTimestamp:
+	default:
+		return nil, fmt.Errorf("unsupported partitioning %q, want %s or %s", opts.PartitionBy, PartitionByCell, PartitionByTimestamp)
+	}
+	p := &Producer{
+		logger:       logger,
+		brokers:      brokers,
+		topic:        topic,
+		clientID:     opts.ClientID,
+		timeout:      opts.Timeout,
+		partitionBy:  opts.PartitionBy,
+		enc:          enc,
+		maxRetries:   opts.MaxRetries,
+		retryBackoff: opts.RetryBackoff,
+		conns:        make(map[int32]*brokerConn),
+	}
+	if p.clientID == "" {
+		p.clientID = "era5"
+	}
+	if p.timeout <= 0 {
+		p.timeout = 30 * time.Second
+	}
+	if err := p.refreshMetadata(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// InsertContext publishes the records and waits for the brokers to
+// acknowledge them. The records of the partitions whose leaders fail are
+// sent again after the partitions are looked up again, so the consumers may
+// see some of the records twice.
+func (p *Producer) InsertContext(ctx context.Context, recs []era5.Record) (sink.Result, error) {
+	start := time.Now()
+	result := sink.Result{Rows: len(recs)}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	msgs := p.encode(recs)
+	result.RawBytes = len(p.buf)
+	err := p.publish(ctx, msgs, &result)
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+// encode encodes the records into the messages held by p.buf, which are
+// ordered by their partitions.
+func (p *Producer) encode(recs []era5.Record) []message {
+	p.buf = p.buf[:0]
+	msgs := make([]message, 0, len(recs))
+	partitions := uint32(len(p.md.leaders))
+	for i := range recs {
+		r := &recs[i]
+		off := len(p.buf)
+		if p.partitionBy == PartitionByCell {
+			p.buf = append(p.buf, vm.FormatCoord(r.Latitude)...)
+			p.buf = append(p.buf, ',')
+			p.buf = append(p.buf, vm.FormatCoord(r.Longitude)...)
+		} else {
+			p.buf = strconv.AppendInt(p.buf, r.Timestamp, 10)
+		}
+		keyLen := len(p.buf) - off
+		size := len(p.buf)
+		p.buf = p.enc.AppendRecord(p.buf, r)
+		if len(p.buf) == size {
+			// All the values are skipped.
+			p.buf = p.buf[:off]
+			continue
+		}
+		h := fnv.New32a()
+		h.Write(p.buf[off : off+keyLen])
+		msgs = append(msgs, message{partition: int(h.Sum32() % partitions), off: off, keyLen: keyLen, ln: len(p.buf) - off})
+	}
+	slices.SortStableFunc(msgs, func(a, b message) int { return a.partition - b.partition })
+	return msgs
+}
+
+// publish sends the messages in produce requests to the leaders of their
+// partitions, at most a batch of maxBatchSize per partition in a request,
+// and sends the messages of the failed partitions again.
+func (p *Producer) publish(ctx context.Context, msgs []message, result *sink.Result) error {
+	partitionCnt := len(p.md.leaders)
+	backoff := p.retryBackoff
+	for attempt := 1; len(msgs) > 0; {
+		// The next batch of every partition by the leaders.
+		batches := make(map[int32]map[int][]message)
+		for i := 0; i < len(msgs); {
+			part := msgs[i].partition
+			n, size := 0, 0
+			for i+n < len(msgs) && msgs[i+n].partition == part && (n == 0 || size+msgs[i+n].ln < maxBatchSize) {
+				size += msgs[i+n].ln
+				n++
+			}
+			leader := p.md.leaders[part]
+			if batches[leader] == nil {
+				batches[leader] = make(map[int][]message)
+			}
+			batches[leader][part] = msgs[i : i+n]
+			for i < len(msgs) && msgs[i].partition == part {
+				i++
+			}
+		}
+		var failed error
+		sent := make(map[int]int)
+		for leader, parts := range batches {
+			done, err := p.produce(ctx, leader, parts, result)
+			for part := range done {
+				sent[part] = len(parts[part])
+			}
+			if err != nil {
+				if !isRetriable(err) {
+					return err
+				}
+				failed = err
+			}
+		}
+		// Drop the messages sent.
+		msgs = slices.DeleteFunc(msgs, func(m message) bool {
+			if sent[m.partition] > 0 {
+				sent[m.partition]--
+				return true
+			}
+			return false
+		})
+		if failed == nil {
+			continue
+		}
+		if attempt > p.maxRetries {
+			return failed
+		}
+		p.logger.Warn("Retrying Kafka produce", "attempt", attempt, "in", backoff, "err", failed)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return failed
+		}
+		backoff *= 2
+		attempt++
+		if err := p.refreshMetadata(ctx); err != nil {
+			return err
+		}
+		if len(p.md.leaders) != partitionCnt {
+			// The messages are assigned to the partitions again.
+			return fmt.Errorf("the partition count of topic %s changed to %d", p.topic, len(p.md.leaders))
+		}
+	}
+	return nil
+}
+
+// produce sends a produce request of the batches of the partitions led by
+// the broker and returns the partitions whose batches were stored.
+func (p *Producer) produce(ctx context.Context, leader int32, parts map[int][]message, result *sink.Result) (map[int]bool, error) {
+	if leader < 0 {
+		return nil, &Error{Code: 5}
+	}
+	now := time.Now().UnixMilli()
+	batches := make(map[int][]byte, len(parts))
+	for part, msgs := range parts {
+		batches[part] = appendRecordBatch(nil, p.buf, msgs, now)
+	}
+	p.req = appendProduceRequest(p.req[:0], -1, int32(p.timeout.Milliseconds()), p.topic, batches)
+	result.Attempts++
+	result.Bytes += len(p.req)
+	requestBytesSent.Add(len(p.req))
+	requestsSent.Inc()
+	body, err := p.roundTrip(ctx, leader, apiProduce, produceVersion, p.req)
+	if err != nil {
+		requestsFailed.Inc()
+		result.AmbiguousAttempts++
+		return nil, err
+	}
+	codes, err := parseProduceResponse(body)
+	if err != nil {
+		requestsFailed.Inc()
+		return nil, err
+	}
+	done := make(map[int]bool)
+	var failed error
+	for part := range parts {
+		code, ok := codes[part]
+		switch {
+		case !ok:
+			failed = fmt.Errorf("no response for partition %d", part)
+		case code != 0:
+			failed = fmt.Errorf("partition %d: %w", part, &Error{Code: code})
+		default:
+			done[part] = true
+		}
+	}
+	if failed != nil {
+		requestsFailed.Inc()
+	}
+	return done, failed
+}
+
+// isRetriable tells whether a failed request may succeed if it is sent again.
+func isRetriable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var kafkaErr *Error
+	if errors.As(err, &kafkaErr) {
+		_, ok := retriableErrors[kafkaErr.Code]
+		return ok
+	}
+	return true
+}
+
+// refreshMetadata looks up the brokers and the partition leaders of the
+// topic with the first broker that responds.
+func (p *Producer) refreshMetadata(ctx context.Context) error {
+	req := appendMetadataRequest(nil, p.topic)
+	var errs []error
+	for _, addr := range p.brokers {
+		bc, err := p.dial(ctx, addr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		body, err := p.exchange(bc, apiMetadata, metadataVersion, req)
+		bc.c.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not get metadata from %s: %w", addr, err))
+			continue
+		}
+		md, err := parseMetadataResponse(body, p.topic)
+		if err != nil {
+			return err
+		}
+		p.md = md
+		// The connections to the brokers that are gone or moved are
+		// closed.
+		for id, bc := range p.conns {
+			if addr, ok := md.addrs[id]; !ok || addr != bc.addr {
+				bc.c.Close()
+				delete(p.conns, id)
+			}
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// roundTrip sends a request to the broker and returns the body of its
+// response. The connection is closed if the request fails, so the next
+// request dials the broker again.
+func (p *Producer) roundTrip(ctx context.Context, node int32, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	bc := p.conns[node]
+	if bc == nil {
+		addr, ok := p.md.addrs[node]
+		if !ok {
+			return nil, fmt.Errorf("unknown broker %d", node)
+		}
+		var err error
+		if bc, err = p.dial(ctx, addr); err != nil {
+			return nil, err
+		}
+		p.conns[node] = bc
+	}
+	res, err := p.exchange(bc, apiKey, apiVersion, body)
+	if err != nil {
+		bc.c.Close()
+		delete(p.conns, node)
+		return nil, fmt.Errorf("broker %d: %w", node, err)
+	}
+	return res, nil
+}
+
+func (p *Producer) dial(ctx context.Context, addr string) (*brokerConn, error) {
+	d := net.Dialer{Timeout: p.timeout}
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", addr, err)
+	}
+	return &brokerConn{addr: addr, c: c, r: bufio.NewReader(c)}, nil
+}
+
+// exchange writes a request to the connection and reads its response.
+func (p *Producer) exchange(bc *brokerConn, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	p.correlationID++
+	header := appendRequestHeader(make([]byte, 4, 64), apiKey, apiVersion, p.correlationID, p.clientID)
+	binary.BigEndian.PutUint32(header, uint32(len(header)-4+len(body)))
+	// The deadline covers the time the brokers wait for the replicas.
+	if err := bc.c.SetDeadline(time.Now().Add(2 * p.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := (&net.Buffers{header, body}).WriteTo(bc.c); err != nil {
+		return nil, fmt.Errorf("could not send request: %w", err)
+	}
+	var size [8]byte
+	if _, err := io.ReadFull(bc.r, size[:]); err != nil {
+		return nil, fmt.Errorf("could not read response: %w", err)
+	}
+	if id := int32(binary.BigEndian.Uint32(size[4:])); id != p.correlationID {
+		return nil, fmt.Errorf("unexpected correlation ID %d of the response, want %d", id, p.correlationID)
+	}
+	n := int(binary.BigEndian.Uint32(size[:]))
+	if n < 4 || n > maxResponseSize {
+		return nil, fmt.Errorf("unexpected response size %d", n)
+	}
+	res := make([]byte, n-4)
+	if _, err := io.ReadFull(bc.r, res); err != nil {
+		return nil, fmt.Errorf("could not read response: %w", err)
+	}
+	return res, nil
+}
+
+// maxResponseSize guards against reading garbage as the size of a response.
+const maxResponseSize = 64 << 20
+
+// Close closes the connections to the brokers. It must not be called
+// concurrently with InsertContext.
+func (p *Producer) Close() error {
+	var errs []error
+	for id, bc := range p.conns {
+		errs = append(errs, bc.c.Close())
+		delete(p.conns, id)
+	}
+	return errors.Join(errs...)
+}
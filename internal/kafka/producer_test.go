@@ -0,0 +1,229 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/internal/textfile"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// fakeBroker is a single broker leading the single partition of every
+// topic. Its produce requests fail with the error codes in turn and succeed
+// once the codes run out.
+type fakeBroker struct {
+	t  *testing.T
+	ln net.Listener
+
+	mu             sync.Mutex
+	codes          []int16
+	metadataReqs   int
+	produceReqs    int
+	values         []string
+	correlationIDs []int32
+}
+
+func newFakeBroker(t *testing.T, codes ...int16) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{t: t, ln: ln, codes: codes}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(c)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) addr() string {
+	return b.ln.Addr().String()
+}
+
+func (b *fakeBroker) serve(c net.Conn) {
+	defer c.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(c, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(c, req); err != nil {
+			return
+		}
+		r := &reader{b: req}
+		apiKey, version, id := r.int16(), r.int16(), r.int32()
+		if clientID := r.string(); clientID != "era5" {
+			b.t.Errorf("got the client ID %q, want era5", clientID)
+		}
+		var res []byte
+		switch {
+		case apiKey == apiMetadata && version == metadataVersion:
+			res = b.metadata(r)
+		case apiKey == apiProduce && version == produceVersion:
+			res = b.produce(r)
+		default:
+			b.t.Errorf("got the API %d v%d", apiKey, version)
+			return
+		}
+		if r.err != nil {
+			b.t.Errorf("could not parse the request: %s", r.err)
+			return
+		}
+		b.mu.Lock()
+		b.correlationIDs = append(b.correlationIDs, id)
+		b.mu.Unlock()
+		frame := binary.BigEndian.AppendUint32(nil, uint32(4+len(res)))
+		frame = binary.BigEndian.AppendUint32(frame, uint32(id))
+		if _, err := c.Write(append(frame, res...)); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) metadata(r *reader) []byte {
+	b.mu.Lock()
+	b.metadataReqs++
+	b.mu.Unlock()
+	topics := make(map[string]int16)
+	for range r.int32() {
+		topics[r.string()] = 0
+	}
+	port := b.ln.Addr().(*net.TCPAddr).Port
+	return appendMetadataResponse(nil, int32(port), topics, []int32{0})
+}
+
+func (b *fakeBroker) produce(r *reader) []byte {
+	r.string() // transactional_id
+	if acks := r.int16(); acks != -1 {
+		b.t.Errorf("got acks %d, want -1", acks)
+	}
+	r.int32() // timeout_ms
+	r.int32() // topics
+	topic := r.string()
+	r.int32() // partitions
+	part := r.int32()
+	batch := r.next(int(r.int32()))
+	values := readRecords(b.t, batch)
+
+	b.mu.Lock()
+	b.produceReqs++
+	var code int16
+	if len(b.codes) > 0 {
+		code, b.codes = b.codes[0], b.codes[1:]
+	}
+	if code == 0 {
+		b.values = append(b.values, values...)
+	}
+	b.mu.Unlock()
+
+	res := binary.BigEndian.AppendUint32(nil, 1)
+	res = appendString(res, topic)
+	res = binary.BigEndian.AppendUint32(res, 1)
+	res = binary.BigEndian.AppendUint32(res, uint32(part))
+	res = binary.BigEndian.AppendUint16(res, uint16(code))
+	res = binary.BigEndian.AppendUint64(res, 0)
+	res = binary.BigEndian.AppendUint64(res, 0xffffffffffffffff)
+	return binary.BigEndian.AppendUint32(res, 0)
+}
+
+// readRecords returns the values of the records of a batch.
+func readRecords(t *testing.T, batch []byte) []string {
+	r := &reader{b: batch}
+	r.int64() // base_offset
+	if n := r.int32(); int(n) != len(batch)-12 {
+		t.Errorf("got the batch length %d, want %d", n, len(batch)-12)
+	}
+	r.next(4 + 1 + 4 + 2 + 4 + 8 + 8 + 8 + 2 + 4)
+	var values []string
+	rest := r.b[4:]
+	for range r.int32() {
+		size, n := binary.Varint(rest)
+		rec := rest[n : n+int(size)]
+		rest = rest[n+int(size):]
+		rec = rec[3:]
+		keyLen, n := binary.Varint(rec)
+		rec = rec[n+int(keyLen):]
+		valueLen, n := binary.Varint(rec)
+		values = append(values, string(rec[n:n+int(valueLen)]))
+	}
+	return values
+}
+
+func TestProducerRetries(t *testing.T) {
+	recs := []era5.Record{
+		{Timestamp: 1000, Latitude: 1.5, Longitude: 2.25, Values: []float64{273.15}},
+		{Timestamp: 1000, Latitude: 1.5, Longitude: 2.5, Values: []float64{274}},
+	}
+	for _, tc := range []struct {
+		name    string
+		codes   []int16
+		retries int
+		// code is the error code of the insert, zero if it succeeds.
+		code         int16
+		produceReqs  int
+		metadataReqs int
+	}{
+		{"success", nil, 2, 0, 1, 1},
+		{"leader change", []int16{6}, 2, 0, 2, 2},
+		{"retries exhausted", []int16{6, 7, 6}, 1, 7, 2, 2},
+		{"not retriable", []int16{10}, 2, 10, 1, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newFakeBroker(t, tc.codes...)
+			p, err := NewProducer(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), []string{b.addr()}, "era5", Options{
+				Options: sink.Options{
+					MaxRetries:   tc.retries,
+					RetryBackoff: time.Millisecond,
+					Variables:    []string{"t2m"},
+				},
+				Format:       textfile.FormatJSONL,
+				MetricPrefix: "era5",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			result, err := p.InsertContext(context.Background(), recs)
+			var kafkaErr *Error
+			switch {
+			case tc.code == 0 && err != nil:
+				t.Fatal(err)
+			case tc.code != 0 && (!errors.As(err, &kafkaErr) || kafkaErr.Code != tc.code):
+				t.Fatalf("got the error %v, want Kafka error %d", err, tc.code)
+			}
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if b.produceReqs != tc.produceReqs || result.Attempts != tc.produceReqs || b.metadataReqs != tc.metadataReqs {
+				t.Fatalf("got %d produce requests, %d attempts and %d metadata requests, want %d, %d and %d",
+					b.produceReqs, result.Attempts, b.metadataReqs, tc.produceReqs, tc.produceReqs, tc.metadataReqs)
+			}
+			wantValues := len(recs)
+			if tc.code != 0 {
+				wantValues = 0
+			}
+			if len(b.values) != wantValues {
+				t.Fatalf("got the messages %q, want %d", b.values, wantValues)
+			}
+			for i := 1; i < len(b.correlationIDs); i++ {
+				if b.correlationIDs[i] <= b.correlationIDs[i-1] {
+					t.Fatalf("got the correlation IDs %v, want them increasing", b.correlationIDs)
+				}
+			}
+		})
+	}
+}
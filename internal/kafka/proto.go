@@ -0,0 +1,267 @@
+package kafka
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// The keys and the versions of the Kafka APIs the producer uses. Produce v3
+// is the first version with the record batches of magic 2, which Kafka 0.11
+// and later accept.
+const (
+	apiProduce      = 0
+	apiMetadata     = 3
+	produceVersion  = 3
+	metadataVersion = 1
+)
+
+// The Kafka error codes the producer recovers from by refreshing the
+// metadata and sending the records again.
+var retriableErrors = map[int16]string{
+	3:  "UNKNOWN_TOPIC_OR_PARTITION",
+	5:  "LEADER_NOT_AVAILABLE",
+	6:  "NOT_LEADER_OR_FOLLOWER",
+	7:  "REQUEST_TIMED_OUT",
+	13: "NETWORK_EXCEPTION",
+	19: "NOT_ENOUGH_REPLICAS",
+	20: "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+}
+
+// Error is a Kafka error code returned for a topic or a partition.
+type Error struct {
+	Code int16
+}
+
+func (e *Error) Error() string {
+	if name, ok := retriableErrors[e.Code]; ok {
+		return fmt.Sprintf("Kafka error %d (%s)", e.Code, name)
+	}
+	return fmt.Sprintf("Kafka error %d", e.Code)
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// appendRequestHeader appends the request header v1 to dst.
+func appendRequestHeader(dst []byte, apiKey, apiVersion int16, correlationID int32, clientID string) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(apiKey))
+	dst = binary.BigEndian.AppendUint16(dst, uint16(apiVersion))
+	dst = binary.BigEndian.AppendUint32(dst, uint32(correlationID))
+	return appendString(dst, clientID)
+}
+
+func appendString(dst []byte, s string) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(s)))
+	return append(dst, s...)
+}
+
+// appendMetadataRequest appends the body of a Metadata v1 request of the
+// topic to dst.
+func appendMetadataRequest(dst []byte, topic string) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, 1)
+	return appendString(dst, topic)
+}
+
+// metadata describes the brokers and the partitions of a topic.
+type metadata struct {
+	// addrs holds the host:port of the brokers by their node IDs.
+	addrs map[int32]string
+	// leaders holds the node IDs of the leaders of the partitions, which
+	// are -1 while a partition has no leader.
+	leaders []int32
+}
+
+// parseMetadataResponse parses the body of a Metadata v1 response of the
+// topic.
+func parseMetadataResponse(body []byte, topic string) (*metadata, error) {
+	r := &reader{b: body}
+	md := &metadata{addrs: make(map[int32]string)}
+	for range r.int32() {
+		id := r.int32()
+		host := r.string()
+		port := r.int32()
+		r.string() // rack
+		md.addrs[id] = fmt.Sprintf("%s:%d", host, port)
+	}
+	r.int32() // controller_id
+	found := false
+	for range r.int32() {
+		code := r.int16()
+		name := r.string()
+		r.int8() // is_internal
+		partitions := r.int32()
+		if r.err == nil && name == topic {
+			found = true
+			if code != 0 {
+				return nil, fmt.Errorf("topic %s: %w", topic, &Error{Code: code})
+			}
+			md.leaders = make([]int32, max(partitions, 0))
+		}
+		for range partitions {
+			r.int16() // error_code
+			index := r.int32()
+			leader := r.int32()
+			for range r.int32() {
+				r.int32() // replica_nodes
+			}
+			for range r.int32() {
+				r.int32() // isr_nodes
+			}
+			if name == topic && index >= 0 && int(index) < len(md.leaders) {
+				md.leaders[index] = leader
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("could not parse metadata: %w", r.err)
+	}
+	if !found || len(md.leaders) == 0 {
+		return nil, fmt.Errorf("topic %s has no partitions", topic)
+	}
+	return md, nil
+}
+
+// message is a Kafka record of the key and the value at off in the buffer
+// of the encoded records.
+type message struct {
+	partition       int
+	off, keyLen, ln int
+}
+
+// appendProduceRequest appends the body of a Produce v3 request of the record
+// batches of the topic by their partitions to dst.
+func appendProduceRequest(dst []byte, acks int16, timeoutMs int32, topic string, batches map[int][]byte) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, 0xffff) // null transactional_id
+	dst = binary.BigEndian.AppendUint16(dst, uint16(acks))
+	dst = binary.BigEndian.AppendUint32(dst, uint32(timeoutMs))
+	dst = binary.BigEndian.AppendUint32(dst, 1)
+	dst = appendString(dst, topic)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(batches)))
+	for p, batch := range batches {
+		dst = binary.BigEndian.AppendUint32(dst, uint32(p))
+		dst = binary.BigEndian.AppendUint32(dst, uint32(len(batch)))
+		dst = append(dst, batch...)
+	}
+	return dst
+}
+
+// parseProduceResponse parses the body of a Produce v3 response and returns
+// the error codes of the partitions.
+func parseProduceResponse(body []byte) (map[int]int16, error) {
+	r := &reader{b: body}
+	codes := make(map[int]int16)
+	for range r.int32() {
+		r.string() // name
+		for range r.int32() {
+			p := r.int32()
+			codes[int(p)] = r.int16()
+			r.int64() // base_offset
+			r.int64() // log_append_time_ms
+		}
+	}
+	r.int32() // throttle_time_ms
+	if r.err != nil {
+		return nil, fmt.Errorf("could not parse produce response: %w", r.err)
+	}
+	return codes, nil
+}
+
+// appendRecordBatch appends the record batch of magic 2 holding the messages
+// of the buffer buf timestamped with ts to dst.
+func appendRecordBatch(dst []byte, buf []byte, msgs []message, ts int64) []byte {
+	start := len(dst)
+	dst = binary.BigEndian.AppendUint64(dst, 0)          // base_offset
+	dst = binary.BigEndian.AppendUint32(dst, 0)          // batch_length
+	dst = binary.BigEndian.AppendUint32(dst, 0xffffffff) // partition_leader_epoch
+	dst = append(dst, 2)                                 // magic
+	crcPos := len(dst)
+	dst = binary.BigEndian.AppendUint32(dst, 0)                   // crc
+	dst = binary.BigEndian.AppendUint16(dst, 0)                   // attributes
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(msgs)-1)) // last_offset_delta
+	dst = binary.BigEndian.AppendUint64(dst, uint64(ts))          // base_timestamp
+	dst = binary.BigEndian.AppendUint64(dst, uint64(ts))          // max_timestamp
+	dst = binary.BigEndian.AppendUint64(dst, 0xffffffffffffffff)  // producer_id
+	dst = binary.BigEndian.AppendUint16(dst, 0xffff)              // producer_epoch
+	dst = binary.BigEndian.AppendUint32(dst, 0xffffffff)          // base_sequence
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(msgs)))
+	for i, m := range msgs {
+		key, value := buf[m.off:m.off+m.keyLen], buf[m.off+m.keyLen:m.off+m.ln]
+		// The attributes, the timestamp delta and the header count are
+		// single zero bytes.
+		size := 3 + varintLen(int64(i)) + varintLen(int64(len(key))) + len(key) + varintLen(int64(len(value))) + len(value)
+		dst = binary.AppendVarint(dst, int64(size))
+		dst = append(dst, 0, 0)
+		dst = binary.AppendVarint(dst, int64(i))
+		dst = binary.AppendVarint(dst, int64(len(key)))
+		dst = append(dst, key...)
+		dst = binary.AppendVarint(dst, int64(len(value)))
+		dst = append(dst, value...)
+		dst = append(dst, 0)
+	}
+	binary.BigEndian.PutUint32(dst[start+8:], uint32(len(dst)-start-12))
+	binary.BigEndian.PutUint32(dst[crcPos:], crc32.Checksum(dst[crcPos+4:], castagnoli))
+	return dst
+}
+
+func varintLen(v int64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutVarint(buf[:], v)
+}
+
+// reader reads the big-endian fields of a response body. It stops at the
+// first error, after which the fields read are zero.
+type reader struct {
+	b   []byte
+	err error
+}
+
+var errShortResponse = errors.New("unexpected end of response")
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil || len(r.b) < n || n < 0 {
+		r.err = cmp.Or(r.err, errShortResponse)
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *reader) int8() int8 {
+	if b := r.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (r *reader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *reader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *reader) int64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a string or a nullable string, which is empty if it is null.
+func (r *reader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
@@ -0,0 +1,149 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+func TestMetadataRequest(t *testing.T) {
+	got := appendRequestHeader(nil, apiMetadata, metadataVersion, 7, "era5")
+	got = appendMetadataRequest(got, "t")
+	want := []byte{
+		0x00, 0x03, // api_key
+		0x00, 0x01, // api_version
+		0x00, 0x00, 0x00, 0x07, // correlation_id
+		0x00, 0x04, 'e', 'r', 'a', '5', // client_id
+		0x00, 0x00, 0x00, 0x01, // topics
+		0x00, 0x01, 't',
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got the request %x, want %x", got, want)
+	}
+}
+
+func TestRecordBatch(t *testing.T) {
+	got := appendRecordBatch(nil, []byte("k1v1"), []message{{off: 0, keyLen: 2, ln: 4}}, 1000)
+	want := []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, // base_offset
+		0, 0, 0, 60, // batch_length
+		0xff, 0xff, 0xff, 0xff, // partition_leader_epoch
+		2,          // magic
+		0, 0, 0, 0, // crc, set below
+		0, 0, // attributes
+		0, 0, 0, 0, // last_offset_delta
+		0, 0, 0, 0, 0, 0, 0x03, 0xe8, // base_timestamp
+		0, 0, 0, 0, 0, 0, 0x03, 0xe8, // max_timestamp
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // producer_id
+		0xff, 0xff, // producer_epoch
+		0xff, 0xff, 0xff, 0xff, // base_sequence
+		0, 0, 0, 1, // records
+		20,      // length 10
+		0, 0, 0, // attributes, timestamp_delta, offset_delta
+		4, 'k', '1', // key
+		4, 'v', '1', // value
+		0, // headers
+	}
+	binary.BigEndian.PutUint32(want[17:], crc32.Checksum(want[21:], crc32.MakeTable(crc32.Castagnoli)))
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got the batch %x, want %x", got, want)
+	}
+}
+
+func TestProduceRequest(t *testing.T) {
+	got := appendProduceRequest(nil, -1, 1500, "t", map[int][]byte{2: {0xaa, 0xbb}})
+	want := []byte{
+		0xff, 0xff, // transactional_id
+		0xff, 0xff, // acks
+		0x00, 0x00, 0x05, 0xdc, // timeout_ms
+		0x00, 0x00, 0x00, 0x01, // topics
+		0x00, 0x01, 't',
+		0x00, 0x00, 0x00, 0x01, // partitions
+		0x00, 0x00, 0x00, 0x02, // index
+		0x00, 0x00, 0x00, 0x02, 0xaa, 0xbb, // records
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got the request %x, want %x", got, want)
+	}
+}
+
+// appendMetadataResponse appends a Metadata v1 response of a single broker
+// and the topics with the error codes and the leaders of their partitions.
+func appendMetadataResponse(dst []byte, port int32, topics map[string]int16, leaders []int32) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, 1)
+	dst = binary.BigEndian.AppendUint32(dst, 0) // node_id
+	dst = appendString(dst, "127.0.0.1")
+	dst = binary.BigEndian.AppendUint32(dst, uint32(port))
+	dst = binary.BigEndian.AppendUint16(dst, 0xffff) // null rack
+	dst = binary.BigEndian.AppendUint32(dst, 0)      // controller_id
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(topics)))
+	for name, code := range topics {
+		dst = binary.BigEndian.AppendUint16(dst, uint16(code))
+		dst = appendString(dst, name)
+		dst = append(dst, 0) // is_internal
+		dst = binary.BigEndian.AppendUint32(dst, uint32(len(leaders)))
+		for i, leader := range leaders {
+			dst = binary.BigEndian.AppendUint16(dst, 0)
+			dst = binary.BigEndian.AppendUint32(dst, uint32(i))
+			dst = binary.BigEndian.AppendUint32(dst, uint32(leader))
+			for range 2 {
+				// The replica and the in-sync nodes.
+				dst = binary.BigEndian.AppendUint32(dst, 1)
+				dst = binary.BigEndian.AppendUint32(dst, uint32(leader))
+			}
+		}
+	}
+	return dst
+}
+
+func TestParseMetadataResponse(t *testing.T) {
+	body := appendMetadataResponse(nil, 9092, map[string]int16{"other": 3, "t": 0}, []int32{0, -1})
+	md, err := parseMetadataResponse(body, "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.addrs[0] != "127.0.0.1:9092" || len(md.addrs) != 1 {
+		t.Fatalf("got the brokers %v, want 0: 127.0.0.1:9092", md.addrs)
+	}
+	if len(md.leaders) != 2 || md.leaders[0] != 0 || md.leaders[1] != -1 {
+		t.Fatalf("got the leaders %v, want [0 -1]", md.leaders)
+	}
+
+	body = appendMetadataResponse(nil, 9092, map[string]int16{"t": 3}, nil)
+	var kafkaErr *Error
+	if _, err := parseMetadataResponse(body, "t"); !errors.As(err, &kafkaErr) || kafkaErr.Code != 3 {
+		t.Fatalf("got the error %v, want Kafka error 3", err)
+	}
+	if _, err := parseMetadataResponse(body, "missing"); err == nil {
+		t.Fatal("got no error for a missing topic")
+	}
+	body = appendMetadataResponse(nil, 9092, map[string]int16{"t": 0}, []int32{0})
+	if _, err := parseMetadataResponse(body[:len(body)-1], "t"); !errors.Is(err, errShortResponse) {
+		t.Fatalf("got the error %v for a short response, want %v", err, errShortResponse)
+	}
+}
+
+func TestParseProduceResponse(t *testing.T) {
+	body := binary.BigEndian.AppendUint32(nil, 1)
+	body = appendString(body, "t")
+	body = binary.BigEndian.AppendUint32(body, 2)
+	for p, code := range []int16{0, 6} {
+		body = binary.BigEndian.AppendUint32(body, uint32(p))
+		body = binary.BigEndian.AppendUint16(body, uint16(code))
+		body = binary.BigEndian.AppendUint64(body, 42)                 // base_offset
+		body = binary.BigEndian.AppendUint64(body, 0xffffffffffffffff) // log_append_time_ms
+	}
+	body = binary.BigEndian.AppendUint32(body, 0) // throttle_time_ms
+	codes, err := parseProduceResponse(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 2 || codes[0] != 0 || codes[1] != 6 {
+		t.Fatalf("got the error codes %v, want map[0:0 1:6]", codes)
+	}
+	if _, err := parseProduceResponse(body[:len(body)-1]); !errors.Is(err, errShortResponse) {
+		t.Fatalf("got the error %v for a short response, want %v", err, errShortResponse)
+	}
+}
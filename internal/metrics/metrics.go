@@ -0,0 +1,130 @@
+// Package metrics implements the exporter self-metrics exposed in the
+// Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is a single time series.
+type metric interface {
+	value() float64
+}
+
+type entry struct {
+	name string
+	help string
+	typ  string
+	m    metric
+}
+
+var (
+	mu      sync.Mutex
+	entries = map[string]*entry{}
+)
+
+func register(name, help, typ string, m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := entries[name]; ok {
+		panic(fmt.Sprintf("BUG: metric %q is already registered", name))
+	}
+	entries[name] = &entry{name: name, help: help, typ: typ, m: m}
+}
+
+// Counter is a monotonically increasing integer metric.
+type Counter struct {
+	v atomic.Uint64
+}
+
+// NewCounter registers a new counter.
+func NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	register(name, help, "counter", c)
+	return c
+}
+
+// Inc increments the counter.
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add adds n to the counter.
+func (c *Counter) Add(n int) {
+	c.v.Add(uint64(n))
+}
+
+// Get returns the counter value.
+func (c *Counter) Get() uint64 {
+	return c.v.Load()
+}
+
+func (c *Counter) value() float64 {
+	return float64(c.v.Load())
+}
+
+// FloatCounter is a monotonically increasing float metric, such as the total
+// number of seconds spent on something.
+type FloatCounter struct {
+	bits atomic.Uint64
+}
+
+// NewFloatCounter registers a new float counter.
+func NewFloatCounter(name, help string) *FloatCounter {
+	c := &FloatCounter{}
+	register(name, help, "counter", c)
+	return c
+}
+
+// Add adds v to the counter.
+func (c *FloatCounter) Add(v float64) {
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (c *FloatCounter) value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+type gaugeFunc func() float64
+
+func (f gaugeFunc) value() float64 {
+	return f()
+}
+
+// NewGaugeFunc registers a gauge whose value is computed by f on every
+// scrape.
+func NewGaugeFunc(name, help string, f func() float64) {
+	register(name, help, "gauge", gaugeFunc(f))
+}
+
+// WritePrometheus writes all registered metrics to w in the Prometheus text
+// exposition format.
+func WritePrometheus(w io.Writer) error {
+	mu.Lock()
+	es := make([]*entry, 0, len(entries))
+	for _, e := range entries {
+		es = append(es, e)
+	}
+	mu.Unlock()
+	sort.Slice(es, func(i, j int) bool { return es[i].name < es[j].name })
+
+	var buf []byte
+	for _, e := range es {
+		buf = fmt.Appendf(buf, "# HELP %s %s\n# TYPE %s %s\n%s ", e.name, e.help, e.name, e.typ, e.name)
+		buf = strconv.AppendFloat(buf, e.m.value(), 'g', -1, 64)
+		buf = append(buf, '\n')
+	}
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,213 @@
+package nats
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/internal/textfile"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// fakeServer is a NATS server with a JetStream stream over net.Pipe. It
+// replies to the published messages with the replies in turn and
+// acknowledges them once the replies run out:
+//   - "ack" acknowledges the message,
+//   - "500" rejects it with a JetStream error,
+//   - "503" responds with the no responders status,
+//   - "drop" closes the connection once the replies before are written.
+type fakeServer struct {
+	t          *testing.T
+	maxPayload int
+
+	mu       sync.Mutex
+	replies  []string
+	dials    int
+	connects []map[string]any
+	// stored holds the payloads of the acknowledged messages by their
+	// Nats-Msg-Id.
+	stored   map[string]string
+	received int
+}
+
+func newFakeServer(t *testing.T, maxPayload int, replies ...string) *fakeServer {
+	return &fakeServer{t: t, maxPayload: maxPayload, replies: replies, stored: make(map[string]string)}
+}
+
+func (s *fakeServer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	s.mu.Lock()
+	s.dials++
+	s.mu.Unlock()
+	go s.serve(server)
+	return client, nil
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	// The replies are written by another goroutine, since the client only
+	// reads them once it has written all the messages.
+	// An empty reply closes the connection.
+	out := make(chan string, 64)
+	go func() {
+		for m := range out {
+			if m == "" {
+				c.Close()
+				continue
+			}
+			io.WriteString(c, m)
+		}
+	}()
+	defer close(out)
+	defer c.Close()
+
+	out <- fmt.Sprintf("INFO {\"server_id\":\"fake\",\"headers\":true,\"max_payload\":%d}\r\n", s.maxPayload)
+	r := bufio.NewReader(c)
+	dropped := false
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(line, " ")
+		switch op {
+		case "CONNECT":
+			var opts map[string]any
+			if err := json.Unmarshal([]byte(args), &opts); err != nil {
+				s.t.Errorf("could not parse %s: %s", line, err)
+				return
+			}
+			s.mu.Lock()
+			s.connects = append(s.connects, opts)
+			s.mu.Unlock()
+		case "PING":
+			out <- "PONG\r\n"
+		case "HPUB":
+			// HPUB <subject> <reply> <header size> <size>
+			fields := strings.Fields(args)
+			hdrSize, _ := strconv.Atoi(fields[2])
+			size, _ := strconv.Atoi(fields[3])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			_, id, _ := strings.Cut(string(data[:hdrSize]), "Nats-Msg-Id: ")
+			id, _, _ = strings.Cut(id, "\r\n")
+			// The messages that follow a drop are never received.
+			if !dropped && !s.reply(out, fields[1], id, string(data[hdrSize:size])) {
+				out <- ""
+				dropped = true
+			}
+		}
+	}
+}
+
+// reply replies to the message and tells whether the connection stays open.
+func (s *fakeServer) reply(out chan<- string, inbox, id, payload string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received++
+	reply := "ack"
+	if len(s.replies) > 0 {
+		reply, s.replies = s.replies[0], s.replies[1:]
+	}
+	switch reply {
+	case "drop":
+		return false
+	case "503":
+		hdr := "NATS/1.0 503\r\n\r\n"
+		out <- fmt.Sprintf("HMSG %s 1 %d %d\r\n%s\r\n", inbox, len(hdr), len(hdr), hdr)
+	case "500":
+		body := `{"error":{"code":500,"err_code":10000,"description":"unavailable"}}`
+		out <- fmt.Sprintf("MSG %s 1 %d\r\n%s\r\n", inbox, len(body), body)
+	default:
+		s.stored[id] = payload
+		// The client answers the pings of the server while it waits.
+		out <- "PING\r\n"
+		body := fmt.Sprintf(`{"stream":"era5","seq":%d}`, len(s.stored))
+		out <- fmt.Sprintf("MSG %s 1 %d\r\n%s\r\n", inbox, len(body), body)
+	}
+	return true
+}
+
+func TestPublisher(t *testing.T) {
+	var recs []era5.Record
+	for i := range 5 {
+		recs = append(recs, era5.Record{Timestamp: 1000, Latitude: 1.5, Longitude: float32(i), Values: []float64{273.15 + float64(i)}})
+	}
+	for _, tc := range []struct {
+		name    string
+		replies []string
+		// code is the JetStream error code of the insert, zero if it
+		// succeeds, and -1 for another error.
+		code     int
+		dials    int
+		received int
+	}{
+		{"success", nil, 0, 1, 5},
+		{"rejected", []string{"ack", "500", "ack"}, 0, 1, 6},
+		{"dropped", []string{"ack", "drop"}, 0, 2, 6},
+		{"retries exhausted", []string{"500", "ack", "ack", "ack", "ack", "500", "500"}, 500, 1, 7},
+		{"no stream", []string{"503"}, 503, 1, 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The max payload fits a single line into a message.
+			s := newFakeServer(t, 128+80, tc.replies...)
+			p, err := newPublisher(slog.New(slog.NewTextHandler(io.Discard, nil)), "nats://secret@localhost", "era5.records", Options{
+				Options: sink.Options{
+					MaxRetries:   2,
+					RetryBackoff: time.Millisecond,
+					Variables:    []string{"t2m"},
+				},
+				Format:       textfile.FormatJSONL,
+				MetricPrefix: "era5",
+				Timeout:      5 * time.Second,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.dial = s.dial
+			defer p.Close()
+			_, err = p.InsertContext(context.Background(), recs)
+			var ackErr *AckError
+			switch {
+			case tc.code == 0 && err != nil:
+				t.Fatal(err)
+			case tc.code > 0 && (!errors.As(err, &ackErr) || ackErr.Code != tc.code):
+				t.Fatalf("got the error %v, want JetStream error %d", err, tc.code)
+			}
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.dials != tc.dials || s.received != tc.received {
+				t.Fatalf("got %d dials and %d messages, want %d and %d", s.dials, s.received, tc.dials, tc.received)
+			}
+			for _, opts := range s.connects {
+				if opts["auth_token"] != "secret" || opts["headers"] != true {
+					t.Fatalf("got the CONNECT options %v, want the token and the headers", opts)
+				}
+			}
+			if tc.code != 0 {
+				return
+			}
+			// Every record is stored once under the ID of its payload.
+			var lines []string
+			for _, payload := range s.stored {
+				lines = append(lines, strings.Split(payload, "\n")...)
+			}
+			if len(s.stored) != len(recs) || len(lines) != len(recs) {
+				t.Fatalf("got the messages %q, want %d of a line each", s.stored, len(recs))
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+// Package points estimates the values of the variables at arbitrary
+// locations, such as weather stations, from the values at the surrounding
+// grid points.
+package points
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// The interpolation methods.
+const (
+	// Nearest takes the values of the closest grid point.
+	Nearest = "nearest"
+	// Bilinear interpolates the values of the four grid points surrounding
+	// the location.
+	Bilinear = "bilinear"
+	// IDW weights the values of the four grid points surrounding the
+	// location by their inverse squared distance to it.
+	IDW = "idw"
+)
+
+// Location is a point the values are estimated at.
+type Location struct {
+	La, Lo float64
+}
+
+// neighbour is a grid point contributing to the values at a location.
+type neighbour struct {
+	// k is the index of the grid point within the records of a timestamp.
+	k int
+	w float64
+}
+
+// Extractor estimates the values at the locations from the records of the
+// grid. It is safe for concurrent use.
+type Extractor struct {
+	locs []Location
+	// las and los are the coordinates the extracted records are set on,
+	// which are combined with each other by the sinks pre-formatting the
+	// grid.
+	las, los   []float32
+	neighbours [][]neighbour
+	gridLen    int
+	fill       []float64
+	hasFill    []bool
+}
+
+// New creates an extractor of the values at the locations from the grid
+// with the given coordinates using the interpolation method. varNames are
+// the names of the variables in the order of the record values. The values
+// equal to the fill value of their variable are left out of the estimates.
+func New(locs []Location, latitudes, longitudes []float32, method string, varNames []string, fill map[string]float64) (*Extractor, error) {
+	if method != Nearest && method != Bilinear && method != IDW {
+		return nil, fmt.Errorf("unsupported interpolation method %q", method)
+	}
+	e := newExtractor(locs, latitudes, longitudes, varNames, fill)
+	for i, loc := range locs {
+		e.las[i], e.los[i] = float32(loc.La), float32(loc.Lo)
+	}
+	wrap := wraps(longitudes)
+	for i, loc := range locs {
+		la0, la1, laFrac, ok := bracket(latitudes, loc.La, false)
+		if !ok {
+			return nil, fmt.Errorf("latitude %g is outside of the grid", loc.La)
+		}
+		lo0, lo1, loFrac, ok := bracket(longitudes, loc.Lo, wrap)
+		if !ok {
+			return nil, fmt.Errorf("longitude %g is outside of the grid", loc.Lo)
+		}
+		k := func(la, lo int) int { return la*len(longitudes) + lo }
+		switch method {
+		case Nearest:
+			la, lo := la0, lo0
+			if laFrac > 0.5 {
+				la = la1
+			}
+			if loFrac > 0.5 {
+				lo = lo1
+			}
+			e.neighbours[i] = []neighbour{{k: k(la, lo), w: 1}}
+		case Bilinear:
+			e.neighbours[i] = []neighbour{
+				{k: k(la0, lo0), w: (1 - laFrac) * (1 - loFrac)},
+				{k: k(la0, lo1), w: (1 - laFrac) * loFrac},
+				{k: k(la1, lo0), w: laFrac * (1 - loFrac)},
+				{k: k(la1, lo1), w: laFrac * loFrac},
+			}
+		case IDW:
+			e.neighbours[i] = idwNeighbours(latitudes, longitudes, loc, [4][2]int{
+				{la0, lo0}, {la0, lo1}, {la1, lo0}, {la1, lo1},
+			}, k)
+		}
+	}
+	return e, nil
+}
+
+// newExtractor creates an extractor of the values at the locations from the
+// grid with the given coordinates without their neighbours.
+func newExtractor(locs []Location, latitudes, longitudes []float32, varNames []string, fill map[string]float64) *Extractor {
+	e := &Extractor{
+		locs:       locs,
+		las:        make([]float32, len(locs)),
+		los:        make([]float32, len(locs)),
+		neighbours: make([][]neighbour, len(locs)),
+		gridLen:    len(latitudes) * len(longitudes),
+		fill:       make([]float64, len(varNames)),
+		hasFill:    make([]bool, len(varNames)),
+	}
+	for i, name := range varNames {
+		e.fill[i], e.hasFill[i] = fill[name]
+	}
+	return e
+}
+
+// Len returns the number of locations.
+func (e *Extractor) Len() int {
+	return len(e.locs)
+}
+
+// Latitudes and Longitudes return the coordinates of the locations as they
+// are set in the extracted records.
+func (e *Extractor) Latitudes() []float32 {
+	return e.las
+}
+
+func (e *Extractor) Longitudes() []float32 {
+	return e.los
+}
+
+// Extract returns the records of the locations estimated from the records of
+// the whole grid at a timestamp, as returned by era5.Scanner.Records. The
+// records of multiple levels are extracted level by level.
+func (e *Extractor) Extract(recs []era5.Record) []era5.Record {
+	if len(recs) == 0 || len(recs)%e.gridLen != 0 {
+		return nil
+	}
+	levelCnt := len(recs) / e.gridLen
+	nv := len(e.fill)
+	out := make([]era5.Record, levelCnt*len(e.locs))
+	values := make([]float64, len(out)*nv)
+	for k := range out {
+		grid := recs[k/len(e.locs)*e.gridLen:][:e.gridLen]
+		i := k % len(e.locs)
+		loc := e.locs[i]
+		r := &out[k]
+		r.Timestamp = grid[0].Timestamp
+		r.Latitude = float32(loc.La)
+		r.Longitude = float32(loc.Lo)
+		r.Level = grid[0].Level
+		r.Values = values[k*nv : (k+1)*nv : (k+1)*nv]
+		for v := range r.Values {
+			var sum, wsum float64
+			for _, n := range e.neighbours[i] {
+				x := grid[n.k].Values[v]
+				if n.w == 0 || era5.IsMissing(x) || e.hasFill[v] && x == e.fill[v] {
+					continue
+				}
+				sum += n.w * x
+				wsum += n.w
+			}
+			if wsum == 0 {
+				r.Values[v] = e.fill[v]
+				continue
+			}
+			r.Values[v] = sum / wsum
+		}
+	}
+	return out
+}
+
+// wraps tells whether the longitudes go around the globe, so that the last
+// one is followed by the first.
+func wraps(longitudes []float32) bool {
+	if len(longitudes) < 2 {
+		return false
+	}
+	step := math.Abs(float64(longitudes[1] - longitudes[0]))
+	span := math.Abs(float64(longitudes[len(longitudes)-1] - longitudes[0]))
+	return math.Abs(span+step-360) < step/2
+}
+
+// bracket returns the indexes of the neighbour coordinates v lies between
+// and the fraction of the way from the first to the second. The coordinates
+// may be ascending or descending. If wrap is set, the coordinates are
+// longitudes going around the globe and v lies between the last and the
+// first one if it is not between any others.
+func bracket(coords []float32, v float64, wrap bool) (int, int, float64, bool) {
+	if len(coords) == 1 {
+		return 0, 0, 0, math.Abs(float64(coords[0])-v) < 1e-6
+	}
+	if wrap {
+		// Bring v into the 360 degrees range starting at the first
+		// coordinate in the direction of the axis.
+		c0 := float64(coords[0])
+		if coords[1] > coords[0] {
+			v = c0 + math.Mod(math.Mod(v-c0, 360)+360, 360)
+		} else {
+			v = c0 - math.Mod(math.Mod(c0-v, 360)+360, 360)
+		}
+	}
+	for i := 1; i < len(coords); i++ {
+		a, b := float64(coords[i-1]), float64(coords[i])
+		if (v-a)*(v-b) <= 0 {
+			return i - 1, i, (v - a) / (b - a), true
+		}
+	}
+	if wrap {
+		n := len(coords) - 1
+		a := float64(coords[n])
+		step := float64(coords[n] - coords[n-1])
+		return n, 0, (v - a) / step, true
+	}
+	return 0, 0, 0, false
+}
+
+// idwNeighbours weights the grid points by the inverse squared distance to
+// the location. The distances are measured on the equirectangular
+// projection around the location. A grid point at the location gets all the
+// weight.
+func idwNeighbours(latitudes, longitudes []float32, loc Location, pts [4][2]int, k func(la, lo int) int) []neighbour {
+	cos := math.Cos(loc.La * math.Pi / 180)
+	ns := make([]neighbour, 0, len(pts))
+	for _, p := range pts {
+		dla := float64(latitudes[p[0]]) - loc.La
+		dlo := math.Mod(math.Abs(float64(longitudes[p[1]])-loc.Lo), 360)
+		dlo = min(dlo, 360-dlo) * cos
+		d2 := dla*dla + dlo*dlo
+		if d2 < 1e-12 {
+			return []neighbour{{k: k(p[0], p[1]), w: 1}}
+		}
+		ns = append(ns, neighbour{k: k(p[0], p[1]), w: 1 / d2})
+	}
+	return ns
+}
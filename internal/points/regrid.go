@@ -0,0 +1,124 @@
+package points
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Mean is the regridding method that averages the values of the grid points
+// within a cell of the target grid.
+const Mean = "mean"
+
+// NewRegridder creates an extractor of the values at the points of a
+// coarser grid with the resolution in degrees from the grid with the given
+// coordinates. The target grid points are the multiples of the resolution
+// within the grid, ordered like the grid. The Nearest method takes the
+// values of the closest grid point and the Mean method averages the values
+// of the grid points within the resolution-sized cell centered at the target
+// grid point, leaving out the fill values.
+func NewRegridder(latitudes, longitudes []float32, resolution float64, method string, varNames []string, fill map[string]float64) (*Extractor, error) {
+	if method != Nearest && method != Mean {
+		return nil, fmt.Errorf("unsupported regridding method %q", method)
+	}
+	if resolution <= 0 {
+		return nil, fmt.Errorf("resolution %g must be positive", resolution)
+	}
+	wrap := wraps(longitudes)
+	las, los := targetCoords(latitudes, resolution), targetCoords(longitudes, resolution)
+	if len(las) == 0 || len(los) == 0 {
+		return nil, fmt.Errorf("no multiples of %g° within the grid", resolution)
+	}
+	cell := func(coords []float32, c float64, wrap bool) []int {
+		if method == Nearest {
+			return []int{nearest(coords, c, wrap)}
+		}
+		return within(coords, c, resolution, wrap)
+	}
+	laCells := make([][]int, len(las))
+	for i, la := range las {
+		laCells[i] = cell(latitudes, la, false)
+	}
+	loCells := make([][]int, len(los))
+	for j, lo := range los {
+		loCells[j] = cell(longitudes, lo, wrap)
+	}
+	var locs []Location
+	var neighbours [][]neighbour
+	for i, la := range las {
+		for j, lo := range los {
+			if len(laCells[i]) == 0 || len(loCells[j]) == 0 {
+				// The grid is finer than its points.
+				continue
+			}
+			ns := make([]neighbour, 0, len(laCells[i])*len(loCells[j]))
+			for _, a := range laCells[i] {
+				for _, b := range loCells[j] {
+					ns = append(ns, neighbour{k: a*len(longitudes) + b, w: 1})
+				}
+			}
+			locs = append(locs, Location{La: la, Lo: lo})
+			neighbours = append(neighbours, ns)
+		}
+	}
+	e := newExtractor(locs, latitudes, longitudes, varNames, fill)
+	e.neighbours = neighbours
+	e.las = make([]float32, len(las))
+	for i, la := range las {
+		e.las[i] = float32(la)
+	}
+	e.los = make([]float32, len(los))
+	for j, lo := range los {
+		e.los[j] = float32(lo)
+	}
+	return e, nil
+}
+
+// targetCoords returns the multiples of the resolution within the range of
+// the coordinates in their order.
+func targetCoords(coords []float32, resolution float64) []float64 {
+	lo, hi := float64(slices.Min(coords)), float64(slices.Max(coords))
+	var target []float64
+	for n := int(math.Ceil(lo/resolution - 1e-6)); n <= int(math.Floor(hi/resolution+1e-6)); n++ {
+		// The coordinates are rounded to drop the float noise of the
+		// multiplication.
+		target = append(target, math.Round(float64(n)*resolution*1e6)/1e6)
+	}
+	if len(coords) > 1 && coords[0] > coords[len(coords)-1] {
+		slices.Reverse(target)
+	}
+	return target
+}
+
+// distance returns the distance from c to the coordinate x, which wraps
+// around the globe if wrap is set.
+func distance(x float32, c float64, wrap bool) float64 {
+	d := float64(x) - c
+	if wrap {
+		d = math.Mod(math.Mod(d+180, 360)+360, 360) - 180
+	}
+	return d
+}
+
+// nearest returns the index of the coordinate closest to c.
+func nearest(coords []float32, c float64, wrap bool) int {
+	best := 0
+	for i, x := range coords {
+		if math.Abs(distance(x, c, wrap)) < math.Abs(distance(coords[best], c, wrap)) {
+			best = i
+		}
+	}
+	return best
+}
+
+// within returns the indexes of the coordinates within the cell
+// [c - resolution/2, c + resolution/2).
+func within(coords []float32, c, resolution float64, wrap bool) []int {
+	var idx []int
+	for i, x := range coords {
+		if d := distance(x, c, wrap); d >= -resolution/2-1e-6 && d < resolution/2-1e-6 {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
@@ -0,0 +1,383 @@
+// Package remotewrite sends ERA5 records with the Prometheus remote write
+// protocol.
+package remotewrite
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/rtm0/era5/internal/aggregate"
+	"github.com/rtm0/era5/internal/enrich"
+	"github.com/rtm0/era5/internal/metrics"
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/internal/vm"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+var (
+	requestsSent     = metrics.NewCounter("era5_remote_write_requests_total", "Remote write requests sent, including retries")
+	requestsFailed   = metrics.NewCounter("era5_remote_write_requests_failed_total", "Remote write requests that failed with a network error or an unexpected status")
+	requestBytesSent = metrics.NewCounter("era5_remote_write_request_bytes_total", "Compressed remote write request body bytes sent")
+)
+
+// Client sends records to a Prometheus remote write endpoint.
+type Client struct {
+	logger   *slog.Logger
+	poster   *sink.HTTPPoster
+	url      string
+	headers  map[string]string
+	varNames []string
+	names    []string
+	// metadata holds the encoded metadata of the metrics if withMetadata
+	// is set.
+	withMetadata bool
+	metadata     []byte
+	encoders     chan *encoder
+	labelsTable  *enrich.Table
+	// labels holds the encoded labels of every grid point except the metric
+	// name.
+	labels     map[enrich.Point][]byte
+	transforms *transform.Set
+	// missing replaces the fill values of the variables that have one,
+	// hasFill tells which, if replaceMissing is true.
+	replaceMissing bool
+	missing        float64
+	fill           []float64
+	hasFill        []bool
+	maxRetries     int
+	retryBackoff   time.Duration
+}
+
+// Options controls how a Client encodes and sends records. Network errors,
+// 5xx and 429 responses are retried.
+type Options struct {
+	sink.Options
+
+	// MaxConns is the max number of concurrent connections.
+	MaxConns int
+
+	// MetricPrefix is added to the names of all metrics.
+	MetricPrefix string
+
+	// Headers are added to every request, e.g. to pick the namespace of an
+	// M3 coordinator.
+	Headers map[string]string
+
+	// Metadata makes every request carry the type, help and unit of the
+	// metrics.
+	Metadata bool
+
+	// Missing is what the samples holding the fill value of their variable
+	// are sent as: "keep" sends the fill value, "stale" sends a Prometheus
+	// staleness marker, so graphs break at the gaps instead of connecting
+	// across them, and "nan" sends NaN. Empty means "keep".
+	Missing string
+
+	// Labels are joined to the grid points as extra labels. Nil means no
+	// extra labels.
+	Labels *enrich.Table
+}
+
+var _ sink.Inserter = (*Client)(nil)
+
+// metricInfo describes the metrics of the known variables unless the file
+// describes them with its attributes. The help of the other variables is
+// their name.
+var metricInfo = map[string]struct {
+	help, unit string
+}{
+	"u10": {"10 metre U wind component", "m s**-1"},
+	"v10": {"10 metre V wind component", "m s**-1"},
+	"t2m": {"2 metre temperature", "K"},
+	"sf":  {"Snowfall", "m of water equivalent"},
+	"tcc": {"Total cloud cover", "(0 - 1)"},
+	"tp":  {"Total precipitation", "m"},
+
+	"u100": {"100 metre U wind component", "m s**-1"},
+	"v100": {"100 metre V wind component", "m s**-1"},
+	"d2m":  {"2 metre dewpoint temperature", "K"},
+	"sp":   {"Surface pressure", "Pa"},
+	"msl":  {"Mean sea level pressure", "Pa"},
+}
+
+// NewClient creates a new remote write client.
+func NewClient(logger *slog.Logger, url string, opts Options) (*Client, error) {
+	if err := vm.CheckMetricPrefix(opts.MetricPrefix); err != nil {
+		return nil, err
+	}
+	maxConns := max(opts.MaxConns, 1)
+	missing, replaceMissing := missingValues[opts.Missing]
+	if !replaceMissing && opts.Missing != "" && opts.Missing != "keep" {
+		return nil, fmt.Errorf("unsupported missing value mode %q", opts.Missing)
+	}
+	c := &Client{
+		logger: logger,
+		poster: &sink.HTTPPoster{
+			Client: &http.Client{
+				Transport: &http.Transport{
+					DialContext: (&net.Dialer{
+						Timeout:   30 * time.Second,
+						KeepAlive: 30 * time.Second,
+					}).DialContext,
+					MaxIdleConns:        maxConns,
+					IdleConnTimeout:     30 * time.Second,
+					MaxIdleConnsPerHost: maxConns,
+					MaxConnsPerHost:     maxConns,
+					DisableCompression:  true,
+				},
+			},
+			RequestsSent:   requestsSent,
+			RequestsFailed: requestsFailed,
+		},
+		url:            url,
+		headers:        opts.Headers,
+		labelsTable:    opts.Labels,
+		transforms:     opts.Transforms,
+		missing:        missing,
+		replaceMissing: replaceMissing,
+		encoders:       make(chan *encoder, maxConns),
+		maxRetries:     opts.MaxRetries,
+		retryBackoff:   opts.RetryBackoff,
+		varNames:       opts.Variables,
+	}
+	if len(c.varNames) == 0 {
+		c.varNames = era5.VarNames
+	}
+	c.names = make([]string, len(c.varNames))
+	c.fill = make([]float64, len(c.varNames))
+	c.hasFill = make([]bool, len(c.varNames))
+	for i, name := range c.varNames {
+		c.names[i] = opts.MetricPrefix + "_" + name
+	}
+	c.withMetadata = opts.Metadata
+	c.SetVarMeta(nil)
+	for range maxConns {
+		c.encoders <- &encoder{}
+	}
+	return c, nil
+}
+
+// staleNaN is the NaN Prometheus uses as a staleness marker.
+var staleNaN = math.Float64frombits(0x7ff0000000000002)
+
+// missingValues maps the missing value modes to the values sent instead of
+// the fill values.
+var missingValues = map[string]float64{
+	"stale": staleNaN,
+	"nan":   math.NaN(),
+}
+
+// SetFillValues sets the fill values of the variables by their names. It
+// must be called before any concurrent Insert calls.
+func (c *Client) SetFillValues(fill map[string]float64) {
+	for i, name := range c.varNames {
+		c.fill[i], c.hasFill[i] = fill[name]
+	}
+}
+
+// SetVarMeta sets the units and the long names of the variables by their
+// names, which describe the metrics in the metadata instead of the built-in
+// descriptions. It does nothing unless the requests carry metadata. It must
+// be called before any concurrent Insert calls.
+func (c *Client) SetVarMeta(meta map[string]era5.VarMeta) {
+	if !c.withMetadata {
+		return
+	}
+	c.metadata = nil
+	for i, name := range c.varNames {
+		m, ok := metricInfo[name]
+		if !ok {
+			m.help = name
+		}
+		if fm, ok := meta[name]; ok {
+			m.help = cmp.Or(fm.LongName, m.help)
+			m.unit = cmp.Or(fm.Units, m.unit)
+		}
+		var md []byte
+		md = appendInt64(md, metadataType, metricTypeGauge)
+		md = appendString(md, metadataMetricFamilyName, c.names[i])
+		md = appendString(md, metadataHelp, m.help)
+		md = appendString(md, metadataUnit, m.unit)
+		c.metadata = appendBytes(c.metadata, writeRequestMetadata, md)
+	}
+}
+
+// SetGrid pre-encodes the labels of the grid points the inserted records
+// belong to. It must be called before any concurrent Insert calls.
+func (c *Client) SetGrid(latitudes, longitudes []float32) {
+	var matched map[enrich.Point][]string
+	if c.labelsTable != nil {
+		matched = c.labelsTable.Match(latitudes, longitudes)
+	}
+	c.labels = make(map[enrich.Point][]byte, len(latitudes)*len(longitudes))
+	for _, la := range latitudes {
+		for _, lo := range longitudes {
+			p := enrich.Point{La: la, Lo: lo}
+			c.labels[p] = c.appendLabels(nil, p, matched[p])
+		}
+	}
+}
+
+// appendLabels encodes the labels of a grid point except the metric name,
+// which goes first, in the order of their names as remote write requires.
+func (c *Client) appendLabels(dst []byte, p enrich.Point, values []string) []byte {
+	labels := [][2]string{{"la", vm.FormatCoord(p.La)}, {"lo", vm.FormatCoord(p.Lo)}}
+	for i, v := range values {
+		if v != "" {
+			labels = append(labels, [2]string{c.labelsTable.Names[i], v})
+		}
+	}
+	slices.SortFunc(labels, func(a, b [2]string) int {
+		return strings.Compare(a[0], b[0])
+	})
+	for _, l := range labels {
+		dst = appendLabel(dst, l[0], l[1])
+	}
+	return dst
+}
+
+// InsertContext sends the records in a single remote write request.
+func (c *Client) InsertContext(ctx context.Context, recs []era5.Record) (sink.Result, error) {
+	start := time.Now()
+	result := sink.Result{Rows: len(recs)}
+	err := c.insert(ctx, recs, &result)
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+// encoder holds the buffers of a single request.
+type encoder struct {
+	raw, series, body []byte
+}
+
+func (c *Client) insert(ctx context.Context, recs []era5.Record, result *sink.Result) error {
+	return c.send(ctx, result, func(enc *encoder) []byte {
+		return c.encode(enc, recs)
+	})
+}
+
+// InsertSummaries sends the summaries of the grid as series of the same
+// metrics as the records but labeled with the quantile instead of the
+// coordinates.
+func (c *Client) InsertSummaries(ctx context.Context, sums []aggregate.Summary) (sink.Result, error) {
+	start := time.Now()
+	result := sink.Result{Rows: len(sums)}
+	err := c.send(ctx, &result, func(enc *encoder) []byte {
+		return c.encodeSummaries(enc, sums)
+	})
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+// send encodes a WriteRequest message with encode and sends it in a single
+// request.
+func (c *Client) send(ctx context.Context, result *sink.Result, encode func(enc *encoder) []byte) error {
+	var enc *encoder
+	select {
+	case enc = <-c.encoders:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { c.encoders <- enc }()
+
+	enc.raw = encode(enc)
+	enc.body = snappy.Encode(enc.body[:cap(enc.body)], enc.raw)
+	result.RawBytes = len(enc.raw)
+
+	backoff := c.retryBackoff
+	for attempt := 1; ; attempt++ {
+		result.Attempts++
+		result.Bytes += len(enc.body)
+		requestBytesSent.Add(len(enc.body))
+		err := c.post(ctx, enc.body, result)
+		if err == nil || attempt > c.maxRetries || !sink.IsRetriable(err) {
+			return err
+		}
+		c.logger.Warn("Retrying remote write", "attempt", attempt, "in", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// encode converts the records into a WriteRequest message. Every record
+// field becomes a time series with a single sample.
+func (c *Client) encode(enc *encoder, recs []era5.Record) []byte {
+	dst := enc.raw[:0]
+	for i := range recs {
+		r := &recs[i]
+		p := enrich.Point{La: r.Latitude, Lo: r.Longitude}
+		labels, ok := c.labels[p]
+		if !ok {
+			labels = c.appendLabels(nil, p, nil)
+		}
+		for j, v := range r.Values {
+			if c.transforms.Skip(v) {
+				continue
+			}
+			value := c.transforms.Value(j, v)
+			if c.replaceMissing && (era5.IsMissing(v) || c.hasFill[j] && v == c.fill[j]) {
+				value = c.missing
+			}
+			ts := appendLabel(enc.series[:0], "__name__", c.names[j])
+			ts = append(ts, labels...)
+			// A sample is a double and a varint timestamp.
+			ts = appendTag(ts, timeSeriesSamples, wireBytes)
+			ts = append(ts, byte(1+8+1+uvarintLen(uint64(r.Timestamp))))
+			ts = appendDouble(ts, sampleValue, value)
+			ts = appendInt64(ts, sampleTimestamp, r.Timestamp)
+			enc.series = ts
+			dst = appendBytes(dst, writeRequestTimeseries, ts)
+		}
+	}
+	return append(dst, c.metadata...)
+}
+
+// encodeSummaries converts the summaries into a WriteRequest message. The
+// values missing at all the grid points are omitted.
+func (c *Client) encodeSummaries(enc *encoder, sums []aggregate.Summary) []byte {
+	dst := enc.raw[:0]
+	for i := range sums {
+		s := &sums[i]
+		for j, v := range s.Values {
+			if math.IsNaN(v) {
+				continue
+			}
+			ts := appendLabel(enc.series[:0], "__name__", c.names[j])
+			ts = appendLabel(ts, "quantile", strconv.FormatFloat(s.Quantile, 'g', -1, 64))
+			ts = appendTag(ts, timeSeriesSamples, wireBytes)
+			ts = append(ts, byte(1+8+1+uvarintLen(uint64(s.Timestamp))))
+			ts = appendDouble(ts, sampleValue, v)
+			ts = appendInt64(ts, sampleTimestamp, s.Timestamp)
+			enc.series = ts
+			dst = appendBytes(dst, writeRequestTimeseries, ts)
+		}
+	}
+	return append(dst, c.metadata...)
+}
+
+func (c *Client) post(ctx context.Context, body []byte, result *sink.Result) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-protobuf")
+	header.Set("Content-Encoding", "snappy")
+	header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for name, value := range c.headers {
+		header.Set(name, value)
+	}
+	return c.poster.Post(ctx, c.url, header, bytes.NewReader(body), result)
+}
@@ -0,0 +1,80 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// The field numbers of the Prometheus remote write protobuf messages.
+const (
+	writeRequestTimeseries = 1
+	writeRequestMetadata   = 3
+
+	timeSeriesLabels  = 1
+	timeSeriesSamples = 2
+
+	labelName  = 1
+	labelValue = 2
+
+	sampleValue     = 1
+	sampleTimestamp = 2
+
+	metadataType             = 1
+	metadataMetricFamilyName = 2
+	metadataHelp             = 4
+	metadataUnit             = 5
+
+	// metricTypeGauge is the GAUGE value of the MetricMetadata.MetricType
+	// enum.
+	metricTypeGauge = 2
+)
+
+// The protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendTag(dst []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(dst, uint64(field<<3|wireType))
+}
+
+func appendString(dst []byte, field int, s string) []byte {
+	dst = appendTag(dst, field, wireBytes)
+	dst = binary.AppendUvarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func appendBytes(dst []byte, field int, b []byte) []byte {
+	dst = appendTag(dst, field, wireBytes)
+	dst = binary.AppendUvarint(dst, uint64(len(b)))
+	return append(dst, b...)
+}
+
+func appendInt64(dst []byte, field int, v int64) []byte {
+	dst = appendTag(dst, field, wireVarint)
+	return binary.AppendUvarint(dst, uint64(v))
+}
+
+func appendDouble(dst []byte, field int, v float64) []byte {
+	dst = appendTag(dst, field, wireFixed64)
+	return binary.LittleEndian.AppendUint64(dst, math.Float64bits(v))
+}
+
+// appendLabel appends a Label message as the field of the enclosing message.
+func appendLabel(dst []byte, name, value string) []byte {
+	dst = appendTag(dst, timeSeriesLabels, wireBytes)
+	dst = binary.AppendUvarint(dst, uint64(2+len(name)+uvarintLen(uint64(len(name)))+len(value)+uvarintLen(uint64(len(value)))))
+	dst = appendString(dst, labelName, name)
+	return appendString(dst, labelValue, value)
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
@@ -0,0 +1,126 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/rtm0/era5/internal/metrics"
+)
+
+// MaxErrorBodySize limits how much of an unexpected response body is read to
+// describe the error.
+const MaxErrorBodySize = 4096
+
+// HTTPPoster sends the requests of the sinks that speak HTTP.
+type HTTPPoster struct {
+	Client *http.Client
+
+	// RequestsSent counts the requests and RequestsFailed the ones that
+	// failed with a network error or an unexpected status.
+	RequestsSent   *metrics.Counter
+	RequestsFailed *metrics.Counter
+
+	// WantStatus is the only status of a successful response. Zero means
+	// any 2xx status.
+	WantStatus int
+}
+
+// Post sends body to url with the header and fills in the status code of
+// result. It returns a *StatusError if the response has an unexpected status.
+// The response body is drained, so the connection is reused. Post returns
+// only once the transport has closed the request body, which it may do after
+// the response has arrived, so the caller may then reuse the buffers of body.
+func (p *HTTPPoster) Post(ctx context.Context, url string, header http.Header, body io.Reader, result *Result) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	var open sync.WaitGroup
+	defer open.Wait()
+	if req.Body != nil {
+		req.Body = newClosedBody(req.Body, &open)
+	}
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return newClosedBody(body, &open), nil
+		}
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	p.RequestsSent.Inc()
+	res, err := p.Client.Do(req)
+	if err != nil {
+		p.RequestsFailed.Inc()
+		return fmt.Errorf("could not post data: %w", err)
+	}
+	defer res.Body.Close()
+	result.StatusCode = res.StatusCode
+	if p.WantStatus != 0 && res.StatusCode != p.WantStatus || p.WantStatus == 0 && res.StatusCode/100 != 2 {
+		p.RequestsFailed.Inc()
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, MaxErrorBodySize))
+		io.Copy(io.Discard, res.Body)
+		return &StatusError{StatusCode: res.StatusCode, Body: string(bytes.TrimSpace(msg))}
+	}
+	if _, err := io.Copy(io.Discard, res.Body); err != nil {
+		return fmt.Errorf("could not drain response body: %w", err)
+	}
+	return nil
+}
+
+// closedBody is a request body that tells the wait group when the transport
+// has closed it.
+type closedBody struct {
+	io.ReadCloser
+	open *sync.WaitGroup
+	once sync.Once
+}
+
+func newClosedBody(body io.ReadCloser, open *sync.WaitGroup) *closedBody {
+	open.Add(1)
+	return &closedBody{ReadCloser: body, open: open}
+}
+
+func (b *closedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.open.Done)
+	return err
+}
+
+// StatusError is returned when an HTTP target responds with an unexpected
+// status.
+type StatusError struct {
+	StatusCode int
+	// Body is the beginning of the response body.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("unexpected status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// IsRetriable tells whether a failed HTTP request may succeed if it is sent
+// again. Server-side failures and throttling are retried, while rejected data
+// is not, since sending the same data again would give the same result.
+func IsRetriable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rtm0/era5/internal/metrics"
+)
+
+// lateReader responds to every request with 503 and reads its body only
+// after responding, which the transports may do, e.g. when the server
+// responds before it has read the whole body.
+type lateReader struct {
+	late   sync.WaitGroup
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (rt *lateReader) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.late.Add(1)
+	go func() {
+		defer rt.late.Done()
+		defer req.Body.Close()
+		time.Sleep(time.Millisecond)
+		body, _ := io.ReadAll(req.Body)
+		rt.mu.Lock()
+		rt.bodies = append(rt.bodies, string(body))
+		rt.mu.Unlock()
+	}()
+	return &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+// TestPostReusedBuffer checks that the buffer of a request body may be reused
+// once Post returns, even if the transport reads the body after responding.
+// Run it with -race.
+func TestPostReusedBuffer(t *testing.T) {
+	rt := &lateReader{}
+	p := &HTTPPoster{
+		Client:         &http.Client{Transport: rt},
+		RequestsSent:   &metrics.Counter{},
+		RequestsFailed: &metrics.Counter{},
+	}
+	var buf []byte
+	var want []string
+	for i := range 10 {
+		buf = fmt.Appendf(buf[:0], "request %d", i)
+		want = append(want, string(buf))
+		var result Result
+		err := p.Post(context.Background(), "http://localhost/write", nil, bytes.NewReader(buf), &result)
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("got the error %v, want status 503", err)
+		}
+	}
+	rt.late.Wait()
+	if strings.Join(rt.bodies, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got the bodies %q, want %q", rt.bodies, want)
+	}
+}
@@ -0,0 +1,70 @@
+// Package sink defines how the exporter hands ERA5 records over to the
+// systems they are exported to.
+package sink
+
+import (
+	"context"
+	"time"
+
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// Inserter inserts batches of ERA5 records into a target system.
+// Implementations must be safe for concurrent use.
+type Inserter interface {
+	// InsertContext inserts records. The returned result is filled in as far
+	// as the insert got, even if an error is returned.
+	InsertContext(ctx context.Context, recs []era5.Record) (Result, error)
+}
+
+// BatchInserter is implemented by the inserters that accept the records of a
+// timestamp column by column, which spares converting them into records.
+type BatchInserter interface {
+	// InsertBatch is like Inserter.InsertContext for a batch of records.
+	InsertBatch(ctx context.Context, b *era5.RecordBatch) (Result, error)
+}
+
+// Options are the options shared by the sinks that send the records over the
+// network. Every sink tells which of its failures are retried.
+type Options struct {
+	// MaxRetries is the max number of times a failed request is sent again.
+	MaxRetries int
+
+	// RetryBackoff is the delay before the first retry. It doubles with
+	// every subsequent retry.
+	RetryBackoff time.Duration
+
+	// Transforms are applied to the values of the variables. Nil means the
+	// values are exported as is.
+	Transforms *transform.Set
+
+	// Variables are the names of the variables in the order of the record
+	// values. Empty means era5.VarNames.
+	Variables []string
+}
+
+// Result describes the outcome of a single insert.
+type Result struct {
+	// Rows is the number of records in the request.
+	Rows int
+	// RawBytes is the size of the encoded records before compression.
+	RawBytes int
+	// Bytes is the number of request body bytes sent, including the
+	// retries. It equals RawBytes if compression is disabled and there were
+	// no retries.
+	Bytes int
+	// Duration is the time spent encoding and sending the records.
+	Duration time.Duration
+	// StatusCode is the HTTP status code of the last response. It is zero
+	// if no response was received or the target does not speak HTTP.
+	StatusCode int
+	// Attempts is the number of requests sent, including the retries. The
+	// records may be split into multiple requests to respect the request
+	// size limits of the target.
+	Attempts int
+	// AmbiguousAttempts is the number of attempts that failed after the
+	// request had been fully sent, so the target may have stored the data
+	// even though the attempt failed.
+	AmbiguousAttempts int
+}
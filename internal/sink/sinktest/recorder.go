@@ -0,0 +1,85 @@
+// Package sinktest provides sink implementations for testing.
+package sinktest
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// Recorder is an in-memory sink.Inserter that records every inserted batch.
+// It is safe for concurrent use.
+type Recorder struct {
+	// Err, if set, is called for every batch before it is recorded. A
+	// non-nil error fails the insert and the batch is not recorded.
+	Err func(recs []era5.Record) error
+
+	mu      sync.Mutex
+	batches [][]era5.Record
+}
+
+var _ sink.Inserter = (*Recorder)(nil)
+
+// InsertContext records a copy of recs, values included, since the callers
+// reuse the buffers of the records.
+func (r *Recorder) InsertContext(ctx context.Context, recs []era5.Record) (sink.Result, error) {
+	start := time.Now()
+	result := sink.Result{Rows: len(recs), Attempts: 1}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if r.Err != nil {
+		if err := r.Err(recs); err != nil {
+			result.Duration = time.Since(start)
+			return result, err
+		}
+	}
+	r.mu.Lock()
+	r.batches = append(r.batches, cloneRecords(recs))
+	r.mu.Unlock()
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+func cloneRecords(recs []era5.Record) []era5.Record {
+	recs = slices.Clone(recs)
+	var n int
+	for i := range recs {
+		n += len(recs[i].Values)
+	}
+	values := make([]float64, 0, n)
+	for i := range recs {
+		values = append(values, recs[i].Values...)
+		recs[i].Values = values[len(values)-len(recs[i].Values) : len(values) : len(values)]
+	}
+	return recs
+}
+
+// Batches returns the recorded batches in the order they were inserted.
+func (r *Recorder) Batches() [][]era5.Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.batches)
+}
+
+// Records returns all recorded records.
+func (r *Recorder) Records() []era5.Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var recs []era5.Record
+	for _, b := range r.batches {
+		recs = append(recs, b...)
+	}
+	return recs
+}
+
+// Reset forgets the recorded batches.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.batches = nil
+	r.mu.Unlock()
+}
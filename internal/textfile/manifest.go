@@ -0,0 +1,89 @@
+package textfile
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ManifestName is the name of the manifest file in the output directory.
+const ManifestName = "manifest.json"
+
+// Manifest describes the files written into a directory, so archived exports
+// can be checked for integrity and replayed later.
+type Manifest struct {
+	Files []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry describes a complete file.
+type ManifestEntry struct {
+	// Name is the file name within the directory.
+	Name        string `json:"name"`
+	Format      string `json:"format"`
+	Compression string `json:"compression"`
+	Rows        int64  `json:"rows"`
+	// MinTimestamp and MaxTimestamp are the time range of the records in
+	// milliseconds since the epoch.
+	MinTimestamp int64 `json:"minTs"`
+	MaxTimestamp int64 `json:"maxTs"`
+	// Size is the size of the file in bytes and SHA256 is the hex-encoded
+	// checksum of its contents.
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ReadManifest reads the manifest of the directory. A directory without a
+// manifest has an empty one.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Manifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", ManifestName, err)
+	}
+	return &m, nil
+}
+
+// writeManifest adds the entries to the manifest of the directory. The files
+// written by the previous runs keep their entries unless they have been
+// written again.
+func writeManifest(dir string, entries []ManifestEntry) error {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	m.Files = slices.DeleteFunc(m.Files, func(e ManifestEntry) bool {
+		return slices.ContainsFunc(entries, func(n ManifestEntry) bool { return n.Name == e.Name })
+	})
+	m.Files = append(m.Files, entries...)
+	slices.SortFunc(m.Files, func(a, b ManifestEntry) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ManifestName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, ManifestName))
+}
@@ -0,0 +1,625 @@
+// Package textfile writes ERA5 records into text files on disk, optionally
+// compressed and rotated by size or time window, for archiving exports or
+// loading them into systems the exporter does not talk to.
+package textfile
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/internal/vm"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// The formats of the files.
+const (
+	// FormatInflux is the InfluxDB line protocol.
+	FormatInflux = "influx"
+	// FormatCSV is comma-separated values with a header row.
+	FormatCSV = "csv"
+	// FormatJSONL is a JSON object per line.
+	FormatJSONL = "jsonl"
+)
+
+// The formats of the timestamps of the CSV and JSONL files.
+const (
+	// TimestampUnixMs is the number of milliseconds since the epoch.
+	TimestampUnixMs = "unixms"
+	// TimestampRFC3339 is the RFC 3339 date and time in UTC, e.g.
+	// 2024-01-02T15:00:00Z.
+	TimestampRFC3339 = "rfc3339"
+)
+
+// The compressions of the files.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Options controls the format and the layout of the files.
+type Options struct {
+	// MetricPrefix is added to the names of all metrics and starts the
+	// names of the files.
+	MetricPrefix string
+
+	// Format is one of FormatInflux, FormatCSV or FormatJSONL.
+	Format string
+
+	// Timestamps is the format of the timestamps of FormatCSV and
+	// FormatJSONL, TimestampUnixMs or TimestampRFC3339. Empty means
+	// TimestampUnixMs. FormatInflux has timestamps in nanoseconds only.
+	Timestamps string
+
+	// Compression is one of CompressionNone, CompressionGzip or
+	// CompressionZstd. Empty means no compression.
+	Compression string
+
+	// MaxFileSize is the size in bytes after which a file is closed and the
+	// records go into a new one. The files may exceed it by a batch of
+	// records and the data held by the compressor, which is up to a few
+	// hundred kilobytes. Zero means no limit.
+	MaxFileSize int64
+
+	// RotateInterval splits the records into files by their timestamps,
+	// one file per time window of this duration aligned to multiples of it.
+	// Zero means the records are not split by time.
+	RotateInterval time.Duration
+
+	// MaxOpenFiles is the max number of time windows written to at the same
+	// time. Once it is exceeded the file of the window that has not received
+	// records for the longest time is closed. Records that arrive for that
+	// window later go into another file.
+	MaxOpenFiles int
+
+	// Transforms are applied to the values of the variables. Nil means the
+	// values are written as is.
+	Transforms *transform.Set
+
+	// Variables are the names of the variables in the order of the record
+	// values. Empty means era5.VarNames.
+	Variables []string
+
+	// FileName is the name of the single file in the directory all the
+	// records are written to. The file of a previous run with the same name
+	// is replaced. Empty means the files are named after MetricPrefix and
+	// numbered. A file name requires zero MaxFileSize and RotateInterval.
+	FileName string
+}
+
+// Writer is a sink that writes the records into files in a directory. The
+// files are written under temporary names and get their final names once
+// they are complete, so the output is only complete after Close, which also
+// adds the files to the manifest of the directory.
+type Writer struct {
+	dir            string
+	prefix         string
+	format         format
+	ext            string
+	fileName       string
+	formatName     string
+	compression    string
+	maxFileSize    int64
+	rotateInterval int64
+	maxOpenFiles   int
+	transforms     *transform.Set
+
+	mu    sync.Mutex
+	files map[int64]*outFile
+	// tick orders the files by the time they last received records.
+	tick int64
+	// seq numbers the files of every time window.
+	seq map[int64]int
+	buf []byte
+	// written describes the complete files for the manifest.
+	written []ManifestEntry
+}
+
+var _ sink.Inserter = (*Writer)(nil)
+
+// outFile is a file being written.
+type outFile struct {
+	path    string
+	f       *os.File
+	counter *countingWriter
+	bw      *bufio.Writer
+	zw      io.WriteCloser
+	touched int64
+	// rows, minTs and maxTs describe the written records.
+	rows         int64
+	minTs, maxTs int64
+}
+
+// NewWriter creates a writer of files into dir.
+func NewWriter(dir string, opts Options) (*Writer, error) {
+	if err := vm.CheckMetricPrefix(opts.MetricPrefix); err != nil {
+		return nil, err
+	}
+	f, ok := formats[opts.Format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported format %q", opts.Format)
+	}
+	varNames := opts.Variables
+	if len(varNames) == 0 {
+		varNames = era5.VarNames
+	}
+	ext := "." + opts.Format
+	switch opts.Compression {
+	case "", CompressionNone:
+		opts.Compression = CompressionNone
+	case CompressionGzip:
+		ext += ".gz"
+	case CompressionZstd:
+		ext += ".zst"
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", opts.Compression)
+	}
+	var rfc3339 bool
+	switch opts.Timestamps {
+	case "", TimestampUnixMs:
+	case TimestampRFC3339:
+		if opts.Format == FormatInflux {
+			return nil, fmt.Errorf("format %q does not support %s timestamps", opts.Format, opts.Timestamps)
+		}
+		rfc3339 = true
+	default:
+		return nil, fmt.Errorf("unsupported timestamp format %q", opts.Timestamps)
+	}
+	if opts.RotateInterval < 0 || opts.RotateInterval > 0 && opts.RotateInterval < time.Second {
+		return nil, fmt.Errorf("rotate interval %s is too short", opts.RotateInterval)
+	}
+	if opts.FileName != "" && (opts.MaxFileSize != 0 || opts.RotateInterval != 0) {
+		return nil, errors.New("a file name cannot be combined with a max file size or a rotate interval")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		dir:            dir,
+		prefix:         opts.MetricPrefix,
+		format:         f(opts.MetricPrefix, varNames, opts.Transforms, rfc3339),
+		formatName:     opts.Format,
+		ext:            ext,
+		fileName:       opts.FileName,
+		compression:    opts.Compression,
+		maxFileSize:    opts.MaxFileSize,
+		rotateInterval: opts.RotateInterval.Milliseconds(),
+		maxOpenFiles:   max(opts.MaxOpenFiles, 1),
+		transforms:     opts.Transforms,
+		files:          make(map[int64]*outFile),
+		seq:            make(map[int64]int),
+	}, nil
+}
+
+// InsertContext appends the records to the files of their time windows.
+func (w *Writer) InsertContext(ctx context.Context, recs []era5.Record) (sink.Result, error) {
+	start := time.Now()
+	result := sink.Result{Rows: len(recs), Attempts: 1}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.tick++
+	for len(recs) > 0 {
+		window := w.window(recs[0].Timestamp)
+		n := 1
+		for n < len(recs) && w.window(recs[n].Timestamp) == window {
+			n++
+		}
+		written, err := w.write(window, recs[:n])
+		result.RawBytes += written
+		if err != nil {
+			result.Duration = time.Since(start)
+			return result, err
+		}
+		recs = recs[n:]
+	}
+	var err error
+	for len(w.files) > w.maxOpenFiles && err == nil {
+		oldest := slices.MinFunc(mapKeys(w.files), func(a, b int64) int {
+			return cmp.Compare(w.files[a].touched, w.files[b].touched)
+		})
+		err = w.close(oldest)
+	}
+	result.Bytes = result.RawBytes
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+// window returns the start of the time window of the timestamp.
+func (w *Writer) window(ts int64) int64 {
+	if w.rotateInterval == 0 {
+		return 0
+	}
+	return ts - ((ts%w.rotateInterval)+w.rotateInterval)%w.rotateInterval
+}
+
+// write appends the records of a time window to its file and closes the
+// file if it has grown over the size limit.
+func (w *Writer) write(window int64, recs []era5.Record) (int, error) {
+	of := w.files[window]
+	if of == nil {
+		var err error
+		of, err = w.open(window)
+		if err != nil {
+			return 0, err
+		}
+		w.files[window] = of
+	}
+	of.touched = w.tick
+	w.buf = w.buf[:0]
+	for i := range recs {
+		size := len(w.buf)
+		w.buf = w.format.appendRec(w.buf, &recs[i])
+		if len(w.buf) == size {
+			continue
+		}
+		if of.rows == 0 {
+			of.minTs, of.maxTs = recs[i].Timestamp, recs[i].Timestamp
+		}
+		of.minTs, of.maxTs = min(of.minTs, recs[i].Timestamp), max(of.maxTs, recs[i].Timestamp)
+		of.rows++
+	}
+	if _, err := of.zw.Write(w.buf); err != nil {
+		return 0, fmt.Errorf("could not write %s: %w", of.path, err)
+	}
+	if w.maxFileSize > 0 && of.size() >= w.maxFileSize {
+		return len(w.buf), w.close(window)
+	}
+	return len(w.buf), nil
+}
+
+// open creates the next file of the time window. The files written by the
+// previous runs into the same directory are kept, the new files get the next
+// free numbers, unless the writer has a single file name.
+func (w *Writer) open(window int64) (*outFile, error) {
+	name := w.prefix
+	if w.rotateInterval > 0 {
+		name += "_" + time.UnixMilli(window).UTC().Format("20060102T150405Z")
+	}
+	path := filepath.Join(w.dir, w.fileName)
+	for w.fileName == "" {
+		w.seq[window]++
+		path = filepath.Join(w.dir, fmt.Sprintf("%s_%04d%s", name, w.seq[window], w.ext))
+		if !exists(path) && !exists(path+".tmp") {
+			break
+		}
+	}
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	of := &outFile{path: path, f: f, counter: &countingWriter{w: f, h: sha256.New()}}
+	of.bw = bufio.NewWriterSize(of.counter, 1<<20)
+	switch w.compression {
+	case CompressionGzip:
+		of.zw = gzip.NewWriter(of.bw)
+	case CompressionZstd:
+		of.zw, _ = zstd.NewWriter(of.bw, zstd.WithEncoderConcurrency(1))
+	default:
+		of.zw = nopCloser{of.bw}
+	}
+	if header := w.format.header(); header != nil {
+		if _, err := of.zw.Write(header); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, err
+		}
+	}
+	return of, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// Close closes all the files that are still open.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []error
+	for window := range w.files {
+		errs = append(errs, w.close(window))
+	}
+	if len(w.written) > 0 {
+		if err := writeManifest(w.dir, w.written); err != nil {
+			errs = append(errs, fmt.Errorf("could not write the manifest: %w", err))
+		}
+		w.written = nil
+	}
+	return errors.Join(errs...)
+}
+
+// close completes the file of the time window and gives it the final name.
+func (w *Writer) close(window int64) error {
+	of := w.files[window]
+	delete(w.files, window)
+	err := errors.Join(of.zw.Close(), of.bw.Flush(), of.f.Close())
+	if err != nil {
+		return fmt.Errorf("could not write %s: %w", of.path, err)
+	}
+	if err := os.Rename(of.f.Name(), of.path); err != nil {
+		return err
+	}
+	w.written = append(w.written, ManifestEntry{
+		Name:         filepath.Base(of.path),
+		Format:       w.formatName,
+		Compression:  w.compression,
+		Rows:         of.rows,
+		MinTimestamp: of.minTs,
+		MaxTimestamp: of.maxTs,
+		Size:         of.counter.n,
+		SHA256:       hex.EncodeToString(of.counter.h.Sum(nil)),
+	})
+	return nil
+}
+
+// size returns the number of bytes written to the file so far, including
+// the ones still buffered by the compressor.
+func (of *outFile) size() int64 {
+	return of.counter.n + int64(of.bw.Buffered())
+}
+
+// countingWriter counts and hashes the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+	h hash.Hash
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.h.Write(p[:n])
+	return n, err
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func mapKeys[K comparable, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// format converts records to text.
+type format interface {
+	// header returns the text the files start with. It is nil if there is
+	// none.
+	header() []byte
+	// appendRec converts a record to a line and appends it to dst. Nothing
+	// is appended if all the values are skipped.
+	appendRec(dst []byte, r *era5.Record) []byte
+}
+
+// FormatOfName returns the format and the compression of a file from the
+// extensions of its name, e.g. .lp or .influx for FormatInflux, .csv, or
+// .jsonl or .ndjson for FormatJSONL, optionally followed by .gz or .zst. It
+// returns false if the name has none of the extensions.
+func FormatOfName(name string) (format, compression string, ok bool) {
+	compression = CompressionNone
+	switch ext := filepath.Ext(name); ext {
+	case ".gz":
+		compression = CompressionGzip
+	case ".zst":
+		compression = CompressionZstd
+	}
+	if compression != CompressionNone {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	switch filepath.Ext(name) {
+	case ".lp", ".influx":
+		return FormatInflux, compression, true
+	case ".csv":
+		return FormatCSV, compression, true
+	case ".jsonl", ".ndjson":
+		return FormatJSONL, compression, true
+	}
+	return "", "", false
+}
+
+// formats create the formats of the records. rfc3339 makes the timestamps
+// RFC 3339 dates instead of milliseconds if the format supports it.
+var formats = map[string]func(metricPrefix string, varNames []string, tfs *transform.Set, rfc3339 bool) format{
+	FormatInflux: newInfluxFormat,
+	FormatCSV:    newCSVFormat,
+	FormatJSONL:  newJSONLFormat,
+}
+
+// Encoder converts records one by one into the lines of a format, for the
+// sinks that send every record as a message of its own.
+type Encoder struct {
+	f format
+}
+
+// NewEncoder creates an encoder of the records into the format, one of
+// FormatInflux or FormatJSONL, whose lines stand on their own without a
+// header. Empty varNames means era5.VarNames.
+func NewEncoder(formatName, metricPrefix string, varNames []string, tfs *transform.Set) (*Encoder, error) {
+	if err := vm.CheckMetricPrefix(metricPrefix); err != nil {
+		return nil, err
+	}
+	newFormat, ok := formats[formatName]
+	if len(varNames) == 0 {
+		varNames = era5.VarNames
+	}
+	if !ok || newFormat(metricPrefix, varNames, tfs, false).header() != nil {
+		return nil, fmt.Errorf("unsupported format %q, want %s or %s", formatName, FormatInflux, FormatJSONL)
+	}
+	return &Encoder{f: newFormat(metricPrefix, varNames, tfs, false)}, nil
+}
+
+// AppendRecord appends the line of the record without the trailing newline
+// to dst. Nothing is appended if all the values are skipped.
+func (e *Encoder) AppendRecord(dst []byte, r *era5.Record) []byte {
+	size := len(dst)
+	dst = e.f.appendRec(dst, r)
+	if len(dst) > size {
+		dst = dst[:len(dst)-1]
+	}
+	return dst
+}
+
+type influxFormat struct {
+	prefix     string
+	varNames   []string
+	transforms *transform.Set
+}
+
+func newInfluxFormat(metricPrefix string, varNames []string, tfs *transform.Set, _ bool) format {
+	return &influxFormat{prefix: metricPrefix, varNames: varNames, transforms: tfs}
+}
+
+func (f *influxFormat) header() []byte { return nil }
+
+func (f *influxFormat) appendRec(dst []byte, r *era5.Record) []byte {
+	if f.transforms.SkipAll(r.Values) {
+		return dst
+	}
+	dst = append(dst, f.prefix...)
+	dst = append(dst, ",la="...)
+	dst = append(dst, vm.FormatCoord(r.Latitude)...)
+	dst = append(dst, ",lo="...)
+	dst = append(dst, vm.FormatCoord(r.Longitude)...)
+	sep := byte(' ')
+	for i, v := range r.Values {
+		if f.transforms.Skip(v) {
+			continue
+		}
+		dst = append(dst, sep)
+		dst = append(dst, f.varNames[i]...)
+		dst = append(dst, '=')
+		dst = f.transforms.AppendValue(dst, i, v)
+		sep = ','
+	}
+	dst = append(dst, ' ')
+	// The InfluxDB line protocol timestamps are in nanoseconds.
+	dst = strconv.AppendInt(dst, r.Timestamp*1e6, 10)
+	return append(dst, '\n')
+}
+
+// appendTimestamp appends the timestamp in milliseconds as an RFC 3339 date
+// in UTC if rfc3339 is set and as is otherwise.
+func appendTimestamp(dst []byte, ts int64, rfc3339 bool) []byte {
+	if rfc3339 {
+		return time.UnixMilli(ts).UTC().AppendFormat(dst, time.RFC3339)
+	}
+	return strconv.AppendInt(dst, ts, 10)
+}
+
+type csvFormat struct {
+	prefix     string
+	varNames   []string
+	transforms *transform.Set
+	rfc3339    bool
+}
+
+func newCSVFormat(metricPrefix string, varNames []string, tfs *transform.Set, rfc3339 bool) format {
+	return &csvFormat{prefix: metricPrefix, varNames: varNames, transforms: tfs, rfc3339: rfc3339}
+}
+
+func (f *csvFormat) header() []byte {
+	h := []byte("timestamp,la,lo")
+	for _, name := range f.varNames {
+		h = append(h, ',')
+		h = append(h, f.prefix...)
+		h = append(h, '_')
+		h = append(h, name...)
+	}
+	return append(h, '\n')
+}
+
+func (f *csvFormat) appendRec(dst []byte, r *era5.Record) []byte {
+	if f.transforms.SkipAll(r.Values) {
+		return dst
+	}
+	dst = appendTimestamp(dst, r.Timestamp, f.rfc3339)
+	dst = append(dst, ',')
+	dst = append(dst, vm.FormatCoord(r.Latitude)...)
+	dst = append(dst, ',')
+	dst = append(dst, vm.FormatCoord(r.Longitude)...)
+	for i, v := range r.Values {
+		dst = append(dst, ',')
+		if !f.transforms.Skip(v) {
+			dst = f.transforms.AppendValue(dst, i, v)
+		}
+	}
+	return append(dst, '\n')
+}
+
+type jsonlFormat struct {
+	// keys holds the quoted keys of the values.
+	keys       []string
+	transforms *transform.Set
+	rfc3339    bool
+}
+
+func newJSONLFormat(metricPrefix string, varNames []string, tfs *transform.Set, rfc3339 bool) format {
+	f := &jsonlFormat{keys: make([]string, len(varNames)), transforms: tfs, rfc3339: rfc3339}
+	for i, name := range varNames {
+		f.keys[i] = strconv.Quote(metricPrefix + "_" + name)
+	}
+	return f
+}
+
+func (f *jsonlFormat) header() []byte { return nil }
+
+func (f *jsonlFormat) appendRec(dst []byte, r *era5.Record) []byte {
+	if f.transforms.SkipAll(r.Values) {
+		return dst
+	}
+	dst = append(dst, `{"timestamp":`...)
+	if f.rfc3339 {
+		dst = append(dst, '"')
+		dst = appendTimestamp(dst, r.Timestamp, true)
+		dst = append(dst, '"')
+	} else {
+		dst = strconv.AppendInt(dst, r.Timestamp, 10)
+	}
+	dst = append(dst, `,"la":`...)
+	dst = append(dst, vm.FormatCoord(r.Latitude)...)
+	dst = append(dst, `,"lo":`...)
+	dst = append(dst, vm.FormatCoord(r.Longitude)...)
+	for i, v := range r.Values {
+		if f.transforms.Skip(v) {
+			continue
+		}
+		dst = append(dst, ',')
+		dst = append(dst, f.keys[i]...)
+		dst = append(dst, ':')
+		// JSON has no NaN.
+		if math.IsNaN(f.transforms.Value(i, v)) {
+			dst = append(dst, "null"...)
+			continue
+		}
+		dst = f.transforms.AppendValue(dst, i, v)
+	}
+	return append(dst, "}\n"...)
+}
@@ -0,0 +1,39 @@
+package transform
+
+import (
+	"math"
+	"strconv"
+)
+
+var pow10 = [...]float64{1, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9}
+
+// AppendFixed appends the decimal representation of v with the given number
+// of digits after the decimal point to dst. It produces the same text as
+// strconv.AppendFloat(dst, v, 'f', decimals, 64) for the values the exporter
+// deals with, but without going through the generic float formatting.
+// Values that do not fit into the fixed-point range fall back to strconv.
+func AppendFixed(dst []byte, v float64, decimals int) []byte {
+	if decimals < 0 || decimals >= len(pow10) {
+		return strconv.AppendFloat(dst, v, 'f', decimals, 64)
+	}
+	scaled := math.RoundToEven(math.Abs(v) * pow10[decimals])
+	if math.IsNaN(scaled) || scaled >= 1<<53 {
+		return strconv.AppendFloat(dst, v, 'f', decimals, 64)
+	}
+	if math.Signbit(v) {
+		dst = append(dst, '-')
+	}
+	n := uint64(scaled)
+	div := uint64(pow10[decimals])
+	dst = strconv.AppendUint(dst, n/div, 10)
+	if decimals == 0 {
+		return dst
+	}
+	dst = append(dst, '.')
+	frac := n % div
+	for d := div / 10; d > 0; d /= 10 {
+		dst = append(dst, byte('0'+frac/d))
+		frac %= d
+	}
+	return dst
+}
@@ -0,0 +1,251 @@
+// Package transform implements the transformations of the values of the
+// exported variables. A transformation is an expression of the sample value
+// x, e.g. "(x - 273.15)*9/5 + 32", in the language of the expr package. The
+// values stored in the files are mostly packed integers, which are unpacked
+// into the physical values before the transformations unless the raw values
+// are exported. The fill values replaced with NaN by the scanner are exported
+// according to the missing value policy instead.
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rtm0/era5/internal/expr"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// Func computes the transformed value of a sample.
+type Func func(x float64) float64
+
+// Set holds the transformations of the variables indexed in the order of the
+// exported variables. A nil Set or a nil Func leaves the values unchanged.
+type Set struct {
+	fns []Func
+	// unpack makes the values unpacked with scale and offset, which hold
+	// the packing of every variable of the file being exported.
+	unpack        bool
+	scale, offset []float64
+	// roundScale rounds the values of every variable that are unpacked
+	// only. Zero leaves them unrounded.
+	roundScale []float64
+	// decimals is the number of decimal digits of the values of every
+	// variable that are unpacked only, which is negative if they are
+	// unrounded.
+	decimals  []int
+	precision int
+	// missing is the policy of the values replaced with NaN,
+	// which are exported as missingValue unless they are skipped. It is
+	// empty if the fill values are kept.
+	missing      string
+	missingValue float64
+}
+
+// Parse parses a list of transformations separated by semicolons, each of the
+// form "var: expression", e.g. "t2m: x - 273.15; tp: x * 1000", of the
+// variables varNames. If unpack is set, the values are unpacked with the
+// packing set by SetPacking before they are transformed. An empty list
+// results in a nil Set unless unpack is set. The unpacked and the
+// transformed values are rounded to precision decimal digits. If precision
+// is negative, the values that are unpacked only are rounded to the
+// resolution of their packing instead, which drops the float noise of the
+// unpacking, and the values that are not packed are left as they are. missing is the policy of the fill values, one of
+// era5.MissingPolicies, which results in a non-nil Set unless they are kept.
+func Parse(specs string, varNames []string, precision int, unpack bool, missing string) (*Set, error) {
+	var s *Set
+	if unpack {
+		s = newSet(len(varNames), precision)
+		s.unpack = true
+	}
+	if missing != "" && missing != era5.MissingKeep {
+		if !slices.Contains(era5.MissingPolicies, missing) {
+			return nil, fmt.Errorf("unsupported missing value policy %q, must be one of %v", missing, era5.MissingPolicies)
+		}
+		if s == nil {
+			s = newSet(len(varNames), precision)
+		}
+		s.missing = missing
+		s.missingValue = math.NaN()
+		if missing == era5.MissingZero {
+			s.missingValue = 0
+		}
+	}
+	for _, spec := range strings.Split(specs, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		name, src, ok := strings.Cut(spec, ":")
+		if !ok {
+			return nil, fmt.Errorf("transformation %q must be of the form var: expression", spec)
+		}
+		name = strings.TrimSpace(name)
+		i := slices.Index(varNames, name)
+		if i < 0 {
+			return nil, fmt.Errorf("unknown variable %q, must be one of %s", name, strings.Join(varNames, ", "))
+		}
+		fn, err := compile(src)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse transformation of %s: %w", name, err)
+		}
+		if s == nil {
+			s = newSet(len(varNames), precision)
+		}
+		if s.fns[i] != nil {
+			return nil, fmt.Errorf("variable %s is transformed more than once", name)
+		}
+		if precision >= 0 {
+			fn = round(fn, precision)
+		}
+		s.fns[i] = fn
+	}
+	return s, nil
+}
+
+func newSet(varCnt, precision int) *Set {
+	s := &Set{
+		fns:        make([]Func, varCnt),
+		scale:      make([]float64, varCnt),
+		offset:     make([]float64, varCnt),
+		roundScale: make([]float64, varCnt),
+		decimals:   make([]int, varCnt),
+		precision:  precision,
+	}
+	for i := range s.scale {
+		s.scale[i] = 1
+		s.roundScale[i] = math.Pow10(max(precision, 0))
+		s.decimals[i] = max(precision, 0)
+	}
+	return s
+}
+
+// SetPacking sets the scale_factor and the add_offset attributes of the
+// variables of the file being exported, which unpack the stored values as
+// x*scale + offset. It does nothing if the Set does not unpack the values.
+// It must not be called concurrently with the other methods.
+func (s *Set) SetPacking(scale, offset []float64) {
+	if s == nil || !s.unpack {
+		return
+	}
+	copy(s.scale, scale)
+	copy(s.offset, offset)
+	if s.precision >= 0 {
+		return
+	}
+	for i, sc := range s.scale {
+		if sc == 1 && s.offset[i] == 0 {
+			// The values are stored unpacked, e.g. as floats.
+			s.roundScale[i] = 0
+			s.decimals[i] = -1
+			continue
+		}
+		// One digit more than the scale resolves keeps the values apart.
+		digits := 0
+		if sc != 0 {
+			digits = max(0, int(math.Ceil(-math.Log10(math.Abs(sc))))+1)
+		}
+		s.roundScale[i] = math.Pow10(digits)
+		s.decimals[i] = digits
+	}
+}
+
+// Has tells whether the values of the i-th variable are unpacked or
+// transformed, which makes them floats.
+func (s *Set) Has(i int) bool {
+	return s != nil && (s.unpack || s.fns[i] != nil)
+}
+
+// isMissing tells whether the sample is a fill value replaced by the
+// scanner.
+func (s *Set) isMissing(v float64) bool {
+	return s != nil && s.missing != "" && era5.IsMissing(v)
+}
+
+// Skip tells whether the sample is a fill value left out of the export.
+func (s *Set) Skip(v float64) bool {
+	return s.isMissing(v) && s.missing == era5.MissingSkip
+}
+
+// SkipAll tells whether all the samples of a record are left out of the
+// export.
+func (s *Set) SkipAll(values []float64) bool {
+	return s != nil && s.missing == era5.MissingSkip &&
+		!slices.ContainsFunc(values, func(v float64) bool { return !era5.IsMissing(v) })
+}
+
+// Value returns the transformed value of a sample of the i-th variable. The
+// fill values replaced by the scanner are NaN or zero depending on the
+// missing value policy, they are NaN if they are skipped.
+func (s *Set) Value(i int, v float64) float64 {
+	if s.isMissing(v) {
+		return s.missingValue
+	}
+	if !s.Has(i) {
+		return v
+	}
+	x := v
+	if s.unpack {
+		x = x*s.scale[i] + s.offset[i]
+		if s.fns[i] == nil {
+			if s.roundScale[i] == 0 {
+				return x
+			}
+			return math.Round(x*s.roundScale[i]) / s.roundScale[i]
+		}
+	}
+	return s.fns[i](x)
+}
+
+// AppendValue appends the text form of the transformed value of a sample of
+// the i-th variable to dst. Values that are not transformed are written as
+// stored, so the packed integers stay integers. The integers and the rounded
+// values are written in fixed point, the latter without the trailing zeros,
+// which spares the generic float formatting.
+func (s *Set) AppendValue(dst []byte, i int, v float64) []byte {
+	if !s.Has(i) && !s.isMissing(v) {
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return strconv.AppendInt(dst, int64(v), 10)
+		}
+		return strconv.AppendFloat(dst, v, 'f', -1, 64)
+	}
+	x := s.Value(i, v)
+	decimals := -1
+	if !s.isMissing(v) {
+		if s.fns[i] == nil {
+			decimals = s.decimals[i]
+		} else if s.precision >= 0 {
+			decimals = s.precision
+		}
+	}
+	if decimals < 0 {
+		return strconv.AppendFloat(dst, x, 'g', -1, 64)
+	}
+	n := len(dst)
+	dst = AppendFixed(dst, x, decimals)
+	if bytes.IndexByte(dst[n:], '.') >= 0 {
+		// The zeros stop at the decimal point of the value.
+		dst = bytes.TrimSuffix(bytes.TrimRight(dst, "0"), []byte("."))
+	}
+	return dst
+}
+
+// round makes fn round its values to the given number of decimal digits. Short
+// decimals take fewer bytes to encode and compress better than the float
+// noise of the arithmetic.
+func round(fn Func, digits int) Func {
+	scale := math.Pow10(digits)
+	return func(x float64) float64 {
+		return math.Round(fn(x)*scale) / scale
+	}
+}
+
+// compile parses an expression of the sample value x.
+func compile(src string) (Func, error) {
+	fn, err := expr.Compile(src, func(name string) (expr.Func[float64], bool) {
+		return func(x float64) float64 { return x }, name == "x"
+	})
+	return Func(fn), err
+}
@@ -0,0 +1,191 @@
+package transform
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+var varNames = []string{"t2m", "tp"}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		specs, missing, want string
+	}{
+		{"t2m x", "", "must be of the form var: expression"},
+		{"sp: x", "", `unknown variable "sp"`},
+		{"t2m: x; t2m: x + 1", "", "transformed more than once"},
+		{"t2m: y", "", "could not parse transformation of t2m: at position 2: unknown identifier y"},
+		{"", "fill", `unsupported missing value policy "fill"`},
+	} {
+		if _, err := Parse(tc.specs, varNames, -1, false, tc.missing); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Parse(%q, %q) = %v, want %q", tc.specs, tc.missing, err, tc.want)
+		}
+	}
+}
+
+func TestNilSet(t *testing.T) {
+	s, err := Parse(" ; ", varNames, -1, false, era5.MissingKeep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != nil {
+		t.Fatalf("got a Set for no transformations")
+	}
+	if s.Has(0) || s.Value(0, 1.5) != 1.5 || s.Skip(math.NaN()) || s.SkipAll([]float64{math.NaN()}) {
+		t.Fatal("a nil Set changed the values")
+	}
+}
+
+func TestValue(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		specs     string
+		precision int
+		unpack    bool
+		// v is the stored value of t2m and tp.
+		v, t2m, tp float64
+	}{
+		{"transformed", "t2m: (x - 273.15)*9/5 + 32", -1, false, 283.15, 50, 283.15},
+		{"transformed and rounded", "t2m: x / 3; tp: x * 1000", 2, false, 1, 0.33, 1000},
+		{"unpacked", "", -1, true, 1234, 251.234, 1234},
+		{"unpacked and rounded", "", 1, true, 1234, 251.2, 1234},
+		{"unpacked and transformed", "t2m: x - 273.15", 2, true, 1234, -21.92, 1234},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := Parse(tc.specs, varNames, tc.precision, tc.unpack, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// t2m is packed with a resolution of 0.001 and tp is stored
+			// as a float.
+			s.SetPacking([]float64{0.001, 1}, []float64{250, 0})
+			if got := s.Value(0, tc.v); math.Abs(got-tc.t2m) > 1e-9 {
+				t.Errorf("got the t2m value %v, want %v", got, tc.t2m)
+			}
+			if got := s.Value(1, tc.v); got != tc.tp {
+				t.Errorf("got the tp value %v, want %v", got, tc.tp)
+			}
+		})
+	}
+}
+
+// TestPackingResolution checks that the values that are unpacked only are
+// rounded to one digit more than the resolution of their packing.
+func TestPackingResolution(t *testing.T) {
+	s, err := Parse("", []string{"a", "b", "c"}, -1, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetPacking([]float64{0.0123, 2, 1}, []float64{0.1, 0, 0})
+	for i, tc := range []struct{ v, want float64 }{
+		{3, 0.137},
+		{3, 6},
+		{0.123456789, 0.123456789},
+	} {
+		if got := s.Value(i, tc.v); got != tc.want {
+			t.Errorf("got the value %v of variable %d, want %v", got, i, tc.want)
+		}
+	}
+}
+
+func TestMissing(t *testing.T) {
+	for _, tc := range []struct {
+		missing string
+		want    float64
+		skip    bool
+	}{
+		{era5.MissingNaN, math.NaN(), false},
+		{era5.MissingZero, 0, false},
+		{era5.MissingSkip, math.NaN(), true},
+	} {
+		s, err := Parse("t2m: x + 1", varNames, -1, false, tc.missing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := s.Value(0, math.NaN())
+		if math.Float64bits(got) != math.Float64bits(tc.want) && !(math.IsNaN(got) && math.IsNaN(tc.want)) {
+			t.Errorf("%s: got the missing value %v, want %v", tc.missing, got, tc.want)
+		}
+		if s.Value(0, 1) != 2 || s.Value(1, 1) != 1 {
+			t.Errorf("%s: the values that are not missing changed", tc.missing)
+		}
+		if s.Skip(math.NaN()) != tc.skip || s.Skip(1) {
+			t.Errorf("%s: got Skip %v, want %v", tc.missing, s.Skip(math.NaN()), tc.skip)
+		}
+		if s.SkipAll([]float64{math.NaN(), math.NaN()}) != tc.skip || s.SkipAll([]float64{math.NaN(), 1}) {
+			t.Errorf("%s: SkipAll does not match Skip", tc.missing)
+		}
+	}
+}
+
+func TestAppendValue(t *testing.T) {
+	rounded, err := Parse("t2m: x - 273.15", varNames, 2, false, era5.MissingZero)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unrounded, err := Parse("t2m: x / 3", varNames, -1, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unrounded.SetPacking([]float64{0.01, 1}, []float64{250, 0})
+	for _, tc := range []struct {
+		s    *Set
+		i    int
+		v    float64
+		want string
+	}{
+		{rounded, 0, 300, "26.85"},
+		{rounded, 0, 283.15, "10"},
+		{rounded, 0, 273.25, "0.1"},
+		{rounded, 0, 1e20, "100000000000000000000"},
+		{rounded, 0, math.NaN(), "0"},
+		{rounded, 1, 5, "5"},
+		{rounded, 1, 0.125, "0.125"},
+		{rounded, 1, -32767, "-32767"},
+		{rounded, 1, -0.0, "0"},
+		{rounded, 1, 1e300, strconv.FormatFloat(1e300, 'f', -1, 64)},
+		{unrounded, 0, 1, "83.33666666666666"},
+		{unrounded, 1, 0.1, "0.1"},
+		{nil, 0, 12, "12"},
+	} {
+		if got := string(tc.s.AppendValue([]byte("x="), tc.i, tc.v)); got != "x="+tc.want {
+			t.Errorf("AppendValue(%d, %v) = %q, want %q", tc.i, tc.v, got, "x="+tc.want)
+		}
+	}
+
+	// The values that are unpacked only are rounded to their packing.
+	packed, err := Parse("", varNames, -1, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed.SetPacking([]float64{0.01, 1}, []float64{250, 0})
+	for _, tc := range []struct {
+		i    int
+		v    float64
+		want string
+	}{
+		{0, 123, "251.23"},
+		{0, -25000, "0"},
+		{0, 100, "251"},
+		{1, 0.1, "0.1"},
+	} {
+		if got := string(packed.AppendValue(nil, tc.i, tc.v)); got != tc.want {
+			t.Errorf("AppendValue(%d, %v) = %q, want %q", tc.i, tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestAppendFixed(t *testing.T) {
+	for _, v := range []float64{0, -0.001, 1.5, -3.25, 0.125, 0.135, 89.75, 1e15, 1e300, math.Inf(-1), math.NaN()} {
+		for _, decimals := range []int{0, 2, 3, 9, 12} {
+			want := strconv.FormatFloat(v, 'f', decimals, 64)
+			if got := string(AppendFixed(nil, v, decimals)); got != want {
+				t.Errorf("AppendFixed(%v, %d) = %q, want %q", v, decimals, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package tsdb
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// The constants of the Prometheus TSDB block format.
+const (
+	indexMagic       = 0xBAAAD700
+	indexVersion     = 2
+	chunksMagic      = 0x85BD40DD
+	chunksVersion    = 1
+	chunkEncodingXOR = 1
+	// chunkSegmentSize is the max size of a chunks file.
+	chunkSegmentSize = 512 * 1024 * 1024
+	metaVersion      = 1
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// series is a single series of a block. Its labels are __name__, la and lo,
+// which is also their sort order.
+type series struct {
+	name, la, lo string
+	// help describes the metric. It is empty if the metric is not
+	// described.
+	help   string
+	ts     []int64
+	values []float64
+}
+
+// blockMeta is the content of the meta.json file of a block.
+type blockMeta struct {
+	ULID    string `json:"ulid"`
+	MinTime int64  `json:"minTime"`
+	MaxTime int64  `json:"maxTime"`
+	Stats   struct {
+		NumSamples uint64 `json:"numSamples"`
+		NumSeries  uint64 `json:"numSeries"`
+		NumChunks  uint64 `json:"numChunks"`
+	} `json:"stats"`
+	Compaction struct {
+		Level   int      `json:"level"`
+		Sources []string `json:"sources"`
+	} `json:"compaction"`
+	Version int `json:"version"`
+}
+
+// writeBlock writes the series sorted by their labels as a new block in
+// dir. The block is written into a temporary directory that is renamed once
+// the block is complete, so readers never see a partial block.
+func writeBlock(dir string, ss []series) error {
+	id := newULID(time.Now())
+	tmp := filepath.Join(dir, id+".tmp-for-creation")
+	if err := os.MkdirAll(filepath.Join(tmp, "chunks"), 0o755); err != nil {
+		return err
+	}
+	meta, err := writeBlockFiles(tmp, ss)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	meta.ULID = id
+	meta.Compaction.Level = 1
+	meta.Compaction.Sources = []string{id}
+	meta.Version = metaVersion
+	data, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "meta.json"), data, 0o644); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, id))
+}
+
+// chunkMeta describes a chunk referenced by a series of the index.
+type chunkMeta struct {
+	ref        uint64
+	mint, maxt int64
+}
+
+func writeBlockFiles(dir string, ss []series) (*blockMeta, error) {
+	meta := &blockMeta{}
+	cw := &chunkWriter{dir: filepath.Join(dir, "chunks")}
+	iw, err := newIndexWriter(filepath.Join(dir, "index"), ss)
+	if err != nil {
+		return nil, err
+	}
+	defer iw.f.Close()
+
+	meta.MinTime, meta.MaxTime = ss[0].ts[0], ss[0].ts[0]
+	var chunks []chunkMeta
+	for i := range ss {
+		s := &ss[i]
+		chunks = chunks[:0]
+		for begin := 0; begin < len(s.ts); begin += maxChunkSamples {
+			end := min(begin+maxChunkSamples, len(s.ts))
+			c := newXORChunk()
+			for j := begin; j < end; j++ {
+				c.append(s.ts[j], s.values[j])
+			}
+			ref, err := cw.write(c.bytes())
+			if err != nil {
+				cw.close()
+				return nil, err
+			}
+			chunks = append(chunks, chunkMeta{ref: ref, mint: s.ts[begin], maxt: s.ts[end-1]})
+		}
+		iw.addSeries(s, chunks)
+		meta.MinTime = min(meta.MinTime, s.ts[0])
+		meta.MaxTime = max(meta.MaxTime, s.ts[len(s.ts)-1])
+		meta.Stats.NumSamples += uint64(len(s.ts))
+		meta.Stats.NumChunks += uint64(len(chunks))
+	}
+	meta.Stats.NumSeries = uint64(len(ss))
+	// The max time of a block is exclusive.
+	meta.MaxTime++
+	if err := cw.close(); err != nil {
+		return nil, err
+	}
+	if err := iw.finish(); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// fileWriter is a buffered file writer that tracks its position.
+type fileWriter struct {
+	f   *os.File
+	w   *bufio.Writer
+	pos uint64
+	err error
+}
+
+func createFileWriter(path string) (*fileWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{f: f, w: bufio.NewWriterSize(f, 1<<20)}, nil
+}
+
+func (w *fileWriter) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(b)
+	w.pos += uint64(len(b))
+}
+
+// pad aligns the position to a multiple of align with zero bytes.
+func (w *fileWriter) pad(align uint64) {
+	if n := w.pos % align; n != 0 {
+		w.write(make([]byte, align-n))
+	}
+}
+
+func (w *fileWriter) close() error {
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if err := w.f.Close(); w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// chunkWriter writes chunks into numbered segment files.
+type chunkWriter struct {
+	dir string
+	seq uint64
+	fw  *fileWriter
+}
+
+// write appends a XOR chunk to the current segment and returns its
+// reference: the segment sequence number in the upper 32 bits and the offset
+// within the segment in the lower ones.
+func (w *chunkWriter) write(data []byte) (uint64, error) {
+	chunk := binary.AppendUvarint(nil, uint64(len(data)))
+	chunk = append(chunk, chunkEncodingXOR)
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.Checksum(chunk[len(chunk)-len(data)-1:], castagnoli))
+
+	if w.fw == nil || w.fw.pos+uint64(len(chunk)) > chunkSegmentSize {
+		if err := w.cut(); err != nil {
+			return 0, err
+		}
+	}
+	ref := w.seq<<32 | w.fw.pos
+	w.fw.write(chunk)
+	return ref, w.fw.err
+}
+
+// cut starts a new segment file.
+func (w *chunkWriter) cut() error {
+	if w.fw != nil {
+		if err := w.fw.close(); err != nil {
+			return err
+		}
+		w.seq++
+	}
+	fw, err := createFileWriter(filepath.Join(w.dir, fmt.Sprintf("%06d", w.seq+1)))
+	if err != nil {
+		return err
+	}
+	w.fw = fw
+	header := binary.BigEndian.AppendUint32(nil, chunksMagic)
+	header = append(header, chunksVersion, 0, 0, 0)
+	w.fw.write(header)
+	return w.fw.err
+}
+
+func (w *chunkWriter) close() error {
+	if w.fw == nil {
+		return nil
+	}
+	return w.fw.close()
+}
+
+// indexWriter writes the index file of a block. The series must be added in
+// the order of their labels.
+type indexWriter struct {
+	*fileWriter
+	symbols map[string]uint32
+	// postings holds the ids of the series with every label value.
+	postings map[string]map[string][]uint32
+	all      []uint32
+	toc      [6]uint64
+	buf      []byte
+}
+
+var labelNames = []string{"__name__", "la", "lo"}
+
+func newIndexWriter(path string, ss []series) (*indexWriter, error) {
+	fw, err := createFileWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &indexWriter{
+		fileWriter: fw,
+		symbols:    make(map[string]uint32),
+		postings:   make(map[string]map[string][]uint32),
+	}
+	for _, name := range labelNames {
+		w.postings[name] = make(map[string][]uint32)
+	}
+
+	header := binary.BigEndian.AppendUint32(nil, indexMagic)
+	w.write(append(header, indexVersion))
+
+	// Symbols are referenced by their index in the sorted symbol table.
+	syms := slices.Clone(labelNames)
+	for _, s := range ss {
+		syms = append(syms, s.name, s.la, s.lo)
+	}
+	slices.Sort(syms)
+	syms = slices.Compact(syms)
+	w.toc[0] = w.pos
+	content := binary.BigEndian.AppendUint32(nil, uint32(len(syms)))
+	for i, s := range syms {
+		w.symbols[s] = uint32(i)
+		content = appendUvarintStr(content, s)
+	}
+	w.writeSection(content)
+	w.pad(16)
+	w.toc[1] = w.pos
+	return w, w.err
+}
+
+func appendUvarintStr(dst []byte, s string) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// writeSection writes the content prefixed with its 4 byte length and
+// followed by its checksum.
+func (w *indexWriter) writeSection(content []byte) {
+	w.write(binary.BigEndian.AppendUint32(nil, uint32(len(content))))
+	w.write(content)
+	w.write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(content, castagnoli)))
+}
+
+func (w *indexWriter) addSeries(s *series, chunks []chunkMeta) {
+	w.pad(16)
+	id := uint32(w.pos / 16)
+	w.all = append(w.all, id)
+	values := []string{s.name, s.la, s.lo}
+
+	b := binary.AppendUvarint(w.buf[:0], uint64(len(labelNames)))
+	for i, name := range labelNames {
+		b = binary.AppendUvarint(b, uint64(w.symbols[name]))
+		b = binary.AppendUvarint(b, uint64(w.symbols[values[i]]))
+		w.postings[name][values[i]] = append(w.postings[name][values[i]], id)
+	}
+	b = binary.AppendUvarint(b, uint64(len(chunks)))
+	for i, c := range chunks {
+		if i == 0 {
+			b = binary.AppendVarint(b, c.mint)
+			b = binary.AppendUvarint(b, uint64(c.maxt-c.mint))
+			b = binary.AppendUvarint(b, c.ref)
+			continue
+		}
+		prev := chunks[i-1]
+		b = binary.AppendUvarint(b, uint64(c.mint-prev.maxt))
+		b = binary.AppendUvarint(b, uint64(c.maxt-c.mint))
+		b = binary.AppendVarint(b, int64(c.ref)-int64(prev.ref))
+	}
+	w.buf = b
+	w.write(binary.AppendUvarint(nil, uint64(len(b))))
+	w.write(b)
+	w.write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(b, castagnoli)))
+}
+
+// finish writes the label indexes, the postings and the tables of contents
+// that follow the series and closes the file.
+func (w *indexWriter) finish() error {
+	// Label indexes are only read by the readers of the first index
+	// version, but they are still part of the format.
+	w.pad(4)
+	w.toc[2] = w.pos
+	labelIndexOffsets := make([]uint64, len(labelNames))
+	sortedValues := make([][]string, len(labelNames))
+	for i, name := range labelNames {
+		values := make([]string, 0, len(w.postings[name]))
+		for v := range w.postings[name] {
+			values = append(values, v)
+		}
+		slices.Sort(values)
+		sortedValues[i] = values
+
+		w.pad(4)
+		labelIndexOffsets[i] = w.pos
+		content := binary.BigEndian.AppendUint32(nil, 1)
+		content = binary.BigEndian.AppendUint32(content, uint32(len(values)))
+		for _, v := range values {
+			content = binary.BigEndian.AppendUint32(content, w.symbols[v])
+		}
+		w.writeSection(content)
+	}
+
+	type postingsOffset struct {
+		name, value string
+		off         uint64
+	}
+	var postingsOffsets []postingsOffset
+	writePostings := func(name, value string, ids []uint32) {
+		w.pad(4)
+		postingsOffsets = append(postingsOffsets, postingsOffset{name, value, w.pos})
+		content := binary.BigEndian.AppendUint32(nil, uint32(len(ids)))
+		for _, id := range ids {
+			content = binary.BigEndian.AppendUint32(content, id)
+		}
+		w.writeSection(content)
+	}
+	w.pad(4)
+	w.toc[4] = w.pos
+	// The postings of all series go first under the empty label.
+	writePostings("", "", w.all)
+	for i, name := range labelNames {
+		for _, v := range sortedValues[i] {
+			writePostings(name, v, w.postings[name][v])
+		}
+	}
+
+	w.toc[3] = w.pos
+	content := binary.BigEndian.AppendUint32(nil, uint32(len(labelNames)))
+	for i, name := range labelNames {
+		content = binary.AppendUvarint(content, 1)
+		content = appendUvarintStr(content, name)
+		content = binary.AppendUvarint(content, labelIndexOffsets[i])
+	}
+	w.writeSection(content)
+
+	w.toc[5] = w.pos
+	content = binary.BigEndian.AppendUint32(nil, uint32(len(postingsOffsets)))
+	for _, po := range postingsOffsets {
+		content = binary.AppendUvarint(content, 2)
+		content = appendUvarintStr(content, po.name)
+		content = appendUvarintStr(content, po.value)
+		content = binary.AppendUvarint(content, po.off)
+	}
+	w.writeSection(content)
+
+	var toc []byte
+	for _, off := range w.toc {
+		toc = binary.BigEndian.AppendUint64(toc, off)
+	}
+	w.write(toc)
+	w.write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(toc, castagnoli)))
+	return w.close()
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID made of the millisecond timestamp and random bits in
+// the Crockford's base32 text form. ULIDs name the block directories.
+func newULID(t time.Time) string {
+	var id [16]byte
+	ms := uint64(t.UnixMilli())
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	rand.Read(id[6:])
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	// 26 characters of 5 bits encode 130 bits, the two most significant
+	// of which are zero.
+	var out [26]byte
+	for i := range out {
+		shift := 125 - 5*i
+		var v uint64
+		switch {
+		case shift >= 64:
+			v = hi >> (shift - 64)
+		case shift > 59:
+			v = hi<<(64-shift) | lo>>shift
+		default:
+			v = lo >> shift
+		}
+		out[i] = crockford[v&0x1f]
+	}
+	return string(out[:])
+}
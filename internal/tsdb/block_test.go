@@ -0,0 +1,244 @@
+package tsdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// blockReader reads a block the way the Prometheus index and chunk readers
+// do and checks every checksum on the way.
+type blockReader struct {
+	t       *testing.T
+	dir     string
+	index   []byte
+	symbols []string
+}
+
+// section returns the content of the section at off, which is prefixed with
+// its 4 byte length and followed by its checksum.
+func (r *blockReader) section(off uint64) []byte {
+	r.t.Helper()
+	n := uint64(binary.BigEndian.Uint32(r.index[off:]))
+	content := r.index[off+4 : off+4+n]
+	if sum := binary.BigEndian.Uint32(r.index[off+4+n:]); sum != crc32.Checksum(content, castagnoli) {
+		r.t.Fatalf("the section at %d has a bad checksum", off)
+	}
+	return content
+}
+
+func uvarint(t *testing.T, b *[]byte) uint64 {
+	t.Helper()
+	v, n := binary.Uvarint(*b)
+	if n <= 0 {
+		t.Fatalf("could not read a uvarint from %x", *b)
+	}
+	*b = (*b)[n:]
+	return v
+}
+
+func uvarintStr(t *testing.T, b *[]byte) string {
+	t.Helper()
+	n := uvarint(t, b)
+	s := string((*b)[:n])
+	*b = (*b)[n:]
+	return s
+}
+
+// readBlock returns the series of the block in dir and the postings of
+// every label value.
+func readBlock(t *testing.T, dir string) ([]series, map[string][]uint32) {
+	t.Helper()
+	index, err := os.ReadFile(filepath.Join(dir, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if magic := binary.BigEndian.Uint32(index); magic != indexMagic || index[4] != indexVersion {
+		t.Fatalf("got the index magic %x and version %d, want %x and %d", magic, index[4], indexMagic, indexVersion)
+	}
+	r := &blockReader{t: t, dir: dir, index: index}
+	toc := index[len(index)-6*8-4:]
+	if sum := binary.BigEndian.Uint32(toc[6*8:]); sum != crc32.Checksum(toc[:6*8], castagnoli) {
+		t.Fatal("the table of contents has a bad checksum")
+	}
+	off := func(i int) uint64 { return binary.BigEndian.Uint64(toc[8*i:]) }
+
+	syms := r.section(off(0))
+	n := binary.BigEndian.Uint32(syms)
+	syms = syms[4:]
+	for range n {
+		r.symbols = append(r.symbols, uvarintStr(t, &syms))
+	}
+	if !slices.IsSorted(r.symbols) {
+		t.Fatalf("the symbols %q are not sorted", r.symbols)
+	}
+
+	postings := make(map[string][]uint32)
+	table := r.section(off(5))
+	n = binary.BigEndian.Uint32(table)
+	table = table[4:]
+	for range n {
+		if keys := uvarint(t, &table); keys != 2 {
+			t.Fatalf("got %d keys in a postings offset, want 2", keys)
+		}
+		name, value := uvarintStr(t, &table), uvarintStr(t, &table)
+		p := r.section(uvarint(t, &table))
+		ids := make([]uint32, binary.BigEndian.Uint32(p))
+		for i := range ids {
+			ids[i] = binary.BigEndian.Uint32(p[4+4*i:])
+		}
+		postings[name+"="+value] = ids
+	}
+
+	var ss []series
+	for _, id := range postings["="] {
+		ss = append(ss, r.series(id))
+	}
+	return ss, postings
+}
+
+func (r *blockReader) series(id uint32) series {
+	t := r.t
+	t.Helper()
+	b := r.index[16*uint64(id):]
+	n, k := binary.Uvarint(b)
+	content := b[k : k+int(n)]
+	if sum := binary.BigEndian.Uint32(b[k+int(n):]); sum != crc32.Checksum(content, castagnoli) {
+		t.Fatalf("the series %d has a bad checksum", id)
+	}
+	labels := make(map[string]string)
+	for range uvarint(t, &content) {
+		name := r.symbols[uvarint(t, &content)]
+		labels[name] = r.symbols[uvarint(t, &content)]
+	}
+	s := series{name: labels["__name__"], la: labels["la"], lo: labels["lo"]}
+	var prev chunkMeta
+	for i := range uvarint(t, &content) {
+		var c chunkMeta
+		if i == 0 {
+			c.mint, k = binary.Varint(content)
+			content = content[k:]
+			c.maxt = c.mint + int64(uvarint(t, &content))
+			c.ref = uvarint(t, &content)
+		} else {
+			c.mint = prev.maxt + int64(uvarint(t, &content))
+			c.maxt = c.mint + int64(uvarint(t, &content))
+			delta, k := binary.Varint(content)
+			content = content[k:]
+			c.ref = uint64(int64(prev.ref) + delta)
+		}
+		ts, values := r.chunk(c.ref)
+		if ts[0] != c.mint || ts[len(ts)-1] != c.maxt {
+			t.Fatalf("got the samples from %d to %d in the chunk of %d to %d", ts[0], ts[len(ts)-1], c.mint, c.maxt)
+		}
+		s.ts = append(s.ts, ts...)
+		s.values = append(s.values, values...)
+		prev = c
+	}
+	return s
+}
+
+func (r *blockReader) chunk(ref uint64) ([]int64, []float64) {
+	t := r.t
+	t.Helper()
+	seg, err := os.ReadFile(filepath.Join(r.dir, "chunks", fmt.Sprintf("%06d", ref>>32+1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if magic := binary.BigEndian.Uint32(seg); magic != chunksMagic || seg[4] != chunksVersion {
+		t.Fatalf("got the chunks magic %x and version %d, want %x and %d", magic, seg[4], chunksMagic, chunksVersion)
+	}
+	b := seg[uint32(ref):]
+	n, k := binary.Uvarint(b)
+	if b[k] != chunkEncodingXOR {
+		t.Fatalf("got the chunk encoding %d, want %d", b[k], chunkEncodingXOR)
+	}
+	data := b[k+1 : k+1+int(n)]
+	if sum := binary.BigEndian.Uint32(b[k+1+int(n):]); sum != crc32.Checksum(b[k:k+1+int(n)], castagnoli) {
+		t.Fatalf("the chunk %x has a bad checksum", ref)
+	}
+	return decodeXOR(t, data)
+}
+
+func TestWriteBlock(t *testing.T) {
+	dir := t.TempDir()
+	var want []series
+	for _, name := range []string{"era5_t2m", "era5_tp"} {
+		for _, la := range []string{"0.25", "1.5"} {
+			s := series{name: name, la: la, lo: "-3.75"}
+			// More samples than fit into a chunk.
+			for i := range 2*maxChunkSamples + 7 {
+				s.ts = append(s.ts, 1e12+int64(i)*3600e3)
+				s.values = append(s.values, 273.15+float64(i)/10)
+			}
+			want = append(want, s)
+		}
+	}
+	want[1].values[3] = math.NaN()
+	if err := writeBlock(dir, want); err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 {
+		t.Fatalf("got the blocks %q, want one", blocks)
+	}
+
+	data, err := os.ReadFile(filepath.Join(blocks[0], "meta.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta blockMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.ULID != filepath.Base(blocks[0]) || len(meta.ULID) != 26 {
+		t.Fatalf("got the ULID %q in the block %q", meta.ULID, blocks[0])
+	}
+	last := want[0].ts[len(want[0].ts)-1]
+	if meta.MinTime != want[0].ts[0] || meta.MaxTime != last+1 {
+		t.Fatalf("got the time range [%d, %d), want [%d, %d)", meta.MinTime, meta.MaxTime, want[0].ts[0], last+1)
+	}
+	if st := meta.Stats; st.NumSeries != 4 || st.NumChunks != 12 || st.NumSamples != 4*uint64(len(want[0].ts)) {
+		t.Fatalf("got the stats %+v", st)
+	}
+
+	got, postings := readBlock(t, blocks[0])
+	if len(got) != len(want) {
+		t.Fatalf("got %d series, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.name != w.name || g.la != w.la || g.lo != w.lo {
+			t.Fatalf("got the series %d {%s la=%s lo=%s}, want {%s la=%s lo=%s}", i, g.name, g.la, g.lo, w.name, w.la, w.lo)
+		}
+		if !slices.Equal(g.ts, w.ts) {
+			t.Fatalf("got the timestamps %v of the series %d, want %v", g.ts, i, w.ts)
+		}
+		for j := range w.values {
+			if math.Float64bits(g.values[j]) != math.Float64bits(w.values[j]) {
+				t.Fatalf("got the value %v at %d of the series %d, want %v", g.values[j], j, i, w.values[j])
+			}
+		}
+	}
+	all := postings["="]
+	for label, want := range map[string][]uint32{
+		"__name__=era5_t2m": all[:2],
+		"__name__=era5_tp":  all[2:],
+		"la=0.25":           {all[0], all[2]},
+		"la=1.5":            {all[1], all[3]},
+		"lo=-3.75":          all,
+	} {
+		if !slices.Equal(postings[label], want) {
+			t.Errorf("got the postings %v of %s, want %v", postings[label], label, want)
+		}
+	}
+}
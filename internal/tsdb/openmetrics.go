@@ -0,0 +1,73 @@
+package tsdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// helpEscaper escapes the HELP text as OpenMetrics requires.
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// writeOpenMetrics writes the series into a new OpenMetrics file named after
+// the start of the time range. The samples of every metric family are
+// contiguous and the samples of every series go in the time order, since
+// promtool requires both.
+func writeOpenMetrics(dir string, mint, _ int64, ss []series) error {
+	name := time.UnixMilli(mint).UTC().Format("20060102T150405Z")
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriterSize(tmp, 1<<20)
+	var line []byte
+	for i := range ss {
+		s := &ss[i]
+		if i == 0 || ss[i-1].name != s.name {
+			fmt.Fprintf(w, "# TYPE %s gauge\n", s.name)
+			if s.help != "" {
+				fmt.Fprintf(w, "# HELP %s %s\n", s.name, helpEscaper.Replace(s.help))
+			}
+		}
+		for j, t := range s.ts {
+			line = append(line[:0], s.name...)
+			line = append(line, `{la="`...)
+			line = append(line, s.la...)
+			line = append(line, `",lo="`...)
+			line = append(line, s.lo...)
+			line = append(line, `"} `...)
+			line = strconv.AppendFloat(line, s.values[j], 'g', -1, 64)
+			line = append(line, ' ')
+			// OpenMetrics timestamps are in seconds.
+			line = strconv.AppendFloat(line, float64(t)/1000, 'f', -1, 64)
+			line = append(line, '\n')
+			w.Write(line)
+		}
+	}
+	w.WriteString("# EOF\n")
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// A time range that has been written before gets a numbered file.
+	for n := 0; ; n++ {
+		path := filepath.Join(dir, name+".om")
+		if n > 0 {
+			path = filepath.Join(dir, fmt.Sprintf("%s_%d.om", name, n))
+		}
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			return os.Rename(tmp.Name(), path)
+		}
+	}
+}
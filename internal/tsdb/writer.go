@@ -0,0 +1,336 @@
+// Package tsdb writes ERA5 records for backfilling into the Prometheus-family
+// systems that do not accept old samples over remote write. The records are
+// written either as TSDB blocks, which can be uploaded as is, or as
+// OpenMetrics files for promtool to create the blocks from.
+package tsdb
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/internal/vm"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// Options controls how a Writer lays out the blocks.
+type Options struct {
+	// MetricPrefix is added to the names of all metrics.
+	MetricPrefix string
+
+	// BlockDuration is the time range covered by a block or a file. They are
+	// aligned to multiples of it.
+	BlockDuration time.Duration
+
+	// MaxOpenBlocks is the max number of time ranges kept in memory. Once it
+	// is exceeded the range that has not received records for the longest
+	// time is written to disk. Records that arrive for a time range that has
+	// already been written make up another block or file of the same range,
+	// which Prometheus merges with the first one during compaction.
+	MaxOpenBlocks int
+
+	// Transforms are applied to the values of the variables. Nil means the
+	// values are written as is.
+	Transforms *transform.Set
+
+	// Variables are the names of the variables in the order of the record
+	// values. Empty means era5.VarNames.
+	Variables []string
+}
+
+// Writer is a sink that writes the records into a directory of Prometheus
+// TSDB blocks or OpenMetrics files. The output is only complete after Close.
+type Writer struct {
+	dir   string
+	write writeFunc
+	// varNames are the names of the variables and names the names of
+	// their metrics.
+	varNames []string
+	names    []string
+	// help holds the description of every metric, which the OpenMetrics
+	// files carry.
+	help          []string
+	blockDuration int64
+	maxOpenBlocks int
+	transforms    *transform.Set
+
+	mu     sync.Mutex
+	blocks map[int64]*memBlock
+	// tick orders the blocks by the time they last received records.
+	tick   int64
+	coords map[float32]string
+}
+
+var _ sink.Inserter = (*Writer)(nil)
+
+// memBlock holds the records of a block range in memory.
+type memBlock struct {
+	mint    int64
+	points  map[[2]float32]*point
+	touched int64
+}
+
+// point holds the samples of all variables at a grid point. The values are
+// transformed when they are inserted, since the blocks may be written after
+// the transformations have been set up for another file. The skipped samples
+// hold skippedValue, so the variables share the timestamps.
+type point struct {
+	ts     []int64
+	values [][]float64
+}
+
+// writeFunc writes the series of the time range [mint, maxt) sorted by their
+// labels into dir.
+type writeFunc func(dir string, mint, maxt int64, ss []series) error
+
+// NewWriter creates a writer of the blocks into dir.
+func NewWriter(dir string, opts Options) (*Writer, error) {
+	return newWriter(dir, opts, func(dir string, _, _ int64, ss []series) error {
+		return writeBlock(dir, ss)
+	})
+}
+
+// NewOpenMetricsWriter creates a writer of OpenMetrics files into dir, one
+// file per time range, in the shape
+// `promtool tsdb create-blocks-from openmetrics` expects.
+func NewOpenMetricsWriter(dir string, opts Options) (*Writer, error) {
+	return newWriter(dir, opts, writeOpenMetrics)
+}
+
+func newWriter(dir string, opts Options, write writeFunc) (*Writer, error) {
+	if err := vm.CheckMetricPrefix(opts.MetricPrefix); err != nil {
+		return nil, err
+	}
+	if opts.BlockDuration < time.Millisecond {
+		return nil, fmt.Errorf("block duration %s is too short", opts.BlockDuration)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		dir:           dir,
+		write:         write,
+		blockDuration: opts.BlockDuration.Milliseconds(),
+		maxOpenBlocks: max(opts.MaxOpenBlocks, 1),
+		transforms:    opts.Transforms,
+		blocks:        make(map[int64]*memBlock),
+		coords:        make(map[float32]string),
+	}
+	varNames := opts.Variables
+	if len(varNames) == 0 {
+		varNames = era5.VarNames
+	}
+	w.names = make([]string, len(varNames))
+	for i, name := range varNames {
+		w.names[i] = opts.MetricPrefix + "_" + name
+	}
+	w.varNames = varNames
+	w.help = make([]string, len(varNames))
+	return w, nil
+}
+
+// SetVarMeta sets the units and the long names of the variables by their
+// names, which describe the metrics, e.g. "Total precipitation [m]".
+func (w *Writer) SetVarMeta(meta map[string]era5.VarMeta) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, name := range w.varNames {
+		m, ok := meta[name]
+		if !ok || m.LongName == "" {
+			continue
+		}
+		w.help[i] = m.LongName
+		if m.Units != "" {
+			w.help[i] += " [" + m.Units + "]"
+		}
+	}
+}
+
+// InsertContext adds the records to the blocks they belong to and writes the
+// blocks that do not fit into memory anymore.
+func (w *Writer) InsertContext(ctx context.Context, recs []era5.Record) (sink.Result, error) {
+	start := time.Now()
+	result := sink.Result{Rows: len(recs), Attempts: 1}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.tick++
+	for i := range recs {
+		r := &recs[i]
+		mint := r.Timestamp - mod(r.Timestamp, w.blockDuration)
+		b := w.blocks[mint]
+		if b == nil {
+			b = &memBlock{mint: mint, points: make(map[[2]float32]*point)}
+			w.blocks[mint] = b
+		}
+		b.touched = w.tick
+		key := [2]float32{r.Latitude, r.Longitude}
+		p := b.points[key]
+		if p == nil {
+			p = &point{values: make([][]float64, len(w.names))}
+			b.points[key] = p
+		}
+		p.ts = append(p.ts, r.Timestamp)
+		for j, v := range r.Values {
+			value := w.transforms.Value(j, v)
+			if w.transforms.Skip(v) {
+				value = skippedValue
+			}
+			p.values[j] = append(p.values[j], value)
+		}
+	}
+	var err error
+	for len(w.blocks) > w.maxOpenBlocks && err == nil {
+		oldest := slices.MinFunc(mapValues(w.blocks), func(a, b *memBlock) int {
+			return cmp.Compare(a.touched, b.touched)
+		})
+		err = w.flush(oldest)
+	}
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+// Close writes all the blocks that are still in memory.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []error
+	for _, b := range w.blocks {
+		errs = append(errs, w.flush(b))
+	}
+	return errors.Join(errs...)
+}
+
+func (w *Writer) flush(b *memBlock) error {
+	delete(w.blocks, b.mint)
+	type located struct {
+		la, lo string
+		p      *point
+	}
+	pts := make([]located, 0, len(b.points))
+	for key, p := range b.points {
+		pts = append(pts, located{la: w.coord(key[0]), lo: w.coord(key[1]), p: p})
+	}
+	if len(pts) == 0 {
+		return nil
+	}
+	slices.SortFunc(pts, func(a, b located) int {
+		return cmp.Or(cmp.Compare(a.la, b.la), cmp.Compare(a.lo, b.lo))
+	})
+	for _, pt := range pts {
+		sortSamples(pt.p)
+	}
+
+	// Series are sorted by their labels, the metric name first.
+	order := make([]int, len(w.names))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return cmp.Compare(w.names[a], w.names[b])
+	})
+	ss := make([]series, 0, len(order)*len(pts))
+	for _, v := range order {
+		for _, pt := range pts {
+			ts, values := withoutSkipped(pt.p.ts, pt.p.values[v])
+			if len(ts) == 0 {
+				continue
+			}
+			ss = append(ss, series{name: w.names[v], la: pt.la, lo: pt.lo, help: w.help[v], ts: ts, values: values})
+		}
+	}
+	if len(ss) == 0 {
+		return nil
+	}
+	if err := w.write(w.dir, b.mint, b.mint+w.blockDuration, ss); err != nil {
+		return fmt.Errorf("could not write time range [%d, %d): %w", b.mint, b.mint+w.blockDuration, err)
+	}
+	return nil
+}
+
+func (w *Writer) coord(v float32) string {
+	s, ok := w.coords[v]
+	if !ok {
+		s = vm.FormatCoord(v)
+		w.coords[v] = s
+	}
+	return s
+}
+
+// sortSamples orders the samples of the point by time and drops the
+// duplicate timestamps keeping the last sample.
+func sortSamples(p *point) {
+	ordered := true
+	for i := 1; i < len(p.ts) && ordered; i++ {
+		ordered = p.ts[i-1] < p.ts[i]
+	}
+	if ordered {
+		return
+	}
+	idx := make([]int, len(p.ts))
+	for i := range idx {
+		idx[i] = i
+	}
+	slices.SortStableFunc(idx, func(a, b int) int {
+		return cmp.Compare(p.ts[a], p.ts[b])
+	})
+	ts := make([]int64, 0, len(idx))
+	values := make([][]float64, len(p.values))
+	for k, i := range idx {
+		if k+1 < len(idx) && p.ts[idx[k+1]] == p.ts[i] {
+			continue
+		}
+		ts = append(ts, p.ts[i])
+		for v := range values {
+			values[v] = append(values[v], p.values[v][i])
+		}
+	}
+	p.ts = ts
+	p.values = values
+}
+
+// skippedValue marks the samples left out of the series. It is a NaN with a
+// payload of its own, which tells it from the NaN values that are written.
+var skippedValue = math.Float64frombits(0x7ff8000000000bad)
+
+// withoutSkipped returns the samples of a series except the skipped ones. The
+// slices are returned as they are if no sample is skipped.
+func withoutSkipped(ts []int64, values []float64) ([]int64, []float64) {
+	isSkipped := func(v float64) bool { return math.Float64bits(v) == math.Float64bits(skippedValue) }
+	if !slices.ContainsFunc(values, isSkipped) {
+		return ts, values
+	}
+	keptTs := make([]int64, 0, len(ts))
+	kept := make([]float64, 0, len(values))
+	for i, v := range values {
+		if !isSkipped(v) {
+			keptTs = append(keptTs, ts[i])
+			kept = append(kept, v)
+		}
+	}
+	return keptTs, kept
+}
+
+func mod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
+func mapValues[K comparable, V any](m map[K]V) []V {
+	vs := make([]V, 0, len(m))
+	for _, v := range m {
+		vs = append(vs, v)
+	}
+	return vs
+}
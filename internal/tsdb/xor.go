@@ -0,0 +1,146 @@
+package tsdb
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// bstream is an append-only stream of bits.
+type bstream struct {
+	b []byte
+	// free is the number of bits left in the last byte.
+	free uint8
+}
+
+func (s *bstream) writeBit(bit bool) {
+	if s.free == 0 {
+		s.b = append(s.b, 0)
+		s.free = 8
+	}
+	s.free--
+	if bit {
+		s.b[len(s.b)-1] |= 1 << s.free
+	}
+}
+
+func (s *bstream) writeByte(v byte) {
+	if s.free == 0 {
+		s.b = append(s.b, v)
+		return
+	}
+	s.b[len(s.b)-1] |= v >> (8 - s.free)
+	s.b = append(s.b, v<<s.free)
+}
+
+// writeBits writes the nbits least significant bits of v, the most
+// significant one first.
+func (s *bstream) writeBits(v uint64, nbits int) {
+	v <<= 64 - uint(nbits)
+	for nbits >= 8 {
+		s.writeByte(byte(v >> 56))
+		v <<= 8
+		nbits -= 8
+	}
+	for nbits > 0 {
+		s.writeBit(v>>63 == 1)
+		v <<= 1
+		nbits--
+	}
+}
+
+// maxChunkSamples is the number of samples after which a chunk is cut, the
+// same as Prometheus does.
+const maxChunkSamples = 120
+
+// xorChunk encodes samples with the Gorilla compression Prometheus uses for
+// float samples: timestamps as delta-of-delta and values as XOR with the
+// previous value. The first two bytes of the data hold the sample count.
+type xorChunk struct {
+	s        bstream
+	num      uint16
+	t        int64
+	v        float64
+	tDelta   uint64
+	leading  uint8
+	trailing uint8
+}
+
+func newXORChunk() *xorChunk {
+	return &xorChunk{s: bstream{b: make([]byte, 2, 128)}, leading: 0xff}
+}
+
+func (c *xorChunk) append(t int64, v float64) {
+	var tDelta uint64
+	switch c.num {
+	case 0:
+		c.s.b = binary.AppendVarint(c.s.b, t)
+		c.s.writeBits(math.Float64bits(v), 64)
+	case 1:
+		tDelta = uint64(t - c.t)
+		for _, b := range binary.AppendUvarint(nil, tDelta) {
+			c.s.writeByte(b)
+		}
+		c.writeValue(v)
+	default:
+		tDelta = uint64(t - c.t)
+		dod := int64(tDelta - c.tDelta)
+		switch {
+		case dod == 0:
+			c.s.writeBit(false)
+		case bitRange(dod, 14):
+			c.s.writeBits(0b10, 2)
+			c.s.writeBits(uint64(dod), 14)
+		case bitRange(dod, 17):
+			c.s.writeBits(0b110, 3)
+			c.s.writeBits(uint64(dod), 17)
+		case bitRange(dod, 20):
+			c.s.writeBits(0b1110, 4)
+			c.s.writeBits(uint64(dod), 20)
+		default:
+			c.s.writeBits(0b1111, 4)
+			c.s.writeBits(uint64(dod), 64)
+		}
+		c.writeValue(v)
+	}
+	c.t = t
+	c.v = v
+	c.tDelta = tDelta
+	c.num++
+	binary.BigEndian.PutUint16(c.s.b, c.num)
+}
+
+func bitRange(x int64, nbits uint8) bool {
+	return -((1<<(nbits-1))-1) <= x && x <= 1<<(nbits-1)
+}
+
+func (c *xorChunk) writeValue(v float64) {
+	delta := math.Float64bits(v) ^ math.Float64bits(c.v)
+	if delta == 0 {
+		c.s.writeBit(false)
+		return
+	}
+	c.s.writeBit(true)
+	leading := uint8(bits.LeadingZeros64(delta))
+	trailing := uint8(bits.TrailingZeros64(delta))
+	// The leading zero count is written in 5 bits.
+	if leading >= 32 {
+		leading = 31
+	}
+	if c.leading != 0xff && leading >= c.leading && trailing >= c.trailing {
+		c.s.writeBit(false)
+		c.s.writeBits(delta>>c.trailing, 64-int(c.leading)-int(c.trailing))
+		return
+	}
+	c.leading, c.trailing = leading, trailing
+	c.s.writeBit(true)
+	c.s.writeBits(uint64(leading), 5)
+	// 64 significant bits are written as 0, which the decoder expects.
+	sigbits := 64 - leading - trailing
+	c.s.writeBits(uint64(sigbits), 6)
+	c.s.writeBits(delta>>trailing, int(sigbits))
+}
+
+func (c *xorChunk) bytes() []byte {
+	return c.s.b
+}
@@ -0,0 +1,152 @@
+package tsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// bitReader reads the bits a bstream writes.
+type bitReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *bitReader) readBit() bool {
+	bit := r.b[r.pos/8]>>(7-r.pos%8)&1 == 1
+	r.pos++
+	return bit
+}
+
+func (r *bitReader) readBits(nbits int) uint64 {
+	var v uint64
+	for range nbits {
+		v <<= 1
+		if r.readBit() {
+			v |= 1
+		}
+	}
+	return v
+}
+
+func (r *bitReader) readUvarint() uint64 {
+	var v uint64
+	for shift := 0; ; shift += 7 {
+		b := r.readBits(8)
+		v |= (b & 0x7f) << shift
+		if b < 0x80 {
+			return v
+		}
+	}
+}
+
+// decodeXOR decodes a XOR chunk the way the iterator of Prometheus does.
+func decodeXOR(t *testing.T, data []byte) ([]int64, []float64) {
+	t.Helper()
+	num := int(binary.BigEndian.Uint16(data))
+	first, n := binary.Varint(data[2:])
+	if n <= 0 {
+		t.Fatalf("could not read the first timestamp of the chunk %x", data)
+	}
+	r := &bitReader{b: data, pos: 8 * (2 + n)}
+	var ts []int64
+	var values []float64
+	var tDelta int64
+	var leading, trailing int
+	tm, v := first, 0.0
+	for i := range num {
+		switch i {
+		case 0:
+			v = math.Float64frombits(r.readBits(64))
+		case 1:
+			tDelta = int64(r.readUvarint())
+			tm += tDelta
+			v = readXORValue(r, v, &leading, &trailing)
+		default:
+			var ones int
+			for ones < 4 && r.readBit() {
+				ones++
+			}
+			var dod int64
+			if ones > 0 {
+				size := []int{0, 14, 17, 20, 64}[ones]
+				bits := r.readBits(size)
+				if size < 64 && bits > 1<<(size-1) {
+					bits -= 1 << size
+				}
+				dod = int64(bits)
+			}
+			tDelta += dod
+			tm += tDelta
+			v = readXORValue(r, v, &leading, &trailing)
+		}
+		ts = append(ts, tm)
+		values = append(values, v)
+	}
+	return ts, values
+}
+
+func readXORValue(r *bitReader, prev float64, leading, trailing *int) float64 {
+	if !r.readBit() {
+		return prev
+	}
+	if r.readBit() {
+		*leading = int(r.readBits(5))
+		sigbits := int(r.readBits(6))
+		if sigbits == 0 {
+			sigbits = 64
+		}
+		*trailing = 64 - *leading - sigbits
+	}
+	sigbits := 64 - *leading - *trailing
+	delta := r.readBits(sigbits) << *trailing
+	return math.Float64frombits(math.Float64bits(prev) ^ delta)
+}
+
+func TestXORChunkBytes(t *testing.T) {
+	c := newXORChunk()
+	c.append(1000, 1)
+	c.append(2000, 1)
+	c.append(3000, 2)
+	want := []byte{
+		0x00, 0x03, // sample count
+		0xd0, 0x0f, // first timestamp, zigzag varint
+		0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // first value
+		0xe8, 0x07, // timestamp delta, uvarint
+		// The unchanged value, the zero delta of delta, and the value 2
+		// as its leading zeros 1, its 11 significant bits and the bits.
+		0x30, 0x97, 0xff, 0xc0,
+	}
+	if got := c.bytes(); !bytes.Equal(got, want) {
+		t.Fatalf("got the chunk %x, want %x", got, want)
+	}
+}
+
+func TestXORChunkRoundTrip(t *testing.T) {
+	var ts []int64
+	var values []float64
+	tm := int64(-1e12)
+	// The deltas of delta fall into every bucket of the encoding, and the
+	// values change by none, some and all of their bits.
+	for i, step := range []int64{3600e3, 3600e3, 3600e3 + 1, 3600e3 - 8191, 3600e3 + 8192, 3600e3 + 65536, 3600e3 - 524287, 1 << 40, 1, 3600e3} {
+		tm += step
+		ts = append(ts, tm)
+		values = append(values, []float64{
+			273.15, 273.15, 273.25, -273.25, 0, math.Inf(1), math.NaN(), 1e-300, math.MaxFloat64, 0.1,
+		}[i])
+	}
+	c := newXORChunk()
+	for i := range ts {
+		c.append(ts[i], values[i])
+	}
+	gotTs, gotValues := decodeXOR(t, c.bytes())
+	if len(gotTs) != len(ts) {
+		t.Fatalf("got %d samples, want %d", len(gotTs), len(ts))
+	}
+	for i := range ts {
+		if gotTs[i] != ts[i] || math.Float64bits(gotValues[i]) != math.Float64bits(values[i]) {
+			t.Fatalf("got the sample %d at %d, want %v at %d", i, gotTs[i], values[i], ts[i])
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package vm
+
+// arena is a bump allocator of byte slices. All the slices it has handed out
+// are released at once by reset, so the memory is reused batch after batch
+// without producing garbage.
+type arena struct {
+	block []byte
+	off   int
+	// peak is the max number of bytes requested between two resets,
+	// including the requests the block could not serve.
+	peak int
+}
+
+func newArena(size int) *arena {
+	return &arena{block: make([]byte, size)}
+}
+
+// alloc returns an empty slice with the capacity of n bytes. The slice is
+// only valid until the next reset. If the block is exhausted the slice is
+// allocated on the heap and the block grows on the next reset.
+func (a *arena) alloc(n int) []byte {
+	a.observe(a.off + n)
+	if a.off+n > len(a.block) {
+		return make([]byte, 0, n)
+	}
+	b := a.block[a.off : a.off : a.off+n]
+	a.off += n
+	return b
+}
+
+// observe records that n bytes of the arena have been in use, e.g. after a
+// slice handed out by alloc has been appended beyond its capacity.
+func (a *arena) observe(n int) {
+	a.peak = max(a.peak, n)
+}
+
+// reset releases all the slices handed out by the arena. The block is grown
+// to fit the peak usage, so that a steady workload stops allocating after
+// the first few batches.
+func (a *arena) reset() {
+	if a.peak > len(a.block) {
+		a.block = make([]byte, a.peak+a.peak/4)
+	}
+	a.off = 0
+	a.peak = 0
+}
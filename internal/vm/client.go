@@ -1,6 +1,9 @@
 package vm
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -8,10 +11,15 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/rtm0/era5/internal/era5"
+	"github.com/rtm0/era5/internal/enrich"
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/pkg/era5"
 )
 
 // Client is a Victoria Metrics client capable of inserting ERA5 metrics via
@@ -19,161 +27,661 @@ import (
 type Client struct {
 	logger       *slog.Logger
 	httpCli      *http.Client
+	poster       *sink.HTTPPoster
 	insertURL    string
 	metricPrefix string
-	recToText    recToTextFunc
+	encoders     chan *encoder
+	coords       coordCache
+	maxRetries   int
+	retryBackoff time.Duration
+	// maxRequestSize limits the size of uncompressed request bodies.
+	maxRequestSize int
+	streaming      bool
+
+	// format is used to pre-format the extra labels of the grid points,
+	// which are held by labels. labels is nil if there are no extra labels.
+	format      textFormat
+	labelsTable *enrich.Table
+	labels      *pointLabels
+	// timeLabels holds the labels of the timestamps. It is nil if the file
+	// has no auxiliary text coordinates.
+	timeLabels     *timeLabels
+	timeLabelNames []string
+	// levelLabels holds the level labels of the vertical levels. It is nil
+	// if the file has no vertical dimension.
+	levelLabels levelLabels
+	// baseURL and apiParams make the insert URL again once the names of the
+	// time labels are known.
+	baseURL   *url.URL
+	apiParams apiParamsFunc
+	varNames  []string
+	selectURL string
+}
+
+// Options controls how a Client encodes and sends records. Only the failures
+// that may go away by themselves, such as network errors, 5xx and 429
+// responses, are retried.
+type Options struct {
+	sink.Options
+
+	// MaxConns is the max number of concurrent connections to Victoria
+	// Metrics.
+	MaxConns int
+
+	// MetricPrefix is added to the names of all metrics.
+	MetricPrefix string
+
+	// Compression is the compression of request bodies: one of "none",
+	// "gzip", "zstd" or "auto". In the auto mode the client probes the
+	// insert API and picks the best compression it accepts. Empty means
+	// "none".
+	Compression string
+
+	// MaxRequestSize is the max size of an uncompressed request body. The
+	// records that do not fit are sent in multiple requests. Zero means no
+	// limit.
+	MaxRequestSize int
+
+	// Streaming makes the client encode the records while sending them
+	// instead of encoding the whole batch upfront. The request body is sent
+	// in chunks, so arbitrarily large batches take constant memory and the
+	// server starts parsing before the encoding is finished.
+	Streaming bool
+
+	// EncodeArenaSize is the initial size in bytes of the arena every
+	// connection encodes batches into. The arena is reset per batch and
+	// grows to fit the largest one, so encoding stops producing garbage
+	// once the batch size settles. Zero disables the arena and the encode
+	// buffer is sized after the average record size instead.
+	EncodeArenaSize int
+
+	// Labels are joined to the grid points as extra labels. Nil means no
+	// extra labels.
+	Labels *enrich.Table
+
+	// SelectURL is the base URL of the query APIs, e.g.
+	// http://vmselect:8481/select/0/prometheus for a Victoria Metrics
+	// cluster. Empty means the query APIs are served next to the insert
+	// API, as they are by a single-node Victoria Metrics.
+	SelectURL string
 }
 
+var _ sink.Inserter = (*Client)(nil)
+
 const metricPrefixRE = "^[a-zA-Z0-9]+$"
 
+// CheckMetricPrefix returns an error if the metric prefix cannot be used in
+// metric names.
+func CheckMetricPrefix(metricPrefix string) error {
+	matches, err := regexp.MatchString(metricPrefixRE, metricPrefix)
+	if err != nil {
+		return err
+	}
+	if !matches {
+		return fmt.Errorf("metric prefix %q does not match %q regular expression", metricPrefix, metricPrefixRE)
+	}
+	return nil
+}
+
 // NewClient creates a new VM client.
-func NewClient(logger *slog.Logger, insertURL string, maxConns int, metricPrefix string) (*Client, error) {
+func NewClient(logger *slog.Logger, insertURL string, opts Options) (*Client, error) {
 	url, err := url.Parse(insertURL)
 	if err != nil {
 		return nil, err
 	}
 
-	matches, err := regexp.Match(metricPrefixRE, []byte(metricPrefix))
-	if err != nil {
+	metricPrefix := opts.MetricPrefix
+	if err := CheckMetricPrefix(metricPrefix); err != nil {
 		return nil, err
 	}
-	if !matches {
-		return nil, fmt.Errorf("metric prefix %q does not match %q regular expression", metricPrefix, metricPrefixRE)
+
+	varNames := opts.Variables
+	if len(varNames) == 0 {
+		varNames = era5.VarNames
 	}
 
-	apiParams := apiParamsFuncs[url.Path]
+	path := apiPath(url.Path)
+	apiParams := apiParamsFuncs[path]
 	if apiParams == nil {
 		return nil, fmt.Errorf("inserting into %q is not supported", insertURL)
 	}
-	q := url.Query()
-	for name, value := range apiParams(metricPrefix) {
-		q.Add(name, value)
+	var labelNames []string
+	if opts.Labels != nil {
+		labelNames = opts.Labels.Names
 	}
-	url.RawQuery = q.Encode()
+	baseURL := *url
+	url = withAPIParams(url, apiParams(metricPrefix, varNames, labelNames))
 
-	recToText := recToTextFuncs[url.Path]
-	if recToText == nil {
+	newFormat := newTextFormatFuncs[path]
+	if newFormat == nil {
 		return nil, fmt.Errorf("inserting into %q is not supported", insertURL)
 	}
 
+	maxConns := opts.MaxConns
+	httpCli := &http.Client{
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:        maxConns,
+			IdleConnTimeout:     30 * time.Second,
+			MaxIdleConnsPerHost: maxConns,
+			MaxConnsPerHost:     maxConns,
+			// Request bodies are compressed explicitly, responses are not
+			// worth compressing.
+			DisableCompression: true,
+		},
+	}
+
+	compression := opts.Compression
+	if compression == "" {
+		compression = CompressionNone
+	}
+	if compression == CompressionAuto {
+		// The probe is an empty body of the format.
+		var probe []byte
+		if f, ok := newFormat(metricPrefix, varNames, opts.Transforms).(binaryFormat); ok {
+			probe = f.header()
+		}
+		compression, err = negotiateCompression(httpCli, url.String(), probe)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Negotiated request compression", "compression", compression)
+	}
+	newCompressor, ok := newCompressorFuncs[compression]
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression %q", opts.Compression)
+	}
+
+	encoders := make(chan *encoder, maxConns)
+	for range maxConns {
+		enc := &encoder{format: newFormat(metricPrefix, varNames, opts.Transforms)}
+		if opts.EncodeArenaSize > 0 {
+			enc.arena = newArena(opts.EncodeArenaSize)
+		}
+		if newCompressor != nil {
+			enc.compressor = newCompressor()
+			enc.newStreamCompressor = newStreamCompressorFuncs[compression]
+		}
+		encoders <- enc
+	}
+
 	return &Client{
-		logger: logger,
-		httpCli: &http.Client{
-			Transport: &http.Transport{
-				DialContext: (&net.Dialer{
-					Timeout:   30 * time.Second,
-					KeepAlive: 30 * time.Second,
-				}).DialContext,
-				MaxIdleConns:        maxConns,
-				IdleConnTimeout:     30 * time.Second,
-				MaxIdleConnsPerHost: maxConns,
-				MaxConnsPerHost:     maxConns,
-			},
+		logger:  logger,
+		httpCli: httpCli,
+		poster: &sink.HTTPPoster{
+			Client:         httpCli,
+			RequestsSent:   requestsSent,
+			RequestsFailed: requestsFailed,
+			WantStatus:     http.StatusNoContent,
 		},
 		insertURL:    url.String(),
 		metricPrefix: metricPrefix,
-		recToText:    recToText,
+		encoders:     encoders,
+		maxRetries:   opts.MaxRetries,
+		retryBackoff: opts.RetryBackoff,
+
+		maxRequestSize: opts.MaxRequestSize,
+		streaming:      opts.Streaming,
+
+		format:      newFormat(metricPrefix, varNames, opts.Transforms),
+		labelsTable: opts.Labels,
+		baseURL:     &baseURL,
+		selectURL:   opts.SelectURL,
+		apiParams:   apiParams,
+		varNames:    varNames,
 	}, nil
 }
 
-// Insert inserts ERA5 records into Victoria Metrics.
-func (c *Client) Insert(recs []era5.Record) {
-	res, err := c.httpCli.Post(c.insertURL, "text/plain", recsToText(recs, c.metricPrefix, c.recToText))
-	if err != nil {
-		c.logger.Error("Could not post data", "err", err)
-		return
+// withAPIParams returns a copy of u with the query parameters of the insert
+// API added.
+func withAPIParams(u *url.URL, params map[string]string) *url.URL {
+	u2 := *u
+	q := u2.Query()
+	for name, value := range params {
+		q.Add(name, value)
+	}
+	u2.RawQuery = q.Encode()
+	return &u2
+}
+
+// SetGrid pre-formats the coordinates of the grid the inserted records belong
+// to. It must be called before any concurrent Insert calls.
+func (c *Client) SetGrid(latitudes, longitudes []float32) {
+	c.coords = newCoordCache(latitudes, longitudes)
+	if c.labelsTable != nil {
+		c.labels = newPointLabels(c.format, c.labelsTable, latitudes, longitudes)
+	}
+}
+
+// SetTimeLabels sets the labels of the timestamps the inserted records
+// belong to, which come from the auxiliary text coordinates of the time axis.
+// values holds the label values of every timestamp in the order of names. It
+// must be called before any concurrent Insert calls.
+func (c *Client) SetTimeLabels(names []string, values map[int64][]string) {
+	c.timeLabels, c.timeLabelNames = nil, nil
+	if len(names) > 0 {
+		c.timeLabels, c.timeLabelNames = newTimeLabels(c.format, names, values), names
+	}
+	c.updateInsertURL()
+}
+
+// SetLevels sets the vertical levels of the inserted records, which are
+// labeled with level. Empty levels mean the records have no level label. It
+// must be called before any concurrent Insert calls.
+func (c *Client) SetLevels(levels []float32) {
+	c.levelLabels = nil
+	if len(levels) > 0 {
+		c.levelLabels = newLevelLabels(c.format, levels)
+	}
+	c.updateInsertURL()
+}
+
+// updateInsertURL makes the insert URL again with the names of all the extra
+// labels, which follow the metric values in the columns of the CSV API.
+func (c *Client) updateInsertURL() {
+	var labelNames []string
+	if c.labelsTable != nil {
+		labelNames = c.labelsTable.Names
+	}
+	labelNames = append(slices.Clone(labelNames), c.timeLabelNames...)
+	if c.levelLabels != nil {
+		labelNames = append(labelNames, levelLabel)
+	}
+	c.insertURL = withAPIParams(c.baseURL, c.apiParams(c.metricPrefix, c.varNames, labelNames)).String()
+}
+
+// Insert inserts ERA5 records into Victoria Metrics. The returned result is
+// filled in as far as the request got, even if an error is returned.
+func (c *Client) Insert(recs []era5.Record) (sink.Result, error) {
+	return c.InsertContext(context.Background(), recs)
+}
+
+// InsertContext is like Insert but the request is bound to ctx. Cancelling
+// ctx aborts waiting for a free connection as well as the request itself and
+// the retries.
+func (c *Client) InsertContext(ctx context.Context, recs []era5.Record) (sink.Result, error) {
+	return c.insertRows(ctx, recordRows(recs))
+}
+
+// InsertBatch is like InsertContext but the records are given column by
+// column, which spares converting them into records.
+func (c *Client) InsertBatch(ctx context.Context, b *era5.RecordBatch) (sink.Result, error) {
+	return c.insertRows(ctx, batchRows{b})
+}
+
+func (c *Client) insertRows(ctx context.Context, recs rows) (sink.Result, error) {
+	start := time.Now()
+	result := sink.Result{Rows: recs.len()}
+	err := c.insert(ctx, recs, &result)
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+func (c *Client) insert(ctx context.Context, recs rows, result *sink.Result) error {
+	// There are as many encoders as there are connections, so each
+	// concurrent insert gets its own buffer for the duration of the request.
+	var enc *encoder
+	select {
+	case enc = <-c.encoders:
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	if res.StatusCode != http.StatusNoContent {
-		c.logger.Error("Unexpected status", "code", res.StatusCode)
+	defer func() { c.encoders <- enc }()
+
+	if c.streaming {
+		return c.insertStream(ctx, enc, recs, result)
 	}
-	if _, err := io.Copy(io.Discard, res.Body); err != nil {
-		c.logger.Error("Failed to drain response body", "err", err)
+	raw := enc.encode(recs, c.coords, c.labels, c.timeLabels, c.levelLabels)
+	result.RawBytes = len(raw)
+	bf, binary := enc.format.(binaryFormat)
+	for len(raw) > 0 {
+		var chunk []byte
+		if binary {
+			chunk, raw = bf.split(raw, c.maxRequestSize)
+			// Every request body starts with the header.
+			enc.framed = append(append(enc.framed[:0], bf.header()...), chunk...)
+			chunk = enc.framed
+		} else {
+			chunk, raw = splitLines(raw, c.maxRequestSize)
+		}
+		if err := c.send(ctx, enc, chunk, result); err != nil {
+			return err
+		}
 	}
-	res.Body.Close()
+	return nil
 }
 
-type apiParamsFunc func(string) map[string]string
+// send compresses the encoded records and sends them in a single request,
+// retrying the retriable failures.
+func (c *Client) send(ctx context.Context, enc *encoder, raw []byte, result *sink.Result) error {
+	body := raw
+	contentEncoding := ""
+	if enc.compressor != nil {
+		var err error
+		body, err = enc.compressor.compress(body)
+		if err != nil {
+			return fmt.Errorf("could not compress data: %w", err)
+		}
+		contentEncoding = enc.compressor.contentEncoding()
+	}
+
+	backoff := c.retryBackoff
+	for attempt := 1; ; attempt++ {
+		result.Attempts++
+		result.Bytes += len(body)
+		requestBytesSent.Add(len(body))
+		err := c.post(ctx, c.insertURL, bytes.NewReader(body), raw, contentEncoding, result)
+		if err == nil || attempt > c.maxRetries || !sink.IsRetriable(err) {
+			return err
+		}
+		c.logger.Warn("Retrying insert", "attempt", attempt, "in", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// splitLines splits text into the head no longer than maxSize and the rest.
+// The text is only split at line boundaries, so the head is longer than
+// maxSize if the first line is. Non-positive maxSize means no limit.
+func splitLines(text []byte, maxSize int) (head, rest []byte) {
+	if maxSize <= 0 || len(text) <= maxSize {
+		return text, nil
+	}
+	n := bytes.LastIndexByte(text[:maxSize], '\n') + 1
+	if n == 0 {
+		n = bytes.IndexByte(text, '\n') + 1
+		if n == 0 {
+			return text, nil
+		}
+	}
+	return text[:n], text[n:]
+}
+
+// post sends a single insert request to insertURL. raw is the uncompressed
+// body, which is used to describe errors.
+func (c *Client) post(ctx context.Context, insertURL string, body io.Reader, raw []byte, contentEncoding string, result *sink.Result) error {
+	var trace connTrace
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain")
+	if contentEncoding != "" {
+		header.Set("Content-Encoding", contentEncoding)
+	}
+	err := c.poster.Post(withConnTrace(ctx, &trace), insertURL, header, body, result)
+	var statusErr *sink.StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		return newStatusError(statusErr.StatusCode, []byte(statusErr.Body), raw)
+	case err != nil && trace.wrote:
+		result.AmbiguousAttempts++
+	}
+	return err
+}
+
+// apiParamsFunc returns the query parameters of the insert API given the
+// metric prefix, the names of the variables and the names of the extra
+// labels.
+type apiParamsFunc func(metricPrefix string, varNames, labelNames []string) map[string]string
 
 var apiParamsFuncs = map[string]apiParamsFunc{
-	"/influx/write":        influxDBAPIParams,
-	"/influx/api/v2/write": influxDBAPIParams,
-	"/write":               influxDBAPIParams,
-	"/api/v2/write":        influxDBAPIParams,
-	"/api/v1/import/csv":   csvAPIParams,
+	"/influx/write":         influxDBAPIParams,
+	"/influx/api/v2/write":  influxDBAPIParams,
+	"/write":                influxDBAPIParams,
+	"/api/v2/write":         influxDBAPIParams,
+	"/api/v1/import/csv":    csvAPIParams,
+	"/api/v1/import/native": noAPIParams,
+	"/api/v1/import":        noAPIParams,
 }
 
-func influxDBAPIParams(metricPrefix string) map[string]string {
+// apiPath returns the insert API path that urlPath ends with, so that the
+// APIs are recognized behind the prefixes of the Victoria Metrics cluster,
+// e.g. /insert/42/influx/write. It returns the longest matching path.
+func apiPath(urlPath string) string {
+	path := ""
+	for p := range apiParamsFuncs {
+		if strings.HasSuffix(urlPath, p) && len(p) > len(path) {
+			path = p
+		}
+	}
+	return path
+}
+
+func influxDBAPIParams(metricPrefix string, varNames, labelNames []string) map[string]string {
+	return nil
+}
+
+// noAPIParams is the apiParamsFunc of the APIs whose records describe
+// themselves.
+func noAPIParams(metricPrefix string, varNames, labelNames []string) map[string]string {
 	return nil
 }
 
-func csvAPIParams(metricPrefix string) map[string]string {
-	return map[string]string{
-		"format": fmt.Sprintf(""+
-			"1:time:unix_ms,"+
-			"2:label:la,"+
-			"3:label:lo,"+
-			"4:metric:%[1]s_u10,"+
-			"5:metric:%[1]s_v10,"+
-			"6:metric:%[1]s_t2m,"+
-			"7:metric:%[1]s_sf,"+
-			"8:metric:%[1]s_tcc,"+
-			"9:metric:%[1]s_tp", metricPrefix),
-	}
-}
-
-type recToTextFunc func(*strings.Builder, *era5.Record, string)
-
-// recsToText converts multiple ERA5 records to text.
-func recsToText(recs []era5.Record, metricPrefix string, recToText recToTextFunc) io.Reader {
-	var sb strings.Builder
-	for _, r := range recs {
-		recToText(&sb, &r, metricPrefix)
-		sb.WriteString("\n")
-	}
-	return strings.NewReader(sb.String())
-}
-
-var recToTextFuncs = map[string]recToTextFunc{
-	"/influx/write":        recToInfluxDB,
-	"/influx/api/v2/write": recToInfluxDB,
-	"/write":               recToInfluxDB,
-	"/api/v2/write":        recToInfluxDB,
-	"/api/v1/import/csv":   recToCSV,
-}
-
-var influxDBFmt = "%s,la=%.2f,lo=%.2f u10=%d,v10=%d,t2m=%d,sf=%d,tcc=%d,tp=%d %d"
-
-// recToInfluxDB converts a ERA5 record into InfluxDB line protocol v2 and
-// appends it to the string builder.
-func recToInfluxDB(sb *strings.Builder, r *era5.Record, metricPrefix string) {
-	sb.WriteString(fmt.Sprintf(influxDBFmt, []any{
-		metricPrefix,
-		r.Latitude,
-		r.Longitude,
-		r.ZonalWind10M,
-		r.MeridionalWind10M,
-		r.Temperature2M,
-		r.Snowfall,
-		r.TotalCloudCover,
-		r.TotalPrecipitation,
-		r.Timestamp,
-	}...))
-}
-
-var csvFmt = "%d,%.2f,%.2f,%d,%d,%d,%d,%d,%d"
-
-// recToCSV converts an ERA5 record into a CSV record and appends it to the
-// string builder.
-func recToCSV(sb *strings.Builder, r *era5.Record, _ string) {
-	sb.WriteString(fmt.Sprintf(csvFmt, []any{
-		r.Timestamp,
-		r.Latitude,
-		r.Longitude,
-		r.ZonalWind10M,
-		r.MeridionalWind10M,
-		r.Temperature2M,
-		r.Snowfall,
-		r.TotalCloudCover,
-		r.TotalPrecipitation,
-	}...))
+func csvAPIParams(metricPrefix string, varNames, labelNames []string) map[string]string {
+	format := "1:time:unix_ms,2:label:la,3:label:lo"
+	for i, name := range varNames {
+		format += fmt.Sprintf(",%d:metric:%s_%s", 4+i, metricPrefix, name)
+	}
+	// The extra labels follow the metric values.
+	for i, name := range labelNames {
+		format += fmt.Sprintf(",%d:label:%s", 4+len(varNames)+i, name)
+	}
+	return map[string]string{"format": format}
+}
+
+// textFormat converts ERA5 records into one of the text formats supported by
+// the insert APIs.
+type textFormat interface {
+	// appendRec converts a record to text and appends it to dst. labels,
+	// tsLabels and level are the extra labels of the record location,
+	// timestamp and level formatted by appendLabels. Nothing is appended if
+	// all the values are skipped.
+	appendRec(dst []byte, r *era5.Record, coords coordCache, labels, tsLabels, level []byte) []byte
+	// appendLabels formats the extra labels of a location and appends them
+	// to dst. Empty values mean the location does not have the label.
+	appendLabels(dst []byte, names, values []string) []byte
+}
+
+type newTextFormatFunc func(metricPrefix string, varNames []string, transforms *transform.Set) textFormat
+
+var newTextFormatFuncs = map[string]newTextFormatFunc{
+	"/influx/write":         newInfluxDBFormat,
+	"/influx/api/v2/write":  newInfluxDBFormat,
+	"/write":                newInfluxDBFormat,
+	"/api/v2/write":         newInfluxDBFormat,
+	"/api/v1/import/csv":    newCSVFormat,
+	"/api/v1/import/native": newNativeFormat,
+	"/api/v1/import":        newJSONLineFormat,
+}
+
+// encoder converts batches of ERA5 records to text using a buffer that is
+// reused between batches. The buffer is pre-sized from the average encoded
+// record size of the recent batches so that it neither grows while encoding
+// nor pins the memory of a single unusually large batch forever.
+type encoder struct {
+	format     textFormat
+	compressor compressor
+	// newStreamCompressor creates a compressing writer for the streaming
+	// mode. It is nil if compression is disabled.
+	newStreamCompressor newStreamCompressorFunc
+	// arena backs buf if the encode arena is enabled.
+	arena      *arena
+	buf        []byte
+	avgRecSize int
+	// framed holds a request body of a binaryFormat, the header followed
+	// by a part of buf.
+	framed []byte
+}
+
+// encode converts multiple ERA5 records to text. The returned slice is only
+// valid until the next call to encode.
+func (e *encoder) encode(recs rows, coords coordCache, labels *pointLabels, tsLabels *timeLabels, levels levelLabels) []byte {
+	n := recs.len()
+	e.reset(n)
+	var scratch era5.Record
+	for i := range n {
+		e.appendRec(recs.row(i, &scratch), coords, labels, tsLabels, levels)
+	}
+	if n > 0 {
+		e.observe(len(e.buf) / n)
+	}
+	if e.arena != nil {
+		e.arena.observe(len(e.buf))
+	}
+	return e.buf
+}
+
+// appendRec encodes a record and appends it to the buffer followed by a
+// newline unless the format is binary.
+func (e *encoder) appendRec(r *era5.Record, coords coordCache, labels *pointLabels, tsLabels *timeLabels, levels levelLabels) {
+	size := len(e.buf)
+	e.buf = e.format.appendRec(e.buf, r, coords, labels.get(r), tsLabels.get(r), levels.get(r))
+	if _, binary := e.format.(binaryFormat); len(e.buf) > size && !binary {
+		e.buf = append(e.buf, '\n')
+	}
+}
+
+// reset empties the buffer and resizes it to fit recCnt records of an
+// average size with some headroom.
+func (e *encoder) reset(recCnt int) {
+	want := recCnt * (e.avgRecSize + e.avgRecSize/4)
+	if e.arena != nil {
+		e.arena.reset()
+		e.buf = e.arena.alloc(want)
+		return
+	}
+	if cap(e.buf) < want || cap(e.buf) > 2*want {
+		e.buf = make([]byte, 0, want)
+	}
+	e.buf = e.buf[:0]
+}
+
+// observe updates the moving average of the encoded record size.
+func (e *encoder) observe(recSize int) {
+	if e.avgRecSize == 0 {
+		e.avgRecSize = recSize
+		return
+	}
+	e.avgRecSize = (7*e.avgRecSize + recSize) / 8
+}
+
+// influxDBFormat converts records into InfluxDB line protocol v2. The parts of
+// the line that only depend on the metric prefix are prepared once.
+type influxDBFormat struct {
+	name        []byte
+	measurement []byte
+	lo          []byte
+	fields      [][]byte
+	transforms  *transform.Set
+}
+
+func newInfluxDBFormat(metricPrefix string, varNames []string, transforms *transform.Set) textFormat {
+	f := &influxDBFormat{
+		name:        []byte(metricPrefix),
+		measurement: []byte(metricPrefix + ",la="),
+		lo:          []byte(",lo="),
+		fields:      make([][]byte, len(varNames)),
+		transforms:  transforms,
+	}
+	for i, name := range varNames {
+		f.fields[i] = []byte(name + "=")
+	}
+	return f
+}
+
+func (f *influxDBFormat) appendRec(dst []byte, r *era5.Record, coords coordCache, labels, tsLabels, level []byte) []byte {
+	if f.transforms.SkipAll(r.Values) {
+		return dst
+	}
+	dst = append(dst, f.measurement...)
+	dst = coords.appendCoord(dst, r.Latitude)
+	dst = append(dst, f.lo...)
+	dst = coords.appendCoord(dst, r.Longitude)
+	dst = append(dst, labels...)
+	dst = append(dst, tsLabels...)
+	dst = append(dst, level...)
+	sep := byte(' ')
+	for i, v := range r.Values {
+		if f.transforms.Skip(v) {
+			continue
+		}
+		dst = append(dst, sep)
+		dst = append(dst, f.fields[i]...)
+		dst = f.transforms.AppendValue(dst, i, v)
+		sep = ','
+	}
+	dst = append(dst, ' ')
+	return strconv.AppendInt(dst, r.Timestamp, 10)
+}
+
+// csvFormat converts records into CSV rows whose column order matches the
+// format parameter returned by csvAPIParams.
+type csvFormat struct {
+	transforms *transform.Set
+}
+
+func newCSVFormat(_ string, _ []string, transforms *transform.Set) textFormat {
+	return csvFormat{transforms: transforms}
+}
+
+func (f csvFormat) appendRec(dst []byte, r *era5.Record, coords coordCache, labels, tsLabels, level []byte) []byte {
+	if f.transforms.SkipAll(r.Values) {
+		return dst
+	}
+	dst = strconv.AppendInt(dst, r.Timestamp, 10)
+	dst = append(dst, ',')
+	dst = coords.appendCoord(dst, r.Latitude)
+	dst = append(dst, ',')
+	dst = coords.appendCoord(dst, r.Longitude)
+	for i, v := range r.Values {
+		dst = append(dst, ',')
+		// The empty values are skipped by the CSV import.
+		if !f.transforms.Skip(v) {
+			dst = f.transforms.AppendValue(dst, i, v)
+		}
+	}
+	dst = append(dst, labels...)
+	dst = append(dst, tsLabels...)
+	return append(dst, level...)
+}
+
+// coordCache holds the text representation of latitude and longitude values.
+// Coordinates repeat for every timestamp so formatting them once per grid is
+// much cheaper than formatting them for every record.
+type coordCache map[float32][]byte
+
+func newCoordCache(la, lo []float32) coordCache {
+	c := make(coordCache, len(la)+len(lo))
+	for _, coords := range [][]float32{la, lo} {
+		for _, v := range coords {
+			if _, ok := c[v]; !ok {
+				c[v] = formatCoord(nil, v)
+			}
+		}
+	}
+	return c
+}
+
+// appendCoord appends the text representation of a coordinate to dst. The
+// coordinates that are not in the cache are formatted on the fly.
+func (c coordCache) appendCoord(dst []byte, v float32) []byte {
+	if b, ok := c[v]; ok {
+		return append(dst, b...)
+	}
+	return formatCoord(dst, v)
+}
+
+func formatCoord(dst []byte, v float32) []byte {
+	return transform.AppendFixed(dst, float64(v), 2)
+}
+
+// FormatCoord returns a coordinate formatted the way it appears in the la
+// and lo labels of the inserted metrics.
+func FormatCoord(v float32) string {
+	return string(formatCoord(nil, v))
 }
@@ -0,0 +1,153 @@
+package vm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// earlyResponder fails half of the attempts, picked at random, with 503 and
+// reads their bodies only after responding, which the transports may do,
+// e.g. when the server responds before it has read the whole body. The other
+// attempts succeed. Every body read is checked to hold a single batch.
+type earlyResponder struct {
+	recs int
+	late sync.WaitGroup
+	mu   sync.Mutex
+	errs []string
+}
+
+func (rt *earlyResponder) RoundTrip(req *http.Request) (*http.Response, error) {
+	check := func() {
+		defer req.Body.Close()
+		body, err := readBody(req)
+		if err == nil {
+			err = checkBody(body, rt.recs)
+		}
+		if err != nil {
+			rt.mu.Lock()
+			rt.errs = append(rt.errs, err.Error())
+			rt.mu.Unlock()
+		}
+	}
+	status := http.StatusNoContent
+	if rand.IntN(2) == 0 {
+		status = http.StatusServiceUnavailable
+		rt.late.Add(1)
+		go func() {
+			defer rt.late.Done()
+			// The retry and the next inserts start meanwhile.
+			time.Sleep(time.Millisecond)
+			check()
+		}()
+	} else {
+		check()
+	}
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+// TestConcurrentInsertsWithRetries checks that a request body stays intact
+// while the encoder it was encoded with serves the retries and the other
+// inserts. Run it with -race.
+func TestConcurrentInsertsWithRetries(t *testing.T) {
+	const (
+		inserts = 16
+		recs    = 2000
+	)
+	for _, compression := range []string{CompressionNone, CompressionGzip, CompressionZstd} {
+		c, err := NewClient(slog.New(slog.NewTextHandler(io.Discard, nil)), "http://vm/influx/write", Options{
+			Options: sink.Options{
+				// An insert fails only if all its attempts fail.
+				MaxRetries: 30,
+			},
+			MaxConns:     2,
+			MetricPrefix: "era5",
+			Compression:  compression,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rt := &earlyResponder{recs: recs}
+		c.httpCli.Transport = rt
+		var wg sync.WaitGroup
+		errs := make(chan error, inserts)
+		for i := range inserts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				batch := make([]era5.Record, recs)
+				for j := range batch {
+					batch[j] = era5.Record{
+						Timestamp: int64(i+1) * 3600000,
+						Latitude:  float32(j%721) / 4,
+						Longitude: float32(j/721) / 4,
+						Values:    []float64{1, 2, 3, 4, 5, 6},
+					}
+				}
+				if _, err := c.Insert(batch); err != nil {
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		rt.late.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatalf("%s: could not insert: %s", compression, err)
+		}
+		if len(rt.errs) > 0 {
+			t.Fatalf("%s: got %d corrupt request bodies, the first: %s", compression, len(rt.errs), rt.errs[0])
+		}
+	}
+}
+
+// readBody reads the decompressed body of a request.
+func readBody(r *http.Request) ([]byte, error) {
+	switch r.Header.Get("Content-Encoding") {
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(zr)
+	case "zstd":
+		zr, err := zstd.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	}
+	return io.ReadAll(r.Body)
+}
+
+// checkBody checks that the body holds the lines of a single batch.
+func checkBody(body []byte, recs int) error {
+	lines := bytes.Split(bytes.TrimSuffix(body, []byte("\n")), []byte("\n"))
+	if len(lines) != recs {
+		return fmt.Errorf("got %d lines, want %d", len(lines), recs)
+	}
+	ts := lines[0][bytes.LastIndexByte(lines[0], ' '):]
+	for _, line := range lines {
+		if !bytes.HasSuffix(line, ts) {
+			return fmt.Errorf("got the lines of multiple batches: %q and %q", lines[0], line)
+		}
+	}
+	return nil
+}
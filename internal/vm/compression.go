@@ -0,0 +1,131 @@
+package vm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported values of the compression option.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionAuto = "auto"
+)
+
+// compressor compresses request bodies. It is not safe for concurrent use.
+type compressor interface {
+	// compress returns the compressed src. The returned slice is only valid
+	// until the next call to compress.
+	compress(src []byte) ([]byte, error)
+	// contentEncoding returns the value of the Content-Encoding header.
+	contentEncoding() string
+}
+
+type newCompressorFunc func() compressor
+
+var newCompressorFuncs = map[string]newCompressorFunc{
+	CompressionNone: nil,
+	CompressionGzip: newGzipCompressor,
+	CompressionZstd: newZstdCompressor,
+}
+
+// newStreamCompressorFunc creates a writer that compresses everything
+// written to it into w.
+type newStreamCompressorFunc func(w io.Writer) io.WriteCloser
+
+var newStreamCompressorFuncs = map[string]newStreamCompressorFunc{
+	CompressionGzip: func(w io.Writer) io.WriteCloser {
+		zw, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+		return zw
+	},
+	CompressionZstd: func(w io.Writer) io.WriteCloser {
+		zw, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedFastest))
+		return zw
+	},
+}
+
+// autoCompressions lists the compressions probed in the auto mode, the most
+// preferred first.
+var autoCompressions = []string{CompressionZstd, CompressionGzip}
+
+type gzipCompressor struct {
+	w   *gzip.Writer
+	buf bytes.Buffer
+}
+
+func newGzipCompressor() compressor {
+	c := &gzipCompressor{}
+	c.w, _ = gzip.NewWriterLevel(&c.buf, gzip.BestSpeed)
+	return c
+}
+
+func (c *gzipCompressor) compress(src []byte) ([]byte, error) {
+	c.buf.Reset()
+	c.w.Reset(&c.buf)
+	if _, err := c.w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := c.w.Close(); err != nil {
+		return nil, err
+	}
+	return c.buf.Bytes(), nil
+}
+
+func (c *gzipCompressor) contentEncoding() string {
+	return "gzip"
+}
+
+type zstdCompressor struct {
+	enc *zstd.Encoder
+	buf []byte
+}
+
+func newZstdCompressor() compressor {
+	enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedFastest))
+	return &zstdCompressor{enc: enc}
+}
+
+func (c *zstdCompressor) compress(src []byte) ([]byte, error) {
+	c.buf = c.enc.EncodeAll(src, c.buf[:0])
+	return c.buf, nil
+}
+
+func (c *zstdCompressor) contentEncoding() string {
+	return "zstd"
+}
+
+// negotiateCompression finds the most preferred compression the insert API
+// accepts. It sends the compressed probe, an empty request body, with every
+// candidate encoding and picks the first one the server responds to with a
+// success.
+func negotiateCompression(httpCli *http.Client, insertURL string, probe []byte) (string, error) {
+	for _, compression := range autoCompressions {
+		c := newCompressorFuncs[compression]()
+		body, err := c.compress(probe)
+		if err != nil {
+			return "", err
+		}
+		req, err := http.NewRequest(http.MethodPost, insertURL, bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-Encoding", c.contentEncoding())
+		res, err := httpCli.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("could not probe %s compression: %w", compression, err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode >= 200 && res.StatusCode < 300 {
+			return compression, nil
+		}
+	}
+	return CompressionNone, nil
+}
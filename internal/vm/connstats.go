@@ -0,0 +1,99 @@
+package vm
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/rtm0/era5/internal/metrics"
+)
+
+// poolWaitThreshold is the time spent waiting for a connection after which
+// the wait is counted as a pool exhaustion event.
+const poolWaitThreshold = 10 * time.Millisecond
+
+var (
+	connsNew         = metrics.NewCounter("era5_vm_conns_new_total", "Number of new connections established to Victoria Metrics")
+	connsReused      = metrics.NewCounter("era5_vm_conns_reused_total", "Number of requests sent over a reused connection")
+	connWaitSeconds  = metrics.NewFloatCounter("era5_vm_conn_wait_seconds_total", "Time spent waiting for a connection from the pool")
+	poolExhausted    = metrics.NewCounter("era5_vm_conn_pool_exhausted_total", "Number of requests that waited for a free connection longer than 10ms")
+	dnsSeconds       = metrics.NewFloatCounter("era5_vm_dns_seconds_total", "Time spent resolving the Victoria Metrics host")
+	dnsLookups       = metrics.NewCounter("era5_vm_dns_lookups_total", "Number of DNS lookups")
+	connectSeconds   = metrics.NewFloatCounter("era5_vm_connect_seconds_total", "Time spent establishing TCP connections")
+	tlsSeconds       = metrics.NewFloatCounter("era5_vm_tls_handshake_seconds_total", "Time spent on TLS handshakes")
+	tlsHandshakes    = metrics.NewCounter("era5_vm_tls_handshakes_total", "Number of TLS handshakes")
+	requestsSent     = metrics.NewCounter("era5_vm_requests_total", "Number of insert requests sent to Victoria Metrics")
+	requestsFailed   = metrics.NewCounter("era5_vm_requests_failed_total", "Number of insert requests that failed")
+	requestBytesSent = metrics.NewCounter("era5_vm_request_bytes_total", "Number of request body bytes sent to Victoria Metrics")
+)
+
+func init() {
+	metrics.NewGaugeFunc("era5_vm_conn_reuse_ratio", "Share of requests sent over a reused connection", ConnReuseRatio)
+}
+
+// ConnReuseRatio returns the share of requests that have been sent over a
+// reused connection so far.
+func ConnReuseRatio() float64 {
+	reused := float64(connsReused.Get())
+	total := reused + float64(connsNew.Get())
+	if total == 0 {
+		return 0
+	}
+	return reused / total
+}
+
+// connTrace collects the connection statistics of a single request.
+type connTrace struct {
+	getConn      time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	// wrote tells whether the request has been fully written.
+	wrote bool
+}
+
+// withConnTrace returns a context that reports the connection events of the
+// request made with it to the connection metrics.
+func withConnTrace(ctx context.Context, t *connTrace) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			t.getConn = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			wait := time.Since(t.getConn)
+			connWaitSeconds.Add(wait.Seconds())
+			if wait > poolWaitThreshold && info.Reused {
+				poolExhausted.Inc()
+			}
+			if info.Reused {
+				connsReused.Inc()
+			} else {
+				connsNew.Inc()
+			}
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			dnsLookups.Inc()
+			dnsSeconds.Add(time.Since(t.dnsStart).Seconds())
+		},
+		ConnectStart: func(string, string) {
+			t.connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			connectSeconds.Add(time.Since(t.connectStart).Seconds())
+		},
+		TLSHandshakeStart: func() {
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			tlsHandshakes.Inc()
+			tlsSeconds.Add(time.Since(t.tlsStart).Seconds())
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			t.wrote = info.Err == nil
+		},
+	})
+}
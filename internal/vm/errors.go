@@ -0,0 +1,96 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/rtm0/era5/internal/sink"
+)
+
+// StatusError is returned when Victoria Metrics responds with an unexpected
+// HTTP status. It wraps the sink.StatusError with the details of the error
+// payload.
+type StatusError struct {
+	sink.StatusError
+
+	// ErrorType and Message are decoded from the structured error payload,
+	// if the response has one.
+	ErrorType string
+	Message   string
+
+	// Line is the 1-based number of the request body line the error refers
+	// to, or zero if the error message does not point to a line. Sample is
+	// the text of that line.
+	Line   int
+	Sample string
+}
+
+func (e *StatusError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.Body
+	}
+	s := fmt.Sprintf("unexpected status %d", e.StatusCode)
+	if e.ErrorType != "" {
+		s += fmt.Sprintf(" (%s)", e.ErrorType)
+	}
+	if msg != "" {
+		s += ": " + msg
+	}
+	if e.Sample != "" {
+		s += fmt.Sprintf("; offending line %d: %q", e.Line, e.Sample)
+	}
+	return s
+}
+
+func (e *StatusError) Unwrap() error {
+	return &e.StatusError
+}
+
+// errorPayload is the JSON error response of the Victoria Metrics APIs.
+type errorPayload struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+}
+
+// lineRE finds the line number in the error messages like
+// `cannot parse line #12` or `line 12`.
+var lineRE = regexp.MustCompile(`(?i)\bline\s*#?(\d+)`)
+
+// newStatusError creates a StatusError from the response status and body.
+// sent is the uncompressed request body, which is used to look up the line
+// the error message refers to.
+func newStatusError(statusCode int, body, sent []byte) *StatusError {
+	e := &StatusError{StatusError: sink.StatusError{StatusCode: statusCode, Body: string(bytes.TrimSpace(body))}}
+	var p errorPayload
+	if json.Unmarshal(body, &p) == nil && p.Error != "" {
+		e.ErrorType = p.ErrorType
+		e.Message = p.Error
+	}
+	msg := e.Message
+	if msg == "" {
+		msg = e.Body
+	}
+	if m := lineRE.FindStringSubmatch(msg); m != nil {
+		e.Line, _ = strconv.Atoi(m[1])
+		e.Sample = nthLine(sent, e.Line)
+	}
+	return e
+}
+
+// nthLine returns the 1-based n-th line of text or an empty string if there
+// is no such line.
+func nthLine(text []byte, n int) string {
+	for i := 1; len(text) > 0; i++ {
+		line, rest, _ := bytes.Cut(text, []byte{'\n'})
+		if i == n {
+			return string(line)
+		}
+		text = rest
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package vm
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Health is the part of the Victoria Metrics self-metrics that shows whether
+// the server keeps up with the ingestion. The values of the metrics with
+// multiple series are summed up.
+type Health struct {
+	// SlowRowInserts and RowsAdded are the counters of the rows that missed
+	// the cache of known series and of all the rows added to the storage.
+	// Their rates give the share of slow inserts.
+	SlowRowInserts float64
+	RowsAdded      float64
+	// PendingRows is the number of rows waiting to be flushed to the
+	// storage.
+	PendingRows float64
+	// RSS and AvailableMemory are the resident memory of the process and
+	// the memory the process may use.
+	RSS             float64
+	AvailableMemory float64
+}
+
+// healthMetrics maps the names of the self-metrics to the Health fields.
+var healthMetrics = map[string]func(h *Health) *float64{
+	"vm_slow_row_inserts_total":      func(h *Health) *float64 { return &h.SlowRowInserts },
+	"vm_rows_added_to_storage_total": func(h *Health) *float64 { return &h.RowsAdded },
+	"vm_pending_rows":                func(h *Health) *float64 { return &h.PendingRows },
+	"process_resident_memory_bytes":  func(h *Health) *float64 { return &h.RSS },
+	"vm_available_memory_bytes":      func(h *Health) *float64 { return &h.AvailableMemory },
+}
+
+// ScrapeHealth reads the health metrics from the /metrics endpoints of
+// Victoria Metrics. The metrics of multiple endpoints, e.g. all vmstorage
+// nodes of a cluster, are summed up.
+func ScrapeHealth(ctx context.Context, metricsURLs []string) (*Health, error) {
+	h := &Health{}
+	for _, u := range metricsURLs {
+		if err := scrapeHealth(ctx, u, h); err != nil {
+			return nil, fmt.Errorf("could not scrape %s: %w", u, err)
+		}
+	}
+	return h, nil
+}
+
+func scrapeHealth(ctx context.Context, metricsURL string, h *Health) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metricsURL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	sc := bufio.NewScanner(res.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		name, rest := line, ""
+		if n := strings.IndexAny(line, "{ "); n >= 0 {
+			name, rest = line[:n], line[n:]
+		}
+		field := healthMetrics[name]
+		if field == nil {
+			continue
+		}
+		if strings.HasPrefix(rest, "{") {
+			n := strings.LastIndexByte(rest, '}')
+			if n < 0 {
+				continue
+			}
+			rest = rest[n+1:]
+		}
+		value, _, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		*field(h) += v
+	}
+	return sc.Err()
+}
@@ -0,0 +1,114 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rtm0/era5/internal/sink"
+)
+
+// promImportPath is the path of the Prometheus exposition format import API
+// and exportPath is the path of the API that exports raw samples.
+const (
+	promImportPath = "/api/v1/import/prometheus"
+	exportPath     = "/api/v1/export"
+)
+
+// promLabelEscaper escapes the characters that are special in the label
+// values of the Prometheus exposition format.
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// InsertInfo inserts a single sample of the <metricPrefix>_<name> metric with
+// the value 1 and the labels given by names and values, in the manner of the
+// Prometheus info metrics. The sample is sent to the Prometheus import API
+// next to the insert API, so it does not depend on the insert API format.
+func (c *Client) InsertInfo(ctx context.Context, name string, names, values []string, ts time.Time) error {
+	var b []byte
+	b = append(b, c.metricPrefix...)
+	b = append(b, '_')
+	b = append(b, name...)
+	b = append(b, '{')
+	for i, n := range names {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, n...)
+		b = append(b, `="`...)
+		b = append(b, promLabelEscaper.Replace(values[i])...)
+		b = append(b, '"')
+	}
+	b = append(b, "} 1 "...)
+	b = strconv.AppendInt(b, ts.UnixMilli(), 10)
+	b = append(b, '\n')
+
+	var result sink.Result
+	if err := c.post(ctx, c.apiURL(promImportPath), bytes.NewReader(b), b, "", &result); err != nil {
+		return fmt.Errorf("could not insert %s_%s: %w", c.metricPrefix, name, err)
+	}
+	return nil
+}
+
+// apiURL returns the URL of another API of the Victoria Metrics the records
+// are inserted into.
+func (c *Client) apiURL(path string) string {
+	u := *c.baseURL
+	u.Path = strings.TrimSuffix(u.Path, apiPath(u.Path)) + path
+	u.RawQuery = ""
+	return u.String()
+}
+
+// InfoTimestamps returns the timestamps of the samples of the
+// <metricPrefix>_<name> metric with the labels given by names and values.
+// The samples are read from the export API at Options.SelectURL.
+func (c *Client) InfoTimestamps(ctx context.Context, name string, names, values []string) ([]int64, error) {
+	u := c.apiURL(exportPath)
+	if c.selectURL != "" {
+		u = strings.TrimSuffix(c.selectURL, "/") + exportPath
+	}
+	var sel strings.Builder
+	sel.WriteString(c.metricPrefix + "_" + name + "{")
+	for i, n := range names {
+		if i > 0 {
+			sel.WriteByte(',')
+		}
+		fmt.Fprintf(&sel, "%s=%q", n, values[i])
+	}
+	sel.WriteByte('}')
+	form := url.Values{"match[]": {sel.String()}, "start": {"0"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := c.httpCli.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not query %s: %w", u, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, sink.MaxErrorBodySize))
+		return nil, fmt.Errorf("could not query %s: unexpected status code %d: %s", u, res.StatusCode, msg)
+	}
+	var tss []int64
+	dec := json.NewDecoder(res.Body)
+	for {
+		var line struct {
+			Timestamps []int64 `json:"timestamps"`
+		}
+		if err := dec.Decode(&line); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("could not parse the response of %s: %w", u, err)
+		}
+		tss = append(tss, line.Timestamps...)
+	}
+	return tss, nil
+}
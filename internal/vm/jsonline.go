@@ -0,0 +1,92 @@
+package vm
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// jsonLineFormat converts records into the JSON lines of the /api/v1/import
+// API, the ones /api/v1/export produces. Every value of a record is a line
+// with the labels of its series and a single sample:
+//
+//	{"metric":{"__name__":"era5_t2m","la":"45.00","lo":"7.25"},"values":[281.5],"timestamps":[1672531200000]}
+//
+// The parts of the lines that only depend on the metric prefix are prepared
+// once.
+type jsonLineFormat struct {
+	// names holds the beginning of the line of every variable up to the
+	// value of the la label.
+	names      [][]byte
+	lo         []byte
+	transforms *transform.Set
+}
+
+func newJSONLineFormat(metricPrefix string, varNames []string, transforms *transform.Set) textFormat {
+	f := &jsonLineFormat{
+		names:      make([][]byte, len(varNames)),
+		lo:         []byte(`","lo":"`),
+		transforms: transforms,
+	}
+	for i, name := range varNames {
+		f.names[i] = append(appendJSONString([]byte(`{"metric":{"__name__":`), metricPrefix+"_"+name), `,"la":"`...)
+	}
+	return f
+}
+
+func (f *jsonLineFormat) appendRec(dst []byte, r *era5.Record, coords coordCache, labels, tsLabels, level []byte) []byte {
+	if f.transforms.SkipAll(r.Values) {
+		return dst
+	}
+	sep := false
+	for i, v := range r.Values {
+		if f.transforms.Skip(v) {
+			continue
+		}
+		if x := f.transforms.Value(i, v); math.IsNaN(x) || math.IsInf(x, 0) {
+			// JSON has no NaN and Victoria Metrics drops the NaN
+			// samples of the other formats anyway.
+			continue
+		}
+		if sep {
+			dst = append(dst, '\n')
+		}
+		sep = true
+		dst = append(dst, f.names[i]...)
+		dst = coords.appendCoord(dst, r.Latitude)
+		dst = append(dst, f.lo...)
+		dst = coords.appendCoord(dst, r.Longitude)
+		dst = append(dst, '"')
+		dst = append(dst, labels...)
+		dst = append(dst, tsLabels...)
+		dst = append(dst, level...)
+		dst = append(dst, `},"values":[`...)
+		dst = f.transforms.AppendValue(dst, i, v)
+		dst = append(dst, `],"timestamps":[`...)
+		dst = strconv.AppendInt(dst, r.Timestamp, 10)
+		dst = append(dst, "]}"...)
+	}
+	return dst
+}
+
+func (f *jsonLineFormat) appendLabels(dst []byte, names, values []string) []byte {
+	for i, name := range names {
+		if values[i] == "" {
+			continue
+		}
+		dst = append(dst, ',')
+		dst = appendJSONString(dst, name)
+		dst = append(dst, ':')
+		dst = appendJSONString(dst, values[i])
+	}
+	return dst
+}
+
+// appendJSONString appends s quoted as a JSON string to dst.
+func appendJSONString(dst []byte, s string) []byte {
+	b, _ := json.Marshal(s)
+	return append(dst, b...)
+}
@@ -0,0 +1,118 @@
+package vm
+
+import (
+	"strings"
+
+	"github.com/rtm0/era5/internal/enrich"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// pointLabels holds the extra labels of the grid points formatted for the
+// insert API.
+type pointLabels struct {
+	m map[enrich.Point][]byte
+	// missing is the text of the points without extra labels.
+	missing []byte
+}
+
+func newPointLabels(f textFormat, t *enrich.Table, latitudes, longitudes []float32) *pointLabels {
+	matched := t.Match(latitudes, longitudes)
+	l := &pointLabels{
+		m:       make(map[enrich.Point][]byte, len(matched)),
+		missing: f.appendLabels(nil, t.Names, make([]string, len(t.Names))),
+	}
+	for p, values := range matched {
+		l.m[p] = f.appendLabels(nil, t.Names, values)
+	}
+	return l
+}
+
+// get returns the extra labels of the record location. A nil pointLabels has
+// no labels.
+func (l *pointLabels) get(r *era5.Record) []byte {
+	if l == nil {
+		return nil
+	}
+	if b, ok := l.m[enrich.Point{La: r.Latitude, Lo: r.Longitude}]; ok {
+		return b
+	}
+	return l.missing
+}
+
+// timeLabels holds the labels of the timestamps formatted for the insert API.
+type timeLabels struct {
+	m map[int64][]byte
+	// missing is the text of the timestamps without labels.
+	missing []byte
+}
+
+func newTimeLabels(f textFormat, names []string, values map[int64][]string) *timeLabels {
+	l := &timeLabels{
+		m:       make(map[int64][]byte, len(values)),
+		missing: f.appendLabels(nil, names, make([]string, len(names))),
+	}
+	for ts, v := range values {
+		l.m[ts] = f.appendLabels(nil, names, v)
+	}
+	return l
+}
+
+// get returns the labels of the record timestamp. A nil timeLabels has no
+// labels.
+func (l *timeLabels) get(r *era5.Record) []byte {
+	if l == nil {
+		return nil
+	}
+	if b, ok := l.m[r.Timestamp]; ok {
+		return b
+	}
+	return l.missing
+}
+
+// levelLabel is the name of the label of the vertical level.
+const levelLabel = "level"
+
+// levelLabels holds the level labels of the vertical levels formatted for the
+// insert API.
+type levelLabels map[float32][]byte
+
+func newLevelLabels(f textFormat, levels []float32) levelLabels {
+	l := make(levelLabels, len(levels))
+	for _, level := range levels {
+		l[level] = f.appendLabels(nil, []string{levelLabel}, []string{era5.FormatLevel(level)})
+	}
+	return l
+}
+
+// get returns the level label of the record. A nil levelLabels has no labels.
+func (l levelLabels) get(r *era5.Record) []byte {
+	return l[r.Level]
+}
+
+// influxTagEscaper escapes the characters that are special in the tag values
+// of the InfluxDB line protocol.
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func (f *influxDBFormat) appendLabels(dst []byte, names, values []string) []byte {
+	for i, name := range names {
+		if values[i] == "" {
+			continue
+		}
+		dst = append(dst, ',')
+		dst = append(dst, name...)
+		dst = append(dst, '=')
+		dst = append(dst, influxTagEscaper.Replace(values[i])...)
+	}
+	return dst
+}
+
+func (csvFormat) appendLabels(dst []byte, names, values []string) []byte {
+	for _, v := range values {
+		dst = append(dst, ',')
+		if strings.ContainsAny(v, ",\"\n\r") {
+			v = `"` + strings.ReplaceAll(v, `"`, `""`) + `"`
+		}
+		dst = append(dst, v...)
+	}
+	return dst
+}
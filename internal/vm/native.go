@@ -0,0 +1,196 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+
+	"github.com/rtm0/era5/internal/transform"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// binaryFormat is a textFormat whose records are binary frames instead of
+// lines. Every request body starts with the header.
+type binaryFormat interface {
+	textFormat
+	header() []byte
+	// split splits the encoded records into the head no longer than maxSize
+	// and the rest at a frame boundary, so the head is longer than maxSize
+	// if the first frame is. Non-positive maxSize means no limit.
+	split(data []byte, maxSize int) (head, rest []byte)
+}
+
+// The separators of the metric names marshaled by Victoria Metrics, which
+// are escaped in the tag keys and values.
+const (
+	nativeEscapeChar       = 0
+	nativeTagSeparatorChar = 1
+	nativeKVSeparatorChar  = 2
+)
+
+// The marshal type of the timestamps and the values of a block that holds a
+// single constant, so the block data is empty.
+const nativeMarshalTypeConst = 3
+
+// nativeFormat converts records into the native format of the
+// /api/v1/import/native API, the one /api/v1/export/native produces. Every
+// value of a record is a frame holding the marshaled metric name and a block
+// of a single sample, both preceded by their big-endian uint32 lengths. The
+// parts of the metric names that only depend on the metric prefix are
+// prepared once.
+type nativeFormat struct {
+	// groups holds the marshaled metric group of every variable followed
+	// by the la tag key.
+	groups     [][]byte
+	lo         []byte
+	transforms *transform.Set
+}
+
+func newNativeFormat(metricPrefix string, varNames []string, transforms *transform.Set) textFormat {
+	f := &nativeFormat{
+		groups:     make([][]byte, len(varNames)),
+		lo:         appendTagValue(nil, "lo"),
+		transforms: transforms,
+	}
+	for i, name := range varNames {
+		f.groups[i] = appendTagValue(appendTagValue(nil, metricPrefix+"_"+name), "la")
+	}
+	return f
+}
+
+// nativeHeader is the time range the samples of a request body are within,
+// the whole int64 range as the zig-zag encoded big-endian bounds.
+var nativeHeader = binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, zigzag(math.MinInt64)), zigzag(math.MaxInt64))
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (f *nativeFormat) header() []byte {
+	return nativeHeader
+}
+
+func (f *nativeFormat) appendRec(dst []byte, r *era5.Record, coords coordCache, labels, tsLabels, level []byte) []byte {
+	if f.transforms.SkipAll(r.Values) {
+		return dst
+	}
+	for i, v := range r.Values {
+		if f.transforms.Skip(v) {
+			continue
+		}
+		x := f.transforms.Value(i, v)
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			// Victoria Metrics drops the NaN samples of the other
+			// formats too.
+			continue
+		}
+		start := len(dst)
+		dst = append(dst, 0, 0, 0, 0)
+		dst = append(dst, f.groups[i]...)
+		dst = coords.appendCoord(dst, r.Latitude)
+		dst = append(dst, nativeTagSeparatorChar)
+		dst = append(dst, f.lo...)
+		dst = coords.appendCoord(dst, r.Longitude)
+		dst = append(dst, nativeTagSeparatorChar)
+		dst = append(dst, labels...)
+		dst = append(dst, tsLabels...)
+		dst = append(dst, level...)
+		binary.BigEndian.PutUint32(dst[start:], uint32(len(dst)-start-4))
+
+		start = len(dst)
+		dst = append(dst, 0, 0, 0, 0)
+		dst = appendNativeBlock(dst, r.Timestamp, x)
+		binary.BigEndian.PutUint32(dst[start:], uint32(len(dst)-start-4))
+	}
+	return dst
+}
+
+func (f *nativeFormat) appendLabels(dst []byte, names, values []string) []byte {
+	for i, name := range names {
+		if values[i] == "" {
+			continue
+		}
+		dst = appendTagValue(dst, name)
+		dst = appendTagValue(dst, values[i])
+	}
+	return dst
+}
+
+func (f *nativeFormat) split(data []byte, maxSize int) (head, rest []byte) {
+	if maxSize <= 0 || len(data) <= maxSize {
+		return data, nil
+	}
+	n := 0
+	for n < len(data) {
+		// A frame is the metric name and the block.
+		end := n
+		for range 2 {
+			end += 4 + int(binary.BigEndian.Uint32(data[end:]))
+		}
+		if end > maxSize && n > 0 {
+			break
+		}
+		n = end
+	}
+	return data[:n], data[n:]
+}
+
+// appendTagValue appends the marshaled metric group, tag key or tag value s
+// to dst.
+func appendTagValue(dst []byte, s string) []byte {
+	for i := range len(s) {
+		switch c := s[i]; c {
+		case nativeEscapeChar, nativeTagSeparatorChar, nativeKVSeparatorChar:
+			dst = append(dst, nativeEscapeChar, '0'+c)
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, nativeTagSeparatorChar)
+}
+
+// appendNativeBlock appends the portable marshaling of a block holding the
+// single sample of value x at timestamp ts to dst: the timestamp, the
+// mantissa of the value, the row count and the decimal exponent of the value
+// followed by the marshal types and the lengths of the timestamps and the
+// values data, which are empty for a constant.
+func appendNativeBlock(dst []byte, ts int64, x float64) []byte {
+	mantissa, exp := toDecimal(x)
+	dst = binary.AppendVarint(dst, ts)
+	dst = binary.AppendVarint(dst, mantissa)
+	dst = binary.AppendUvarint(dst, 1)
+	dst = binary.AppendVarint(dst, int64(exp))
+	dst = append(dst, nativeMarshalTypeConst, nativeMarshalTypeConst)
+	dst = binary.AppendUvarint(dst, 0)
+	return binary.AppendUvarint(dst, 0)
+}
+
+// toDecimal returns the mantissa and the decimal exponent of the shortest
+// decimal that is x when parsed as a float.
+func toDecimal(x float64) (int64, int16) {
+	if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+		return int64(x), 0
+	}
+	var buf [32]byte
+	// The text is like -1.2345e+02 with at most 17 significant digits.
+	text := strconv.AppendFloat(buf[:0], x, 'e', -1, 64)
+	digits, exp, _ := bytes.Cut(text, []byte{'e'})
+	e, _ := strconv.Atoi(string(exp))
+	var mantissa int64
+	neg := false
+	for _, c := range digits {
+		switch {
+		case c == '-':
+			neg = true
+		case c == '.':
+			e -= len(digits) - bytes.IndexByte(digits, '.') - 1
+		default:
+			mantissa = mantissa*10 + int64(c-'0')
+		}
+	}
+	if neg {
+		mantissa = -mantissa
+	}
+	return mantissa, int16(e)
+}
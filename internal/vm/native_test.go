@@ -0,0 +1,170 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+func TestNativeHeader(t *testing.T) {
+	want := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe,
+	}
+	if got := (&nativeFormat{}).header(); !bytes.Equal(got, want) {
+		t.Fatalf("got the header %x, want %x", got, want)
+	}
+}
+
+func TestAppendTagValue(t *testing.T) {
+	got := appendTagValue([]byte("k\x01"), "a\x00b\x01c\x02")
+	want := []byte("k\x01a\x000b\x001c\x002\x01")
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestAppendNativeBlock(t *testing.T) {
+	got := appendNativeBlock(nil, 1000, 273.25)
+	want := []byte{
+		0xd0, 0x0f, // timestamp
+		0xfa, 0xaa, 0x03, // mantissa 27325
+		0x01,       // row count
+		0x03,       // exponent -2
+		0x03, 0x03, // const marshal types
+		0x00, 0x00, // empty timestamps and values data
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got the block %x, want %x", got, want)
+	}
+}
+
+func TestToDecimal(t *testing.T) {
+	for _, tc := range []struct {
+		x        float64
+		mantissa int64
+		exp      int16
+	}{
+		{5, 5, 0},
+		{-273, -273, 0},
+		{273.25, 27325, -2},
+		{-0.001, -1, -3},
+		{0.1, 1, -1},
+		{1.5e-7, 15, -8},
+		{1e20, 1, 20},
+		{math.MaxFloat64, 17976931348623157, 292},
+	} {
+		mantissa, exp := toDecimal(tc.x)
+		if mantissa != tc.mantissa || exp != tc.exp {
+			t.Errorf("toDecimal(%v) = %d, %d, want %d, %d", tc.x, mantissa, exp, tc.mantissa, tc.exp)
+		}
+	}
+}
+
+// nativeSample is a decoded frame of the native format.
+type nativeSample struct {
+	tags []string
+	ts   int64
+	x    float64
+}
+
+// decodeNative decodes the frames the way Victoria Metrics unmarshals the
+// raw metric names and the portable blocks.
+func decodeNative(t *testing.T, data []byte) []nativeSample {
+	t.Helper()
+	var samples []nativeSample
+	for len(data) > 0 {
+		n := binary.BigEndian.Uint32(data)
+		name := data[4 : 4+n]
+		data = data[4+n:]
+		var s nativeSample
+		var tag []byte
+		for i := 0; i < len(name); i++ {
+			switch c := name[i]; c {
+			case nativeEscapeChar:
+				i++
+				tag = append(tag, name[i]-'0')
+			case nativeTagSeparatorChar:
+				s.tags = append(s.tags, string(tag))
+				tag = tag[:0]
+			default:
+				tag = append(tag, c)
+			}
+		}
+
+		n = binary.BigEndian.Uint32(data)
+		block := data[4 : 4+n]
+		data = data[4+n:]
+		var k int
+		varint := func() int64 {
+			v, m := binary.Varint(block[k:])
+			k += m
+			return v
+		}
+		s.ts = varint()
+		mantissa := varint()
+		rows, m := binary.Uvarint(block[k:])
+		k += m
+		exp := varint()
+		if rows != 1 || !bytes.Equal(block[k:], []byte{nativeMarshalTypeConst, nativeMarshalTypeConst, 0, 0}) {
+			t.Fatalf("got the block %x, want a single constant", block)
+		}
+		s.x = float64(mantissa) * math.Pow10(int(exp))
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+func TestNativeFormat(t *testing.T) {
+	f := newNativeFormat("era5", []string{"t2m", "a\x01b", "tp"}, nil).(*nativeFormat)
+	coords := newCoordCache([]float32{1.5}, []float32{-3.25})
+	labels := f.appendLabels(nil, []string{"site", "empty"}, []string{"x\x02y", ""})
+	recs := []era5.Record{
+		{Timestamp: 1000, Latitude: 1.5, Longitude: -3.25, Values: []float64{273.25, -2, math.NaN()}},
+		{Timestamp: 2000, Latitude: 0.75, Longitude: -3.25, Values: []float64{0.5, 7, 1e-3}},
+	}
+	var data []byte
+	for i := range recs {
+		data = f.appendRec(data, &recs[i], coords, labels, nil, nil)
+	}
+	tags := func(name, la string) []string {
+		return []string{name, "la", la, "lo", "-3.25", "site", "x\x02y"}
+	}
+	want := []nativeSample{
+		{tags("era5_t2m", "1.50"), 1000, 273.25},
+		{tags("era5_a\x01b", "1.50"), 1000, -2},
+		{tags("era5_t2m", "0.75"), 2000, 0.5},
+		{tags("era5_a\x01b", "0.75"), 2000, 7},
+		{tags("era5_tp", "0.75"), 2000, 1e-3},
+	}
+	got := decodeNative(t, data)
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d: %v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		g := got[i]
+		if !slices.Equal(g.tags, w.tags) || g.ts != w.ts || math.Abs(g.x-w.x) > 1e-12 {
+			t.Errorf("got the sample %q %d %v, want %q %d %v", g.tags, g.ts, g.x, w.tags, w.ts, w.x)
+		}
+	}
+
+	// Every split is at a frame boundary and keeps at least one frame.
+	for maxSize := 1; maxSize <= len(data); maxSize += 7 {
+		var frames int
+		for rest := data; len(rest) > 0; {
+			var head []byte
+			head, rest = f.split(rest, maxSize)
+			if len(head) == 0 {
+				t.Fatalf("got an empty head for the max size %d", maxSize)
+			}
+			frames += len(decodeNative(t, head))
+		}
+		if frames != len(want) {
+			t.Fatalf("got %d frames split by the max size %d, want %d", frames, maxSize, len(want))
+		}
+	}
+}
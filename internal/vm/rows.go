@@ -0,0 +1,38 @@
+package vm
+
+import "github.com/rtm0/era5/pkg/era5"
+
+// rows is a sequence of records to encode, either a slice of records or a
+// columnar batch, so both are encoded by the same code.
+type rows interface {
+	len() int
+	// row returns the record i. The record may be filled into scratch,
+	// which is only valid until the next call.
+	row(i int, scratch *era5.Record) *era5.Record
+	// slice returns the records [begin, end).
+	slice(begin, end int) rows
+}
+
+// recordRows are the rows of a slice of records.
+type recordRows []era5.Record
+
+func (r recordRows) len() int { return len(r) }
+
+func (r recordRows) row(i int, _ *era5.Record) *era5.Record { return &r[i] }
+
+func (r recordRows) slice(begin, end int) rows { return r[begin:end] }
+
+// batchRows are the rows of a columnar batch. Every row is filled into the
+// scratch record, which spares an allocation per record.
+type batchRows struct {
+	b *era5.RecordBatch
+}
+
+func (r batchRows) len() int { return r.b.Len() }
+
+func (r batchRows) row(i int, scratch *era5.Record) *era5.Record {
+	r.b.Record(i, scratch)
+	return scratch
+}
+
+func (r batchRows) slice(begin, end int) rows { return batchRows{r.b.Slice(begin, end)} }
@@ -0,0 +1,145 @@
+package vm
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// streamFlushSize is the amount of encoded data accumulated before it is
+// written to the request body in the streaming mode.
+const streamFlushSize = 64 * 1024
+
+// insertStream sends the records in as many streaming requests as the
+// request size limit requires.
+func (c *Client) insertStream(ctx context.Context, enc *encoder, recs rows, result *sink.Result) error {
+	for recs.len() > 0 {
+		n, err := c.sendStream(ctx, enc, recs, result)
+		if err != nil {
+			return err
+		}
+		recs = recs.slice(n, recs.len())
+	}
+	return nil
+}
+
+// sendStream sends the records in a single streaming request, retrying the
+// retriable failures, and returns the number of records sent. Fewer records
+// than given are sent if they do not fit into the request size limit. The
+// retries send exactly the same records as the first attempt.
+func (c *Client) sendStream(ctx context.Context, enc *encoder, recs rows, result *sink.Result) (int, error) {
+	contentEncoding := ""
+	if enc.newStreamCompressor != nil {
+		contentEncoding = enc.compressor.contentEncoding()
+	}
+	backoff := c.retryBackoff
+	for attempt := 1; ; attempt++ {
+		result.Attempts++
+		pr, pw := io.Pipe()
+		written := make(chan streamed, 1)
+		go func() {
+			written <- c.writeStream(pw, enc, recs)
+		}()
+		err := c.post(ctx, c.insertURL, pr, nil, contentEncoding, result)
+		// Unblock the writer if the request ended before reading the whole
+		// body.
+		pr.CloseWithError(io.ErrClosedPipe)
+		w := <-written
+		result.Bytes += w.bytes
+		requestBytesSent.Add(w.bytes)
+		if attempt == 1 {
+			result.RawBytes += w.rawBytes
+		}
+		if err == nil && w.err != nil {
+			err = w.err
+		}
+		if err == nil {
+			return w.recs, nil
+		}
+		if attempt > c.maxRetries || !sink.IsRetriable(err) {
+			return 0, err
+		}
+		// Make sure the retry sends the same records.
+		recs = recs.slice(0, w.recs)
+		c.logger.Warn("Retrying insert", "attempt", attempt, "in", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return 0, err
+		}
+		backoff *= 2
+	}
+}
+
+// streamed describes what has been written to a streaming request body.
+type streamed struct {
+	recs     int
+	rawBytes int
+	bytes    int
+	err      error
+}
+
+// writeStream encodes records into w in small portions until all records are
+// encoded or the request size limit is reached, and closes w.
+func (c *Client) writeStream(pw *io.PipeWriter, enc *encoder, recs rows) streamed {
+	var res streamed
+	cw := &countingWriter{w: pw}
+	var w io.Writer = cw
+	var zw io.WriteCloser
+	if enc.newStreamCompressor != nil {
+		zw = enc.newStreamCompressor(cw)
+		w = zw
+	}
+	write := func() bool {
+		if _, err := w.Write(enc.buf); err != nil {
+			res.err = err
+			return false
+		}
+		res.rawBytes += len(enc.buf)
+		enc.buf = enc.buf[:0]
+		return true
+	}
+
+	enc.buf = enc.buf[:0]
+	if f, ok := enc.format.(binaryFormat); ok {
+		enc.buf = append(enc.buf, f.header()...)
+	}
+	var scratch era5.Record
+	for i := range recs.len() {
+		size := len(enc.buf)
+		enc.appendRec(recs.row(i, &scratch), c.coords, c.labels, c.timeLabels, c.levelLabels)
+		if c.maxRequestSize > 0 && res.rawBytes+len(enc.buf) > c.maxRequestSize && res.recs > 0 {
+			// The record does not fit, leave it for the next request.
+			enc.buf = enc.buf[:size]
+			break
+		}
+		res.recs++
+		if len(enc.buf) >= streamFlushSize && !write() {
+			break
+		}
+	}
+	if res.err == nil && len(enc.buf) > 0 {
+		write()
+	}
+	if zw != nil && res.err == nil {
+		res.err = zw.Close()
+	}
+	res.bytes = cw.n
+	pw.CloseWithError(res.err)
+	return res
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += n
+	return n, err
+}
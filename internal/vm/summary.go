@@ -0,0 +1,68 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/rtm0/era5/internal/aggregate"
+	"github.com/rtm0/era5/internal/sink"
+)
+
+// InsertSummaries inserts the summaries of the grid as series of the same
+// metrics as the records but labeled with the quantile instead of the
+// coordinates. Only the InfluxDB line protocol APIs are supported.
+func (c *Client) InsertSummaries(ctx context.Context, sums []aggregate.Summary) (sink.Result, error) {
+	start := time.Now()
+	result := sink.Result{Rows: len(sums)}
+	f, ok := c.format.(*influxDBFormat)
+	if !ok {
+		return result, errors.New("summaries can only be inserted via the InfluxDB line protocol")
+	}
+	var enc *encoder
+	select {
+	case enc = <-c.encoders:
+	case <-ctx.Done():
+		return result, ctx.Err()
+	}
+	defer func() { c.encoders <- enc }()
+
+	var raw []byte
+	for i := range sums {
+		raw = f.appendSummary(raw, &sums[i])
+	}
+	result.RawBytes = len(raw)
+	if len(raw) == 0 {
+		return result, nil
+	}
+	err := c.send(ctx, enc, raw, &result)
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+// appendSummary converts a summary into a line. The values missing at all
+// the grid points are omitted and so is the line if all of them are.
+func (f *influxDBFormat) appendSummary(dst []byte, s *aggregate.Summary) []byte {
+	n := len(dst)
+	dst = append(dst, f.name...)
+	dst = append(dst, ",quantile="...)
+	dst = strconv.AppendFloat(dst, s.Quantile, 'g', -1, 64)
+	sep := byte(' ')
+	for i, v := range s.Values {
+		if math.IsNaN(v) {
+			continue
+		}
+		dst = append(dst, sep)
+		dst = append(dst, f.fields[i][1:]...)
+		dst = strconv.AppendFloat(dst, v, 'g', -1, 64)
+		sep = ','
+	}
+	if sep == ' ' {
+		return dst[:n]
+	}
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, s.Timestamp, 10)
+	return append(dst, '\n')
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rtm0/era5/internal/aggregate"
+	"github.com/rtm0/era5/internal/journal"
+	"github.com/rtm0/era5/internal/metrics"
+	"github.com/rtm0/era5/internal/sink"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// loader inserts the records extracted from a file into a sink in batches.
+type loader struct {
+	logger *slog.Logger
+	ins    sink.Inserter
+	// jrnl receives the outcome of every batch. It is nil if journaling is
+	// disabled.
+	jrnl *journal.Journal
+	// file is the name of the file the records come from.
+	file string
+
+	// concurrency is the number of loading goroutines and inflight is the
+	// max number of batches each of them keeps in flight.
+	concurrency   int
+	inflight      int
+	recsPerInsert int
+	// sortBySeries makes the records of every timestamp ordered by geohash
+	// before they are split into batches.
+	sortBySeries bool
+	// insertTimeout limits the duration of a single insert. Zero means no
+	// limit.
+	insertTimeout time.Duration
+
+	// maxFailedRows is the number of failed rows after which the export is
+	// aborted via abort. Negative means never.
+	maxFailedRows int64
+	abort         context.CancelCauseFunc
+	totalFailed   atomic.Int64
+
+	// throttle limits the number of concurrent inserts while the target is
+	// under pressure. It is nil if throttling is disabled.
+	throttle *throttle
+
+	// agg makes the loader insert the summaries of the records of every
+	// timestamp instead of the records. It is nil if aggregation is
+	// disabled.
+	agg *aggregate.Aggregator
+
+	// markers makes the loader write a completion marker for every
+	// timestamp whose records have all been inserted. The sink must be a
+	// markerStore.
+	markers bool
+}
+
+// summaryInserter is implemented by the sinks that can insert the summaries
+// computed in the aggregation mode.
+type summaryInserter interface {
+	InsertSummaries(ctx context.Context, sums []aggregate.Summary) (sink.Result, error)
+}
+
+var (
+	rowsInserted = metrics.NewCounter("era5_rows_inserted_total", "Number of rows inserted")
+	rowsFailed   = metrics.NewCounter("era5_rows_failed_total", "Number of rows that failed to be inserted")
+)
+
+// loadResult is the outcome of loading the records of a single timestamp.
+type loadResult struct {
+	rows       int
+	failedRows int
+	// rawBytes is the size of the encoded records before compression and
+	// sentBytes is the number of bytes sent over the network, including
+	// the retries.
+	rawBytes  int64
+	sentBytes int64
+	// timestamps holds the number of records of every timestamp loaded,
+	// which may be a part of the records of the timestamp. They were all
+	// inserted unless failedRows is positive.
+	timestamps map[int64]int
+}
+
+// run loads the records received from extracted and reports the outcome of
+// every timestamp to the returned channel. The channel is closed once
+// extracted is closed and all its records are loaded.
+func (l *loader) run(ctx context.Context, extracted <-chan []era5.Record) <-chan loadResult {
+	loaded := make(chan loadResult)
+	var loaders sync.WaitGroup
+	for range l.concurrency {
+		loaders.Add(1)
+		go func() {
+			l.load(ctx, extracted, loaded)
+			loaders.Done()
+		}()
+	}
+	go func() {
+		loaders.Wait()
+		close(loaded)
+	}()
+	return loaded
+}
+
+func (l *loader) load(ctx context.Context, extracted <-chan []era5.Record, loaded chan<- loadResult) {
+	inflight := make(chan struct{}, l.inflight)
+	var sending sync.WaitGroup
+	for recs := range extracted {
+		if l.agg != nil {
+			loaded <- l.loadSummaries(ctx, recs)
+			continue
+		}
+		if l.sortBySeries {
+			era5.SortByGeohash(recs)
+		}
+		l.loadParts(ctx, recordParts(recs), inflight, &sending, loaded)
+	}
+	sending.Wait()
+}
+
+// runBatches is like run for the records of every timestamp given column by
+// column. The sink must be a sink.BatchInserter.
+func (l *loader) runBatches(ctx context.Context, extracted <-chan *era5.RecordBatch) <-chan loadResult {
+	loaded := make(chan loadResult)
+	var loaders sync.WaitGroup
+	for range l.concurrency {
+		loaders.Add(1)
+		go func() {
+			inflight := make(chan struct{}, l.inflight)
+			var sending sync.WaitGroup
+			for b := range extracted {
+				l.loadParts(ctx, batchParts{b}, inflight, &sending, loaded)
+			}
+			sending.Wait()
+			loaders.Done()
+		}()
+	}
+	go func() {
+		loaders.Wait()
+		close(loaded)
+	}()
+	return loaded
+}
+
+// parts are the records of a timestamp, which are inserted in parts of
+// -recsPerInsert records.
+type parts interface {
+	len() int
+	// insert inserts the records [begin, end).
+	insert(ctx context.Context, ins sink.Inserter, begin, end int) (sink.Result, error)
+	// at returns the timestamp and the latitude of the record i.
+	at(i int) (int64, float32)
+	// counts returns the number of records of every timestamp.
+	counts() map[int64]int
+}
+
+// recordParts are the parts of a slice of records.
+type recordParts []era5.Record
+
+func (p recordParts) len() int { return len(p) }
+
+func (p recordParts) insert(ctx context.Context, ins sink.Inserter, begin, end int) (sink.Result, error) {
+	return ins.InsertContext(ctx, p[begin:end])
+}
+
+func (p recordParts) at(i int) (int64, float32) { return p[i].Timestamp, p[i].Latitude }
+
+func (p recordParts) counts() map[int64]int {
+	counts := make(map[int64]int)
+	for i := range p {
+		counts[p[i].Timestamp]++
+	}
+	return counts
+}
+
+// batchParts are the parts of a columnar batch.
+type batchParts struct {
+	b *era5.RecordBatch
+}
+
+func (p batchParts) len() int { return p.b.Len() }
+
+func (p batchParts) insert(ctx context.Context, ins sink.Inserter, begin, end int) (sink.Result, error) {
+	return ins.(sink.BatchInserter).InsertBatch(ctx, p.b.Slice(begin, end))
+}
+
+func (p batchParts) at(i int) (int64, float32) { return p.b.Timestamp, p.b.Latitudes[i] }
+
+func (p batchParts) counts() map[int64]int { return map[int64]int{p.b.Timestamp: p.b.Len()} }
+
+// loadParts inserts the records in parts, keeping at most cap(inflight) parts
+// in flight, and reports the outcome to loaded once all the parts are done.
+func (l *loader) loadParts(ctx context.Context, recs parts, inflight chan struct{}, sending *sync.WaitGroup, loaded chan<- loadResult) {
+	n := recs.len()
+	var batches sync.WaitGroup
+	var failed, rawBytes, sentBytes atomic.Int64
+	for begin := 0; begin < n; begin += l.recsPerInsert {
+		end := min(begin+l.recsPerInsert, n)
+		inflight <- struct{}{}
+		batches.Add(1)
+		go func() {
+			res, err := l.insert(ctx, func(ctx context.Context) (sink.Result, error) {
+				return recs.insert(ctx, l.ins, begin, end)
+			})
+			rawBytes.Add(int64(res.RawBytes))
+			sentBytes.Add(int64(res.Bytes))
+			if l.jrnl != nil {
+				if err := l.jrnl.Record(l.journalEntry(recs, begin, end, res, err)); err != nil {
+					l.logger.Error("Could not write journal", "err", err)
+				}
+			}
+			if err == nil {
+				rowsInserted.Add(end - begin)
+			} else {
+				l.logger.Error("Could not insert records", "rows", end-begin, "err", err)
+				failed.Add(int64(end - begin))
+				l.fail(end - begin)
+			}
+			<-inflight
+			batches.Done()
+		}()
+	}
+	sending.Add(1)
+	go func() {
+		batches.Wait()
+		counts := recs.counts()
+		if l.markers && failed.Load() == 0 {
+			// The markers need whole timestamps, which is why they
+			// cannot be combined with -scanRows.
+			l.mark(ctx, slices.Sorted(maps.Keys(counts)))
+		}
+		loaded <- loadResult{
+			rows:       n,
+			failedRows: int(failed.Load()),
+			rawBytes:   rawBytes.Load(),
+			sentBytes:  sentBytes.Load(),
+			timestamps: counts,
+		}
+		sending.Done()
+	}()
+}
+
+// loadSummaries inserts the summaries of the records instead of the records.
+func (l *loader) loadSummaries(ctx context.Context, recs []era5.Record) loadResult {
+	sums := l.agg.Summarize(recs)
+	res, err := l.insert(ctx, func(ctx context.Context) (sink.Result, error) {
+		return l.ins.(summaryInserter).InsertSummaries(ctx, sums)
+	})
+	lr := loadResult{rows: len(recs), rawBytes: int64(res.RawBytes), sentBytes: int64(res.Bytes), timestamps: recordParts(recs).counts()}
+	if err == nil {
+		rowsInserted.Add(len(recs))
+		if l.markers {
+			l.mark(ctx, slices.Sorted(maps.Keys(lr.timestamps)))
+		}
+	} else {
+		l.logger.Error("Could not insert summaries", "rows", len(recs), "err", err)
+		lr.failedRows = len(recs)
+		l.fail(len(recs))
+	}
+	return lr
+}
+
+// mark writes the completion markers of the timestamps. A marker that could
+// not be written only makes the next run export the timestamp again.
+func (l *loader) mark(ctx context.Context, timestamps []int64) {
+	ms := l.ins.(markerStore)
+	names, values := markerLabels(l.file)
+	for _, t := range timestamps {
+		ts := time.UnixMilli(t)
+		if err := ms.InsertInfo(ctx, markerName, names, values, ts); err != nil {
+			l.logger.Warn("Could not write completion marker", "ts", ts.UTC(), "err", err)
+		}
+	}
+}
+
+// fail accounts for n rows that failed to be inserted and aborts the export
+// if there are too many of them.
+func (l *loader) fail(n int) {
+	rowsFailed.Add(n)
+	total := l.totalFailed.Add(int64(n))
+	if l.maxFailedRows >= 0 && total > l.maxFailedRows {
+		l.abort(fmt.Errorf("%d rows failed to be inserted, which exceeds -maxFailedRows=%d", total, l.maxFailedRows))
+	}
+}
+
+// insert calls insertFunc applying the throttling and the insert timeout.
+func (l *loader) insert(ctx context.Context, insertFunc func(ctx context.Context) (sink.Result, error)) (sink.Result, error) {
+	if err := l.throttle.acquire(ctx); err != nil {
+		return sink.Result{}, err
+	}
+	defer l.throttle.release()
+	if l.insertTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.insertTimeout)
+		defer cancel()
+	}
+	return insertFunc(ctx)
+}
+
+// journalEntry describes the outcome of inserting a batch. The batch
+// identity is made of the file, the timestamp and the position of the batch
+// within the records of the timestamp, which stays the same between runs with
+// the same -recsPerInsert.
+func (l *loader) journalEntry(recs parts, begin, end int, res sink.Result, err error) *journal.Entry {
+	ts, laFrom := recs.at(begin)
+	_, laTo := recs.at(end - 1)
+	e := &journal.Entry{
+		Batch:             fmt.Sprintf("%s/%d/%d-%d", l.file, ts, begin, end),
+		File:              l.file,
+		Timestamp:         ts,
+		LaFrom:            laFrom,
+		LaTo:              laTo,
+		Rows:              end - begin,
+		Status:            journal.StatusOK,
+		Attempts:          res.Attempts,
+		AmbiguousAttempts: res.AmbiguousAttempts,
+	}
+	if err != nil {
+		e.Status = journal.StatusFailed
+		e.Err = err.Error()
+	}
+	return e
+}
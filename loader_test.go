@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"testing"
+
+	"github.com/rtm0/era5/internal/sink/sinktest"
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+func TestLoaderParts(t *testing.T) {
+	rec := &sinktest.Recorder{
+		Err: func(recs []era5.Record) error {
+			if recs[0].Timestamp == 2000 && recs[0].Latitude == 2 {
+				return errors.New("rejected")
+			}
+			return nil
+		},
+	}
+	l := &loader{
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		ins:           rec,
+		concurrency:   1,
+		inflight:      2,
+		recsPerInsert: 2,
+		maxFailedRows: -1,
+	}
+	var buffers [][]float64
+	extracted := make(chan []era5.Record)
+	go func() {
+		for _, ts := range []int64{1000, 2000} {
+			values := make([]float64, 5)
+			buffers = append(buffers, values)
+			recs := make([]era5.Record, len(values))
+			for i := range recs {
+				values[i] = float64(ts) + float64(i)
+				recs[i] = era5.Record{Timestamp: ts, Latitude: float32(i), Values: values[i : i+1]}
+			}
+			extracted <- recs
+		}
+		close(extracted)
+	}()
+	failed := make(map[int64]int)
+	for r := range l.run(context.Background(), extracted) {
+		if r.rows != 5 || len(r.timestamps) != 1 {
+			t.Fatalf("got the result %+v, want 5 rows of a single timestamp", r)
+		}
+		for ts, n := range r.timestamps {
+			if n != 5 {
+				t.Fatalf("got %d records of %d, want 5", n, ts)
+			}
+			failed[ts] = r.failedRows
+		}
+	}
+	if want := map[int64]int{1000: 0, 2000: 2}; !maps.Equal(failed, want) {
+		t.Fatalf("got the failed rows %v, want %v", failed, want)
+	}
+
+	// The scanner reuses the buffers of the values.
+	for _, values := range buffers {
+		clear(values)
+	}
+	got := make(map[float64]bool)
+	for _, r := range rec.Records() {
+		got[r.Values[0]] = true
+	}
+	for _, x := range []float64{1000, 1001, 1002, 1003, 1004, 2000, 2001, 2004} {
+		if !got[x] {
+			t.Errorf("the value %v was not recorded: %v", x, got)
+		}
+	}
+	if len(got) != 8 {
+		t.Fatalf("got the values %v, want 8 of them", got)
+	}
+}
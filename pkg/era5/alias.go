@@ -0,0 +1,124 @@
+package era5
+
+import (
+	"slices"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+)
+
+// coordAliases maps the names other copies of ERA5 give the coordinates to
+// the ERA5 names, e.g. lat, lon and time0 of the ERA5 files on AWS
+// (era5-pds), which name the time axis of the accumulations time1.
+var coordAliases = []struct{ alias, name string }{
+	{"lat", "latitude"},
+	{"lon", "longitude"},
+	{"time0", "valid_time"},
+	{"time1", "valid_time"},
+}
+
+// aliasGroup presents a group whose coordinates have the names of
+// coordAliases under the ERA5 names, so the scanner reads it like the ERA5
+// files. A coordinate is renamed only if the group lacks the ERA5 name.
+type aliasGroup struct {
+	api.Group
+	// names maps the names in the group to the ERA5 names and aliases maps
+	// them back.
+	names   map[string]string
+	aliases map[string]string
+}
+
+// withAliases returns g with its coordinates renamed per coordAliases.
+func withAliases(g api.Group) *aliasGroup {
+	ag := &aliasGroup{Group: g, names: make(map[string]string), aliases: make(map[string]string)}
+	present := append(g.ListVariables(), g.ListDimensions()...)
+	for _, a := range coordAliases {
+		if !slices.Contains(present, a.alias) || slices.Contains(present, a.name) || ag.aliases[a.name] != "" {
+			continue
+		}
+		ag.names[a.alias] = a.name
+		ag.aliases[a.name] = a.alias
+	}
+	return ag
+}
+
+// name returns the name under which the group presents the name in the
+// file.
+func (g *aliasGroup) name(name string) string {
+	if n, ok := g.names[name]; ok {
+		return n
+	}
+	return name
+}
+
+// fileName returns the name in the file of the name presented.
+func (g *aliasGroup) fileName(name string) string {
+	if alias, ok := g.aliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+func (g *aliasGroup) rename(names []string) []string {
+	if len(g.names) == 0 {
+		return names
+	}
+	renamed := make([]string, len(names))
+	for i, name := range names {
+		renamed[i] = g.name(name)
+	}
+	return renamed
+}
+
+func (g *aliasGroup) ListVariables() []string {
+	return g.rename(g.Group.ListVariables())
+}
+
+func (g *aliasGroup) ListDimensions() []string {
+	return g.rename(g.Group.ListDimensions())
+}
+
+func (g *aliasGroup) GetDimension(name string) (uint64, bool) {
+	return g.Group.GetDimension(g.fileName(name))
+}
+
+func (g *aliasGroup) GetVariable(name string) (*api.Variable, error) {
+	v, err := g.Group.GetVariable(g.fileName(name))
+	if err != nil {
+		return nil, err
+	}
+	renamed := *v
+	renamed.Dimensions = g.rename(v.Dimensions)
+	return &renamed, nil
+}
+
+func (g *aliasGroup) GetVarGetter(name string) (api.VarGetter, error) {
+	vg, err := g.Group.GetVarGetter(g.fileName(name))
+	if err != nil {
+		return nil, err
+	}
+	if len(g.names) == 0 {
+		return vg, nil
+	}
+	return &aliasVar{VarGetter: vg, g: g}, nil
+}
+
+// GetGroup returns the subgroup at the path with the aliases of its own
+// coordinates.
+func (g *aliasGroup) GetGroup(p string) (api.Group, error) {
+	sub, err := g.Group.GetGroup(p)
+	if err != nil {
+		return nil, err
+	}
+	return withAliases(sub), nil
+}
+
+// aliasVar is a variable of an aliasGroup, whose dimensions have the ERA5
+// names.
+type aliasVar struct {
+	api.VarGetter
+	g *aliasGroup
+}
+
+func (v *aliasVar) Dimensions() []string {
+	return v.g.rename(v.VarGetter.Dimensions())
+}
@@ -0,0 +1,58 @@
+package era5
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+	"github.com/batchatco/go-native-netcdf/netcdf/cdf"
+	"github.com/batchatco/go-native-netcdf/netcdf/util"
+)
+
+// TestAliases reads a file laid out like the ERA5 files on AWS, whose
+// coordinates are named lat, lon and time0.
+func TestAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "air_temperature_at_2_metres.nc")
+	w, err := cdf.OpenWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := func(units string) api.AttributeMap {
+		m, err := util.NewOrderedMap([]string{"units"}, map[string]any{"units": units})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	vars := []struct {
+		name string
+		v    api.Variable
+	}{
+		{"lon", api.Variable{Values: []float32{0, 0.25}, Dimensions: []string{"lon"}, Attributes: attrs("degrees_east")}},
+		{"lat", api.Variable{Values: []float32{0.25, 0}, Dimensions: []string{"lat"}, Attributes: attrs("degrees_north")}},
+		{"time0", api.Variable{Values: []int32{1000000, 1000001}, Dimensions: []string{"time0"}, Attributes: attrs("hours since 1900-01-01 00:00:00.0")}},
+		{"air_temperature_at_2_metres", api.Variable{
+			Values:     [][][]float32{{{1, 2}, {3, 4}}, {{5, 6}, {7, 8}}},
+			Dimensions: []string{"time0", "lat", "lon"},
+			Attributes: attrs("K"),
+		}},
+	}
+	for _, v := range vars {
+		if err := w.AddVar(v.name, v.v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, s := scanPhysical(t, path, "air_temperature_at_2_metres")
+	if lats := s.Latitudes(); len(lats) != 2 || lats[0] != 0.25 {
+		t.Fatalf("got the latitudes %v, want [0.25 0]", lats)
+	}
+	ts := s.Timestamps()
+	if len(ts) != 2 {
+		t.Fatalf("got %d timestamps, want 2", len(ts))
+	}
+	checkPhysical(t, ts[0], got[ts[0]], []float64{1, 2, 3, 4}, 0)
+	checkPhysical(t, ts[1], got[ts[1]], []float64{5, 6, 7, 8}, 0)
+}
@@ -0,0 +1,150 @@
+package era5
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// azureBlobHostSuffix is the host suffix of the blob endpoints of Azure
+// storage accounts.
+const azureBlobHostSuffix = ".blob.core.windows.net"
+
+// azureAPIVersion is the version of the Blob service API the requests are
+// signed for.
+const azureAPIVersion = "2021-08-06"
+
+// openAzureBlob opens a blob given by an az://container/blob URL of the
+// account set by AZURE_STORAGE_ACCOUNT or by the URL of the blob,
+// https://<account>.blob.core.windows.net/container/blob. The requests are
+// authorized with the SAS token in the URL or in AZURE_STORAGE_SAS_TOKEN, or
+// signed with the account key in AZURE_STORAGE_KEY. All of these can be
+// given by AZURE_STORAGE_CONNECTION_STRING instead, whose BlobEndpoint also
+// allows reading from the Azurite emulator. Blobs are read anonymously if
+// there are no credentials, which is enough for public containers.
+func openAzureBlob(ctx context.Context, blobURL string) (source, error) {
+	conn, err := parseAzureConnectionString(os.Getenv("AZURE_STORAGE_CONNECTION_STRING"))
+	if err != nil {
+		return nil, err
+	}
+	account := cmpOr(os.Getenv("AZURE_STORAGE_ACCOUNT"), conn["AccountName"])
+	key := cmpOr(os.Getenv("AZURE_STORAGE_KEY"), conn["AccountKey"])
+	sas := strings.TrimPrefix(cmpOr(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), conn["SharedAccessSignature"]), "?")
+
+	var u *url.URL
+	if rest, ok := strings.CutPrefix(blobURL, "az://"); ok {
+		container, blob, ok := strings.Cut(rest, "/")
+		if !ok || container == "" || blob == "" {
+			return nil, fmt.Errorf("invalid Azure URL %q, want az://container/blob", blobURL)
+		}
+		endpoint := conn["BlobEndpoint"]
+		if endpoint == "" {
+			if account == "" {
+				return nil, fmt.Errorf("AZURE_STORAGE_ACCOUNT must be set to read %s", blobURL)
+			}
+			endpoint = "https://" + account + azureBlobHostSuffix
+		}
+		u, err = url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + container + "/" + awsEscapePath(blob))
+	} else {
+		u, err = url.Parse(blobURL)
+		if err == nil {
+			account = strings.TrimSuffix(u.Host, azureBlobHostSuffix)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid Azure URL %q: %w", blobURL, err)
+	}
+	if u.RawQuery == "" && sas != "" {
+		u.RawQuery = sas
+	}
+
+	var authorize func(req *http.Request) error
+	switch {
+	case u.Query().Has("sig"):
+		// The SAS token authorizes the requests by itself.
+	case key != "":
+		keyBytes, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Azure storage account key: %w", err)
+		}
+		authorize = func(req *http.Request) error {
+			signSharedKey(req, account, keyBytes, time.Now())
+			return nil
+		}
+	}
+	return openHTTPObject(ctx, u.String(), authorize)
+}
+
+// parseAzureConnectionString parses a connection string of a storage
+// account, Key1=Value1;Key2=Value2.
+func parseAzureConnectionString(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, kv := range strings.Split(s, ";") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_CONNECTION_STRING: missing '=' in %q", kv)
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return m, nil
+}
+
+// signSharedKey signs a request without a body with the Shared Key of the
+// storage account.
+func signSharedKey(req *http.Request, account string, key []byte, now time.Time) {
+	req.Header.Set("X-Ms-Date", now.UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureAPIVersion)
+
+	var msHeaders []string
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name+":"+strings.TrimSpace(strings.Join(values, ",")))
+		}
+	}
+	slices.Sort(msHeaders)
+
+	resource := "/" + account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	slices.Sort(params)
+	for _, name := range params {
+		values := slices.Clone(query[name])
+		slices.Sort(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		"", // Content-Encoding
+		"", // Content-Language
+		"", // Content-Length
+		"", // Content-MD5
+		"", // Content-Type
+		"", // Date, sent as x-ms-date
+		"", // If-Modified-Since
+		"", // If-Match
+		"", // If-None-Match
+		"", // If-Unmodified-Since
+		req.Header.Get("Range"),
+		strings.Join(msHeaders, "\n"),
+		resource,
+	}, "\n")
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(stringToSign))
+	req.Header.Set("Authorization", "SharedKey "+account+":"+base64.StdEncoding.EncodeToString(h.Sum(nil)))
+}
@@ -0,0 +1,63 @@
+package era5
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// azuriteKey is the well-known account key of the devstoreaccount1 account
+// of the Azurite emulator.
+const azuriteKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+// TestSignSharedKey checks the Shared Key signature of a ranged read
+// against the string to sign laid out by the Azure Storage documentation.
+func TestSignSharedKey(t *testing.T) {
+	key, err := base64.StdEncoding.DecodeString(azuriteKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://devstoreaccount1.blob.core.windows.net/era5/2024%2001/t2m.nc?timeout=30&comp=block&comp=a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=0-9")
+	req.Header.Set("X-Ms-Client-Request-Id", "42")
+	signSharedKey(req, "devstoreaccount1", key, time.Date(2013, 5, 24, 0, 0, 0, 0, time.UTC))
+
+	stringToSign := "GET\n" +
+		"\n\n\n\n\n\n\n\n\n\n" + // the standard headers but Range
+		"bytes=0-9\n" +
+		"x-ms-client-request-id:42\n" +
+		"x-ms-date:Fri, 24 May 2013 00:00:00 GMT\n" +
+		"x-ms-version:2021-08-06\n" +
+		"/devstoreaccount1/era5/2024%2001/t2m.nc\n" +
+		"comp:a,block\n" +
+		"timeout:30"
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(stringToSign))
+	want := "SharedKey devstoreaccount1:" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("got the authorization %s, want %s", got, want)
+	}
+	if got := req.Header.Get("X-Ms-Date"); got != "Fri, 24 May 2013 00:00:00 GMT" {
+		t.Fatalf("got the date %s", got)
+	}
+}
+
+func TestParseAzureConnectionString(t *testing.T) {
+	got, err := parseAzureConnectionString("DefaultEndpointsProtocol=http; AccountName=devstoreaccount1;AccountKey=" + azuriteKey +
+		";BlobEndpoint=http://127.0.0.1:10000/devstoreaccount1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["AccountName"] != "devstoreaccount1" || got["AccountKey"] != azuriteKey || got["BlobEndpoint"] != "http://127.0.0.1:10000/devstoreaccount1" {
+		t.Fatalf("got %v", got)
+	}
+	if _, err := parseAzureConnectionString("AccountName"); err == nil {
+		t.Fatal("parsed a connection string without a value")
+	}
+}
@@ -0,0 +1,93 @@
+package era5
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// BBox is a geographic region in degrees. The longitudes may be given in
+// either the -180..180 or the 0..360 convention, which is matched against
+// the grid of any convention. MinLon greater than MaxLon makes the region
+// cross the antimeridian, e.g. 170,-170.
+type BBox struct {
+	MinLat, MinLon float64
+	MaxLat, MaxLon float64
+}
+
+// ParseBBox parses a region given as minLat,minLon,maxLat,maxLon.
+func ParseBBox(s string) (*BBox, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("want minLat,minLon,maxLat,maxLon, got %q", s)
+	}
+	var v [4]float64
+	for i, f := range fields {
+		var err error
+		v[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	b := &BBox{MinLat: v[0], MinLon: v[1], MaxLat: v[2], MaxLon: v[3]}
+	if b.MinLat < -90 || b.MaxLat > 90 || b.MinLat > b.MaxLat {
+		return nil, fmt.Errorf("latitudes %g,%g are not an ascending range within [-90, 90]", b.MinLat, b.MaxLat)
+	}
+	if b.MinLon < -180 || b.MaxLon > 360 {
+		return nil, fmt.Errorf("longitudes %g,%g are out of range [-180, 360]", b.MinLon, b.MaxLon)
+	}
+	return b, nil
+}
+
+// containsLat reports whether the latitude is within the region.
+func (b *BBox) containsLat(la float32) bool {
+	return float64(la) >= b.MinLat && float64(la) <= b.MaxLat
+}
+
+// containsLon reports whether the longitude is within the region.
+func (b *BBox) containsLon(lo float32) bool {
+	if b.MaxLon-b.MinLon >= 360 {
+		return true
+	}
+	x, lo1, lo2 := wrapLon(float64(lo)), wrapLon(b.MinLon), wrapLon(b.MaxLon)
+	if lo1 <= lo2 {
+		return x >= lo1 && x <= lo2
+	}
+	return x >= lo1 || x <= lo2
+}
+
+// wrapLon maps a longitude into [0, 360).
+func wrapLon(lo float64) float64 {
+	lo = math.Mod(lo, 360)
+	if lo < 0 {
+		lo += 360
+	}
+	return lo
+}
+
+// selectCoords returns the coordinates keep is true for and their indexes
+// within the grid, which idx holds for all the coordinates.
+func selectCoords(coords []float32, idx []int, keep func(float32) bool) ([]float32, []int) {
+	var values []float32
+	var selected []int
+	for i, c := range coords {
+		if keep(c) {
+			values = append(values, c)
+			selected = append(selected, idx[i])
+		}
+	}
+	return values, selected
+}
+
+// strideCoords returns every n-th of the coordinates and of their indexes
+// within the grid, starting with the first one.
+func strideCoords(coords []float32, idx []int, n int) ([]float32, []int) {
+	values := make([]float32, 0, (len(coords)+n-1)/n)
+	strided := make([]int, 0, cap(values))
+	for i := 0; i < len(coords); i += n {
+		values = append(values, coords[i])
+		strided = append(strided, idx[i])
+	}
+	return values, strided
+}
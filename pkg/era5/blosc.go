@@ -0,0 +1,233 @@
+package era5
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The flags of the header of a Blosc chunk.
+const (
+	bloscShuffle    = 0x01
+	bloscMemcpyed   = 0x02
+	bloscBitShuffle = 0x04
+	bloscDontSplit  = 0x10
+)
+
+// bloscHeaderSize is the size of the header of a Blosc chunk.
+const bloscHeaderSize = 16
+
+// bloscDecompress decompresses a chunk compressed with Blosc 1, the default
+// compressor of Zarr. The blocks compressed with LZ4, zlib and Zstandard and
+// the byte shuffle are supported.
+func bloscDecompress(src []byte) ([]byte, error) {
+	if len(src) < bloscHeaderSize {
+		return nil, errors.New("the Blosc chunk is shorter than its header")
+	}
+	flags, typeSize := src[2], int(src[3])
+	n := int(binary.LittleEndian.Uint32(src[4:]))
+	blockSize := int(binary.LittleEndian.Uint32(src[8:]))
+	if int(binary.LittleEndian.Uint32(src[12:])) > len(src) {
+		return nil, errors.New("the Blosc chunk is truncated")
+	}
+	if flags&bloscMemcpyed != 0 {
+		if len(src) < bloscHeaderSize+n {
+			return nil, errors.New("the Blosc chunk is truncated")
+		}
+		return bytes.Clone(src[bloscHeaderSize : bloscHeaderSize+n]), nil
+	}
+	if flags&bloscBitShuffle != 0 {
+		return nil, errors.New("the Blosc bit shuffle is not supported, only the byte shuffle is")
+	}
+	if blockSize <= 0 || typeSize <= 0 {
+		return nil, fmt.Errorf("invalid Blosc block size %d or type size %d", blockSize, typeSize)
+	}
+	var decompress func(dst, src []byte) error
+	switch codec := flags >> 5; codec {
+	case 1:
+		decompress = lz4Decompress
+	case 3:
+		decompress = zlibDecompress
+	case 4:
+		decompress = zstdDecompress
+	default:
+		return nil, fmt.Errorf("the Blosc codec %s is not supported, only lz4, zlib and zstd are", bloscCodecName(codec))
+	}
+	nBlocks := (n + blockSize - 1) / blockSize
+	if len(src) < bloscHeaderSize+4*nBlocks {
+		return nil, errors.New("the Blosc chunk is truncated")
+	}
+	dst := make([]byte, n)
+	tmp := make([]byte, blockSize)
+	for b := range nBlocks {
+		size := min(blockSize, n-b*blockSize)
+		leftover := size < blockSize
+		start := int(binary.LittleEndian.Uint32(src[bloscHeaderSize+4*b:]))
+		out := dst[b*blockSize : b*blockSize+size]
+		if flags&bloscShuffle != 0 && typeSize > 1 {
+			out = tmp[:size]
+		}
+		// The block is split into a stream per byte of the values unless
+		// told otherwise, and the last block if shorter is never split.
+		streams := 1
+		if flags&bloscDontSplit == 0 && !leftover {
+			streams = typeSize
+		}
+		if size%streams != 0 {
+			return nil, errors.New("the Blosc block does not split evenly")
+		}
+		streamSize := size / streams
+		pos := start
+		for s := range streams {
+			if pos+4 > len(src) {
+				return nil, errors.New("the Blosc chunk is truncated")
+			}
+			cbytes := int(binary.LittleEndian.Uint32(src[pos:]))
+			pos += 4
+			if pos+cbytes > len(src) {
+				return nil, errors.New("the Blosc chunk is truncated")
+			}
+			stream := out[s*streamSize : (s+1)*streamSize]
+			if cbytes == streamSize {
+				// The stream did not compress and is stored as is.
+				copy(stream, src[pos:pos+cbytes])
+			} else if err := decompress(stream, src[pos:pos+cbytes]); err != nil {
+				return nil, err
+			}
+			pos += cbytes
+		}
+		if flags&bloscShuffle != 0 && typeSize > 1 {
+			unshuffle(dst[b*blockSize:b*blockSize+size], out, typeSize)
+		}
+	}
+	return dst, nil
+}
+
+func bloscCodecName(codec byte) string {
+	switch codec {
+	case 0:
+		return "blosclz"
+	case 2:
+		return "snappy"
+	}
+	return fmt.Sprint(codec)
+}
+
+// unshuffle reverts the byte shuffle, which stores the first bytes of all the
+// values of a block, then the second bytes and so on. The bytes that do not
+// make up a whole value are stored as is.
+func unshuffle(dst, src []byte, typeSize int) {
+	n := len(src) / typeSize
+	for i := range n {
+		for j := range typeSize {
+			dst[i*typeSize+j] = src[j*n+i]
+		}
+	}
+	copy(dst[n*typeSize:], src[n*typeSize:])
+}
+
+// lz4Decompress decompresses an LZ4 block into dst, which has the size of
+// the decompressed data.
+func lz4Decompress(dst, src []byte) error {
+	errCorrupt := errors.New("the LZ4 block is corrupt")
+	d, i := 0, 0
+	for i < len(src) {
+		token := src[i]
+		i++
+		n := int(token >> 4)
+		if n == 15 {
+			for {
+				if i >= len(src) {
+					return errCorrupt
+				}
+				b := src[i]
+				i++
+				n += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if i+n > len(src) || d+n > len(dst) {
+			return errCorrupt
+		}
+		d += copy(dst[d:], src[i:i+n])
+		i += n
+		if i == len(src) {
+			// The last sequence has literals only.
+			break
+		}
+		if i+2 > len(src) {
+			return errCorrupt
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > d {
+			return errCorrupt
+		}
+		n = int(token & 15)
+		if n == 15 {
+			for {
+				if i >= len(src) {
+					return errCorrupt
+				}
+				b := src[i]
+				i++
+				n += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		n += 4
+		if d+n > len(dst) {
+			return errCorrupt
+		}
+		// The match may overlap the bytes it produces, so it is copied
+		// byte by byte.
+		for k := range n {
+			dst[d+k] = dst[d-offset+k]
+		}
+		d += n
+	}
+	if d != len(dst) {
+		return fmt.Errorf("the LZ4 block holds %d bytes instead of %d", d, len(dst))
+	}
+	return nil
+}
+
+func zlibDecompress(dst, src []byte) error {
+	zr, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	_, err = io.ReadFull(zr, dst)
+	return err
+}
+
+// zstdDecoder decodes the Zstandard frames of all the chunks, which it
+// allows concurrently.
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+func zstdDecompress(dst, src []byte) error {
+	if len(dst) == 0 {
+		return nil
+	}
+	out, err := zstdDecoder.DecodeAll(src, dst[:0])
+	if err != nil {
+		return err
+	}
+	if len(out) != len(dst) {
+		return fmt.Errorf("the Zstandard frame holds %d bytes instead of %d", len(out), len(dst))
+	}
+	if &out[0] != &dst[0] {
+		copy(dst, out)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package era5
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+)
+
+// deviate reports a deviation of the file from the ERA5 layout that the
+// scanner copes with by the action. It fails in the strict mode and records a
+// warning otherwise.
+func (s *Scanner) deviate(action, format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if s.strict {
+		return errors.New(msg)
+	}
+	s.warnings = append(s.warnings, msg+", "+action)
+	return nil
+}
+
+// coordValues reads the values of a coordinate variable. Coordinates stored
+// with a type other than T are a deviation.
+func coordValues[T int32 | float32](s *Scanner, root api.Group, group, dimName string) ([]T, error) {
+	v, from, err := dimValues[T](root, group, dimName)
+	if err != nil {
+		return nil, err
+	}
+	if from != "" {
+		if err := s.deviate("converting it", "coordinate %s is stored as %s instead of %T", dimName, from, T(0)); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// checkVar checks the dtype, the shape and the attributes of a variable.
+func (s *Scanner) checkVar(name string, vg api.VarGetter) error {
+	dims := vg.Dimensions()
+	varDims := []string{s.timeDim}
+	if s.expvers != nil {
+		varDims = append(varDims, expverDim)
+	}
+	if s.levelDim != "" {
+		varDims = append(varDims, s.levelDim)
+	}
+	varDims = append(varDims, "latitude", "longitude")
+	if len(dims) != len(varDims) {
+		return fmt.Errorf("variable %s has dimensions %v, want %v", name, dims, varDims)
+	}
+	if n := len(dims); dims[n-2] == "longitude" && dims[n-1] == "latitude" {
+		return fmt.Errorf("variable %s has the longitude dimension before the latitude, which is not supported", name)
+	}
+	if !slices.Equal(dims, varDims) {
+		if err := s.deviate("treating them as such", "variable %s has dimensions %v instead of %v", name, dims, varDims); err != nil {
+			return err
+		}
+	}
+	if n := vg.Len(); n != int64(len(s.times)) {
+		return fmt.Errorf("variable %s has %d time steps, want %d", name, n, len(s.times))
+	}
+	switch t := vg.GoType(); t {
+	case "int16":
+	case "int8", "uint8", "int32", "uint16", "uint32", "int64", "uint64":
+		if err := s.deviate("exporting them as stored", "values of %s are stored as %s instead of int16", name, t); err != nil {
+			return err
+		}
+	case "float32", "float64":
+		// The values are unpacked already, so the packing attributes
+		// are not expected.
+		return s.deviate("exporting them as stored", "values of %s are stored as %s instead of int16", name, t)
+	default:
+		return fmt.Errorf("values of %s are stored as unsupported type %s", name, t)
+	}
+	attrs := vg.Attributes()
+	for _, key := range []string{"scale_factor", "add_offset"} {
+		if _, ok := attrs.Get(key); !ok {
+			if err := s.deviate("exporting its values as stored", "variable %s has no %s attribute", name, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// timeSteps returns the values read from a variable as float64 planes of the
+// grid at every time step. The planes of a time step are ordered like the
+// dimensions between the time axis and the grid, i.e. the expver and the
+// levels, and there is a single plane if there are no such dimensions. The
+// values of every numeric type are converted exactly, except for the 64-bit
+// integers beyond 2^53, which the data variables do not reach.
+func timeSteps(v any) ([][][][]float64, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("unsupported values of type %T", v)
+	}
+	steps := make([][][][]float64, rv.Len())
+	for i := range steps {
+		var err error
+		steps[i], err = appendPlanes(nil, rv.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return steps, nil
+}
+
+// appendPlanes appends the grid planes of the values to dst. The values of
+// the dimensions outside of the grid are walked with reflection, which is
+// cheap since it is done once per plane.
+func appendPlanes(dst [][][]float64, v any) ([][][]float64, error) {
+	switch v := v.(type) {
+	case [][]int16:
+		return append(dst, convert2[float64](v)), nil
+	case [][]float32:
+		return append(dst, convert2[float64](v)), nil
+	case [][]float64:
+		return append(dst, v), nil
+	case [][]int8:
+		return append(dst, convert2[float64](v)), nil
+	case [][]uint8:
+		return append(dst, convert2[float64](v)), nil
+	case [][]int32:
+		return append(dst, convert2[float64](v)), nil
+	case [][]uint16:
+		return append(dst, convert2[float64](v)), nil
+	case [][]uint32:
+		return append(dst, convert2[float64](v)), nil
+	case [][]int64:
+		return append(dst, convert2[float64](v)), nil
+	case [][]uint64:
+		return append(dst, convert2[float64](v)), nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("unsupported values of type %T", v)
+	}
+	for i := range rv.Len() {
+		var err error
+		dst, err = appendPlanes(dst, rv.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+func convert2[T, S number](src [][]S) [][]T {
+	dst := make([][]T, len(src))
+	for i, row := range src {
+		dst[i] = convert[T](row)
+	}
+	return dst
+}
+
+// attrFloat64 returns the value of a numeric attribute as float64.
+func attrFloat64(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,92 @@
+package era5
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+	"github.com/batchatco/go-native-netcdf/netcdf/cdf"
+)
+
+func TestTimeStepsWideValues(t *testing.T) {
+	for _, tc := range []struct {
+		v    any
+		want float64
+	}{
+		{[][][]int32{{{1, 40000}}}, 40000},
+		{[][][]uint16{{{1, 40000}}}, 40000},
+		{[][][]int64{{{1, -1 << 40}}}, -1 << 40},
+		{[][][]uint64{{{1, 1 << 53}}}, 1 << 53},
+		{[][][]float32{{{1, 0.1}}}, float64(float32(0.1))},
+		{[][][]float64{{{1, math.SmallestNonzeroFloat64}}}, math.SmallestNonzeroFloat64},
+	} {
+		steps, err := timeSteps(tc.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := steps[0][0][0]; got[0] != 1 || got[1] != tc.want {
+			t.Errorf("timeSteps(%T) = %v, want [1 %v]", tc.v, got, tc.want)
+		}
+	}
+}
+
+// TestNativeValues checks that the values stored as floats and as integers
+// wider than int16 reach the records as stored, however far they are from the
+// values at the first and the last time step.
+func TestNativeValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "native.nc")
+	w, err := cdf.OpenWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := func(kv ...any) api.AttributeMap { return attrMap(t, kv...) }
+	const fill = int32(-2147483647)
+	vars := []struct {
+		name string
+		v    api.Variable
+	}{
+		{"longitude", api.Variable{Values: []float32{0, 0.25}, Dimensions: []string{"longitude"}, Attributes: attrs()}},
+		{"latitude", api.Variable{Values: []float32{0}, Dimensions: []string{"latitude"}, Attributes: attrs()}},
+		{"time", api.Variable{Values: []int32{1000000, 1000001, 1000002}, Dimensions: []string{"time"}, Attributes: attrs("units", "hours since 1900-01-01 00:00:00.0")}},
+		{"f", api.Variable{
+			Values:     [][][]float32{{{0, 0}}, {{1e6, 0.001}}, {{0, 0}}},
+			Dimensions: []string{"time", "latitude", "longitude"},
+			Attributes: attrs(),
+		}},
+		{"i", api.Variable{
+			Values:     [][][]int32{{{0, 0}}, {{100000, fill}}, {{0, 0}}},
+			Dimensions: []string{"time", "latitude", "longitude"},
+			Attributes: attrs("_FillValue", fill),
+		}},
+	}
+	for _, v := range vars {
+		if err := w.AddVar(v.name, v.v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewScanner(path, Options{Variables: []string{"f", "i"}, HourIndexes: []int{1}, Missing: MissingNaN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if scale, offset := s.Packing(); scale[0] != 1 || offset[0] != 0 || scale[1] != 1 || offset[1] != 0 {
+		t.Fatalf("got the packing %v %v, want the scale 1 and the offset 0", scale, offset)
+	}
+	if !s.Scan() {
+		t.Fatal(s.Error())
+	}
+	recs := s.Records()
+	if len(recs) != 2 {
+		t.Fatalf("got %d records, want 2", len(recs))
+	}
+	if got := recs[0].Values; got[0] != 1e6 || got[1] != 100000 {
+		t.Fatalf("got the values %v, want [1e+06 100000]", got)
+	}
+	if got := recs[1].Values; got[0] != float64(float32(0.001)) || !IsMissing(got[1]) {
+		t.Fatalf("got the values %v, want [0.001 NaN]", got)
+	}
+}
@@ -0,0 +1,64 @@
+package era5
+
+import (
+	"container/list"
+	"sync"
+)
+
+// chunkKey identifies a block of consecutive time steps of a variable.
+type chunkKey struct {
+	varIndex   int
+	chunkIndex int64
+}
+
+type chunkEntry struct {
+	key    chunkKey
+	values [][][][]float64
+}
+
+// chunkCache is an LRU cache of decoded variable values. Reading a block of
+// time steps at once and keeping it around lets the consecutive Scan() calls
+// avoid reading and decompressing the same file chunks over and over again.
+type chunkCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[chunkKey]*list.Element
+	lru      *list.List
+}
+
+func newChunkCache(capacity int) *chunkCache {
+	return &chunkCache{
+		capacity: capacity,
+		entries:  make(map[chunkKey]*list.Element, capacity),
+		lru:      list.New(),
+	}
+}
+
+// get returns the cached values of a chunk or nil if the chunk is not cached.
+func (c *chunkCache) get(key chunkKey) [][][][]float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*chunkEntry).values
+}
+
+// put adds the values of a chunk to the cache evicting the least recently
+// used chunk if the cache is full.
+func (c *chunkCache) put(key chunkKey, values [][][][]float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	if c.lru.Len() >= c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*chunkEntry).key)
+	}
+	c.entries[key] = c.lru.PushFront(&chunkEntry{key: key, values: values})
+}
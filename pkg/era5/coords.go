@@ -0,0 +1,134 @@
+package era5
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+)
+
+// dimValues reads the values of a coordinate variable converting them to T,
+// since files converted by other tools may store the coordinates with any
+// numeric type. from is the type the values are stored with if they have been
+// converted and empty otherwise.
+func dimValues[T int32 | float32 | float64](root api.Group, group, dimName string) (values []T, from string, err error) {
+	v, _, err := varValues(root, group, dimName)
+	if err != nil {
+		return nil, "", err
+	}
+	return numberValues[T](v, dimName)
+}
+
+// numberValues converts the values of the coordinate dimName read from a file
+// like dimValues.
+func numberValues[T int32 | float32 | float64](v any, dimName string) (values []T, from string, err error) {
+	from = strings.TrimPrefix(fmt.Sprintf("%T", v), "[]")
+	switch v := v.(type) {
+	case []T:
+		return v, "", nil
+	case []int8:
+		return convert[T](v), from, nil
+	case []int16:
+		return convert[T](v), from, nil
+	case []int32:
+		return convert[T](v), from, nil
+	case []int64:
+		return convert[T](v), from, nil
+	case []uint8:
+		return convert[T](v), from, nil
+	case []uint16:
+		return convert[T](v), from, nil
+	case []uint32:
+		return convert[T](v), from, nil
+	case []uint64:
+		return convert[T](v), from, nil
+	case []float32:
+		return convert[T](v), from, nil
+	case []float64:
+		return convert[T](v), from, nil
+	case string, []string:
+		return nil, "", fmt.Errorf("coordinate %s holds strings instead of numbers", dimName)
+	default:
+		return nil, "", fmt.Errorf("coordinate %s has unsupported type %T", dimName, v)
+	}
+}
+
+// number is any numeric type a coordinate may be stored with.
+type number interface {
+	int8 | int16 | int32 | int64 | uint8 | uint16 | uint32 | uint64 | float32 | float64
+}
+
+func convert[T, S number](src []S) []T {
+	dst := make([]T, len(src))
+	for i, v := range src {
+		dst[i] = T(v)
+	}
+	return dst
+}
+
+// timeLabels reads the auxiliary coordinates of the time axis timeDim stored
+// as strings or char arrays, such as expver that tells the final ERA5 data
+// from the preliminary ERA5T, in the group and its ancestors. It returns the
+// names of the coordinates and their values at every time index.
+func timeLabels(root api.Group, group, timeDim string, timeLen int) ([]string, [][]string, error) {
+	var names []string
+	var values [][]string
+	for p := group; ; p = path.Dir(p) {
+		g, err := getGroup(root, p)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, name := range g.ListVariables() {
+			if slices.Contains(names, name) {
+				continue
+			}
+			vg, err := g.GetVarGetter(name)
+			if err != nil {
+				continue
+			}
+			if dims := vg.Dimensions(); len(dims) == 0 || dims[0] != timeDim || vg.GoType() != "string" {
+				continue
+			}
+			v, err := vg.Values()
+			if err != nil {
+				closeGroup(g, root)
+				return nil, nil, fmt.Errorf("could not read %s: %w", name, err)
+			}
+			strs, ok := textValues(v, timeLen)
+			if !ok {
+				continue
+			}
+			names = append(names, name)
+			values = append(values, strs)
+		}
+		closeGroup(g, root)
+		if p == rootGroup {
+			return names, values, nil
+		}
+	}
+}
+
+// textValues returns the values of a text variable along the time axis. Char
+// arrays of fixed length strings are padded with zero bytes.
+func textValues(v any, timeLen int) ([]string, bool) {
+	var strs []string
+	switch v := v.(type) {
+	case []string:
+		strs = v
+	case string:
+		// A char array without the string length dimension has a single
+		// character per time index.
+		strs = strings.Split(v, "")
+	default:
+		return nil, false
+	}
+	if len(strs) != timeLen {
+		return nil, false
+	}
+	for i := range strs {
+		strs[i] = strings.TrimSpace(strings.TrimRight(strs[i], "\x00"))
+	}
+	return strs, true
+}
@@ -0,0 +1,141 @@
+package era5
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+)
+
+// deaccumulation turns the values of a variable accumulated over cycles, such
+// as the precipitation of ERA5-Land accumulated since 00 UTC, into the values
+// of every time step, v(t) - v(t-1) within a cycle and v(t) at its first time
+// step. The values stay in the units of the packing of the variable without
+// its offset, which cancels out of the differences, so they are unpacked with
+// the scale alone.
+type deaccumulation struct {
+	scale, offset float64
+	fill          float64
+	hasFill       bool
+}
+
+// newDeaccumulation returns the de-accumulation of a variable packed with
+// scale and offset whose values equal to fill are missing if hasFill is set.
+func newDeaccumulation(scale, offset, fill float64, hasFill bool) *deaccumulation {
+	return &deaccumulation{scale: scale, offset: offset, fill: fill, hasFill: hasFill}
+}
+
+// apply returns the planes of the values of a time step given the planes of
+// the accumulations at the time step and at the previous one, which is nil at
+// the first time step of a cycle. A value is missing if either accumulation
+// is.
+func (d *deaccumulation) apply(cur, prev [][][]float64) [][][]float64 {
+	// The offset of the accumulations at the first time step of a cycle is
+	// moved into the values.
+	shift := 0.0
+	if d.scale != 0 {
+		shift = d.offset / d.scale
+	}
+	nan := math.NaN()
+	dst := make([][][]float64, len(cur))
+	for p, plane := range cur {
+		dst[p] = make([][]float64, len(plane))
+		for i, row := range plane {
+			dst[p][i] = make([]float64, len(row))
+			for j, a := range row {
+				switch {
+				case d.isFill(a) || prev != nil && d.isFill(prev[p][i][j]):
+					dst[p][i][j] = nan
+				case prev != nil:
+					dst[p][i][j] = a - prev[p][i][j]
+				default:
+					dst[p][i][j] = a + shift
+				}
+			}
+		}
+	}
+	return dst
+}
+
+// missing returns the planes of the missing values of a time step shaped
+// like cur.
+func (d *deaccumulation) missing(cur [][][]float64) [][][]float64 {
+	nan := math.NaN()
+	dst := make([][][]float64, len(cur))
+	for p, plane := range cur {
+		dst[p] = make([][]float64, len(plane))
+		for i, row := range plane {
+			dst[p][i] = make([]float64, len(row))
+			for j := range row {
+				dst[p][i][j] = nan
+			}
+		}
+	}
+	return dst
+}
+
+func (d *deaccumulation) isFill(x float64) bool {
+	return math.IsNaN(x) || d.hasFill && x == d.fill
+}
+
+// newDeaccumulations returns the de-accumulations of the variables names by
+// the indexes of the variables. The variables absent from the file are left
+// out.
+func (s *Scanner) newDeaccumulations(names []string) ([]*deaccumulation, error) {
+	deacc := make([]*deaccumulation, len(s.varNames))
+	scale, offset := s.Packing()
+	fill := s.storedFillValues()
+	for _, name := range names {
+		i := slices.Index(s.varNames, name)
+		if i < 0 {
+			return nil, fmt.Errorf("variable %s to de-accumulate is not among the variables %v", name, s.varNames)
+		}
+		if s.vars[0][i] == nil {
+			continue
+		}
+		f, hasFill := fill[name]
+		deacc[i] = newDeaccumulation(scale[i], offset[i], f, hasFill)
+	}
+	return deacc, nil
+}
+
+// deaccumulate returns the values of the variable at the index t of the time
+// axis turned into the values of the time step given the accumulations cur
+// there. The values at the first time step of the file are missing unless
+// it starts a cycle, since the previous accumulations are unknown.
+func (s *Scanner) deaccumulate(t int64, varIndex int, vg api.VarGetter, cur [][][]float64) ([][][]float64, error) {
+	d := s.deacc[varIndex]
+	start, known := s.cycleStart(t)
+	switch {
+	case start:
+		return d.apply(cur, nil), nil
+	case !known:
+		return d.missing(cur), nil
+	}
+	prev, err := s.scanIndex(t-1, varIndex, vg)
+	if err != nil {
+		return nil, err
+	}
+	return d.apply(cur, prev), nil
+}
+
+// cycleStart tells whether the index t of the time axis is the first time
+// step of an accumulation cycle, which is the case if the previous time step
+// is at one of the reset hours. known is false if the previous time step is
+// out of the file and the step of the time axis is unknown.
+func (s *Scanner) cycleStart(t int64) (start, known bool) {
+	var prev int64
+	switch {
+	case t > 0:
+		prev = s.times[t-1]
+	case len(s.times) > 1:
+		prev = s.times[0] - (s.times[1] - s.times[0])
+	default:
+		return false, false
+	}
+	pt := time.UnixMilli(prev).UTC()
+	start = pt.Minute() == 0 && pt.Second() == 0 && slices.Contains(s.resetHours, pt.Hour())
+	return start, start || t > 0
+}
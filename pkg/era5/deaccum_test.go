@@ -0,0 +1,60 @@
+package era5
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+	"github.com/batchatco/go-native-netcdf/netcdf/cdf"
+)
+
+func TestDeaccumulate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tp.nc")
+	w, err := cdf.OpenWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := func(kv ...any) api.AttributeMap { return attrMap(t, kv...) }
+	vars := []struct {
+		name string
+		v    api.Variable
+	}{
+		{"longitude", api.Variable{Values: []float32{0}, Dimensions: []string{"longitude"}, Attributes: attrs()}},
+		{"latitude", api.Variable{Values: []float32{0}, Dimensions: []string{"latitude"}, Attributes: attrs()}},
+		// 00, 01 and 02 UTC.
+		{"time", api.Variable{Values: []int32{1000008, 1000009, 1000010}, Dimensions: []string{"time"}, Attributes: attrs("units", "hours since 1900-01-01 00:00:00.0")}},
+		{"tp", api.Variable{
+			Values:     [][][]int16{{{100}}, {{200}}, {{500}}},
+			Dimensions: []string{"time", "latitude", "longitude"},
+			Attributes: attrs("scale_factor", 0.001, "add_offset", 5.0),
+		}},
+	}
+	for _, v := range vars {
+		if err := w.AddVar(v.name, v.v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewScanner(path, Options{Variables: []string{"tp"}, Deaccumulate: []string{"tp"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	scale, offset := s.Packing()
+	var got []float64
+	for s.Scan() {
+		for _, r := range s.Records() {
+			got = append(got, r.Values[0]*scale[0]+offset[0])
+		}
+	}
+	if err := s.Error(); err != nil {
+		t.Fatal(err)
+	}
+	// The first time step ends the previous cycle, which started before the
+	// file, and the second one starts a cycle.
+	want := []float64{math.NaN(), 5.2, 0.3}
+	checkPhysical(t, 0, got, want, 1e-9)
+}
@@ -0,0 +1,39 @@
+// Package era5 reads the ERA5 reanalysis data from NetCDF and GRIB files and
+// Zarr stores one timestamp at a time. It copes with the files of both the
+// classic and the new CDS, in the classic NetCDF, the NetCDF-4 or the GRIB
+// format, and with the Zarr v2 stores such as ARCO-ERA5, local or in object
+// storage, and hands out the values as stored, whatever their type, along with
+// the packing that turns the packed ones into physical values. The float
+// values, such as the ones of the GRIB files and the Zarr stores, are physical
+// values already.
+//
+// A file is read with a Scanner:
+//
+//	s, err := era5.NewScanner("era5.nc", era5.Options{Variables: []string{"t2m"}})
+//	if err != nil {
+//		return err
+//	}
+//	defer s.Close()
+//	scale, offset := s.Packing()
+//	for s.Scan() {
+//		for _, r := range s.Records() {
+//			t2m := r.Values[0]*scale[0] + offset[0]
+//			...
+//		}
+//	}
+//	if err := s.Error(); err != nil {
+//		return err
+//	}
+//
+// or with the iterators of the scanner:
+//
+//	for r, err := range s.AllRecords() {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The exported identifiers of the package are its stable API. New fields are
+// only added to Options with a zero value that keeps the former behavior.
+package era5
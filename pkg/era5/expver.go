@@ -0,0 +1,122 @@
+package era5
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+)
+
+// expverDim is the name of the dimension of the experiment version that the
+// files of the classic CDS have if they mix the final ERA5 data (0001) with
+// the preliminary ERA5T data (0005). It follows the time axis in the
+// dimensions of the variables and only one of the versions holds the data at
+// any timestamp.
+const expverDim = "expver"
+
+// expverAxis returns the experiment versions of the variable in the group at
+// path p formatted the way CDS names them, e.g. 0001. It returns nil if the
+// variable has no expver dimension.
+func expverAxis(root api.Group, p, varName string) ([]string, error) {
+	g, err := getGroup(root, p)
+	if err != nil {
+		return nil, err
+	}
+	vg, err := g.GetVarGetter(varName)
+	closeGroup(g, root)
+	if err != nil {
+		return nil, withHint(err)
+	}
+	if dims := vg.Dimensions(); len(dims) < 4 || dims[1] != expverDim {
+		return nil, nil
+	}
+	v, _, err := varValues(root, p, expverDim)
+	if err != nil {
+		return nil, err
+	}
+	if strs, ok := v.([]string); ok {
+		return strs, nil
+	}
+	nums, _, err := numberValues[int32](v, expverDim)
+	if err != nil {
+		return nil, err
+	}
+	if len(nums) == 0 {
+		return nil, fmt.Errorf("coordinate %s is empty", expverDim)
+	}
+	expvers := make([]string, len(nums))
+	for i, n := range nums {
+		expvers[i] = fmt.Sprintf("%04d", n)
+	}
+	return expvers, nil
+}
+
+// resolveExpvers returns the index of the experiment version holding the
+// data at every index of the time axis. The versions are expected to follow
+// each other in time, the final data first, so the boundaries between them
+// are found with a binary search over the first variable the file holds
+// instead of reading the whole axis. Scan checks that the data is where it is
+// expected to be.
+func (s *Scanner) resolveExpvers() ([]int, error) {
+	vg := s.vars[0][s.ref]
+	s.expverFill, s.expverHasFill = s.storedFillValues()[s.varNames[s.ref]]
+	probes := make(map[int]int)
+	var err error
+	holding := func(t int) int {
+		if e, ok := probes[t]; ok || err != nil {
+			return e
+		}
+		var v any
+		v, err = vg.GetSlice(int64(t), int64(t)+1)
+		if err != nil {
+			return 0
+		}
+		var steps [][][][]float64
+		steps, err = timeSteps(v)
+		if err != nil {
+			return 0
+		}
+		probes[t] = s.dataExpver(steps[0])
+		return probes[t]
+	}
+	expverOf := make([]int, len(s.times))
+	begin := 0
+	for e := range len(s.expvers) - 1 {
+		end := begin + sort.Search(len(s.times)-begin, func(i int) bool {
+			return holding(begin+i) > e
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", s.fileNames[s.ref], err)
+		}
+		for t := begin; t < end; t++ {
+			expverOf[t] = e
+		}
+		begin = end
+	}
+	for t := begin; t < len(s.times); t++ {
+		expverOf[t] = len(s.expvers) - 1
+	}
+	return expverOf, nil
+}
+
+// dataExpver returns the index of the first experiment version whose planes
+// of the first variable hold any values other than its fill value at a time
+// step, or the number of versions if none does.
+func (s *Scanner) dataExpver(planes [][][]float64) int {
+	if !s.expverHasFill {
+		return 0
+	}
+	perExpver := len(planes) / len(s.expvers)
+	for e := range s.expvers {
+		for _, plane := range planes[e*perExpver : (e+1)*perExpver] {
+			for _, row := range plane {
+				if slices.ContainsFunc(row, func(x float64) bool { return x != s.expverFill && !math.IsNaN(x) }) {
+					return e
+				}
+			}
+		}
+	}
+	return len(s.expvers)
+}
@@ -0,0 +1,99 @@
+package era5
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+	"github.com/batchatco/go-native-netcdf/netcdf/cdf"
+	"github.com/batchatco/go-native-netcdf/netcdf/util"
+)
+
+// attrMap returns the attributes of the keys and values in kv.
+func attrMap(t *testing.T, kv ...any) api.AttributeMap {
+	t.Helper()
+	var keys []string
+	values := make(map[string]any)
+	for i := 0; i < len(kv); i += 2 {
+		keys = append(keys, kv[i].(string))
+		values[kv[i].(string)] = kv[i+1]
+	}
+	m, err := util.NewOrderedMap(keys, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// writeExpverFile writes a file of the classic CDS whose variable b holds
+// the data of the first time step in the first experiment version and the
+// data of the second one in the second version.
+func writeExpverFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "expver.nc")
+	w, err := cdf.OpenWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := func(kv ...any) api.AttributeMap { return attrMap(t, kv...) }
+	const fill = int16(-32767)
+	vars := []struct {
+		name string
+		v    api.Variable
+	}{
+		{"longitude", api.Variable{Values: []float32{0, 0.25}, Dimensions: []string{"longitude"}, Attributes: attrs()}},
+		{"latitude", api.Variable{Values: []float32{0.25, 0}, Dimensions: []string{"latitude"}, Attributes: attrs()}},
+		{"expver", api.Variable{Values: []int32{1, 5}, Dimensions: []string{"expver"}, Attributes: attrs()}},
+		{"time", api.Variable{Values: []int32{1000000, 1000001}, Dimensions: []string{"time"}, Attributes: attrs("units", "hours since 1900-01-01 00:00:00.0")}},
+		{"b", api.Variable{
+			Values: [][][][]int16{
+				{{{1, 2}, {3, 4}}, {{fill, fill}, {fill, fill}}},
+				{{{fill, fill}, {fill, fill}}, {{5, 6}, {7, 8}}},
+			},
+			Dimensions: []string{"time", "expver", "latitude", "longitude"},
+			Attributes: attrs("_FillValue", fill),
+		}},
+	}
+	for _, v := range vars {
+		if err := w.AddVar(v.name, v.v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExpverWithAbsentFirstVariable(t *testing.T) {
+	s, err := NewScanner(writeExpverFile(t), Options{
+		Variables:           []string{"a", "b"},
+		SkipAbsentVariables: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var got [][]float64
+	for s.Scan() {
+		var values []float64
+		for _, r := range s.Records() {
+			values = append(values, r.Values[1])
+		}
+		got = append(got, values)
+	}
+	if err := s.Error(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]float64{{1, 2, 3, 4}, {5, 6, 7, 8}}
+	if len(got) != len(want) {
+		t.Fatalf("scanned %d timestamps, want %d", len(got), len(want))
+	}
+	for i := range want {
+		for j := range want[i] {
+			if j >= len(got[i]) || got[i][j] != want[i][j] {
+				t.Fatalf("timestamp %d: got the values %v, want %v", i, got[i], want[i])
+			}
+		}
+	}
+}
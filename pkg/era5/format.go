@@ -0,0 +1,141 @@
+package era5
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/hdf5"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The formats of the files told by their magic bytes.
+const (
+	formatNetCDF = "NetCDF"
+	formatGRIB   = "GRIB"
+)
+
+// fileFormat returns the format of a file by its magic bytes. The formats
+// the scanner cannot read get an error with a hint.
+func fileFormat(r io.ReaderAt) (string, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	switch {
+	case bytes.Equal(magic, []byte("GRIB")):
+		return formatGRIB, nil
+	case compression(magic) != "":
+		return "", fmt.Errorf("the file is compressed with %s; decompress it first", compression(magic))
+	case bytes.HasPrefix(magic, []byte("CDF")), bytes.Equal(magic, []byte("\x89HDF")):
+		return formatNetCDF, nil
+	default:
+		return "", fmt.Errorf("the file is neither in the NetCDF nor in the GRIB format, it starts with %q", magic)
+	}
+}
+
+// The magic bytes of the compressed files.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compression returns the compression of a file starting with magic or an
+// empty string if it is not compressed.
+func compression(magic []byte) string {
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(magic, zstdMagic):
+		return "zstd"
+	default:
+		return ""
+	}
+}
+
+// Compression returns the compression of a local file, gzip or zstd, or an
+// empty string if the file is not compressed or is a Zarr store.
+func Compression(filePath string) (string, error) {
+	if IsZarrStore(filePath) {
+		return "", nil
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return compression(magic), nil
+}
+
+// Decompress writes the decompressed contents of a file compressed with
+// gzip or zstd to w.
+func Decompress(filePath string, w io.Writer) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReaderSize(f, 1<<20)
+	magic, err := br.Peek(4)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	var r io.Reader
+	switch compression(magic) {
+	case "gzip":
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	case "zstd":
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return fmt.Errorf("%s is not compressed", filePath)
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// withHint adds a hint to the errors of the HDF5 reader of NetCDF-4 files
+// the user can fix by converting the file.
+func withHint(err error) error {
+	if errors.Is(err, hdf5.ErrUnsupportedFilter) || errors.Is(err, hdf5.ErrUnknownCompression) {
+		return fmt.Errorf("%w; the NetCDF-4 file is compressed with a filter other than deflate, "+
+			"recompress it with deflate first, e.g. with nccopy -d 1 in.nc out.nc", err)
+	}
+	return err
+}
+
+// IsZarrStore tells whether the path is a Zarr store: a local directory
+// holding the Zarr metadata or a URL of a remote storage whose last element
+// has the .zarr extension or that ends with a slash, e.g.
+// gs://bucket/era5.zarr, since remote storages are not listed.
+func IsZarrStore(filePath string) bool {
+	if IsRemote(filePath) {
+		return strings.HasSuffix(filePath, "/") || strings.Contains(path.Base(filePath), ".zarr")
+	}
+	for _, name := range []string{zarrMetadataKey, zarrGroupKey, zarrArrayKey, "zarr.json"} {
+		if _, err := os.Stat(filepath.Join(filePath, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+package era5
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcsReadScope is the OAuth2 scope requested for reading objects.
+const gcsReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// openGCSObject opens an object given by a gs://bucket/object URL. The
+// credentials are found the way of the Application Default Credentials: the
+// file given by GOOGLE_APPLICATION_CREDENTIALS, the file written by `gcloud
+// auth application-default login` or the metadata server on Google Cloud.
+// Objects are read anonymously if there are no credentials, which is enough
+// for public buckets. Setting STORAGE_EMULATOR_HOST makes the object read
+// from a storage emulator without credentials.
+func openGCSObject(ctx context.Context, gsURL string) (source, error) {
+	bucket, object, ok := strings.Cut(strings.TrimPrefix(gsURL, "gs://"), "/")
+	if !ok || bucket == "" || object == "" {
+		return nil, fmt.Errorf("invalid GCS URL %q, want gs://bucket/object", gsURL)
+	}
+	path := "/" + bucket + "/" + awsEscapePath(object)
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		return openHTTPObject(ctx, strings.TrimSuffix(host, "/")+path, nil)
+	}
+	googleCredsOnce.Do(func() {
+		googleTokens, googleCredsErr = findGoogleCredentials(ctx)
+	})
+	ts, err := googleTokens, googleCredsErr
+	if err != nil {
+		return nil, fmt.Errorf("could not load Google credentials: %w", err)
+	}
+	var authorize func(req *http.Request) error
+	if ts != nil {
+		authorize = func(req *http.Request) error {
+			token, err := ts.token(req.Context())
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			return nil
+		}
+	}
+	return openHTTPObject(ctx, "https://storage.googleapis.com"+path, authorize)
+}
+
+// The Application Default Credentials are looked up once and shared by all
+// the files.
+var (
+	googleCredsOnce sync.Once
+	googleTokens    *tokenSource
+	googleCredsErr  error
+)
+
+// googleCredentials is the credentials file of a service account or a user.
+type googleCredentials struct {
+	Type string `json:"type"`
+
+	// Service account.
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+
+	// Authorized user.
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// findGoogleCredentials returns the source of the access tokens of the
+// Application Default Credentials or nil if there are none.
+func findGoogleCredentials(ctx context.Context) (*tokenSource, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err == nil {
+			p := filepath.Join(dir, "gcloud", "application_default_credentials.json")
+			if _, err := os.Stat(p); err == nil {
+				path = p
+			}
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var creds googleCredentials
+		if err := json.Unmarshal(data, &creds); err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", path, err)
+		}
+		switch creds.Type {
+		case "service_account":
+			key, err := parseRSAKey(creds.PrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse the private key in %s: %w", path, err)
+			}
+			return &tokenSource{fetch: func(ctx context.Context) (*http.Request, error) {
+				return serviceAccountTokenRequest(ctx, &creds, key)
+			}}, nil
+		case "authorized_user":
+			return &tokenSource{fetch: func(ctx context.Context) (*http.Request, error) {
+				return formRequest(ctx, "https://oauth2.googleapis.com/token", url.Values{
+					"grant_type":    {"refresh_token"},
+					"client_id":     {creds.ClientID},
+					"client_secret": {creds.ClientSecret},
+					"refresh_token": {creds.RefreshToken},
+				})
+			}}, nil
+		default:
+			return nil, fmt.Errorf("unsupported credentials type %q in %s", creds.Type, path)
+		}
+	}
+	ts := &tokenSource{fetch: metadataTokenRequest}
+	// Outside of Google Cloud the metadata server is not there, which means
+	// anonymous access.
+	probeCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if _, err := ts.token(probeCtx); err != nil {
+		return nil, nil
+	}
+	return ts, nil
+}
+
+// metadataTokenRequest requests the access token of the service account
+// attached to the Google Cloud instance.
+func metadataTokenRequest(ctx context.Context) (*http.Request, error) {
+	host := cmpOr(os.Getenv("GCE_METADATA_HOST"), "metadata.google.internal")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token?scopes="+url.QueryEscape(gcsReadScope), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return req, nil
+}
+
+// serviceAccountTokenRequest exchanges a JWT signed with the service account
+// key for an access token.
+func serviceAccountTokenRequest(ctx context.Context, creds *googleCredentials, key *rsa.PrivateKey) (*http.Request, error) {
+	tokenURI := cmpOr(creds.TokenURI, "https://oauth2.googleapis.com/token")
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": creds.PrivateKeyID})
+	if err != nil {
+		return nil, err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iss":   creds.ClientEmail,
+		"scope": gcsReadScope,
+		"aud":   tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return formRequest(ctx, tokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)},
+	})
+}
+
+func formRequest(ctx context.Context, tokenURL string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+func parseRSAKey(s string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// tokenSource caches an OAuth2 access token and fetches a new one shortly
+// before it expires.
+type tokenSource struct {
+	// fetch creates the request of a new token.
+	fetch func(ctx context.Context) (*http.Request, error)
+
+	mu      sync.Mutex
+	value   string
+	expires time.Time
+}
+
+func (ts *tokenSource) token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.value != "" && time.Until(ts.expires) > time.Minute {
+		return ts.value, nil
+	}
+	req, err := ts.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	res, err := remoteClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not get an access token: %w", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("could not get an access token: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not get an access token: unexpected status %d: %s", res.StatusCode, bytes.TrimSpace(body))
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &t); err != nil || t.AccessToken == "" {
+		return "", fmt.Errorf("could not parse the access token response: %s", bytes.TrimSpace(body))
+	}
+	ts.value = t.AccessToken
+	ts.expires = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+	return ts.value, nil
+}
@@ -0,0 +1,180 @@
+package era5
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeGoogleCredentials writes the credentials file and points
+// GOOGLE_APPLICATION_CREDENTIALS at it.
+func writeGoogleCredentials(t *testing.T, creds googleCredentials) {
+	t.Helper()
+	data, err := json.Marshal(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+}
+
+// TestServiceAccountToken checks that the JWT exchanged for an access token
+// is signed with RS256 by the service account key and holds its claims.
+func TestServiceAccountToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if got := r.PostForm.Get("grant_type"); got != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			t.Errorf("got the grant type %s", got)
+		}
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		if len(parts) != 3 {
+			t.Errorf("got the assertion %q, want a JWT", r.PostForm.Get("assertion"))
+			return
+		}
+		sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+			t.Errorf("could not verify the JWT signature: %s", err)
+		}
+		var header map[string]string
+		var claims map[string]any
+		for i, v := range []any{&header, &claims} {
+			data, err := base64.RawURLEncoding.DecodeString(parts[i])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := json.Unmarshal(data, v); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if header["alg"] != "RS256" || header["typ"] != "JWT" || header["kid"] != "key1" {
+			t.Errorf("got the JWT header %v", header)
+		}
+		if claims["iss"] != "era5@example.iam.gserviceaccount.com" || claims["aud"] != "http://"+r.Host+"/token" ||
+			claims["scope"] != gcsReadScope || claims["exp"].(float64)-claims["iat"].(float64) != 3600 {
+			t.Errorf("got the JWT claims %v", claims)
+		}
+		w.Write([]byte(`{"access_token":"token1","expires_in":3600}`))
+	}))
+	defer srv.Close()
+	writeGoogleCredentials(t, googleCredentials{
+		Type:         "service_account",
+		ClientEmail:  "era5@example.iam.gserviceaccount.com",
+		PrivateKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		PrivateKeyID: "key1",
+		TokenURI:     srv.URL + "/token",
+	})
+	ts, err := findGoogleCredentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The token is cached until shortly before it expires.
+	for range 2 {
+		token, err := ts.token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "token1" {
+			t.Fatalf("got the token %q, want token1", token)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("got %d token requests, want 1", requests)
+	}
+}
+
+func TestAuthorizedUserToken(t *testing.T) {
+	writeGoogleCredentials(t, googleCredentials{
+		Type:         "authorized_user",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		RefreshToken: "refresh",
+	})
+	ts, err := findGoogleCredentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := ts.fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	want := "client_id=id&client_secret=secret&grant_type=refresh_token&refresh_token=refresh"
+	if req.URL.String() != "https://oauth2.googleapis.com/token" || req.PostForm.Encode() != want {
+		t.Fatalf("got the request to %s of %s, want %s", req.URL, req.PostForm.Encode(), want)
+	}
+}
+
+func TestTokenError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	ts := &tokenSource{fetch: func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, nil)
+	}}
+	if _, err := ts.token(context.Background()); err == nil || !strings.Contains(err.Error(), "unexpected status 400: {\"error\":\"invalid_grant\"}") {
+		t.Fatalf("got the error %v, want status 400", err)
+	}
+}
+
+func TestParseRSAKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range []*pem.Block{
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		{Type: "PRIVATE KEY", Bytes: pkcs8},
+	} {
+		got, err := parseRSAKey(string(pem.EncodeToMemory(block)))
+		if err != nil {
+			t.Fatalf("could not parse a %s: %s", block.Type, err)
+		}
+		if !got.Equal(key) {
+			t.Fatalf("got another key from a %s", block.Type)
+		}
+	}
+	if _, err := parseRSAKey("not a key"); err == nil {
+		t.Fatal("parsed a key without PEM data")
+	}
+}
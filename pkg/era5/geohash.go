@@ -0,0 +1,64 @@
+package era5
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Geohash returns the 64-bit binary geohash of a location: the bits of the
+// longitude and latitude interval bisections interleaved, longitude first.
+// Locations close to each other tend to have close geohashes.
+func Geohash(la, lo float32) uint64 {
+	laMin, laMax := -90.0, 90.0
+	loMin, loMax := -180.0, 180.0
+	lat, lon := float64(la), float64(lo)
+	if lon >= 180 {
+		lon -= 360
+	}
+	var h uint64
+	for i := range 64 {
+		h <<= 1
+		if i%2 == 0 {
+			mid := (loMin + loMax) / 2
+			if lon >= mid {
+				h |= 1
+				loMin = mid
+			} else {
+				loMax = mid
+			}
+		} else {
+			mid := (laMin + laMax) / 2
+			if lat >= mid {
+				h |= 1
+				laMin = mid
+			} else {
+				laMax = mid
+			}
+		}
+	}
+	return h
+}
+
+// SortByGeohash reorders records by the geohash of their location, keeping
+// the original order of the records at the same location.
+func SortByGeohash(recs []Record) {
+	type key struct {
+		hash uint64
+		idx  int
+	}
+	keys := make([]key, len(recs))
+	for i := range recs {
+		keys[i] = key{hash: Geohash(recs[i].Latitude, recs[i].Longitude), idx: i}
+	}
+	slices.SortFunc(keys, func(a, b key) int {
+		if c := cmp.Compare(a.hash, b.hash); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.idx, b.idx)
+	})
+	sorted := make([]Record, len(recs))
+	for i, k := range keys {
+		sorted[i] = recs[k.idx]
+	}
+	copy(recs, sorted)
+}
@@ -0,0 +1,771 @@
+package era5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+)
+
+// gribHeaderWindow is the size of the reads of the headers of the GRIB
+// messages, which take a single read for most messages.
+const gribHeaderWindow = 16 << 10
+
+// gribGrid is a regular latitude/longitude grid. The points are stored row
+// by row from the first latitude to the last one, each row from the first
+// longitude eastwards.
+type gribGrid struct {
+	ni, nj             int
+	la1, lo1, la2, lo2 float64
+}
+
+// latitudes returns the latitudes of the rows of the grid.
+func (g gribGrid) latitudes() []float32 {
+	return gridAxis(g.la1, g.la2, g.nj)
+}
+
+// longitudes returns the longitudes of the columns of the grid.
+func (g gribGrid) longitudes() []float32 {
+	lo2 := g.lo2
+	if lo2 < g.lo1 {
+		lo2 += 360
+	}
+	return gridAxis(g.lo1, lo2, g.ni)
+}
+
+// gridAxis returns n coordinates evenly spaced from first to last, which are
+// computed from the bounds rather than by adding up the increment, so they
+// are as exact as the bounds.
+func gridAxis(first, last float64, n int) []float32 {
+	axis := make([]float32, n)
+	for i := range axis {
+		x := first
+		if n > 1 {
+			x += (last - first) * float64(i) / float64(n-1)
+		}
+		axis[i] = float32(x)
+	}
+	return axis
+}
+
+// gribField is a field of a GRIB file, the values of a parameter on a level
+// at a time, packed with the simple packing Y = (R + X*2^E) / 10^D.
+type gribField struct {
+	param gribParam
+	// levelType is the type of the level, e.g. 100 for isobaric, and level
+	// its value, in hPa for the isobaric levels.
+	levelType int
+	level     float64
+	// valid is the time the values are valid at in milliseconds since the
+	// epoch, which is the end of the period of the accumulated parameters.
+	valid int64
+	grid  gribGrid
+	// ref, binScale and decScale are R, E and D of the packing and bits is
+	// the number of bits of every packed value X.
+	ref      float64
+	binScale int
+	decScale int
+	bits     int
+	// bitmapOff is the offset of the bitmap telling the points that have a
+	// value, or -1 if all of them have one.
+	bitmapOff int64
+	dataOff   int64
+	dataLen   int64
+}
+
+// bounds returns the range of the values the packing of the field can
+// represent.
+func (f *gribField) bounds() (float64, float64) {
+	base := f.ref * math.Pow10(-f.decScale)
+	span := float64(uint64(1)<<f.bits-1) * math.Ldexp(1, f.binScale) * math.Pow10(-f.decScale)
+	return min(base, base+span), max(base, base+span)
+}
+
+// decode reads the values of the field as grid rows. The points without a
+// value are NaN.
+func (f *gribField) decode(r io.ReaderAt) ([][]float32, error) {
+	n := f.grid.ni * f.grid.nj
+	var bitmap []byte
+	packed := n
+	if f.bitmapOff >= 0 {
+		bitmap = make([]byte, (n+7)/8)
+		if _, err := r.ReadAt(bitmap, f.bitmapOff); err != nil {
+			return nil, fmt.Errorf("could not read the bitmap at %d: %w", f.bitmapOff, err)
+		}
+		packed = 0
+		for i := range n {
+			if bitmap[i/8]&(0x80>>(i%8)) != 0 {
+				packed++
+			}
+		}
+	}
+	if need := (int64(packed)*int64(f.bits) + 7) / 8; need > f.dataLen {
+		return nil, fmt.Errorf("the data at %d holds %d bytes instead of %d", f.dataOff, f.dataLen, need)
+	}
+	data := make([]byte, (int64(packed)*int64(f.bits)+7)/8)
+	if _, err := r.ReadAt(data, f.dataOff); err != nil {
+		return nil, fmt.Errorf("could not read the data at %d: %w", f.dataOff, err)
+	}
+	base := f.ref * math.Pow10(-f.decScale)
+	mult := math.Ldexp(1, f.binScale) * math.Pow10(-f.decScale)
+	values := make([]float32, n)
+	br := bitReader{data: data}
+	for i := range values {
+		if bitmap != nil && bitmap[i/8]&(0x80>>(i%8)) == 0 {
+			values[i] = float32(math.NaN())
+			continue
+		}
+		values[i] = float32(base + float64(br.next(f.bits))*mult)
+	}
+	rows := make([][]float32, f.grid.nj)
+	for j := range rows {
+		rows[j] = values[j*f.grid.ni : (j+1)*f.grid.ni : (j+1)*f.grid.ni]
+	}
+	return rows, nil
+}
+
+// bitReader reads the packed values of a field, which are big-endian and
+// follow each other without padding.
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+// next returns the next value of n bits. The caller checks that the data
+// holds it.
+func (b *bitReader) next(n int) uint64 {
+	if n == 16 && b.pos%8 == 0 {
+		// The usual packing of ERA5.
+		x := binary.BigEndian.Uint16(b.data[b.pos/8:])
+		b.pos += 16
+		return uint64(x)
+	}
+	var x uint64
+	for n > 0 {
+		avail := 8 - b.pos%8
+		take := min(avail, n)
+		bits := uint64(b.data[b.pos/8]>>(avail-take)) & (1<<take - 1)
+		x = x<<take | bits
+		b.pos += take
+		n -= take
+	}
+	return x
+}
+
+// headerReader reads the headers of the GRIB messages through a window, so
+// the small sections of a message take a single read.
+type headerReader struct {
+	src source
+	buf []byte
+	off int64
+}
+
+// read returns n bytes at the offset off.
+func (r *headerReader) read(off int64, n int) ([]byte, error) {
+	if off >= r.off && off+int64(n) <= r.off+int64(len(r.buf)) {
+		return r.buf[off-r.off : off-r.off+int64(n)], nil
+	}
+	size := min(int64(max(n, gribHeaderWindow)), r.src.Size()-off)
+	if size < int64(n) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, size)
+	if _, err := r.src.ReadAt(buf, off); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	r.buf, r.off = buf, off
+	return buf[:n], nil
+}
+
+// indexGRIB reads the headers of all the messages of a GRIB file and returns
+// their fields in the order they are stored.
+func indexGRIB(src source) ([]*gribField, error) {
+	r := &headerReader{src: src}
+	var fields []*gribField
+	for off := int64(0); off < src.Size(); {
+		hdr, err := r.read(off, 8)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the GRIB message at %d: %w", off, err)
+		}
+		if string(hdr[:4]) != "GRIB" {
+			return nil, fmt.Errorf("no GRIB message at %d", off)
+		}
+		var length int64
+		switch edition := hdr[7]; edition {
+		case 1:
+			length, fields, err = indexGRIB1(r, off, fields)
+		case 2:
+			length, fields, err = indexGRIB2(r, off, fields)
+		default:
+			err = fmt.Errorf("unsupported GRIB edition %d", edition)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("GRIB message at %d: %w", off, err)
+		}
+		off += length
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("the file holds no GRIB messages")
+	}
+	return fields, nil
+}
+
+// indexGRIB1 appends the field of the GRIB1 message at off to fields and
+// returns the length of the message.
+func indexGRIB1(r *headerReader, off int64, fields []*gribField) (int64, []*gribField, error) {
+	hdr, err := r.read(off, 8)
+	if err != nil {
+		return 0, nil, err
+	}
+	length := int64(uint24(hdr[4:]))
+	if length&0x800000 != 0 {
+		return 0, nil, errors.New("GRIB1 messages larger than 8 MiB are not supported")
+	}
+	pos := off + 8
+	pds, err := readSection1(r, pos, 28)
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not read the product definition: %w", err)
+	}
+	pos += int64(len(pds))
+	f := &gribField{bitmapOff: -1}
+	table, param := int(pds[3]), int(pds[8])
+	f.param = ecmwfParam(table*1000 + param)
+	if table == 128 {
+		f.param = ecmwfParam(param)
+	}
+	f.levelType = int(pds[9])
+	f.level = float64(binary.BigEndian.Uint16(pds[10:]))
+	ref := time.Date((int(pds[24])-1)*100+int(pds[12]), time.Month(pds[13]), int(pds[14]), int(pds[15]), int(pds[16]), 0, 0, time.UTC)
+	unit, err := grib1TimeUnit(int(pds[17]))
+	if err != nil {
+		return 0, nil, err
+	}
+	var step int
+	switch tri := pds[20]; tri {
+	case 0, 1:
+		step = int(pds[18])
+	case 2, 3, 4, 5:
+		step = int(pds[19])
+	case 10:
+		step = int(binary.BigEndian.Uint16(pds[18:]))
+	default:
+		return 0, nil, fmt.Errorf("unsupported time range indicator %d", tri)
+	}
+	f.valid = ref.Add(time.Duration(step) * unit).UnixMilli()
+	f.decScale = signMagnitude(uint64(binary.BigEndian.Uint16(pds[26:])), 16)
+
+	flags := pds[7]
+	if flags&0x80 == 0 {
+		return 0, nil, fmt.Errorf("the grid %d is predefined, only the grids defined in the message are supported", pds[6])
+	}
+	gds, err := readSection1(r, pos, 28)
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not read the grid definition: %w", err)
+	}
+	pos += int64(len(gds))
+	if gds[5] != 0 {
+		return 0, nil, fmt.Errorf("the data representation type %d is not supported, only regular latitude/longitude grids are; %s", gds[5], gridHint)
+	}
+	if gds[3] == 0 && gds[4] != 255 || binary.BigEndian.Uint16(gds[6:]) == 0xffff {
+		return 0, nil, fmt.Errorf("reduced grids are not supported; %s", gridHint)
+	}
+	if scan := gds[27]; scan&0xa0 != 0 {
+		return 0, nil, fmt.Errorf("the scanning mode %#x is not supported", scan)
+	}
+	f.grid = gribGrid{
+		ni:  int(binary.BigEndian.Uint16(gds[6:])),
+		nj:  int(binary.BigEndian.Uint16(gds[8:])),
+		la1: float64(signMagnitude(uint64(uint24(gds[10:])), 24)) / 1e3,
+		lo1: float64(signMagnitude(uint64(uint24(gds[13:])), 24)) / 1e3,
+		la2: float64(signMagnitude(uint64(uint24(gds[17:])), 24)) / 1e3,
+		lo2: float64(signMagnitude(uint64(uint24(gds[20:])), 24)) / 1e3,
+	}
+	if flags&0x40 != 0 {
+		bms, err := r.read(pos, 6)
+		if err != nil {
+			return 0, nil, fmt.Errorf("could not read the bitmap: %w", err)
+		}
+		if binary.BigEndian.Uint16(bms[4:]) != 0 {
+			return 0, nil, errors.New("predefined bitmaps are not supported")
+		}
+		f.bitmapOff = pos + 6
+		pos += int64(uint24(bms))
+	}
+	bds, err := r.read(pos, 11)
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not read the binary data: %w", err)
+	}
+	if bds[3]&0xc0 != 0 {
+		return 0, nil, fmt.Errorf("the packing %#x is not supported, only the simple packing is; %s", bds[3]>>4, packingHint)
+	}
+	f.binScale = signMagnitude(uint64(binary.BigEndian.Uint16(bds[4:])), 16)
+	f.ref = ibmFloat(bds[6:10])
+	f.bits = int(bds[10])
+	f.dataOff = pos + 11
+	f.dataLen = int64(uint24(bds)) - 11
+	return length, append(fields, f), nil
+}
+
+// readSection1 reads a GRIB1 section starting with its 3-byte length, which
+// is at least minLen.
+func readSection1(r *headerReader, pos int64, minLen int) ([]byte, error) {
+	hdr, err := r.read(pos, 3)
+	if err != nil {
+		return nil, err
+	}
+	n := int(uint24(hdr))
+	if n < minLen {
+		return nil, fmt.Errorf("the section holds %d bytes, want at least %d", n, minLen)
+	}
+	return r.read(pos, n)
+}
+
+// indexGRIB2 appends the fields of the GRIB2 message at off to fields and
+// returns the length of the message. A message may repeat the sections from
+// the grid or the product definition on to hold multiple fields.
+func indexGRIB2(r *headerReader, off int64, fields []*gribField) (int64, []*gribField, error) {
+	hdr, err := r.read(off, 16)
+	if err != nil {
+		return 0, nil, err
+	}
+	discipline := int(hdr[6])
+	length := int64(binary.BigEndian.Uint64(hdr[8:]))
+	end := off + length
+	var ref time.Time
+	var grid gribGrid
+	var gridErr error
+	var f *gribField
+	var bitmapOff int64 = -1
+	for pos := off + 16; pos < end; {
+		h, err := r.read(pos, 4)
+		if err != nil {
+			return 0, nil, err
+		}
+		if string(h) == "7777" {
+			return length, fields, nil
+		}
+		h, err = r.read(pos, 5)
+		if err != nil {
+			return 0, nil, err
+		}
+		n, num := int64(binary.BigEndian.Uint32(h)), h[4]
+		if n < 5 || pos+n > end {
+			return 0, nil, fmt.Errorf("section %d at %d has an invalid length %d", num, pos, n)
+		}
+		var sec []byte
+		if num != 6 && num != 7 {
+			if sec, err = r.read(pos, int(n)); err != nil {
+				return 0, nil, err
+			}
+		}
+		switch num {
+		case 1:
+			if n < 19 {
+				return 0, nil, errors.New("the identification section is too short")
+			}
+			ref = time.Date(int(binary.BigEndian.Uint16(sec[12:])), time.Month(sec[14]), int(sec[15]), int(sec[16]), int(sec[17]), int(sec[18]), 0, time.UTC)
+		case 3:
+			grid, gridErr = parseGrid2(sec)
+		case 4:
+			if gridErr != nil {
+				return 0, nil, gridErr
+			}
+			f, err = parseProduct2(sec, discipline, ref)
+			if err != nil {
+				return 0, nil, err
+			}
+			f.grid = grid
+		case 5:
+			if f == nil {
+				return 0, nil, errors.New("the data representation precedes the product definition")
+			}
+			if err := parsePacking2(sec, f); err != nil {
+				return 0, nil, err
+			}
+		case 6:
+			sec, err = r.read(pos, 6)
+			if err != nil {
+				return 0, nil, err
+			}
+			switch ind := sec[5]; ind {
+			case 0:
+				bitmapOff = pos + 6
+			case 254:
+				// The bitmap of the previous field applies.
+			case 255:
+				bitmapOff = -1
+			default:
+				return 0, nil, fmt.Errorf("predefined bitmap %d is not supported", ind)
+			}
+		case 7:
+			if f == nil {
+				return 0, nil, errors.New("the data precedes the product definition")
+			}
+			f.bitmapOff = bitmapOff
+			f.dataOff = pos + 5
+			f.dataLen = n - 5
+			fields = append(fields, f)
+			f = nil
+		}
+		pos += n
+	}
+	return 0, nil, errors.New("the message has no end section")
+}
+
+// parseGrid2 parses the grid definition section of GRIB2.
+func parseGrid2(sec []byte) (gribGrid, error) {
+	if len(sec) < 14 {
+		return gribGrid{}, errors.New("the grid definition section is too short")
+	}
+	if sec[10] != 0 {
+		return gribGrid{}, fmt.Errorf("reduced grids are not supported; %s", gridHint)
+	}
+	if tmpl := binary.BigEndian.Uint16(sec[12:]); tmpl != 0 {
+		return gribGrid{}, fmt.Errorf("the grid definition template 3.%d is not supported, only regular latitude/longitude grids (3.0) are; %s", tmpl, gridHint)
+	}
+	if len(sec) < 72 {
+		return gribGrid{}, errors.New("the grid definition section is too short")
+	}
+	if scan := sec[71]; scan&0xb0 != 0 {
+		return gribGrid{}, fmt.Errorf("the scanning mode %#x is not supported", scan)
+	}
+	// The coordinates are in millionths of a degree unless the basic angle
+	// is set.
+	unit := 1e-6
+	if basic, sub := binary.BigEndian.Uint32(sec[38:]), binary.BigEndian.Uint32(sec[42:]); basic != 0 && basic != math.MaxUint32 {
+		unit = float64(basic)
+		if sub != 0 && sub != math.MaxUint32 {
+			unit /= float64(sub)
+		}
+	}
+	coord := func(b []byte) float64 {
+		return float64(signMagnitude(uint64(binary.BigEndian.Uint32(b)), 32)) * unit
+	}
+	return gribGrid{
+		ni:  int(binary.BigEndian.Uint32(sec[30:])),
+		nj:  int(binary.BigEndian.Uint32(sec[34:])),
+		la1: coord(sec[46:]),
+		lo1: coord(sec[50:]),
+		la2: coord(sec[55:]),
+		lo2: coord(sec[59:]),
+	}, nil
+}
+
+// parseProduct2 parses the product definition section of GRIB2 into a new
+// field. ref is the reference time of the message.
+func parseProduct2(sec []byte, discipline int, ref time.Time) (*gribField, error) {
+	if len(sec) < 34 {
+		return nil, errors.New("the product definition section is too short")
+	}
+	tmpl := binary.BigEndian.Uint16(sec[7:])
+	if tmpl != 0 && tmpl != 8 {
+		return nil, fmt.Errorf("the product definition template 4.%d is not supported, only 4.0 and 4.8 are", tmpl)
+	}
+	f := &gribField{bitmapOff: -1}
+	f.levelType = int(sec[22])
+	// The level of the surfaces such as the ground has all the bits set.
+	if scale, value := sec[23], binary.BigEndian.Uint32(sec[24:]); scale != 0xff && value != math.MaxUint32 {
+		f.level = float64(signMagnitude(uint64(value), 32)) * math.Pow10(-signMagnitude(uint64(scale), 8))
+	}
+	if f.levelType == gribIsobaric {
+		// The isobaric levels are in Pa, while ERA5 has them in hPa.
+		f.level /= 100
+	}
+	f.param = gribParam2(discipline, int(sec[9]), int(sec[10]), f.levelType, f.level)
+	if tmpl == 8 {
+		if len(sec) < 41 {
+			return nil, errors.New("the product definition section is too short")
+		}
+		end := time.Date(int(binary.BigEndian.Uint16(sec[34:])), time.Month(sec[36]), int(sec[37]), int(sec[38]), int(sec[39]), int(sec[40]), 0, time.UTC)
+		f.valid = end.UnixMilli()
+		return f, nil
+	}
+	unit, err := grib2TimeUnit(int(sec[17]))
+	if err != nil {
+		return nil, err
+	}
+	step := signMagnitude(uint64(binary.BigEndian.Uint32(sec[18:])), 32)
+	f.valid = ref.Add(time.Duration(step) * unit).UnixMilli()
+	return f, nil
+}
+
+// parsePacking2 parses the data representation section of GRIB2 into f.
+func parsePacking2(sec []byte, f *gribField) error {
+	if len(sec) < 11 {
+		return errors.New("the data representation section is too short")
+	}
+	if tmpl := binary.BigEndian.Uint16(sec[9:]); tmpl != 0 {
+		return fmt.Errorf("the data representation template 5.%d is not supported, only the simple packing (5.0) is; %s", tmpl, packingHint)
+	}
+	if len(sec) < 20 {
+		return errors.New("the data representation section is too short")
+	}
+	f.ref = float64(math.Float32frombits(binary.BigEndian.Uint32(sec[11:])))
+	f.binScale = signMagnitude(uint64(binary.BigEndian.Uint16(sec[15:])), 16)
+	f.decScale = signMagnitude(uint64(binary.BigEndian.Uint16(sec[17:])), 16)
+	f.bits = int(sec[19])
+	if f.bits > 32 {
+		return fmt.Errorf("%d bits per value are not supported", f.bits)
+	}
+	return nil
+}
+
+// The hints for the GRIB files the reader does not support.
+const (
+	gridHint    = "request the data on a regular grid, e.g. with grid=0.25/0.25, or convert it with cdo remapbil"
+	packingHint = "repack the file with grib_set -r -s packingType=grid_simple"
+)
+
+// gribIsobaric is the type of the isobaric levels in both editions.
+const gribIsobaric = 100
+
+// grib1TimeUnit returns the unit of code table 4 of GRIB1.
+func grib1TimeUnit(code int) (time.Duration, error) {
+	switch code {
+	case 0:
+		return time.Minute, nil
+	case 1:
+		return time.Hour, nil
+	case 2:
+		return 24 * time.Hour, nil
+	case 10:
+		return 3 * time.Hour, nil
+	case 11:
+		return 6 * time.Hour, nil
+	case 12:
+		return 12 * time.Hour, nil
+	case 13:
+		return 15 * time.Minute, nil
+	case 14:
+		return 30 * time.Minute, nil
+	case 254:
+		return time.Second, nil
+	}
+	return 0, fmt.Errorf("unsupported unit of time %d", code)
+}
+
+// grib2TimeUnit returns the unit of code table 4.4 of GRIB2.
+func grib2TimeUnit(code int) (time.Duration, error) {
+	switch code {
+	case 0:
+		return time.Minute, nil
+	case 1:
+		return time.Hour, nil
+	case 2:
+		return 24 * time.Hour, nil
+	case 10:
+		return 3 * time.Hour, nil
+	case 11:
+		return 6 * time.Hour, nil
+	case 12:
+		return 12 * time.Hour, nil
+	case 13:
+		return time.Second, nil
+	}
+	return 0, fmt.Errorf("unsupported unit of time %d", code)
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+// signMagnitude decodes a signed integer of n bits stored the GRIB way, as
+// the sign bit followed by the magnitude.
+func signMagnitude(v uint64, n int) int {
+	sign := uint64(1) << (n - 1)
+	if v&sign != 0 {
+		return -int(v &^ sign)
+	}
+	return int(v)
+}
+
+// ibmFloat decodes the IBM single precision float the reference values of
+// GRIB1 are stored as: the sign bit, a base 16 exponent biased by 64 and a
+// 24-bit fraction.
+func ibmFloat(b []byte) float64 {
+	fraction := float64(uint24(b[1:]))
+	x := fraction * math.Pow(16, float64(int(b[0]&0x7f)-64-6))
+	if b[0]&0x80 != 0 {
+		return -x
+	}
+	return x
+}
+
+// gribVar collects the fields of a parameter.
+type gribVar struct {
+	param     gribParam
+	levelType int
+	// fields holds the fields by their time and level.
+	fields map[gribKey]*gribField
+	lo, hi float64
+}
+
+type gribKey struct {
+	valid int64
+	level float64
+}
+
+// openGRIB opens a GRIB file as a group laid out like the NetCDF files of
+// the new CDS: a variable per parameter with the valid_time, the
+// pressure_level if the parameter is on isobaric levels, the latitude and
+// the longitude dimensions. The fields missing at some time or level are NaN
+// there. The variables are named like in the NetCDF files, e.g. t2m, and
+// have the units and the long_name attributes of the parameter. Their
+// valid_range is the range the packing of their fields can represent. All
+// the fields must be on the same regular latitude/longitude grid and packed
+// with the simple packing, which is the way the CDS delivers ERA5 on such a
+// grid.
+func openGRIB(src source) (api.Group, error) {
+	fields, err := indexGRIB(src)
+	if err != nil {
+		return nil, err
+	}
+	grid := fields[0].grid
+	var vars []*gribVar
+	var times []int64
+	var levels []float64
+	for _, f := range fields {
+		if f.grid != grid {
+			return nil, fmt.Errorf("the fields of %s are on a grid other than the one of the first field, "+
+				"fields on multiple grids are not supported", f.param.name)
+		}
+		i := slices.IndexFunc(vars, func(v *gribVar) bool { return v.param.name == f.param.name })
+		if i < 0 {
+			vars = append(vars, &gribVar{param: f.param, levelType: f.levelType, fields: make(map[gribKey]*gribField), lo: math.Inf(1), hi: math.Inf(-1)})
+			i = len(vars) - 1
+		}
+		v := vars[i]
+		if f.levelType != v.levelType {
+			return nil, fmt.Errorf("the fields of %s are on levels of types %d and %d, a single type is supported", f.param.name, v.levelType, f.levelType)
+		}
+		key := gribKey{valid: f.valid}
+		if f.levelType == gribIsobaric {
+			key.level = f.level
+			if !slices.Contains(levels, f.level) {
+				levels = append(levels, f.level)
+			}
+		}
+		if v.fields[key] != nil {
+			return nil, fmt.Errorf("%s has multiple fields at %s%s", f.param.name,
+				time.UnixMilli(f.valid).UTC().Format(time.RFC3339), levelSuffix(f))
+		}
+		v.fields[key] = f
+		lo, hi := f.bounds()
+		v.lo, v.hi = min(v.lo, lo), max(v.hi, hi)
+		if !slices.Contains(times, f.valid) {
+			times = append(times, f.valid)
+		}
+	}
+	slices.Sort(times)
+	slices.Sort(levels)
+
+	root := newMemRoot(func() { src.Close() })
+	attrs := newAttrs()
+	attrs.Add("units", "degrees_north")
+	attrs.Add("long_name", "latitude")
+	root.addCoord("latitude", grid.latitudes(), attrs)
+	attrs = newAttrs()
+	attrs.Add("units", "degrees_east")
+	attrs.Add("long_name", "longitude")
+	root.addCoord("longitude", grid.longitudes(), attrs)
+	validTimes := make([]int64, len(times))
+	for i, ts := range times {
+		validTimes[i] = ts / 1000
+	}
+	attrs = newAttrs()
+	attrs.Add("units", "seconds since 1970-01-01")
+	attrs.Add("long_name", "time")
+	root.addCoord("valid_time", validTimes, attrs)
+	if len(levels) > 0 {
+		attrs = newAttrs()
+		attrs.Add("units", "hPa")
+		attrs.Add("long_name", "pressure")
+		root.addCoord("pressure_level", levels, attrs)
+	}
+	for _, v := range vars {
+		root.vars = append(root.vars, v.memVar(src, times, levels, grid))
+	}
+	return root, nil
+}
+
+func levelSuffix(f *gribField) string {
+	if f.levelType != gribIsobaric {
+		return ""
+	}
+	return fmt.Sprintf(" and %g hPa", f.level)
+}
+
+// memVar returns the variable of the parameter on the time axis times and
+// the isobaric levels.
+func (v *gribVar) memVar(src source, times []int64, levels []float64, grid gribGrid) *memVar {
+	attrs := newAttrs()
+	if v.param.units != "" {
+		attrs.Add("units", v.param.units)
+	}
+	if v.param.longName != "" {
+		attrs.Add("long_name", v.param.longName)
+	}
+	if v.param.id != 0 {
+		attrs.Add("GRIB_paramId", int32(v.param.id))
+	}
+	attrs.Add("valid_range", []float64{v.lo, v.hi})
+	dims := []string{"valid_time", "latitude", "longitude"}
+	planeLevels := []float64{0}
+	if v.levelType == gribIsobaric {
+		dims = slices.Insert(dims, 1, "pressure_level")
+		planeLevels = levels
+	}
+	// plane returns the values at the time index t and the level l.
+	plane := func(t int, l float64) ([][]float32, error) {
+		f := v.fields[gribKey{valid: times[t], level: l}]
+		if f == nil {
+			rows := make([][]float32, grid.nj)
+			for j := range rows {
+				rows[j] = make([]float32, grid.ni)
+				for i := range rows[j] {
+					rows[j][i] = float32(math.NaN())
+				}
+			}
+			return rows, nil
+		}
+		return f.decode(src)
+	}
+	read := func(begin, end int64) (any, error) {
+		if v.levelType != gribIsobaric {
+			steps := make([][][]float32, 0, end-begin)
+			for t := begin; t < end; t++ {
+				rows, err := plane(int(t), 0)
+				if err != nil {
+					return nil, err
+				}
+				steps = append(steps, rows)
+			}
+			return steps, nil
+		}
+		steps := make([][][][]float32, 0, end-begin)
+		for t := begin; t < end; t++ {
+			planes := make([][][]float32, len(planeLevels))
+			for l, level := range planeLevels {
+				var err error
+				if planes[l], err = plane(int(t), level); err != nil {
+					return nil, err
+				}
+			}
+			steps = append(steps, planes)
+		}
+		return steps, nil
+	}
+	return &memVar{
+		name:   v.param.name,
+		dims:   dims,
+		attrs:  attrs,
+		goType: "float32",
+		len:    int64(len(times)),
+		read:   read,
+	}
+}
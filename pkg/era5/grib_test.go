@@ -0,0 +1,231 @@
+package era5
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// signMag32 encodes v as a sign-magnitude integer of 32 bits.
+func signMag32(v int) uint32 {
+	if v < 0 {
+		return uint32(-v) | 1<<31
+	}
+	return uint32(v)
+}
+
+// signMag24 encodes v as a sign-magnitude integer of 24 bits.
+func signMag24(v int) []byte {
+	u := uint32(v)
+	if v < 0 {
+		u = uint32(-v) | 1<<23
+	}
+	return []byte{byte(u >> 16), byte(u >> 8), byte(u)}
+}
+
+// gribSection returns a GRIB2 section of the number num with the body,
+// which starts with the fifth octet.
+func gribSection(num byte, body []byte) []byte {
+	sec := binary.BigEndian.AppendUint32(nil, uint32(5+len(body)))
+	return append(append(sec, num), body...)
+}
+
+// gribMessage2 returns a GRIB2 message holding 2 metre temperature packed
+// with the simple packing R=250, E=0 and D=0 in 8 bits, on a 3x2 grid from
+// 10N 0E to 9N 2E, valid step hours after 2024-01-01. The points the
+// bitmap, if any, marks as missing are left out of packed.
+func gribMessage2(step int, packed []byte, bitmap []byte) []byte {
+	sec1 := make([]byte, 16)
+	binary.BigEndian.PutUint16(sec1, 98)
+	binary.BigEndian.PutUint16(sec1[7:], 2024)
+	sec1[9], sec1[10] = 1, 1
+
+	sec3 := make([]byte, 67)
+	binary.BigEndian.PutUint32(sec3[1:], 6)
+	binary.BigEndian.PutUint32(sec3[25:], 3)
+	binary.BigEndian.PutUint32(sec3[29:], 2)
+	binary.BigEndian.PutUint32(sec3[37:], math.MaxUint32)
+	binary.BigEndian.PutUint32(sec3[41:], signMag32(10e6))
+	binary.BigEndian.PutUint32(sec3[45:], 0)
+	binary.BigEndian.PutUint32(sec3[50:], signMag32(9e6))
+	binary.BigEndian.PutUint32(sec3[54:], signMag32(2e6))
+
+	sec4 := make([]byte, 29)
+	sec4[12] = 1
+	binary.BigEndian.PutUint32(sec4[13:], uint32(step))
+	sec4[17], sec4[18] = 103, 0
+	binary.BigEndian.PutUint32(sec4[19:], 2)
+	sec4[23], sec4[24] = 255, 255
+	binary.BigEndian.PutUint32(sec4[25:], math.MaxUint32)
+
+	sec5 := make([]byte, 16)
+	binary.BigEndian.PutUint32(sec5, uint32(len(packed)))
+	binary.BigEndian.PutUint32(sec5[6:], math.Float32bits(250))
+	sec5[14] = 8
+
+	sec6 := []byte{255}
+	if bitmap != nil {
+		sec6 = append([]byte{0}, bitmap...)
+	}
+	var body []byte
+	body = append(body, gribSection(1, sec1)...)
+	body = append(body, gribSection(3, sec3)...)
+	body = append(body, gribSection(4, sec4)...)
+	body = append(body, gribSection(5, sec5)...)
+	body = append(body, gribSection(6, sec6)...)
+	body = append(body, gribSection(7, packed)...)
+	body = append(body, "7777"...)
+	msg := []byte{'G', 'R', 'I', 'B', 0, 0, 0, 2}
+	msg = binary.BigEndian.AppendUint64(msg, uint64(16+len(body)))
+	return append(msg, body...)
+}
+
+// ibmBytes encodes the positive x as an IBM single precision float.
+func ibmBytes(x float64) []byte {
+	exp := 64
+	for x >= 1 {
+		x /= 16
+		exp++
+	}
+	for x < 1.0/16 {
+		x *= 16
+		exp--
+	}
+	f := uint32(x * (1 << 24))
+	return []byte{byte(exp), byte(f >> 16), byte(f >> 8), byte(f)}
+}
+
+// gribMessage1 returns a GRIB1 message holding the temperature on the
+// isobaric level packed with the simple packing R=2000, E=0 and D=1 in 16
+// bits, on the same grid as gribMessage2, valid at 2024-01-01.
+func gribMessage1(level int, packed []uint16) []byte {
+	pds := make([]byte, 28)
+	copy(pds, []byte{0, 0, 28, 128, 98, 128, 255, 0x80, 130, gribIsobaric})
+	binary.BigEndian.PutUint16(pds[10:], uint16(level))
+	copy(pds[12:], []byte{24, 1, 1, 0, 0, 1})
+	pds[24] = 21
+	binary.BigEndian.PutUint16(pds[26:], 1)
+
+	gds := make([]byte, 32)
+	gds[2], gds[4] = 32, 255
+	binary.BigEndian.PutUint16(gds[6:], 3)
+	binary.BigEndian.PutUint16(gds[8:], 2)
+	copy(gds[10:], signMag24(10000))
+	copy(gds[13:], signMag24(0))
+	copy(gds[17:], signMag24(9000))
+	copy(gds[20:], signMag24(2000))
+
+	bds := make([]byte, 11, 11+2*len(packed)+1)
+	copy(bds[6:], ibmBytes(2000))
+	bds[10] = 16
+	for _, x := range packed {
+		bds = binary.BigEndian.AppendUint16(bds, x)
+	}
+	if len(bds)%2 != 0 {
+		bds = append(bds, 0)
+	}
+	copy(bds, signMag24(len(bds)))
+
+	msg := []byte{'G', 'R', 'I', 'B', 0, 0, 0, 1}
+	msg = append(msg, pds...)
+	msg = append(msg, gds...)
+	msg = append(msg, bds...)
+	msg = append(msg, "7777"...)
+	copy(msg[4:], signMag24(len(msg)))
+	return msg
+}
+
+func writeGRIBFile(t *testing.T, msgs ...[]byte) string {
+	t.Helper()
+	var data []byte
+	for _, msg := range msgs {
+		data = append(data, msg...)
+	}
+	path := filepath.Join(t.TempDir(), "era5.grib")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// scanPhysical scans the variable of the file and returns its physical
+// values by timestamp, in the order of the records. The missing values are
+// NaN.
+func scanPhysical(t *testing.T, path, name string) (map[int64][]float64, *Scanner) {
+	t.Helper()
+	s, err := NewScanner(path, Options{Variables: []string{name}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	scale, offset := s.Packing()
+	fill := s.FillValues()[name]
+	got := make(map[int64][]float64)
+	for s.Scan() {
+		for _, r := range s.Records() {
+			x := math.NaN()
+			if v := r.Values[0]; v != fill {
+				x = v*scale[0] + offset[0]
+			}
+			got[r.Timestamp] = append(got[r.Timestamp], x)
+		}
+	}
+	if err := s.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return got, s
+}
+
+func checkPhysical(t *testing.T, ts int64, got, want []float64, tolerance float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d: got %d values, want %d", ts, len(got), len(want))
+	}
+	for i := range want {
+		if math.IsNaN(want[i]) != math.IsNaN(got[i]) || math.Abs(got[i]-want[i]) > tolerance {
+			t.Fatalf("%d: got the values %v, want %v", ts, got, want)
+		}
+	}
+}
+
+func TestGRIB2(t *testing.T) {
+	path := writeGRIBFile(t,
+		gribMessage2(0, []byte{0, 1, 2, 3, 4, 5}, nil),
+		// The second point is missing.
+		gribMessage2(1, []byte{10, 12, 13, 14, 255}, []byte{0b10111100}),
+	)
+	got, s := scanPhysical(t, path, "t2m")
+	if lats := s.Latitudes(); len(lats) != 2 || lats[0] != 10 || lats[1] != 9 {
+		t.Fatalf("got the latitudes %v, want [10 9]", lats)
+	}
+	if lons := s.Longitudes(); len(lons) != 3 || lons[0] != 0 || lons[2] != 2 {
+		t.Fatalf("got the longitudes %v, want [0 1 2]", lons)
+	}
+	t0 := int64(1704067200000)
+	want := map[int64][]float64{
+		t0:           {250, 251, 252, 253, 254, 255},
+		t0 + 3600000: {260, math.NaN(), 262, 263, 264, 505},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d timestamps, want %d", len(got), len(want))
+	}
+	for ts, w := range want {
+		checkPhysical(t, ts, got[ts], w, 0)
+	}
+}
+
+func TestGRIB1(t *testing.T) {
+	path := writeGRIBFile(t,
+		gribMessage1(850, []uint16{0, 1, 2, 3, 4, 5}),
+		gribMessage1(500, []uint16{100, 200, 300, 400, 500, 600}),
+	)
+	got, s := scanPhysical(t, path, "t")
+	if levels := s.Levels(); len(levels) != 2 || levels[0] != 500 || levels[1] != 850 {
+		t.Fatalf("got the levels %v, want [500 850]", levels)
+	}
+	want := []float64{210, 220, 230, 240, 250, 260, 200, 200.1, 200.2, 200.3, 200.4, 200.5}
+	// The values are decoded into float32.
+	checkPhysical(t, 1704067200000, got[1704067200000], want, 1e-4)
+}
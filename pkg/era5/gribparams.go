@@ -0,0 +1,134 @@
+package era5
+
+import "fmt"
+
+// gribParam describes a parameter of a GRIB file.
+type gribParam struct {
+	// name is the name of the variable of the parameter, which is the one
+	// the NetCDF files of the CDS give it, e.g. t2m for 2t.
+	name     string
+	units    string
+	longName string
+	// id is the ECMWF parameter ID, zero for the parameters without one.
+	id int
+}
+
+// ecmwfParams holds the common ERA5 parameters by their ECMWF parameter
+// IDs, which are the numbers of table 128 and 1000 times the number of
+// another table plus the parameter number, e.g. 228246 for 100u.
+var ecmwfParams = map[int]gribParam{
+	31:     {name: "siconc", units: "(0 - 1)", longName: "Sea ice area fraction"},
+	34:     {name: "sst", units: "K", longName: "Sea surface temperature"},
+	39:     {name: "swvl1", units: "m**3 m**-3", longName: "Volumetric soil water layer 1"},
+	49:     {name: "fg10", units: "m s**-1", longName: "10 metre wind gust since previous post-processing"},
+	60:     {name: "pv", units: "K m**2 kg**-1 s**-1", longName: "Potential vorticity"},
+	129:    {name: "z", units: "m**2 s**-2", longName: "Geopotential"},
+	130:    {name: "t", units: "K", longName: "Temperature"},
+	131:    {name: "u", units: "m s**-1", longName: "U component of wind"},
+	132:    {name: "v", units: "m s**-1", longName: "V component of wind"},
+	133:    {name: "q", units: "kg kg**-1", longName: "Specific humidity"},
+	134:    {name: "sp", units: "Pa", longName: "Surface pressure"},
+	135:    {name: "w", units: "Pa s**-1", longName: "Vertical velocity"},
+	136:    {name: "tcw", units: "kg m**-2", longName: "Total column water"},
+	137:    {name: "tcwv", units: "kg m**-2", longName: "Total column vertically-integrated water vapour"},
+	138:    {name: "vo", units: "s**-1", longName: "Vorticity (relative)"},
+	139:    {name: "stl1", units: "K", longName: "Soil temperature level 1"},
+	141:    {name: "sd", units: "m of water equivalent", longName: "Snow depth"},
+	142:    {name: "lsp", units: "m", longName: "Large-scale precipitation"},
+	143:    {name: "cp", units: "m", longName: "Convective precipitation"},
+	144:    {name: "sf", units: "m of water equivalent", longName: "Snowfall"},
+	151:    {name: "msl", units: "Pa", longName: "Mean sea level pressure"},
+	155:    {name: "d", units: "s**-1", longName: "Divergence"},
+	157:    {name: "r", units: "%", longName: "Relative humidity"},
+	159:    {name: "blh", units: "m", longName: "Boundary layer height"},
+	164:    {name: "tcc", units: "(0 - 1)", longName: "Total cloud cover"},
+	165:    {name: "u10", units: "m s**-1", longName: "10 metre U wind component"},
+	166:    {name: "v10", units: "m s**-1", longName: "10 metre V wind component"},
+	167:    {name: "t2m", units: "K", longName: "2 metre temperature"},
+	168:    {name: "d2m", units: "K", longName: "2 metre dewpoint temperature"},
+	169:    {name: "ssrd", units: "J m**-2", longName: "Surface solar radiation downwards"},
+	172:    {name: "lsm", units: "(0 - 1)", longName: "Land-sea mask"},
+	175:    {name: "strd", units: "J m**-2", longName: "Surface thermal radiation downwards"},
+	176:    {name: "ssr", units: "J m**-2", longName: "Surface net solar radiation"},
+	177:    {name: "str", units: "J m**-2", longName: "Surface net thermal radiation"},
+	182:    {name: "e", units: "m of water equivalent", longName: "Evaporation"},
+	186:    {name: "lcc", units: "(0 - 1)", longName: "Low cloud cover"},
+	187:    {name: "mcc", units: "(0 - 1)", longName: "Medium cloud cover"},
+	188:    {name: "hcc", units: "(0 - 1)", longName: "High cloud cover"},
+	201:    {name: "mx2t", units: "K", longName: "Maximum temperature at 2 metres since previous post-processing"},
+	202:    {name: "mn2t", units: "K", longName: "Minimum temperature at 2 metres since previous post-processing"},
+	203:    {name: "o3", units: "kg kg**-1", longName: "Ozone mass mixing ratio"},
+	212:    {name: "tisr", units: "J m**-2", longName: "TOA incident solar radiation"},
+	228:    {name: "tp", units: "m", longName: "Total precipitation"},
+	235:    {name: "skt", units: "K", longName: "Skin temperature"},
+	246:    {name: "clwc", units: "kg kg**-1", longName: "Specific cloud liquid water content"},
+	247:    {name: "ciwc", units: "kg kg**-1", longName: "Specific cloud ice water content"},
+	248:    {name: "cc", units: "(0 - 1)", longName: "Fraction of cloud cover"},
+	228029: {name: "i10fg", units: "m s**-1", longName: "Instantaneous 10 metre wind gust"},
+	228246: {name: "u100", units: "m s**-1", longName: "100 metre U wind component"},
+	228247: {name: "v100", units: "m s**-1", longName: "100 metre V wind component"},
+}
+
+// ecmwfParam returns the parameter with the ECMWF parameter ID. The
+// parameters missing in ecmwfParams are named p<id>, e.g. p260015.
+func ecmwfParam(id int) gribParam {
+	p, ok := ecmwfParams[id]
+	if !ok {
+		p.name = fmt.Sprintf("p%d", id)
+	}
+	p.id = id
+	return p
+}
+
+// wmoKey identifies a parameter of the WMO tables of GRIB2, which depends on
+// the type and the value of the level for some parameters, e.g. the
+// temperature is 2t 2 m above the ground and t on the isobaric levels. The
+// level -1 stands for any level.
+type wmoKey struct {
+	discipline, category, number int
+	levelType                    int
+	level                        float64
+}
+
+// wmoParams maps the WMO parameters of GRIB2 to the ECMWF parameter IDs of
+// ERA5.
+var wmoParams = map[wmoKey]int{
+	{0, 0, 0, gribIsobaric, -1}:  130,
+	{0, 0, 0, 103, 2}:            167,
+	{0, 0, 6, 103, 2}:            168,
+	{0, 0, 17, 1, -1}:            235,
+	{0, 1, 0, gribIsobaric, -1}:  133,
+	{0, 1, 1, gribIsobaric, -1}:  157,
+	{0, 2, 2, gribIsobaric, -1}:  131,
+	{0, 2, 3, gribIsobaric, -1}:  132,
+	{0, 2, 2, 103, 10}:           165,
+	{0, 2, 3, 103, 10}:           166,
+	{0, 2, 2, 103, 100}:          228246,
+	{0, 2, 3, 103, 100}:          228247,
+	{0, 2, 8, gribIsobaric, -1}:  135,
+	{0, 2, 12, gribIsobaric, -1}: 138,
+	{0, 2, 13, gribIsobaric, -1}: 155,
+	{0, 3, 0, 1, -1}:             134,
+	{0, 3, 0, 101, -1}:           151,
+	{0, 3, 4, gribIsobaric, -1}:  129,
+	{0, 3, 4, 1, -1}:             129,
+}
+
+// gribParam2 returns the parameter of a GRIB2 field. ECMWF encodes the
+// parameters missing in the WMO tables with the discipline 192, the number
+// of the ECMWF table as the category and the parameter number. The other
+// parameters missing in wmoParams are named p<discipline>_<category>_<number>.
+func gribParam2(discipline, category, number, levelType int, level float64) gribParam {
+	if discipline == 192 {
+		if category == 128 {
+			return ecmwfParam(number)
+		}
+		return ecmwfParam(category*1000 + number)
+	}
+	for _, l := range []float64{level, -1} {
+		if id, ok := wmoParams[wmoKey{discipline, category, number, levelType, l}]; ok {
+			return ecmwfParam(id)
+		}
+	}
+	return gribParam{name: fmt.Sprintf("p%d_%d_%d", discipline, category, number)}
+}
@@ -0,0 +1,204 @@
+package era5
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+)
+
+// rootGroup is the path of the root group of a file.
+const rootGroup = "/"
+
+// findGroup returns the absolute path of the group that holds the variables
+// names, or any of them if anyOf is set, or any data variables if names is
+// empty. If group is empty, the variables are looked up in the root group and
+// then in the subgroups depth first, so files that keep them in a group are
+// read without knowing its name.
+func findGroup(root api.Group, group string, names []string, anyOf bool) (string, error) {
+	holds := func(g api.Group) bool { return hasVars(g, names) }
+	what := fmt.Sprintf("all of the %v variables", names)
+	if anyOf {
+		holds = func(g api.Group) bool { return slices.Contains(absentVars(g, names), false) }
+		what = fmt.Sprintf("any of the %v variables", names)
+	}
+	if len(names) == 0 {
+		holds = func(g api.Group) bool { return len(dataVars(g)) > 0 }
+		what = "data variables"
+	}
+	if group != "" {
+		p := path.Clean(rootGroup + group)
+		g, err := getGroup(root, p)
+		if err != nil {
+			return "", fmt.Errorf("could not open group %s: %w", p, err)
+		}
+		defer closeGroup(g, root)
+		if !holds(g) {
+			return "", fmt.Errorf("group %s does not hold %s", p, what)
+		}
+		return p, nil
+	}
+	p, ok := searchGroup(root, root, rootGroup, holds)
+	if !ok {
+		return "", fmt.Errorf("no group holds %s", what)
+	}
+	return p, nil
+}
+
+// searchGroup returns the path of the first group accepted by holds within
+// the group g at path p.
+func searchGroup(root, g api.Group, p string, holds func(g api.Group) bool) (string, bool) {
+	if holds(g) {
+		return p, true
+	}
+	for _, name := range g.ListSubgroups() {
+		subPath := path.Join(p, name)
+		sub, err := getGroup(root, subPath)
+		if err != nil {
+			continue
+		}
+		found, ok := searchGroup(root, sub, subPath, holds)
+		closeGroup(sub, root)
+		if ok {
+			return found, true
+		}
+	}
+	return "", false
+}
+
+func hasVars(g api.Group, names []string) bool {
+	vars := g.ListVariables()
+	for _, name := range names {
+		if !slices.Contains(vars, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// absentVars tells which of the variables names the group lacks.
+func absentVars(g api.Group, names []string) []bool {
+	vars := g.ListVariables()
+	absent := make([]bool, len(names))
+	for i, name := range names {
+		absent[i] = !slices.Contains(vars, name)
+	}
+	return absent
+}
+
+// dataVars returns the names of the variables of the group laid out along a
+// time axis, optionally the expver and a vertical axis, and the grid, which
+// tells them from the coordinates and the other auxiliary variables.
+func dataVars(g api.Group) []string {
+	var names []string
+	for _, name := range g.ListVariables() {
+		vg, err := g.GetVarGetter(name)
+		if err != nil {
+			continue
+		}
+		dims := vg.Dimensions()
+		if len(dims) < 3 || len(dims) > 5 || !slices.Contains(dims, "latitude") || !slices.Contains(dims, "longitude") {
+			continue
+		}
+		if !slices.ContainsFunc(timeAxes, func(a timeAxis) bool { return slices.Contains(dims, a.name) }) {
+			continue
+		}
+		extra := 0
+		for _, d := range dims {
+			if d == expverDim || slices.Contains(levelAxes, d) {
+				extra++
+			}
+		}
+		if len(dims) == 3+extra {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// DiscoverVariables returns the names of the data variables of a file, which
+// are the variables laid out along the time axis and the grid, in the order
+// they are stored. If group is empty, the first group holding data variables
+// is used, searched from the root group.
+func DiscoverVariables(filePath, group string) ([]string, error) {
+	root, err := openDataset(context.Background(), filePath, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	p, err := findGroup(root, group, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	g, err := getGroup(root, p)
+	if err != nil {
+		return nil, err
+	}
+	defer closeGroup(g, root)
+	return dataVars(g), nil
+}
+
+// getGroup returns the group at the absolute path p. The root group itself
+// is returned for the root path.
+func getGroup(root api.Group, p string) (api.Group, error) {
+	if p == rootGroup {
+		return root, nil
+	}
+	return root.GetGroup(p)
+}
+
+// closeGroup closes a group returned by getGroup unless it is the root.
+func closeGroup(g, root api.Group) {
+	if g != root {
+		g.Close()
+	}
+}
+
+// openGroup opens a dataset and returns its group at the absolute path p.
+func openGroup(ctx context.Context, filePath string, readAhead int, p string) (api.Group, error) {
+	root, err := openDataset(ctx, filePath, readAhead)
+	if err != nil {
+		return nil, err
+	}
+	g, err := getGroup(root, p)
+	if err != nil {
+		root.Close()
+		return nil, fmt.Errorf("could not open group %s: %w", p, err)
+	}
+	// The group keeps the file open on its own.
+	closeGroup(root, g)
+	return g, nil
+}
+
+// varValues reads all the values and the attributes of a variable. The
+// variable is looked up in the group at path p and then in its ancestors,
+// since NetCDF-4 files keep the coordinates shared by multiple groups in a
+// common parent.
+func varValues(root api.Group, p, name string) (any, api.AttributeMap, error) {
+	for {
+		g, err := getGroup(root, p)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The HDF5 reader logs a warning for every missing variable, so
+		// the variable is looked up in the list first.
+		if slices.Contains(g.ListVariables(), name) {
+			vg, err := g.GetVarGetter(name)
+			if err != nil {
+				closeGroup(g, root)
+				return nil, nil, withHint(err)
+			}
+			v, err := vg.Values()
+			attrs := vg.Attributes()
+			closeGroup(g, root)
+			return v, attrs, withHint(err)
+		}
+		closeGroup(g, root)
+		if p == rootGroup {
+			return nil, nil, fmt.Errorf("variable %s is not found", name)
+		}
+		p = path.Dir(p)
+	}
+}
@@ -0,0 +1,55 @@
+package era5
+
+import (
+	"context"
+	"fmt"
+)
+
+// VarInfo describes a variable of a file.
+type VarInfo struct {
+	Name string
+	// Type is the type of the values in the CDL notation, e.g. short.
+	Type       string
+	Dimensions []string
+	// Len is the length of the first dimension, which is the number of
+	// values of a coordinate.
+	Len int64
+	// Attributes holds the names and the values of the attributes in the
+	// order they are stored. The values are formatted as text.
+	Attributes [][2]string
+}
+
+// Inspect returns the path of the group of a file that holds the data
+// variables and the description of all the variables of the group. group is
+// looked up like Options.Group.
+func Inspect(filePath, group string) (string, []VarInfo, error) {
+	root, err := openDataset(context.Background(), filePath, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	defer root.Close()
+	p, err := findGroup(root, group, nil, false)
+	if err != nil {
+		return "", nil, err
+	}
+	g, err := getGroup(root, p)
+	if err != nil {
+		return "", nil, err
+	}
+	defer closeGroup(g, root)
+	var infos []VarInfo
+	for _, name := range g.ListVariables() {
+		vg, err := g.GetVarGetter(name)
+		if err != nil {
+			return "", nil, fmt.Errorf("could not read %s: %w", name, err)
+		}
+		info := VarInfo{Name: name, Type: vg.Type(), Dimensions: vg.Dimensions(), Len: vg.Len()}
+		attrs := vg.Attributes()
+		for _, key := range attrs.Keys() {
+			v, _ := attrs.Get(key)
+			info.Attributes = append(info.Attributes, [2]string{key, fmt.Sprint(v)})
+		}
+		infos = append(infos, info)
+	}
+	return p, infos, nil
+}
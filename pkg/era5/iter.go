@@ -0,0 +1,37 @@
+package era5
+
+import "iter"
+
+// All returns an iterator over the records of every timestamp, which calls
+// Scan until there are no timestamps left. An error ends the iteration after
+// it is yielded with nil records. The scanner cannot be rewound, so the
+// iteration continues where the previous one stopped.
+func (s *Scanner) All() iter.Seq2[[]Record, error] {
+	return func(yield func([]Record, error) bool) {
+		for s.Scan() {
+			if !yield(s.Records(), nil) {
+				return
+			}
+		}
+		if s.err != nil {
+			yield(nil, s.err)
+		}
+	}
+}
+
+// AllRecords is like All but yields the records one by one.
+func (s *Scanner) AllRecords() iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		for recs, err := range s.All() {
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			for _, r := range recs {
+				if !yield(r, nil) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package era5
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// The orders of the latitudes of the grid. ERA5 stores them descending, from
+// the north to the south, while some processed files have them ascending.
+const (
+	LatitudeDescending = "descending"
+	LatitudeAscending  = "ascending"
+	// LatitudeUnordered is the order of latitudes that are neither.
+	LatitudeUnordered = "unordered"
+)
+
+// LatitudeOrders lists the orders the latitudes can be normalized to with
+// Options.LatitudeOrder.
+var LatitudeOrders = []string{LatitudeDescending, LatitudeAscending}
+
+// checkLatitudeOrder returns an error if the order the latitudes are
+// normalized to is not supported. Empty means the order of the file.
+func checkLatitudeOrder(order string) error {
+	if order != "" && !slices.Contains(LatitudeOrders, order) {
+		return fmt.Errorf("unsupported latitude order %q, must be one of %v", order, LatitudeOrders)
+	}
+	return nil
+}
+
+// coordOrder returns the order of the coordinates.
+func coordOrder(coords []float32) string {
+	desc, asc := true, true
+	for i := 1; i < len(coords); i++ {
+		desc = desc && coords[i] < coords[i-1]
+		asc = asc && coords[i] > coords[i-1]
+	}
+	switch {
+	case desc:
+		return LatitudeDescending
+	case asc:
+		return LatitudeAscending
+	default:
+		return LatitudeUnordered
+	}
+}
+
+// sortCoords sorts the coordinates in the order, either of the latitudes or
+// the longitudes, along with their indexes within the grid.
+func sortCoords(coords []float32, idx []int, order string) {
+	sort.Stable(coordSorter{coords: coords, idx: idx, desc: order == LatitudeDescending})
+}
+
+type coordSorter struct {
+	coords []float32
+	idx    []int
+	desc   bool
+}
+
+func (s coordSorter) Len() int { return len(s.coords) }
+
+func (s coordSorter) Less(i, j int) bool {
+	if s.desc {
+		return s.coords[i] > s.coords[j]
+	}
+	return s.coords[i] < s.coords[j]
+}
+
+func (s coordSorter) Swap(i, j int) {
+	s.coords[i], s.coords[j] = s.coords[j], s.coords[i]
+	s.idx[i], s.idx[j] = s.idx[j], s.idx[i]
+}
@@ -0,0 +1,50 @@
+package era5
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+)
+
+// levelAxes lists the names of the vertical dimension of the pressure-level
+// datasets: level in the files of the classic CDS and pressure_level in the
+// NetCDF-4 files of the new CDS. It precedes the grid in the dimensions of
+// the variables.
+var levelAxes = []string{"level", "pressure_level"}
+
+// levelAxis returns the name of the vertical dimension of the variable in the
+// group at path p and the values of its coordinate. The name is empty if the
+// variable has no vertical dimension.
+func levelAxis(root api.Group, p, varName string) (string, []float32, error) {
+	g, err := getGroup(root, p)
+	if err != nil {
+		return "", nil, err
+	}
+	vg, err := g.GetVarGetter(varName)
+	closeGroup(g, root)
+	if err != nil {
+		return "", nil, withHint(err)
+	}
+	dims := vg.Dimensions()
+	if len(dims) < 4 || !slices.Contains(levelAxes, dims[len(dims)-3]) {
+		return "", nil, nil
+	}
+	dim := dims[len(dims)-3]
+	// The levels are stored as integers in the classic files and as floats
+	// in the new ones, both are as expected.
+	levels, _, err := dimValues[float32](root, p, dim)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(levels) == 0 {
+		return "", nil, fmt.Errorf("coordinate %s is empty", dim)
+	}
+	return dim, levels, nil
+}
+
+// FormatLevel formats a level the way it is exported as a label, e.g. 850.
+func FormatLevel(level float32) string {
+	return strconv.FormatFloat(float64(level), 'g', -1, 32)
+}
@@ -0,0 +1,256 @@
+package era5
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+	"github.com/batchatco/go-native-netcdf/netcdf/util"
+)
+
+// memGroup presents a group of a dataset in a format other than NetCDF the
+// way the NetCDF reader does, so the scanner reads every format with the
+// same code. The layout of the group is held in memory and the values of its
+// variables are read on demand.
+type memGroup struct {
+	attrs  api.AttributeMap
+	vars   []*memVar
+	groups []*memGroup
+	name   string
+	// file is shared by all the groups of the dataset and closed with the
+	// last of them.
+	file *sharedFile
+}
+
+// sharedFile closes the file of a dataset once all the groups handed out
+// are closed, since openGroup closes the root and keeps a subgroup.
+type sharedFile struct {
+	mu    sync.Mutex
+	refs  int
+	close func()
+}
+
+func (f *sharedFile) acquire() {
+	f.mu.Lock()
+	f.refs++
+	f.mu.Unlock()
+}
+
+func (f *sharedFile) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refs--
+	if f.refs == 0 && f.close != nil {
+		f.close()
+	}
+}
+
+// newMemRoot returns the root group of a dataset, which calls close once it
+// and all the groups it hands out are closed. close may be nil.
+func newMemRoot(close func()) *memGroup {
+	return &memGroup{attrs: newAttrs(), file: &sharedFile{refs: 1, close: close}}
+}
+
+// addGroup adds an empty subgroup with the name.
+func (g *memGroup) addGroup(name string) *memGroup {
+	sub := &memGroup{attrs: newAttrs(), name: name, file: g.file}
+	g.groups = append(g.groups, sub)
+	return sub
+}
+
+// memVar is a variable of a memGroup. Its values are either held in memory,
+// as the coordinates are, or read by a function.
+type memVar struct {
+	name   string
+	dims   []string
+	attrs  api.AttributeMap
+	goType string
+	len    int64
+	// values holds the values of the variable along its first dimension.
+	// It is nil if the values are read by read.
+	values any
+	// read returns the values at the indexes [begin, end) of the first
+	// dimension, a slice with an element per index.
+	read func(begin, end int64) (any, error)
+}
+
+// newAttrs returns an empty attribute map the attributes are added to in
+// order.
+func newAttrs() *util.OrderedMap {
+	attrs, _ := util.NewOrderedMap(nil, nil)
+	return attrs
+}
+
+// addCoord adds a variable holding the values of a coordinate, which is a
+// slice, along the dimension of the same name.
+func (g *memGroup) addCoord(name string, values any, attrs api.AttributeMap) {
+	rv := reflect.ValueOf(values)
+	g.vars = append(g.vars, &memVar{
+		name:   name,
+		dims:   []string{name},
+		attrs:  attrs,
+		goType: rv.Type().Elem().String(),
+		len:    int64(rv.Len()),
+		values: values,
+	})
+}
+
+func (g *memGroup) Close() {
+	g.file.release()
+}
+
+func (g *memGroup) Attributes() api.AttributeMap {
+	return g.attrs
+}
+
+func (g *memGroup) ListVariables() []string {
+	names := make([]string, len(g.vars))
+	for i, v := range g.vars {
+		names[i] = v.name
+	}
+	return names
+}
+
+func (g *memGroup) variable(name string) (*memVar, error) {
+	i := slices.IndexFunc(g.vars, func(v *memVar) bool { return v.name == name })
+	if i < 0 {
+		return nil, fmt.Errorf("variable %s is not found", name)
+	}
+	return g.vars[i], nil
+}
+
+func (g *memGroup) GetVariable(name string) (*api.Variable, error) {
+	v, err := g.variable(name)
+	if err != nil {
+		return nil, err
+	}
+	values, err := v.Values()
+	if err != nil {
+		return nil, err
+	}
+	return &api.Variable{Values: values, Dimensions: v.dims, Attributes: v.attrs}, nil
+}
+
+func (g *memGroup) GetVarGetter(name string) (api.VarGetter, error) {
+	return g.variable(name)
+}
+
+func (g *memGroup) ListSubgroups() []string {
+	names := make([]string, len(g.groups))
+	for i, sub := range g.groups {
+		names[i] = sub.name
+	}
+	return names
+}
+
+// GetGroup returns the subgroup at the path, which is relative to the group
+// or, starting with a slash, to the root if the group is the root.
+func (g *memGroup) GetGroup(p string) (api.Group, error) {
+	sub := g
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		i := slices.IndexFunc(sub.groups, func(sub *memGroup) bool { return sub.name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("group %s is not found", p)
+		}
+		sub = sub.groups[i]
+	}
+	g.file.acquire()
+	return sub, nil
+}
+
+// ListTypes returns no types, since the other formats have no user-defined
+// types.
+func (g *memGroup) ListTypes() []string {
+	return nil
+}
+
+func (g *memGroup) GetType(string) (string, bool) {
+	return "", false
+}
+
+func (g *memGroup) GetGoType(string) (string, bool) {
+	return "", false
+}
+
+func (g *memGroup) ListDimensions() []string {
+	var dims []string
+	for _, v := range g.vars {
+		for _, d := range v.dims {
+			if !slices.Contains(dims, d) {
+				dims = append(dims, d)
+			}
+		}
+	}
+	return dims
+}
+
+// GetDimension returns the length of a dimension, which is known if the
+// group holds its coordinate.
+func (g *memGroup) GetDimension(name string) (uint64, bool) {
+	v, err := g.variable(name)
+	if err != nil || len(v.dims) != 1 || v.dims[0] != name {
+		return 0, false
+	}
+	return uint64(v.len), true
+}
+
+func (v *memVar) Len() int64 {
+	return v.len
+}
+
+func (v *memVar) Values() (any, error) {
+	return v.GetSlice(0, v.len)
+}
+
+func (v *memVar) GetSlice(begin, end int64) (any, error) {
+	if begin < 0 || end > v.len || begin > end {
+		return nil, fmt.Errorf("slice [%d, %d) of %s is out of range [0, %d)", begin, end, v.name, v.len)
+	}
+	if v.values != nil {
+		return reflect.ValueOf(v.values).Slice(int(begin), int(end)).Interface(), nil
+	}
+	return v.read(begin, end)
+}
+
+func (v *memVar) Dimensions() []string {
+	return v.dims
+}
+
+func (v *memVar) Attributes() api.AttributeMap {
+	return v.attrs
+}
+
+// Type returns the type of the values in the CDL notation like the NetCDF
+// reader.
+func (v *memVar) Type() string {
+	switch v.goType {
+	case "int8":
+		return "byte"
+	case "uint8":
+		return "ubyte"
+	case "int16":
+		return "short"
+	case "uint16":
+		return "ushort"
+	case "int32":
+		return "int"
+	case "uint32":
+		return "uint"
+	case "float32":
+		return "float"
+	case "float64":
+		return "double"
+	default:
+		return v.goType
+	}
+}
+
+func (v *memVar) GoType() string {
+	return v.goType
+}
@@ -0,0 +1,53 @@
+package era5
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// The policies of the values equal to the fill value of their variable, set
+// by Options.Missing.
+const (
+	// MissingKeep exports the fill values as they are stored.
+	MissingKeep = "keep"
+	// MissingSkip leaves the samples out of the export.
+	MissingSkip = "skip"
+	// MissingNaN exports the samples as NaN.
+	MissingNaN = "nan"
+	// MissingZero exports the samples as zero.
+	MissingZero = "zero"
+)
+
+// MissingPolicies lists the supported policies of the fill values.
+var MissingPolicies = []string{MissingKeep, MissingSkip, MissingNaN, MissingZero}
+
+// IsMissing tells whether a value of a record is missing. The fill values are
+// replaced with NaN unless they are kept, and NaN is never data, so the
+// missing values are told apart with IsMissing rather than by comparison.
+func IsMissing(x float64) bool {
+	return math.IsNaN(x)
+}
+
+// checkMissing returns an error if the policy of the fill values is not
+// supported. Empty means MissingKeep.
+func checkMissing(policy string) error {
+	if policy != "" && !slices.Contains(MissingPolicies, policy) {
+		return fmt.Errorf("unsupported missing value policy %q, must be one of %v", policy, MissingPolicies)
+	}
+	return nil
+}
+
+// replaceMissing replaces the fill values in the column of the values of the
+// variable v with NaN. It does nothing if the fill values are kept.
+func (s *Scanner) replaceMissing(v int, col []float64) {
+	if s.fill == nil || !s.hasFill[v] {
+		return
+	}
+	nan := math.NaN()
+	for i, x := range col {
+		if x == s.fill[v] {
+			col[i] = nan
+		}
+	}
+}
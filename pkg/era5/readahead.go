@@ -0,0 +1,215 @@
+package era5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/batchatco/go-native-netcdf/netcdf"
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+)
+
+// source is a random access file the NetCDF or GRIB data is read from.
+type source interface {
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// localFile is a source backed by a file on the local filesystem.
+type localFile struct {
+	*os.File
+	size int64
+}
+
+func openLocalFile(filePath string) (*localFile, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%s is a directory", filePath)
+	}
+	return &localFile{File: f, size: fi.Size()}, nil
+}
+
+func (f *localFile) Size() int64 {
+	return f.size
+}
+
+// openDataset opens a NetCDF or a GRIB file, told by its magic bytes, or a
+// Zarr store as a NetCDF group whose coordinates have the ERA5 names, see
+// coordAliases. If readAhead is positive a NetCDF file is read through a
+// read-ahead buffer of that size. Remote NetCDF files are always read through
+// a read-ahead buffer, defaultRemoteReadAhead unless set. The reads of remote
+// files are cancelled with ctx.
+func openDataset(ctx context.Context, filePath string, readAhead int) (api.Group, error) {
+	g, err := openUnaliased(ctx, filePath, readAhead)
+	if err != nil {
+		return nil, err
+	}
+	return withAliases(g), nil
+}
+
+// openUnaliased is openDataset without renaming the coordinates.
+func openUnaliased(ctx context.Context, filePath string, readAhead int) (api.Group, error) {
+	if IsZarrStore(filePath) {
+		return openZarr(ctx, filePath)
+	}
+	var src source
+	var err error
+	remote := IsRemote(filePath)
+	if remote {
+		src, err = openRemote(ctx, filePath)
+	} else {
+		src, err = openLocalFile(filePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	format, err := fileFormat(src)
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+	if format == formatGRIB {
+		nc, err := openGRIB(src)
+		if err != nil {
+			src.Close()
+			return nil, err
+		}
+		return nc, nil
+	}
+	if !remote && readAhead <= 0 {
+		src.Close()
+		nc, err := netcdf.Open(filePath)
+		if err != nil {
+			return nil, withHint(err)
+		}
+		return nc, nil
+	}
+	if readAhead <= 0 {
+		readAhead = defaultRemoteReadAhead
+	}
+	nc, err := netcdf.New(newReadAheadFile(src, readAhead))
+	if err != nil {
+		src.Close()
+		return nil, withHint(err)
+	}
+	return nc, nil
+}
+
+// window is a contiguous part of a file held in memory.
+type window struct {
+	off  int64
+	data []byte
+	err  error
+}
+
+func (w *window) contains(off int64) bool {
+	return w != nil && off >= w.off && off < w.off+int64(len(w.data))
+}
+
+// readAheadFile serves reads from an in-memory window of the source and
+// fetches the window that follows it in the background, so sequential
+// readers do not wait for the storage on every read. This matters most for
+// network filesystems and remote sources where each read is expensive.
+type readAheadFile struct {
+	src  source
+	size int
+	pos  int64
+	cur  *window
+	// next receives the window that is being fetched in the background.
+	next chan *window
+}
+
+func newReadAheadFile(src source, size int) *readAheadFile {
+	return &readAheadFile{src: src, size: size}
+}
+
+// Read implements io.Reader.
+func (f *readAheadFile) Read(p []byte) (int, error) {
+	if f.pos >= f.src.Size() {
+		return 0, io.EOF
+	}
+	if !f.cur.contains(f.pos) {
+		f.cur = f.fetch(f.pos - f.pos%int64(f.size))
+		if f.cur.err != nil && len(f.cur.data) == 0 {
+			err := f.cur.err
+			f.cur = nil
+			return 0, err
+		}
+		f.prefetch(f.cur.off + int64(f.size))
+	}
+	n := copy(p, f.cur.data[f.pos-f.cur.off:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+// fetch returns the window starting at off, either from the pending
+// background fetch or by reading it synchronously.
+func (f *readAheadFile) fetch(off int64) *window {
+	if f.next != nil {
+		next := <-f.next
+		f.next = nil
+		if next.off == off {
+			return next
+		}
+	}
+	return f.readWindow(off)
+}
+
+// prefetch starts fetching the window starting at off in the background.
+func (f *readAheadFile) prefetch(off int64) {
+	if off >= f.src.Size() {
+		return
+	}
+	f.next = make(chan *window, 1)
+	go func(next chan<- *window) {
+		next <- f.readWindow(off)
+	}(f.next)
+}
+
+func (f *readAheadFile) readWindow(off int64) *window {
+	data := make([]byte, min(int64(f.size), f.src.Size()-off))
+	n, err := f.src.ReadAt(data, off)
+	if errors.Is(err, io.EOF) && n == len(data) {
+		err = nil
+	}
+	return &window{off: off, data: data[:n], err: err}
+}
+
+// Seek implements io.Seeker.
+func (f *readAheadFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.src.Size()
+	default:
+		return 0, errors.New("readAheadFile.Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("readAheadFile.Seek: negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Close waits for the background fetch to finish and closes the source.
+func (f *readAheadFile) Close() error {
+	if f.next != nil {
+		<-f.next
+		f.next = nil
+	}
+	return f.src.Close()
+}
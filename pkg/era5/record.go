@@ -0,0 +1,90 @@
+package era5
+
+// Record is a collection of readings taken at a given geo location at a given
+// time.
+type Record struct {
+	// Dimensions
+	Timestamp int64
+	Latitude  float32
+	Longitude float32
+	// Level is the vertical level, e.g. the pressure in hPa. It is zero if
+	// the file has no vertical dimension.
+	Level float32
+
+	// Values holds the values of the variables in the order of the names
+	// the records have been scanned with, see Options.Variables. They are
+	// the values as stored in the file, whatever its type, so the packed
+	// values are unpacked with Scanner.Packing.
+	Values []float64
+}
+
+// RecordBatch holds the records of a single timestamp column by column. It
+// spares the allocation of a Record for every grid point and keeps the values
+// of a variable next to each other, which makes encoding large grids cheaper.
+type RecordBatch struct {
+	Timestamp int64
+	// Latitudes, Longitudes and Levels hold the coordinates of every record.
+	// Levels is nil if the file has no vertical dimension.
+	Latitudes  []float32
+	Longitudes []float32
+	Levels     []float32
+	// Values holds a column of the values of every variable in the order of
+	// the names the records have been scanned with.
+	Values [][]float64
+}
+
+// Len returns the number of records in the batch.
+func (b *RecordBatch) Len() int {
+	return len(b.Latitudes)
+}
+
+// Slice returns the records [begin, end) of the batch. The returned batch
+// shares the columns with b.
+func (b *RecordBatch) Slice(begin, end int) *RecordBatch {
+	s := &RecordBatch{
+		Timestamp:  b.Timestamp,
+		Latitudes:  b.Latitudes[begin:end],
+		Longitudes: b.Longitudes[begin:end],
+		Values:     make([][]float64, len(b.Values)),
+	}
+	if b.Levels != nil {
+		s.Levels = b.Levels[begin:end]
+	}
+	for v, col := range b.Values {
+		s.Values[v] = col[begin:end]
+	}
+	return s
+}
+
+// Record fills r with the record i of the batch. The values are copied into
+// r.Values, which is reused if it has the capacity, so a single Record can
+// visit all the records of the batch without allocations.
+func (b *RecordBatch) Record(i int, r *Record) {
+	r.Timestamp = b.Timestamp
+	r.Latitude = b.Latitudes[i]
+	r.Longitude = b.Longitudes[i]
+	r.Level = 0
+	if b.Levels != nil {
+		r.Level = b.Levels[i]
+	}
+	if cap(r.Values) < len(b.Values) {
+		r.Values = make([]float64, len(b.Values))
+	}
+	r.Values = r.Values[:len(b.Values)]
+	for v, col := range b.Values {
+		r.Values[v] = col[i]
+	}
+}
+
+// Records converts the batch into records, which share a single allocation
+// of the values.
+func (b *RecordBatch) Records() []Record {
+	n := len(b.Values)
+	recs := make([]Record, b.Len())
+	values := make([]float64, len(recs)*n)
+	for i := range recs {
+		recs[i].Values = values[i*n : (i+1)*n : (i+1)*n]
+		b.Record(i, &recs[i])
+	}
+	return recs
+}
@@ -0,0 +1,203 @@
+package era5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRemoteReadAhead is the read-ahead buffer size used for remote files
+// if none is set, since every read of a remote file is a request.
+const defaultRemoteReadAhead = 8 << 20
+
+// remoteOpener returns the function opening the file in a remote storage
+// the path is a URL of, or nil if it is not one.
+func remoteOpener(filePath string) func(ctx context.Context, url string) (source, error) {
+	scheme, rest, ok := strings.Cut(filePath, "://")
+	if !ok {
+		return nil
+	}
+	switch scheme {
+	case "s3":
+		return openS3Object
+	case "gs":
+		return openGCSObject
+	case "az":
+		return openAzureBlob
+	case "https":
+		host, _, _ := strings.Cut(rest, "/")
+		if strings.HasSuffix(host, azureBlobHostSuffix) {
+			return openAzureBlob
+		}
+	}
+	return nil
+}
+
+// IsRemote tells whether the path is a URL of a file in a remote storage.
+func IsRemote(filePath string) bool {
+	return remoteOpener(filePath) != nil
+}
+
+// openRemote opens a file in a remote storage. The reads of the file are
+// cancelled with ctx.
+func openRemote(ctx context.Context, filePath string) (source, error) {
+	openCtx, cancel := context.WithTimeout(ctx, remoteTimeout)
+	defer cancel()
+	src, err := remoteOpener(filePath)(openCtx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if o, ok := src.(*httpObject); ok {
+		o.ctx = ctx
+	}
+	return src, nil
+}
+
+// remoteTimeout limits the duration of a single request to a remote
+// storage.
+const remoteTimeout = 5 * time.Minute
+
+// remoteRetries is the number of times a failed read of a remote file is
+// retried.
+const remoteRetries = 3
+
+var remoteClient = &http.Client{
+	Transport: &http.Transport{
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+		// The files are compressed internally, so the responses are not
+		// worth compressing.
+		DisableCompression: true,
+	},
+}
+
+// httpObject is a source read with ranged GET requests.
+type httpObject struct {
+	url  string
+	size int64
+	// authorize adds the credentials to a request. It is nil for anonymous
+	// access.
+	authorize func(req *http.Request) error
+	// ctx cancels the reads. Nil means they are never cancelled.
+	ctx context.Context
+}
+
+// openHTTPObject requests the size of the object at url.
+func openHTTPObject(ctx context.Context, url string, authorize func(req *http.Request) error) (*httpObject, error) {
+	o := &httpObject{url: url, authorize: authorize}
+	res, err := o.do(ctx, http.MethodHead, "")
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.ContentLength < 0 {
+		return nil, fmt.Errorf("the size of %s is unknown", url)
+	}
+	o.size = res.ContentLength
+	return o, nil
+}
+
+// do sends a request for the object and checks the response status.
+func (o *httpObject) do(ctx context.Context, method, byteRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, o.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	if o.authorize != nil {
+		if err := o.authorize(req); err != nil {
+			return nil, fmt.Errorf("could not authorize request: %w", err)
+		}
+	}
+	res, err := remoteClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+		return nil, &remoteError{url: o.url, status: res.StatusCode, msg: strings.TrimSpace(string(msg))}
+	}
+	return res, nil
+}
+
+// remoteError is returned when the storage responds with an unexpected
+// status code.
+type remoteError struct {
+	url    string
+	status int
+	msg    string
+}
+
+func (e *remoteError) Error() string {
+	if e.msg == "" {
+		return fmt.Sprintf("%s: unexpected status %d %s", e.url, e.status, http.StatusText(e.status))
+	}
+	return fmt.Sprintf("%s: unexpected status %d %s: %s", e.url, e.status, http.StatusText(e.status), e.msg)
+}
+
+// ReadAt implements io.ReaderAt. Failed reads are retried unless the storage
+// has rejected the request.
+func (o *httpObject) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	n := min(int64(len(p)), o.size-off)
+	byteRange := "bytes=" + strconv.FormatInt(off, 10) + "-" + strconv.FormatInt(off+n-1, 10)
+	ctx := o.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var err error
+	for attempt := 0; attempt <= remoteRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return 0, fmt.Errorf("could not read %s at %d: %w", o.url, off, err)
+			}
+		}
+		var read int
+		read, err = o.readRange(ctx, p[:n], byteRange)
+		if err == nil {
+			if n < int64(len(p)) {
+				return read, io.EOF
+			}
+			return read, nil
+		}
+		var re *remoteError
+		if errors.As(err, &re) && re.status < 500 && re.status != http.StatusTooManyRequests {
+			break
+		}
+	}
+	return 0, fmt.Errorf("could not read %s at %d: %w", o.url, off, err)
+}
+
+func (o *httpObject) readRange(ctx context.Context, p []byte, byteRange string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteTimeout)
+	defer cancel()
+	res, err := o.do(ctx, http.MethodGet, byteRange)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("%s does not support ranged reads", o.url)
+	}
+	return io.ReadFull(res.Body, p)
+}
+
+func (o *httpObject) Size() int64 {
+	return o.size
+}
+
+func (o *httpObject) Close() error {
+	return nil
+}
@@ -0,0 +1,211 @@
+package era5
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// openS3Object opens an object given by an s3://bucket/key URL. The
+// credentials and the region are taken from the standard AWS environment
+// variables or the shared credentials file. Objects are read anonymously if
+// there are no credentials, which is enough for public buckets. Setting
+// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL makes the object read from an
+// S3-compatible storage, such as MinIO, with path-style URLs.
+func openS3Object(ctx context.Context, s3URL string) (source, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(s3URL, "s3://"), "/")
+	if !ok || bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid S3 URL %q, want s3://bucket/key", s3URL)
+	}
+	region := cmpOr(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	var objURL string
+	switch endpoint := cmpOr(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL")); {
+	case endpoint != "":
+		objURL = strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/" + awsEscapePath(key)
+	case strings.Contains(bucket, "."):
+		// Virtual-hosted URLs of buckets with dots do not match the TLS
+		// certificate of S3.
+		objURL = "https://s3." + region + ".amazonaws.com/" + bucket + "/" + awsEscapePath(key)
+	default:
+		objURL = "https://" + bucket + ".s3." + region + ".amazonaws.com/" + awsEscapePath(key)
+	}
+	creds, err := loadAWSCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("could not load AWS credentials: %w", err)
+	}
+	var authorize func(req *http.Request) error
+	if creds != nil {
+		authorize = func(req *http.Request) error {
+			signV4(req, creds, region, "s3", time.Now())
+			return nil
+		}
+	}
+	return openHTTPObject(ctx, objURL, authorize)
+}
+
+// awsCredentials are the credentials requests to AWS are signed with.
+type awsCredentials struct {
+	accessKeyID, secretAccessKey, sessionToken string
+}
+
+// loadAWSCredentials reads the credentials from the environment variables
+// or the profile of the shared credentials file given by AWS_PROFILE. It
+// returns nil if there are no credentials.
+func loadAWSCredentials() (*awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &awsCredentials{
+			accessKeyID:     id,
+			secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	profile := cmpOr(os.Getenv("AWS_PROFILE"), "default")
+	var creds awsCredentials
+	section := ""
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		k, v, _ := strings.Cut(line, "=")
+		switch strings.TrimSpace(k) {
+		case "aws_access_key_id":
+			creds.accessKeyID = strings.TrimSpace(v)
+		case "aws_secret_access_key":
+			creds.secretAccessKey = strings.TrimSpace(v)
+		case "aws_session_token":
+			creds.sessionToken = strings.TrimSpace(v)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if creds.accessKeyID == "" {
+		return nil, nil
+	}
+	return &creds, nil
+}
+
+// signV4 signs a request without a body with the AWS Signature Version 4.
+// The payload is unsigned unless the X-Amz-Content-Sha256 header holds its
+// hash already. The Range header is signed along with the host and the
+// x-amz headers.
+func signV4(req *http.Request, creds *awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = "UNSIGNED-PAYLOAD"
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscapePath escapes every segment of an object key the way AWS expects
+// in the canonical URI: everything but the unreserved characters.
+func awsEscapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		var b strings.Builder
+		for _, c := range []byte(s) {
+			if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		segments[i] = b.String()
+	}
+	return strings.Join(segments, "/")
+}
+
+// cmpOr returns the first non-empty string.
+func cmpOr(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
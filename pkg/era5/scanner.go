@@ -0,0 +1,1040 @@
+package era5
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+)
+
+// VarNames lists the variables read from a file by default.
+var VarNames = []string{"u10", "v10", "t2m", "sf", "tcc", "tp"}
+
+// Options controls what and how a Scanner reads from a file.
+type Options struct {
+	// HourIndexes is the set of time indexes to scan. Takes precedence over
+	// LimitHours.
+	HourIndexes []int
+
+	// LimitHours limits the scan to this many first hours within From and
+	// To that match Every. Zero means no limit.
+	LimitHours int
+
+	// From and To limit the scan to the timestamps in [From, To). The zero
+	// time means no limit.
+	From, To time.Time
+
+	// Every limits the scan to the timestamps that are multiples of it
+	// since the epoch, e.g. 6h keeps 00:00, 06:00, 12:00 and 18:00 UTC.
+	// Zero means every timestamp.
+	Every time.Duration
+
+	// Concurrency is the number of goroutines that decode the variables of
+	// a timestamp in parallel. Each goroutine reads the file through its own
+	// handle. Zero or one means sequential decoding.
+	Concurrency int
+
+	// ScanAhead is the number of timestamps read in parallel ahead of Scan,
+	// each through its own Concurrency file handles. Scan still returns the
+	// timestamps in order. Zero or one means the timestamps are read one at
+	// a time by Scan.
+	ScanAhead int
+
+	// ScanRows is the number of latitude rows of the grid read by a single
+	// Scan, counting the rows of every level. The timestamp takes multiple
+	// Scan calls if it has more rows, which bounds the number of records held
+	// at once regardless of the grid size. Zero means a Scan reads all the
+	// rows of a timestamp.
+	ScanRows int
+
+	// Part and Parts split the selected timestamps into Parts contiguous
+	// ranges of (almost) equal size and make the scanner read only the range
+	// with the index Part. This allows multiple scanners to read the same
+	// file in parallel. Zero Parts means the scanner reads all timestamps.
+	Part  int
+	Parts int
+
+	// ReadAhead is the size of the read-ahead buffer in bytes. While the
+	// scanner decodes one part of the file, the part that follows is fetched
+	// in the background. Zero disables read-ahead.
+	ReadAhead int
+
+	// ChunkCacheSize is the number of decoded chunks kept in memory. A chunk
+	// holds ChunkTimeSteps consecutive time steps of a single variable. Zero
+	// disables the cache and every Scan() reads a single time step.
+	ChunkCacheSize int
+	ChunkTimeSteps int
+
+	// Group is the path of the NetCDF group that holds the variables, e.g.
+	// /era5/surface. Coordinates not found in the group are looked up in
+	// its ancestors. Empty means the first group holding the variables,
+	// searched from the root depth first.
+	Group string
+
+	// Strict makes NewScanner fail on any deviation from the ERA5 layout,
+	// such as coordinates stored as float64, values stored as int32 or
+	// missing attributes. By default the deviations the scanner can cope
+	// with are reported by Warnings.
+	Strict bool
+
+	// Skip reports whether the timestamp in milliseconds since the epoch
+	// must not be scanned. The skipped timestamps are left out before the
+	// timestamps are split into Parts. Nil means no timestamps are skipped.
+	Skip func(ts int64) bool
+
+	// Variables are the names of the variables to read in the order their
+	// values are stored in Record.Values. Empty means VarNames.
+	Variables []string
+
+	// VarMap maps the names of the variables in the file to the names of
+	// the Variables, e.g. 2t to t2m for the files named with the GRIB short
+	// names. The variables missing in the map keep their names.
+	VarMap map[string]string
+
+	// Missing is the policy of the values equal to the fill value of their
+	// variable, one of MissingPolicies. Unless they are kept, the scanner
+	// replaces them with NaN, see IsMissing, and the sinks export them
+	// according to the policy. Empty means MissingKeep.
+	Missing string
+
+	// BBox limits the scan to the grid points within the region. Nil means
+	// the whole grid.
+	BBox *BBox
+
+	// SpatialStride makes the scanner read every SpatialStride-th latitude
+	// and longitude of the grid within BBox, starting with the first one,
+	// e.g. 4 turns a 0.25° grid into a 1° grid. Zero or one means every grid
+	// point.
+	SpatialStride int
+
+	// LatitudeOrder is the order the latitudes of the grid are scanned in,
+	// one of LatitudeOrders. The records of a timestamp follow the grid, so
+	// the latitudes are reordered if the file stores them differently. Empty
+	// means the order of the file.
+	LatitudeOrder string
+
+	// SkipAbsentVariables makes NewScanner accept a file that lacks some of
+	// the Variables as long as it holds any of them. The values of the
+	// absent variables are NaN in the records, whatever the Missing policy,
+	// and the variables are reported by Warnings.
+	SkipAbsentVariables bool
+
+	// Deaccumulate are the names of the Variables accumulated over cycles,
+	// e.g. tp of ERA5-Land, whose values are turned into the values of
+	// every time step by subtracting the accumulations of the previous time
+	// step of the file. The packing of the values drops the offset of the
+	// variable, see Packing. The values at the first time step of the file
+	// are missing unless it starts a cycle. The previous time steps are read
+	// once more unless the chunk cache holds them.
+	Deaccumulate []string
+
+	// AccumulationResets are the UTC hours the cycles of the Deaccumulate
+	// variables end at, so the value at the next time step is the first
+	// one of a cycle. Empty means 0, as in ERA5-Land, whose value at 00 UTC
+	// holds the accumulation of the whole previous day.
+	AccumulationResets []int
+
+	// Lon180 maps the longitudes of the grid into the [-180, 180) range, so
+	// 0..359.75 of ERA5 become -180..179.75, and scans them ascending.
+	Lon180 bool
+}
+
+// Scanner retrieves metric value from a file one timestamp at a time.
+type Scanner struct {
+	ncs []api.Group
+	la  []float32
+	lo  []float32
+	// laOrder is the order of the latitudes as stored in the file.
+	laOrder string
+	// laIdx and loIdx hold the indexes of the scanned latitudes and
+	// longitudes within the grid of the file.
+	laIdx []int
+	loIdx []int
+	// timeDim is the name of the time dimension and times holds the time
+	// axis of the file in milliseconds since the epoch.
+	timeDim string
+	times   []int64
+	// levelDim is the name of the vertical dimension and levels holds its
+	// coordinate. They are empty if the variables have no such dimension.
+	levelDim string
+	levels   []float32
+	// expvers are the experiment versions of the expver dimension and
+	// expverOf holds the index of the version holding the data at every
+	// index of the time axis. They are nil if the variables have no expver
+	// dimension. The version holds the data if the first variable the file
+	// holds has values other than its fill value there.
+	expvers       []string
+	expverOf      []int
+	expverFill    float64
+	expverHasFill bool
+	ts            []int64
+	// idx holds the time axis index of every timestamp in ts.
+	idx []int64
+	// varNames are the names of the variables read and fileNames their
+	// names in the file. vars holds a getter of every variable for every
+	// file handle.
+	varNames  []string
+	fileNames []string
+	vars      [][]api.VarGetter
+	// absent tells which variables the file lacks, their getters are nil.
+	// It is nil unless absent variables are skipped. ref is the index of
+	// the first variable the file holds, whose layout is taken for the one
+	// of all the variables.
+	absent []bool
+	ref    int
+	// deacc holds the de-accumulation of every accumulated variable. It is
+	// nil unless any variable is de-accumulated, and so are the others.
+	// resetHours are the UTC hours the accumulation cycles end at.
+	deacc      []*deaccumulation
+	resetHours []int
+	// fill holds the fill values of the variables replaced with NaN,
+	// hasFill tells which variables have one. They are nil if the fill
+	// values are kept.
+	fill    []float64
+	hasFill []bool
+	// labelNames are the names of the auxiliary text coordinates of the
+	// time axis and labels holds their values at every timestamp.
+	labelNames []string
+	labels     map[int64][]string
+	// chunks caches decoded blocks of time steps. It is nil if caching is
+	// disabled.
+	chunks   *chunkCache
+	chunkLen int64
+	// aheadLanes is the number of timestamps read ahead in parallel, each
+	// by its own lane. lanes are started by the first Scan and stopped by
+	// Close. They are nil if the timestamps are read by Scan. reading
+	// tracks the goroutines reading the timestamps in the background.
+	aheadLanes int
+	lanes      []chan laneResult
+	stop       chan struct{}
+	reading    sync.WaitGroup
+	pos        int
+	// scanRows is the number of rows built by a Scan and step holds the
+	// decoded timestamp whose rows starting with row are not built yet.
+	scanRows int
+	step     *step
+	row      int
+	batch    *RecordBatch
+	err      error
+	strict   bool
+	warnings []string
+}
+
+// NewScanner creates a new ERA5 file scanner.
+func NewScanner(filePath string, opts Options) (*Scanner, error) {
+	return NewScannerContext(context.Background(), filePath, opts)
+}
+
+// NewScannerContext is like NewScanner but the reads of remote files are
+// cancelled with ctx, both while the scanner is created and later on.
+func NewScannerContext(ctx context.Context, filePath string, opts Options) (*Scanner, error) {
+	if err := checkMissing(opts.Missing); err != nil {
+		return nil, err
+	}
+	if err := checkLatitudeOrder(opts.LatitudeOrder); err != nil {
+		return nil, err
+	}
+	nc, err := openDataset(ctx, filePath, opts.ReadAhead)
+	if err != nil {
+		return nil, err
+	}
+	s := &Scanner{ncs: []api.Group{nc}, strict: opts.Strict, varNames: opts.Variables}
+	if len(s.varNames) == 0 {
+		s.varNames = VarNames
+	}
+	s.fileNames = s.varNames
+	if len(opts.VarMap) > 0 {
+		s.fileNames = make([]string, len(s.varNames))
+		for i, name := range s.varNames {
+			s.fileNames[i] = name
+			for fileName, mapped := range opts.VarMap {
+				if mapped == name {
+					s.fileNames[i] = fileName
+				}
+			}
+		}
+	}
+	group, err := findGroup(nc, opts.Group, s.fileNames, opts.SkipAbsentVariables)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if opts.SkipAbsentVariables {
+		g, err := getGroup(nc, group)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.absent = absentVars(g, s.fileNames)
+		closeGroup(g, nc)
+		s.ref = slices.Index(s.absent, false)
+		for i, name := range s.fileNames {
+			if s.absent[i] {
+				s.warnings = append(s.warnings, fmt.Sprintf("variable %s is absent, exporting its values as missing", name))
+			}
+		}
+	}
+	s.la, err = coordValues[float32](s, nc, group, "latitude")
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.lo, err = coordValues[float32](s, nc, group, "longitude")
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.laIdx, s.loIdx = indexes(len(s.la)), indexes(len(s.lo))
+	s.laOrder = coordOrder(s.la)
+	if s.laOrder != LatitudeDescending {
+		action := "keeping their order"
+		if opts.LatitudeOrder != "" {
+			action = "scanning them " + opts.LatitudeOrder
+		}
+		if err := s.deviate(action, "latitudes are %s instead of %s", s.laOrder, LatitudeDescending); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	if opts.LatitudeOrder != "" && opts.LatitudeOrder != s.laOrder {
+		sortCoords(s.la, s.laIdx, opts.LatitudeOrder)
+	}
+	if opts.Lon180 {
+		for i, lo := range s.lo {
+			if lo >= 180 {
+				s.lo[i] = lo - 360
+			}
+		}
+		sortCoords(s.lo, s.loIdx, LatitudeAscending)
+	}
+	if b := opts.BBox; b != nil {
+		s.la, s.laIdx = selectCoords(s.la, s.laIdx, b.containsLat)
+		s.lo, s.loIdx = selectCoords(s.lo, s.loIdx, b.containsLon)
+		if len(s.la) == 0 || len(s.lo) == 0 {
+			s.Close()
+			return nil, fmt.Errorf("no grid points within the bounding box %g,%g,%g,%g", b.MinLat, b.MinLon, b.MaxLat, b.MaxLon)
+		}
+	}
+	if opts.SpatialStride > 1 {
+		s.la, s.laIdx = strideCoords(s.la, s.laIdx, opts.SpatialStride)
+		s.lo, s.loIdx = strideCoords(s.lo, s.loIdx, opts.SpatialStride)
+	}
+	axis, times, from, err := timestamps(nc, group)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if from != "" {
+		if err := s.deviate("converting it", "coordinate %s is stored as %s instead of %s", axis.name, from, axis.goType); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	s.timeDim, s.times = axis.name, times
+	s.levelDim, s.levels, err = levelAxis(nc, group, s.fileNames[s.ref])
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.expvers, err = expverAxis(nc, group, s.fileNames[s.ref])
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	labelNames, labelValues, err := timeLabels(nc, group, s.timeDim, len(times))
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if group != rootGroup {
+		g, err := getGroup(nc, group)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		nc.Close()
+		s.ncs[0] = g
+	}
+	// idx holds the indexes of the selected timestamps within the time axis.
+	idx := indexes(len(times))
+	if len(opts.HourIndexes) > 0 {
+		idx = opts.HourIndexes
+	}
+	if !opts.From.IsZero() || !opts.To.IsZero() {
+		from, to := opts.From.UnixMilli(), opts.To.UnixMilli()
+		idx = slices.DeleteFunc(slices.Clone(idx), func(i int) bool {
+			return i >= 0 && i < len(times) && (!opts.From.IsZero() && times[i] < from || !opts.To.IsZero() && times[i] >= to)
+		})
+	}
+	if every := opts.Every.Milliseconds(); every > 0 {
+		idx = slices.DeleteFunc(slices.Clone(idx), func(i int) bool {
+			return i >= 0 && i < len(times) && times[i]%every != 0
+		})
+	}
+	if len(opts.HourIndexes) == 0 && opts.LimitHours > 0 && opts.LimitHours < len(idx) {
+		idx = idx[0:opts.LimitHours]
+	}
+	if opts.Skip != nil {
+		idx = slices.DeleteFunc(slices.Clone(idx), func(i int) bool {
+			return i >= 0 && i < len(times) && opts.Skip(times[i])
+		})
+	}
+	if opts.Parts > 1 {
+		n := len(idx)
+		idx = idx[opts.Part*n/opts.Parts : (opts.Part+1)*n/opts.Parts]
+	}
+	s.idx = make([]int64, len(idx))
+	s.ts = make([]int64, len(idx))
+	for i, hrIndex := range idx {
+		if hrIndex < 0 || hrIndex >= len(times) {
+			s.Close()
+			return nil, fmt.Errorf("hour index %d is out of range [0, %d)", hrIndex, len(times))
+		}
+		s.idx[i] = int64(hrIndex)
+		s.ts[i] = times[hrIndex]
+	}
+	if len(labelNames) > 0 {
+		s.labelNames = labelNames
+		s.labels = make(map[int64][]string, len(s.ts))
+		for i, hrIndex := range idx {
+			values := make([]string, len(labelNames))
+			for j := range labelNames {
+				values[j] = labelValues[j][hrIndex]
+			}
+			s.labels[s.ts[i]] = values
+		}
+	}
+
+	s.aheadLanes = max(opts.ScanAhead, 1)
+	s.scanRows = opts.ScanRows
+	perLane := max(min(opts.Concurrency, len(s.varNames)), 1)
+	for len(s.ncs) < s.aheadLanes*perLane {
+		nc, err := openGroup(ctx, filePath, opts.ReadAhead, group)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.ncs = append(s.ncs, nc)
+	}
+	for h, nc := range s.ncs {
+		vars := make([]api.VarGetter, len(s.varNames))
+		for i, name := range s.fileNames {
+			if s.absent != nil && s.absent[i] {
+				continue
+			}
+			vars[i], err = nc.GetVarGetter(name)
+			err = withHint(err)
+			if err == nil && h == 0 {
+				err = s.checkVar(name, vars[i])
+			}
+			if err != nil {
+				s.Close()
+				return nil, err
+			}
+		}
+		s.vars = append(s.vars, vars)
+	}
+	if len(opts.Deaccumulate) > 0 {
+		s.resetHours = opts.AccumulationResets
+		if len(s.resetHours) == 0 {
+			s.resetHours = []int{0}
+		}
+		for _, h := range s.resetHours {
+			if h < 0 || h > 23 {
+				s.Close()
+				return nil, fmt.Errorf("invalid accumulation reset hour %d, want 0..23", h)
+			}
+		}
+		s.deacc, err = s.newDeaccumulations(opts.Deaccumulate)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	if opts.Missing != "" && opts.Missing != MissingKeep {
+		stored := s.storedFillValues()
+		s.fill = make([]float64, len(s.varNames))
+		s.hasFill = make([]bool, len(s.varNames))
+		for i, name := range s.varNames {
+			s.fill[i], s.hasFill[i] = stored[name]
+		}
+	}
+	if s.expvers != nil {
+		s.expverOf, err = s.resolveExpvers()
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		// The expver dimension is collapsed into a label like the expver
+		// coordinate of the time axis of the new CDS files.
+		if s.labels == nil {
+			s.labels = make(map[int64][]string, len(s.ts))
+		}
+		s.labelNames = append(s.labelNames, expverDim)
+		for i, hrIndex := range s.idx {
+			s.labels[s.ts[i]] = append(s.labels[s.ts[i]], s.expvers[s.expverOf[hrIndex]])
+		}
+	}
+	if opts.ChunkCacheSize > 0 && opts.ChunkTimeSteps > 0 {
+		s.chunks = newChunkCache(opts.ChunkCacheSize)
+		s.chunkLen = int64(opts.ChunkTimeSteps)
+	}
+	return s, nil
+}
+
+// indexes returns the indexes 0, 1, ..., n-1.
+func indexes(n int) []int {
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	return idx
+}
+
+// TimeRange returns the first and the last timestamp of a file in
+// milliseconds since the epoch. group is looked up like Options.Group as the
+// one holding the variables, or any data variables if variables is empty, as
+// with DiscoverVariables.
+func TimeRange(filePath, group string, variables []string) (first, last int64, err error) {
+	nc, err := openDataset(context.Background(), filePath, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer nc.Close()
+	group, err = findGroup(nc, group, variables, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, times, _, err := timestamps(nc, group)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(times) == 0 {
+		return 0, 0, fmt.Errorf("the time axis is empty")
+	}
+	return slices.Min(times), slices.Max(times), nil
+}
+
+// Close closes the scanner.
+func (s *Scanner) Close() {
+	if s.lanes != nil {
+		close(s.stop)
+		s.lanes = nil
+	}
+	s.reading.Wait()
+	for _, nc := range s.ncs {
+		nc.Close()
+	}
+}
+
+// Summary returns the summary information about the dataset suitable for
+// logging.
+func (s *Scanner) Summary() []any {
+	if s.levelDim != "" {
+		return []any{
+			"dims", []string{"ts", "level", "lo", "la"},
+			"metrics", s.varNames,
+			"tsCnt", len(s.ts),
+			"levelCnt", len(s.levels),
+			"laCnt", len(s.la),
+			"loCnt", len(s.lo),
+			"totalRecCnt", s.TotalRecCount(),
+		}
+	}
+	return []any{
+		"dims", []string{"ts", "lo", "la"},
+		"metrics", s.varNames,
+		"tsCnt", len(s.ts),
+		"laCnt", len(s.la),
+		"loCnt", len(s.lo),
+		"totalRecCnt", s.TotalRecCount(),
+	}
+}
+
+// VarNames returns the names of the variables read in the order of the
+// Record values.
+func (s *Scanner) VarNames() []string {
+	return s.varNames
+}
+
+// Latitudes returns the latitudes of the dataset grid.
+func (s *Scanner) Latitudes() []float32 {
+	return s.la
+}
+
+// Levels returns the vertical levels of the dataset. It is nil if the
+// variables have no vertical dimension.
+func (s *Scanner) Levels() []float32 {
+	return s.levels
+}
+
+// LatitudeOrder returns the order of the latitudes as stored in the file, one
+// of LatitudeOrders or LatitudeUnordered. Latitudes returns them in the order
+// they are scanned in.
+func (s *Scanner) LatitudeOrder() string {
+	return s.laOrder
+}
+
+// Longitudes returns the longitudes of the dataset grid.
+func (s *Scanner) Longitudes() []float32 {
+	return s.lo
+}
+
+// FillValues returns the values that mark missing data of the variables by
+// their names as they appear in the records, which is NaN unless the fill
+// values are kept, and always for the variables the file lacks. NaN is missing
+// in any variable, see IsMissing. The variables without the _FillValue or
+// missing_value attribute are absent unless their values are floats, whose
+// missing values are NaN.
+func (s *Scanner) FillValues() map[string]float64 {
+	fill := s.storedFillValues()
+	if s.fill != nil {
+		for name := range fill {
+			fill[name] = math.NaN()
+		}
+	}
+	return fill
+}
+
+// VarMeta describes the meaning of a variable with its attributes.
+type VarMeta struct {
+	// Units and LongName are the units and the long_name attributes, e.g.
+	// m and Total precipitation. They are empty if the variable has none.
+	Units    string
+	LongName string
+}
+
+// VarMeta returns the units and the long names of the variables by their
+// names. The variables absent from the file are left out.
+func (s *Scanner) VarMeta() map[string]VarMeta {
+	meta := make(map[string]VarMeta, len(s.varNames))
+	for i, name := range s.varNames {
+		vg := s.vars[0][i]
+		if vg == nil {
+			continue
+		}
+		var m VarMeta
+		attrs := vg.Attributes()
+		if v, ok := attrs.Get("units"); ok {
+			m.Units, _ = v.(string)
+		}
+		if v, ok := attrs.Get("long_name"); ok {
+			m.LongName, _ = v.(string)
+		}
+		meta[name] = m
+	}
+	return meta
+}
+
+// storedFillValues returns the fill values of the variables by their names as
+// they are stored in the file. The variables the file lacks and the
+// de-accumulated ones have the fill value NaN, and so do the float variables
+// without the attributes.
+func (s *Scanner) storedFillValues() map[string]float64 {
+	fill := make(map[string]float64)
+	for i, name := range s.varNames {
+		vg := s.vars[0][i]
+		if vg == nil || s.deacc != nil && s.deacc[i] != nil {
+			fill[name] = math.NaN()
+			continue
+		}
+		attrs := vg.Attributes()
+		for _, key := range []string{"_FillValue", "missing_value"} {
+			if v, ok := attrs.Get(key); ok {
+				if v, ok := attrFloat64(v); ok {
+					fill[name] = v
+					break
+				}
+			}
+		}
+		if t := vg.GoType(); t == "float32" || t == "float64" {
+			if _, ok := fill[name]; !ok {
+				fill[name] = math.NaN()
+			}
+		}
+	}
+	return fill
+}
+
+// Packing returns the scale_factor and the add_offset attributes of the
+// variables in the order of the Record values, which unpack the stored values
+// into the physical ones as x*scale + offset. The variables without the
+// attributes, such as the ones stored as floats, have the scale of 1 and the
+// offset of 0. The de-accumulated variables have the offset of 0, since the
+// offset cancels out of the differences.
+func (s *Scanner) Packing() (scale, offset []float64) {
+	scale = make([]float64, len(s.varNames))
+	offset = make([]float64, len(s.varNames))
+	for i := range s.varNames {
+		scale[i] = 1
+		if s.vars[0][i] == nil {
+			continue
+		}
+		attrs := s.vars[0][i].Attributes()
+		if v, ok := attrs.Get("scale_factor"); ok {
+			if v, ok := attrFloat64(v); ok {
+				scale[i] = v
+			}
+		}
+		if v, ok := attrs.Get("add_offset"); ok {
+			if v, ok := attrFloat64(v); ok {
+				offset[i] = v
+			}
+		}
+		if s.deacc != nil && s.deacc[i] != nil {
+			offset[i] = 0
+		}
+	}
+	return scale, offset
+}
+
+// Warnings returns the deviations from the ERA5 layout the scanner copes
+// with and the absent variables it skips. The deviations are errors in the
+// strict mode instead.
+func (s *Scanner) Warnings() []string {
+	return s.warnings
+}
+
+// TimeLabels returns the names of the auxiliary text coordinates of the time
+// axis, such as expver, and their values at every scanned timestamp. The
+// values are in the order of the names.
+func (s *Scanner) TimeLabels() ([]string, map[int64][]string) {
+	return s.labelNames, s.labels
+}
+
+// Timestamps returns the timestamps the scanner reads in milliseconds since
+// the epoch.
+func (s *Scanner) Timestamps() []int64 {
+	return s.ts
+}
+
+// RemainingTimestamps returns the timestamps the scanner has yet to read,
+// including the one read in parts by Scan at the moment.
+func (s *Scanner) RemainingTimestamps() []int64 {
+	return s.ts[s.pos:]
+}
+
+// TotalRecCount returns the total number of records within the dataset.
+func (s *Scanner) TotalRecCount() int {
+	return len(s.ts) * max(len(s.levels), 1) * len(s.la) * len(s.lo)
+}
+
+// Seek makes the next Scan read the first of the scanned timestamps at or
+// after the index i of the time axis of the file, which is given like
+// Options.HourIndexes, so an interrupted scan resumes where it stopped instead
+// of from the beginning. The scanner may seek backwards as well. The
+// timestamps are expected in the order of the time axis.
+func (s *Scanner) Seek(i int) error {
+	if i < 0 || i >= len(s.times) {
+		return fmt.Errorf("hour index %d is out of range [0, %d)", i, len(s.times))
+	}
+	// The timestamps read ahead are abandoned.
+	if s.lanes != nil {
+		close(s.stop)
+		s.lanes = nil
+	}
+	s.reading.Wait()
+	s.pos = slices.IndexFunc(s.idx, func(hrIndex int64) bool { return hrIndex >= int64(i) })
+	if s.pos < 0 {
+		s.pos = len(s.ts)
+	}
+	s.step, s.row, s.batch = nil, 0, nil
+	return nil
+}
+
+// Scan reads all records for the next timescamp, or the next Options.ScanRows
+// rows of it. The records of every level make up a contiguous block ordered
+// like the grid. They are retrieved with either Records or Batch.
+func (s *Scanner) Scan() bool {
+	return s.ScanContext(context.Background())
+}
+
+// ScanContext is like Scan but returns false as soon as ctx is done, with
+// Error returning the cause. The timestamp being decoded is abandoned and the
+// scanner cannot be used anymore except for Close, which waits for the
+// decoding to finish.
+func (s *Scanner) ScanContext(ctx context.Context) bool {
+	if s.pos >= len(s.ts) || s.err != nil {
+		return false
+	}
+	if err := context.Cause(ctx); err != nil {
+		s.err = err
+		return false
+	}
+	if s.step == nil {
+		s.step, s.err = s.nextStep(ctx)
+		if s.err != nil {
+			s.step, s.batch = nil, nil
+			return false
+		}
+		s.row = 0
+	}
+	rows := max(len(s.levels), 1) * len(s.la)
+	end := rows
+	if s.scanRows > 0 {
+		end = min(s.row+s.scanRows, rows)
+	}
+	s.batch = s.build(s.step, s.row, end)
+	s.row = end
+	if s.row == rows {
+		s.step = nil
+		s.pos++
+	}
+	return true
+}
+
+// nextStep decodes the timestamp at the current position.
+func (s *Scanner) nextStep(ctx context.Context) (*step, error) {
+	var lane <-chan laneResult
+	switch {
+	case s.aheadLanes > 1:
+		if s.lanes == nil {
+			s.startLanes()
+		}
+		lane = s.lanes[s.pos%len(s.lanes)]
+	case ctx.Done() != nil:
+		// The timestamp is decoded in the background, so that it can be
+		// abandoned.
+		ch := make(chan laneResult, 1)
+		pos := s.pos
+		s.reading.Add(1)
+		go func() {
+			defer s.reading.Done()
+			st, err := s.read(pos, s.vars)
+			ch <- laneResult{step: st, err: err}
+		}()
+		lane = ch
+	default:
+		return s.read(s.pos, s.vars)
+	}
+	select {
+	case r := <-lane:
+		return r.step, r.err
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// laneResult is the outcome of reading a timestamp by a lane.
+type laneResult struct {
+	step *step
+	err  error
+}
+
+// startLanes starts the goroutines that read the timestamps ahead of Scan
+// from the current position on. Every lane reads every len(lanes)-th
+// timestamp through its own file handles, so Scan receives the timestamps in
+// order by taking them from the lanes round-robin.
+func (s *Scanner) startLanes() {
+	s.lanes = make([]chan laneResult, s.aheadLanes)
+	s.stop = make(chan struct{})
+	perLane := len(s.vars) / s.aheadLanes
+	for k := range s.lanes {
+		lane, stop := make(chan laneResult, 1), s.stop
+		s.lanes[k] = lane
+		vars := s.vars[k*perLane : (k+1)*perLane]
+		// The lane reads the positions p with p%len(lanes) == k.
+		first := s.pos + (k-s.pos%s.aheadLanes+s.aheadLanes)%s.aheadLanes
+		s.reading.Add(1)
+		go func() {
+			defer s.reading.Done()
+			for pos := first; pos < len(s.ts); pos += s.aheadLanes {
+				st, err := s.read(pos, vars)
+				select {
+				case lane <- laneResult{step: st, err: err}:
+				case <-stop:
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// step holds the decoded values of the variables at a timestamp.
+type step struct {
+	pos    int
+	values [][][][]float64
+	// first is the index of the first plane of the experiment version
+	// holding the data.
+	first int
+}
+
+// read decodes the values of the timestamp at the position pos through the
+// file handles vars.
+func (s *Scanner) read(pos int, vars [][]api.VarGetter) (*step, error) {
+	values, err := s.scanVars(pos, vars)
+	if err != nil {
+		return nil, err
+	}
+	// The planes of the other experiment versions hold fill values only.
+	var first int
+	if s.expvers != nil {
+		e := s.expverOf[s.idx[pos]]
+		if actual := s.dataExpver(values[s.ref]); actual != e && actual < len(s.expvers) {
+			return nil, fmt.Errorf("the data at %s is in expver %s instead of %s, the experiment versions must follow each other in time",
+				time.UnixMilli(s.ts[pos]).UTC().Format(time.RFC3339), s.expvers[actual], s.expvers[e])
+		}
+		first = e * len(values[s.ref]) / len(s.expvers)
+	}
+	return &step{pos: pos, values: values, first: first}, nil
+}
+
+// build returns the records of the rows [begin, end) of the decoded
+// timestamp. The rows are the latitudes of the grid at every level, so the
+// records of every level make up a contiguous block ordered like the grid.
+func (s *Scanner) build(st *step, begin, end int) *RecordBatch {
+	values := st.values
+	n := (end - begin) * len(s.lo)
+	b := &RecordBatch{
+		Timestamp:  s.ts[st.pos],
+		Latitudes:  make([]float32, n),
+		Longitudes: make([]float32, n),
+		Values:     make([][]float64, len(values)),
+	}
+	if s.levels != nil {
+		b.Levels = make([]float32, n)
+	}
+	// The columns of all the variables share a single allocation.
+	cols := make([]float64, n*len(values))
+	for v := range values {
+		b.Values[v] = cols[v*n : (v+1)*n : (v+1)*n]
+	}
+	nan := math.NaN()
+	k := 0
+	for row := begin; row < end; row++ {
+		l, i := row/len(s.la), row%len(s.la)
+		la := s.la[i]
+		for j, lo := range s.lo {
+			b.Latitudes[k] = la
+			b.Longitudes[k] = lo
+			if b.Levels != nil {
+				b.Levels[k] = s.levels[l]
+			}
+			for v := range values {
+				if values[v] == nil {
+					b.Values[v][k] = nan
+					continue
+				}
+				b.Values[v][k] = values[v][st.first+l][s.laIdx[i]][s.loIdx[j]]
+			}
+			k++
+		}
+	}
+	for v, col := range b.Values {
+		s.replaceMissing(v, col)
+	}
+	return b
+}
+
+// scanVars reads the values of all variables at the position pos. The
+// variables are distributed between the file handles round-robin and each
+// handle is read by its own goroutine.
+func (s *Scanner) scanVars(pos int, handles [][]api.VarGetter) ([][][][]float64, error) {
+	values := make([][][][]float64, len(s.varNames))
+	errs := make([]error, len(handles))
+	var wg sync.WaitGroup
+	for h, vars := range handles {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := h; i < len(vars); i += len(handles) {
+				if vars[i] == nil {
+					// The variable is absent from the file.
+					continue
+				}
+				values[i], errs[h] = s.scan(pos, i, vars[i])
+				if errs[h] != nil {
+					errs[h] = fmt.Errorf("could not read %s: %w", s.fileNames[i], errs[h])
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (s *Scanner) scan(pos, varIndex int, vg api.VarGetter) ([][][]float64, error) {
+	t := s.idx[pos]
+	values, err := s.scanIndex(t, varIndex, vg)
+	if err != nil || s.deacc == nil || s.deacc[varIndex] == nil {
+		return values, err
+	}
+	return s.deaccumulate(t, varIndex, vg, values)
+}
+
+// scanIndex reads the values of the variable at the index t of the time axis
+// as stored.
+func (s *Scanner) scanIndex(t int64, varIndex int, vg api.VarGetter) ([][][]float64, error) {
+	if s.chunks != nil {
+		return s.scanChunk(t, varIndex, vg)
+	}
+	v, err := vg.GetSlice(t, t+1)
+	if err != nil {
+		return nil, err
+	}
+	values, err := timeSteps(v)
+	if err != nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// scanChunk returns the values of the variable at the index idx of the time
+// axis from the chunk cache, reading the whole chunk on a cache miss.
+func (s *Scanner) scanChunk(idx int64, varIndex int, vg api.VarGetter) ([][][]float64, error) {
+	key := chunkKey{varIndex: varIndex, chunkIndex: idx / s.chunkLen}
+	begin := key.chunkIndex * s.chunkLen
+	values := s.chunks.get(key)
+	if values == nil {
+		limit := min(begin+s.chunkLen, vg.Len())
+		v, err := vg.GetSlice(begin, limit)
+		if err != nil {
+			return nil, err
+		}
+		values, err = timeSteps(v)
+		if err != nil {
+			return nil, err
+		}
+		s.chunks.put(key, values)
+	}
+	return values[idx-begin], nil
+}
+
+// Records returns the records that have been read by the last Scan() operation.
+// The function transfers ownership of records to the caller and the subsequent
+// calls to this function without prior invocation of Scan() will return nil.
+func (s *Scanner) Records() []Record {
+	if s.batch == nil {
+		return nil
+	}
+	recs := s.batch.Records()
+	s.batch = nil
+	return recs
+}
+
+// Batch returns the records that have been read by the last Scan() operation
+// column by column. Like Records, it transfers ownership of the batch to the
+// caller and either of them returns nil until the next Scan().
+func (s *Scanner) Batch() *RecordBatch {
+	b := s.batch
+	s.batch = nil
+	return b
+}
+
+// Error returns the error after last scan.
+func (s *Scanner) Error() error {
+	return s.err
+}
@@ -0,0 +1,100 @@
+package era5
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+)
+
+// timeAxis describes a name the time coordinate may have.
+type timeAxis struct {
+	name string
+	// goType is the type the coordinate is stored with.
+	goType string
+	// units are assumed if the coordinate has no units attribute.
+	units string
+}
+
+// timeAxes lists the names of the time coordinate: time in the files of the
+// classic CDS and valid_time in the NetCDF-4 files of the new CDS.
+var timeAxes = []timeAxis{
+	{name: "time", goType: "int32", units: "hours since 1900-01-01 00:00:00"},
+	{name: "valid_time", goType: "int64", units: "seconds since 1970-01-01"},
+}
+
+// timestamps reads the time coordinate of the group and converts it to
+// milliseconds since the epoch according to its units attribute. It returns
+// the axis found and the type the coordinate is stored with if that is not
+// the usual one.
+func timestamps(root api.Group, group string) (axis timeAxis, ts []int64, from string, err error) {
+	var firstErr error
+	for _, axis := range timeAxes {
+		v, attrs, err := varValues(root, group, axis.name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		values, _, err := numberValues[float64](v, axis.name)
+		if err != nil {
+			return axis, nil, "", err
+		}
+		if t := strings.TrimPrefix(fmt.Sprintf("%T", v), "[]"); t != axis.goType {
+			from = t
+		}
+		units := axis.units
+		if u, ok := attrs.Get("units"); ok {
+			if u, ok := u.(string); ok {
+				units = u
+			}
+		}
+		unit, epoch, err := parseTimeUnits(units)
+		if err != nil {
+			return axis, nil, "", fmt.Errorf("could not parse the units of %s: %w", axis.name, err)
+		}
+		ts = make([]int64, len(values))
+		for i, x := range values {
+			ts[i] = epoch.UnixMilli() + int64(math.Round(x*float64(unit/time.Millisecond)))
+		}
+		return axis, ts, from, nil
+	}
+	return timeAxis{}, nil, "", firstErr
+}
+
+// parseTimeUnits parses the CF units of a time coordinate, such as "hours
+// since 1900-01-01 00:00:00.0". Only the standard calendar is supported,
+// which is the one of ERA5.
+func parseTimeUnits(units string) (time.Duration, time.Time, error) {
+	unitName, ref, ok := strings.Cut(strings.TrimSpace(units), " since ")
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("want units of the form '<unit> since <date>', got %q", units)
+	}
+	var unit time.Duration
+	switch strings.ToLower(strings.TrimSpace(unitName)) {
+	case "days", "day", "d":
+		unit = 24 * time.Hour
+	case "hours", "hour", "hrs", "hr", "h":
+		unit = time.Hour
+	case "minutes", "minute", "mins", "min":
+		unit = time.Minute
+	case "seconds", "second", "secs", "sec", "s":
+		unit = time.Second
+	case "milliseconds", "millisecond", "msecs", "msec", "ms":
+		unit = time.Millisecond
+	default:
+		return 0, time.Time{}, fmt.Errorf("unsupported time unit %q", unitName)
+	}
+	ref = strings.TrimSpace(ref)
+	ref = strings.TrimSuffix(strings.TrimSuffix(ref, " UTC"), "Z")
+	ref = strings.Replace(ref, "T", " ", 1)
+	for _, layout := range []string{"2006-1-2 15:4:5", "2006-1-2 15:4", "2006-1-2"} {
+		if epoch, err := time.Parse(layout, ref); err == nil {
+			return unit, epoch, nil
+		}
+	}
+	return 0, time.Time{}, fmt.Errorf("unsupported reference date %q", ref)
+}
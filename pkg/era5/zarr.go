@@ -0,0 +1,841 @@
+package era5
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+	"github.com/batchatco/go-native-netcdf/netcdf/util"
+)
+
+// The keys of the metadata of a Zarr v2 store.
+const (
+	zarrMetadataKey = ".zmetadata"
+	zarrGroupKey    = ".zgroup"
+	zarrArrayKey    = ".zarray"
+	zarrAttrsKey    = ".zattrs"
+)
+
+// zarrStore reads the objects of a Zarr store by their keys.
+type zarrStore interface {
+	// get returns the object with the key or an error wrapping
+	// fs.ErrNotExist if there is none.
+	get(key string) ([]byte, error)
+}
+
+// dirStore is a Zarr store in a local directory.
+type dirStore string
+
+func (d dirStore) get(key string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), filepath.FromSlash(key)))
+}
+
+// remoteStore is a Zarr store in a remote storage, whose objects are read
+// like the remote files.
+type remoteStore struct {
+	ctx context.Context
+	url string
+}
+
+func (s *remoteStore) get(key string) ([]byte, error) {
+	src, err := openRemote(s.ctx, s.url+"/"+key)
+	if err != nil {
+		var re *remoteError
+		if errors.As(err, &re) && re.status == http.StatusNotFound {
+			return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+		}
+		return nil, err
+	}
+	defer src.Close()
+	data := make([]byte, src.Size())
+	if _, err := src.ReadAt(data, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return data, nil
+}
+
+// openZarr opens a Zarr v2 store, a local directory or a prefix of a remote
+// storage, as a NetCDF group. The groups and the arrays of the store become
+// the groups and the variables, whose dimensions are named by the
+// _ARRAY_DIMENSIONS attribute the way xarray writes them, and the fill value
+// of an array becomes its _FillValue attribute. The values are decompressed
+// with Blosc, zlib, gzip, Zstandard or LZ4, and the chunks missing in the
+// store hold the fill value. Remote stores must have consolidated metadata,
+// since the remote storages are not listed.
+func openZarr(ctx context.Context, storePath string) (api.Group, error) {
+	var store zarrStore
+	dir := ""
+	if IsRemote(storePath) {
+		store = &remoteStore{ctx: ctx, url: strings.TrimSuffix(storePath, "/")}
+	} else {
+		store, dir = dirStore(storePath), storePath
+	}
+	keys, meta, err := zarrMetadata(store, dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := meta[zarrGroupKey]; !ok {
+		if _, err := store.get("zarr.json"); err == nil {
+			return nil, errors.New("the store is in the Zarr v3 format, only Zarr v2 stores are supported")
+		}
+		return nil, fmt.Errorf("the store has no %s, only the stores whose root is a group are supported", zarrGroupKey)
+	}
+	root := newMemRoot(nil)
+	groups := map[string]*memGroup{"": root}
+	// group returns the group at the path, adding it and its ancestors
+	// unless they exist.
+	var group func(p string) *memGroup
+	group = func(p string) *memGroup {
+		if g, ok := groups[p]; ok {
+			return g
+		}
+		parent := ""
+		if i := strings.LastIndex(p, "/"); i >= 0 {
+			parent = p[:i]
+		}
+		g := group(parent).addGroup(path.Base(p))
+		groups[p] = g
+		return g
+	}
+	for _, key := range keys {
+		p, name := path.Split(key)
+		p = strings.TrimSuffix(p, "/")
+		switch name {
+		case zarrGroupKey:
+			g := group(p)
+			if attrs, ok := meta[joinKey(p, zarrAttrsKey)]; ok {
+				if g.attrs, _, err = zarrAttrs(attrs); err != nil {
+					return nil, fmt.Errorf("could not parse the attributes of %s: %w", key, err)
+				}
+			}
+		case zarrArrayKey:
+			if p == "" {
+				return nil, errors.New("the root of the store is an array, only the stores whose root is a group are supported")
+			}
+			v, err := newZarrVar(store, p, meta[key], meta[joinKey(p, zarrAttrsKey)])
+			if err != nil {
+				return nil, fmt.Errorf("could not read array %s: %w", p, err)
+			}
+			if v == nil {
+				continue
+			}
+			parent, _ := path.Split(p)
+			g := group(strings.TrimSuffix(parent, "/"))
+			g.vars = append(g.vars, v)
+		}
+	}
+	return root, nil
+}
+
+func joinKey(p, name string) string {
+	if p == "" {
+		return name
+	}
+	return p + "/" + name
+}
+
+// zarrMetadata returns the keys of the metadata objects of a store in the
+// order they are stored and the objects by their keys. They are read from
+// the consolidated metadata if the store has it and found by walking the
+// directory of a local store otherwise, which is empty for the remote ones.
+func zarrMetadata(store zarrStore, dir string) ([]string, map[string]json.RawMessage, error) {
+	data, err := store.get(zarrMetadataKey)
+	if err == nil {
+		var consolidated struct {
+			Metadata json.RawMessage `json:"metadata"`
+		}
+		if err := json.Unmarshal(data, &consolidated); err != nil {
+			return nil, nil, fmt.Errorf("could not parse %s: %w", zarrMetadataKey, err)
+		}
+		keys, meta, err := orderedObject(consolidated.Metadata)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not parse %s: %w", zarrMetadataKey, err)
+		}
+		return keys, meta, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+	if dir == "" {
+		return nil, nil, fmt.Errorf("the store has no consolidated metadata, which the remote stores need since they are not listed; "+
+			"consolidate it with zarr.consolidate_metadata, which writes %s", zarrMetadataKey)
+	}
+	var keys []string
+	meta := make(map[string]json.RawMessage)
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		isArray := false
+		for _, name := range []string{zarrGroupKey, zarrArrayKey, zarrAttrsKey} {
+			data, err := os.ReadFile(filepath.Join(p, name))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			key := name
+			if rel != "." {
+				key = filepath.ToSlash(rel) + "/" + name
+			}
+			keys = append(keys, key)
+			meta[key] = data
+			isArray = isArray || name == zarrArrayKey
+		}
+		if isArray {
+			// The directories of an array hold its chunks.
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys, meta, nil
+}
+
+// orderedObject returns the keys of a JSON object in their order and the
+// values by the keys.
+func orderedObject(data []byte) ([]string, map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, nil, errors.New("want a JSON object")
+	}
+	var keys []string
+	values := make(map[string]json.RawMessage)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key := t.(string)
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, nil, err
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = v
+	}
+	return keys, values, nil
+}
+
+// zarrAttrs converts the attributes of a group or an array. It returns the
+// dimensions of an array from _ARRAY_DIMENSIONS, which is not an attribute.
+func zarrAttrs(data json.RawMessage) (*util.OrderedMap, []string, error) {
+	attrs := newAttrs()
+	if len(data) == 0 {
+		return attrs, nil, nil
+	}
+	keys, values, err := orderedObject(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var dims []string
+	for _, key := range keys {
+		if key == "_ARRAY_DIMENSIONS" {
+			if err := json.Unmarshal(values[key], &dims); err != nil {
+				return nil, nil, fmt.Errorf("invalid _ARRAY_DIMENSIONS: %w", err)
+			}
+			continue
+		}
+		attrs.Add(key, attrValue(values[key]))
+	}
+	return attrs, dims, nil
+}
+
+// attrValue converts a JSON attribute the way the NetCDF reader returns the
+// attributes: the integers as int64, the other numbers as float64, the
+// strings as string and the lists of them as slices. The other values are
+// kept as the JSON text.
+func attrValue(raw json.RawMessage) any {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return string(raw)
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return numberValue(v)
+	case bool:
+		return v
+	case []any:
+		if len(v) == 0 {
+			return string(raw)
+		}
+		if strs, ok := convertAll[string](v, func(x any) (string, bool) { s, ok := x.(string); return s, ok }); ok {
+			return strs
+		}
+		if ints, ok := convertAll[int64](v, func(x any) (int64, bool) {
+			n, ok := x.(json.Number)
+			if !ok {
+				return 0, false
+			}
+			i, ok := numberValue(n).(int64)
+			return i, ok
+		}); ok {
+			return ints
+		}
+		if floats, ok := convertAll[float64](v, func(x any) (float64, bool) {
+			n, ok := x.(json.Number)
+			if !ok {
+				return 0, false
+			}
+			f, err := n.Float64()
+			return f, err == nil
+		}); ok {
+			return floats
+		}
+	}
+	return string(raw)
+}
+
+func numberValue(n json.Number) any {
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+func convertAll[T any](values []any, conv func(any) (T, bool)) ([]T, bool) {
+	dst := make([]T, len(values))
+	for i, v := range values {
+		var ok bool
+		if dst[i], ok = conv(v); !ok {
+			return nil, false
+		}
+	}
+	return dst, true
+}
+
+// zarrArrayMeta is the .zarray metadata of an array.
+type zarrArrayMeta struct {
+	Shape              []int             `json:"shape"`
+	Chunks             []int             `json:"chunks"`
+	Dtype              json.RawMessage   `json:"dtype"`
+	Compressor         *zarrCodec        `json:"compressor"`
+	FillValue          json.RawMessage   `json:"fill_value"`
+	Order              string            `json:"order"`
+	Filters            []json.RawMessage `json:"filters"`
+	DimensionSeparator string            `json:"dimension_separator"`
+}
+
+type zarrCodec struct {
+	ID string `json:"id"`
+}
+
+// zarrType is the data type of an array, e.g. <f4.
+type zarrType struct {
+	kind   byte
+	size   int
+	order  binary.ByteOrder
+	goType string
+}
+
+func parseZarrType(raw json.RawMessage) (zarrType, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return zarrType{}, fmt.Errorf("structured data type %s is not supported", raw)
+	}
+	if len(s) < 3 {
+		return zarrType{}, fmt.Errorf("invalid data type %q", s)
+	}
+	t := zarrType{kind: s[1], order: binary.LittleEndian}
+	if s[0] == '>' {
+		t.order = binary.BigEndian
+	}
+	size, err := strconv.Atoi(s[2:])
+	if err != nil || size <= 0 {
+		return zarrType{}, fmt.Errorf("invalid data type %q", s)
+	}
+	t.size = size
+	switch {
+	case t.kind == 'f' && size == 4:
+		t.goType = "float32"
+	case t.kind == 'f' && size == 8:
+		t.goType = "float64"
+	case t.kind == 'i' && size == 1:
+		t.goType = "int8"
+	case t.kind == 'i' && size == 2:
+		t.goType = "int16"
+	case t.kind == 'i' && size == 4:
+		t.goType = "int32"
+	case t.kind == 'i' && size == 8:
+		t.goType = "int64"
+	case t.kind == 'u' && size == 1:
+		t.goType = "uint8"
+	case t.kind == 'u' && size == 2:
+		t.goType = "uint16"
+	case t.kind == 'u' && size == 4:
+		t.goType = "uint32"
+	case t.kind == 'u' && size == 8:
+		t.goType = "uint64"
+	case t.kind == 'S':
+		t.goType = "string"
+	case t.kind == 'U':
+		// The characters are UTF-32 code units.
+		t.goType = "string"
+		t.size *= 4
+	default:
+		return zarrType{}, fmt.Errorf("data type %q is not supported", s)
+	}
+	return t, nil
+}
+
+// zarrArray reads the chunks of an array.
+type zarrArray struct {
+	store  zarrStore
+	path   string
+	shape  []int
+	chunks []int
+	dtype  zarrType
+	// fill is the fill value converted to the type of the values, nil if
+	// the array has none.
+	fill       any
+	sep        string
+	decompress func([]byte) ([]byte, error)
+}
+
+// newZarrVar returns the variable of the array at the path p of the store.
+// It returns nil for the scalars, which the scanner has no use for.
+func newZarrVar(store zarrStore, p string, arrayMeta, attrsMeta json.RawMessage) (*memVar, error) {
+	var m zarrArrayMeta
+	if err := json.Unmarshal(arrayMeta, &m); err != nil {
+		return nil, err
+	}
+	if len(m.Shape) == 0 {
+		return nil, nil
+	}
+	if len(m.Chunks) != len(m.Shape) || slices.Contains(m.Chunks, 0) {
+		return nil, fmt.Errorf("invalid chunks %v of shape %v", m.Chunks, m.Shape)
+	}
+	if m.Order != "" && m.Order != "C" {
+		return nil, fmt.Errorf("the order %s is not supported, only C is", m.Order)
+	}
+	if len(m.Filters) > 0 {
+		return nil, errors.New("filters are not supported")
+	}
+	dtype, err := parseZarrType(m.Dtype)
+	if err != nil {
+		return nil, err
+	}
+	a := &zarrArray{store: store, path: p, shape: m.Shape, chunks: m.Chunks, dtype: dtype, sep: cmpOr(m.DimensionSeparator, ".")}
+	if m.Compressor != nil {
+		if a.decompress, err = zarrDecompressor(m.Compressor.ID); err != nil {
+			return nil, err
+		}
+	}
+	if dtype.goType != "string" {
+		if a.fill, err = zarrFill(m.FillValue, dtype); err != nil {
+			return nil, err
+		}
+	} else if len(m.Shape) != 1 {
+		return nil, errors.New("only the one-dimensional arrays of strings are supported")
+	}
+	attrs, dims, err := zarrAttrs(attrsMeta)
+	if err != nil {
+		return nil, err
+	}
+	if len(dims) != len(m.Shape) {
+		// The arrays not written by xarray have no dimension names.
+		dims = make([]string, len(m.Shape))
+		for i := range dims {
+			dims[i] = fmt.Sprintf("%s_dim_%d", path.Base(p), i)
+		}
+	}
+	if a.fill != nil {
+		if _, ok := attrs.Get("_FillValue"); !ok {
+			attrs.Add("_FillValue", a.fill)
+		}
+	}
+	return &memVar{
+		name:   path.Base(p),
+		dims:   dims,
+		attrs:  attrs,
+		goType: dtype.goType,
+		len:    int64(m.Shape[0]),
+		read:   a.read,
+	}, nil
+}
+
+// zarrDecompressor returns the decompression of the Zarr codec with the ID.
+func zarrDecompressor(id string) (func([]byte) ([]byte, error), error) {
+	switch id {
+	case "blosc":
+		return bloscDecompress, nil
+	case "zlib":
+		return func(src []byte) ([]byte, error) {
+			zr, err := zlib.NewReader(bytes.NewReader(src))
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			return io.ReadAll(zr)
+		}, nil
+	case "gzip":
+		return func(src []byte) ([]byte, error) {
+			zr, err := gzip.NewReader(bytes.NewReader(src))
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			return io.ReadAll(zr)
+		}, nil
+	case "zstd":
+		return func(src []byte) ([]byte, error) {
+			return zstdDecoder.DecodeAll(src, nil)
+		}, nil
+	case "lz4":
+		// The LZ4 codec of numcodecs prefixes the block with the size of
+		// the decompressed data.
+		return func(src []byte) ([]byte, error) {
+			if len(src) < 4 {
+				return nil, errors.New("the LZ4 chunk is shorter than its header")
+			}
+			dst := make([]byte, binary.LittleEndian.Uint32(src))
+			if err := lz4Decompress(dst, src[4:]); err != nil {
+				return nil, err
+			}
+			return dst, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("the compressor %s is not supported, only blosc, zlib, gzip, zstd and lz4 are", id)
+}
+
+// zarrFill returns the fill value of an array converted to the type of its
+// values or nil if the array has none.
+func zarrFill(raw json.RawMessage, t zarrType) (any, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var x float64
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		switch s {
+		case "NaN":
+			x = math.NaN()
+		case "Infinity":
+			x = math.Inf(1)
+		case "-Infinity":
+			x = math.Inf(-1)
+		default:
+			return nil, fmt.Errorf("invalid fill value %s", raw)
+		}
+	} else if err := json.Unmarshal(raw, &x); err != nil {
+		return nil, fmt.Errorf("invalid fill value %s", raw)
+	}
+	switch t.goType {
+	case "float32":
+		return float32(x), nil
+	case "float64":
+		return x, nil
+	case "int8":
+		return int8(x), nil
+	case "int16":
+		return int16(x), nil
+	case "int32":
+		return int32(x), nil
+	case "int64":
+		return int64(x), nil
+	case "uint8":
+		return uint8(x), nil
+	case "uint16":
+		return uint16(x), nil
+	case "uint32":
+		return uint32(x), nil
+	case "uint64":
+		return uint64(x), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t.goType)
+}
+
+// read returns the values at the indexes [begin, end) of the first
+// dimension.
+func (a *zarrArray) read(begin, end int64) (any, error) {
+	switch a.dtype.goType {
+	case "float32":
+		return readZarr[float32](a, begin, end)
+	case "float64":
+		return readZarr[float64](a, begin, end)
+	case "int8":
+		return readZarr[int8](a, begin, end)
+	case "int16":
+		return readZarr[int16](a, begin, end)
+	case "int32":
+		return readZarr[int32](a, begin, end)
+	case "int64":
+		return readZarr[int64](a, begin, end)
+	case "uint8":
+		return readZarr[uint8](a, begin, end)
+	case "uint16":
+		return readZarr[uint16](a, begin, end)
+	case "uint32":
+		return readZarr[uint32](a, begin, end)
+	case "uint64":
+		return readZarr[uint64](a, begin, end)
+	}
+	return a.readStrings(begin, end)
+}
+
+// chunk returns the decompressed chunk at the index of the chunk grid or nil
+// if the store lacks it.
+func (a *zarrArray) chunk(idx []int) ([]byte, error) {
+	parts := make([]string, len(idx))
+	for i, x := range idx {
+		parts[i] = strconv.Itoa(x)
+	}
+	key := a.path + "/" + strings.Join(parts, a.sep)
+	data, err := a.store.get(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if a.decompress != nil {
+		if data, err = a.decompress(data); err != nil {
+			return nil, fmt.Errorf("could not decompress chunk %s: %w", key, err)
+		}
+	}
+	if want := product(a.chunks) * a.dtype.size; len(data) != want {
+		return nil, fmt.Errorf("chunk %s holds %d bytes instead of %d", key, len(data), want)
+	}
+	return data, nil
+}
+
+func product(values []int) int {
+	n := 1
+	for _, v := range values {
+		n *= v
+	}
+	return n
+}
+
+// forChunks calls fn with the index of every chunk holding the values at
+// the indexes [begin, end) of the first dimension.
+func (a *zarrArray) forChunks(begin, end int64, fn func(idx []int) error) error {
+	lo := make([]int, len(a.shape))
+	hi := make([]int, len(a.shape))
+	for d := range a.shape {
+		if a.shape[d] == 0 {
+			return nil
+		}
+		hi[d] = (a.shape[d] - 1) / a.chunks[d]
+	}
+	if begin >= end {
+		return nil
+	}
+	lo[0], hi[0] = int(begin)/a.chunks[0], int(end-1)/a.chunks[0]
+	idx := slices.Clone(lo)
+	for {
+		if err := fn(idx); err != nil {
+			return err
+		}
+		d := len(idx) - 1
+		for ; d >= 0 && idx[d] == hi[d]; d-- {
+			idx[d] = lo[d]
+		}
+		if d < 0 {
+			return nil
+		}
+		idx[d]++
+	}
+}
+
+// readZarr reads the values of an array of numbers at the indexes [begin,
+// end) of the first dimension into nested slices.
+func readZarr[T number](a *zarrArray, begin, end int64) (any, error) {
+	shape := slices.Clone(a.shape)
+	shape[0] = int(end - begin)
+	out := make([]T, product(shape))
+	if fill, ok := a.fill.(T); ok && fill != 0 {
+		for i := range out {
+			out[i] = fill
+		}
+	}
+	sel := make([]int, len(shape))
+	sel[0] = int(begin)
+	err := a.forChunks(begin, end, func(idx []int) error {
+		raw, err := a.chunk(idx)
+		if err != nil || raw == nil {
+			return err
+		}
+		copyChunk(out, shape, sel, decodeValues[T](raw, a.dtype), a.chunks, idx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nestValues(out, shape)
+}
+
+// copyChunk copies the values of the chunk at the index idx of the chunk
+// grid that fall into the selection of the shape starting at sel into out.
+func copyChunk[T any](out []T, shape, sel []int, values []T, chunks, idx []int) {
+	r := len(shape)
+	start := make([]int, r)
+	stop := make([]int, r)
+	for d := range r {
+		start[d] = max(sel[d], idx[d]*chunks[d])
+		stop[d] = min(sel[d]+shape[d], (idx[d]+1)*chunks[d])
+		if start[d] >= stop[d] {
+			return
+		}
+	}
+	pos := slices.Clone(start)
+	for {
+		srcOff, dstOff := 0, 0
+		srcStride, dstStride := 1, 1
+		for d := r - 1; d >= 0; d-- {
+			srcOff += (pos[d] - idx[d]*chunks[d]) * srcStride
+			dstOff += (pos[d] - sel[d]) * dstStride
+			srcStride *= chunks[d]
+			dstStride *= shape[d]
+		}
+		n := stop[r-1] - start[r-1]
+		copy(out[dstOff:dstOff+n], values[srcOff:srcOff+n])
+		d := r - 2
+		for ; d >= 0 && pos[d] == stop[d]-1; d-- {
+			pos[d] = start[d]
+		}
+		if d < 0 {
+			return
+		}
+		pos[d]++
+	}
+}
+
+// decodeValues converts the bytes of a chunk into its values.
+func decodeValues[T number](raw []byte, t zarrType) []T {
+	values := make([]T, len(raw)/t.size)
+	switch v := any(values).(type) {
+	case []float32:
+		for i := range v {
+			v[i] = math.Float32frombits(t.order.Uint32(raw[4*i:]))
+		}
+	case []float64:
+		for i := range v {
+			v[i] = math.Float64frombits(t.order.Uint64(raw[8*i:]))
+		}
+	case []int8:
+		for i := range v {
+			v[i] = int8(raw[i])
+		}
+	case []uint8:
+		copy(v, raw)
+	case []int16:
+		for i := range v {
+			v[i] = int16(t.order.Uint16(raw[2*i:]))
+		}
+	case []uint16:
+		for i := range v {
+			v[i] = t.order.Uint16(raw[2*i:])
+		}
+	case []int32:
+		for i := range v {
+			v[i] = int32(t.order.Uint32(raw[4*i:]))
+		}
+	case []uint32:
+		for i := range v {
+			v[i] = t.order.Uint32(raw[4*i:])
+		}
+	case []int64:
+		for i := range v {
+			v[i] = int64(t.order.Uint64(raw[8*i:]))
+		}
+	case []uint64:
+		for i := range v {
+			v[i] = t.order.Uint64(raw[8*i:])
+		}
+	}
+	return values
+}
+
+// nestValues turns the values of an array stored row by row into the nested
+// slices the NetCDF reader returns, e.g. [][][]float32 for three dimensions.
+func nestValues[T any](flat []T, shape []int) (any, error) {
+	if slices.Contains(shape[1:], 0) {
+		return nil, fmt.Errorf("empty dimensions of shape %v are not supported", shape)
+	}
+	switch len(shape) {
+	case 1:
+		return flat, nil
+	case 2:
+		return split(flat, shape[1]), nil
+	case 3:
+		return split(split(flat, shape[2]), shape[1]), nil
+	case 4:
+		return split(split(split(flat, shape[3]), shape[2]), shape[1]), nil
+	case 5:
+		return split(split(split(split(flat, shape[4]), shape[3]), shape[2]), shape[1]), nil
+	}
+	return nil, fmt.Errorf("arrays with %d dimensions are not supported", len(shape))
+}
+
+// split splits s into slices of n elements.
+func split[E any](s []E, n int) [][]E {
+	dst := make([][]E, len(s)/n)
+	for i := range dst {
+		dst[i] = s[i*n : (i+1)*n : (i+1)*n]
+	}
+	return dst
+}
+
+// readStrings reads the values of a one-dimensional array of strings. The
+// strings are padded with zeros to the size of the type.
+func (a *zarrArray) readStrings(begin, end int64) (any, error) {
+	out := make([]string, end-begin)
+	err := a.forChunks(begin, end, func(idx []int) error {
+		raw, err := a.chunk(idx)
+		if err != nil || raw == nil {
+			return err
+		}
+		first := idx[0] * a.chunks[0]
+		for i := max(int(begin), first); i < min(int(end), first+a.chunks[0]); i++ {
+			b := raw[(i-first)*a.dtype.size : (i-first+1)*a.dtype.size]
+			if a.dtype.kind == 'U' {
+				var sb strings.Builder
+				for k := 0; k < len(b); k += 4 {
+					if r := rune(a.dtype.order.Uint32(b[k:])); r != 0 && utf8.ValidRune(r) {
+						sb.WriteRune(r)
+					}
+				}
+				out[i-int(begin)] = sb.String()
+			} else {
+				out[i-int(begin)] = string(bytes.TrimRight(b, "\x00"))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
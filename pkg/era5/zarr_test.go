@@ -0,0 +1,126 @@
+package era5
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// lz4Literals encodes data as an LZ4 block holding literals only.
+func lz4Literals(data []byte) []byte {
+	n := len(data)
+	if n < 15 {
+		return append([]byte{byte(n << 4)}, data...)
+	}
+	block := []byte{0xf0}
+	for n -= 15; n >= 255; n -= 255 {
+		block = append(block, 255)
+	}
+	return append(append(block, byte(n)), data...)
+}
+
+// writeZarrStore writes a store laid out like ARCO-ERA5, whose variable t2m
+// of the shape 3x2x3 is split into chunks of 2x2x2 compressed with the LZ4
+// codec of numcodecs. The chunk holding the last time step of the last
+// longitude is missing.
+func writeZarrStore(t *testing.T) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "era5.zarr")
+	write := func(key string, data []byte) {
+		p := filepath.Join(dir, filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".zgroup", []byte(`{"zarr_format": 2}`))
+	write(".zattrs", []byte(`{"title": "ERA5"}`))
+	coord := func(name, dtype string, n int, attrs string, data []byte) {
+		write(name+"/.zarray", []byte(`{"zarr_format": 2, "shape": [`+strconv.Itoa(n)+`], "chunks": [`+strconv.Itoa(n)+`], "dtype": "`+dtype+`", "compressor": null, "fill_value": null, "order": "C", "filters": null}`))
+		write(name+"/.zattrs", []byte(`{"_ARRAY_DIMENSIONS": ["`+name+`"]`+attrs+`}`))
+		write(name+"/0", data)
+	}
+	var times, lats, lons []byte
+	for _, h := range []int64{1000000, 1000001, 1000002} {
+		times = binary.LittleEndian.AppendUint64(times, uint64(h))
+	}
+	for _, x := range []float32{10, 9} {
+		lats = binary.LittleEndian.AppendUint32(lats, math.Float32bits(x))
+	}
+	for _, x := range []float32{0, 1, 2} {
+		lons = binary.LittleEndian.AppendUint32(lons, math.Float32bits(x))
+	}
+	coord("time", "<i8", 3, `, "units": "hours since 1900-01-01"`, times)
+	coord("latitude", "<f4", 2, `, "units": "degrees_north"`, lats)
+	coord("longitude", "<f4", 3, `, "units": "degrees_east"`, lons)
+
+	write("t2m/.zarray", []byte(`{"zarr_format": 2, "shape": [3, 2, 3], "chunks": [2, 2, 2], "dtype": "<f4", "compressor": {"id": "lz4", "acceleration": 1}, "fill_value": "NaN", "order": "C", "filters": null}`))
+	write("t2m/.zattrs", []byte(`{"_ARRAY_DIMENSIONS": ["time", "latitude", "longitude"], "units": "K"}`))
+	for ct := range 2 {
+		for ci := range 2 {
+			if ct == 1 && ci == 1 {
+				continue
+			}
+			var chunk []byte
+			for k := range 2 {
+				for j := range 2 {
+					for l := range 2 {
+						tt, i := 2*ct+k, 2*ci+l
+						x := float32(0)
+						if tt < 3 && i < 3 {
+							x = zarrValue(tt, j, i)
+						}
+						chunk = binary.LittleEndian.AppendUint32(chunk, math.Float32bits(x))
+					}
+				}
+			}
+			data := binary.LittleEndian.AppendUint32(nil, uint32(len(chunk)))
+			write("t2m/"+strconv.Itoa(ct)+".0."+strconv.Itoa(ci), append(data, lz4Literals(chunk)...))
+		}
+	}
+	return dir
+}
+
+func zarrValue(t, j, i int) float32 {
+	return float32(200 + 10*t + 3*j + i)
+}
+
+func TestZarr(t *testing.T) {
+	got, s := scanPhysical(t, writeZarrStore(t), "t2m")
+	if lons := s.Longitudes(); len(lons) != 3 || lons[2] != 2 {
+		t.Fatalf("got the longitudes %v, want [0 1 2]", lons)
+	}
+	epoch := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+	for tt := range 3 {
+		ts := epoch.Add(time.Duration(1000000+tt) * time.Hour).UnixMilli()
+		var want []float64
+		for j := range 2 {
+			for i := range 3 {
+				x := float64(zarrValue(tt, j, i))
+				if tt == 2 && i == 2 {
+					x = math.NaN()
+				}
+				want = append(want, x)
+			}
+		}
+		checkPhysical(t, ts, got[ts], want, 0)
+	}
+}
+func TestLZ4Decompress(t *testing.T) {
+	// The literals abc, a match of 6 bytes at the offset 3 and the last
+	// literals xyz.
+	src := []byte{0x32, 'a', 'b', 'c', 3, 0, 0x30, 'x', 'y', 'z'}
+	dst := make([]byte, 12)
+	if err := lz4Decompress(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if string(dst) != "abcabcabcxyz" {
+		t.Fatalf("got %q, want %q", dst, "abcabcabcxyz")
+	}
+}
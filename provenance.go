@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// infoInserter is implemented by the sinks that can record the provenance of
+// an export as an info metric.
+type infoInserter interface {
+	InsertInfo(ctx context.Context, name string, names, values []string, ts time.Time) error
+}
+
+// markerStore is implemented by the sinks that keep the completion markers
+// written by -markers.
+type markerStore interface {
+	infoInserter
+	InfoTimestamps(ctx context.Context, name string, names, values []string) ([]int64, error)
+}
+
+// markerName is the name of the completion marker metric without the metric
+// prefix.
+const markerName = "export_done"
+
+// markerLabels returns the labels of the completion markers of a file.
+func markerLabels(file string) (names, values []string) {
+	return []string{"file"}, []string{filepath.Base(file)}
+}
+
+// doneTimestamps returns the timestamps of the file that have completion
+// markers.
+func doneTimestamps(ctx context.Context, ms markerStore, file string) (map[int64]bool, error) {
+	names, values := markerLabels(file)
+	tss, err := ms.InfoTimestamps(ctx, markerName, names, values)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int64]bool, len(tss))
+	for _, ts := range tss {
+		done[ts] = true
+	}
+	return done, nil
+}
+
+// newRunID returns a random ID that tells the runs of the exporter apart.
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// buildVersion returns the version of the exporter binary: the module
+// version if it has been installed with go install and the VCS revision
+// otherwise.
+func buildVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := bi.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+	return "devel"
+}
+
+// fileSHA256 returns the hex-encoded SHA-256 hash of the file contents.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeExportInfo writes the era5_export_info metric that traces the data of
+// the job back to the export run: the exporter version, the file and its
+// hash, the settings that select the exported data and the run ID.
+func (e *exporter) writeExportInfo(ctx context.Context, logger *slog.Logger, job exportJob) {
+	ins, ok := job.ins.(infoInserter)
+	if !ok {
+		return
+	}
+	// Remote files are not hashed, since that would download them once more,
+	// and neither are Zarr stores, which are directories of chunks.
+	var hash string
+	if !era5.IsRemote(job.file) && !era5.IsZarrStore(job.file) {
+		var err error
+		hash, err = fileSHA256(job.file)
+		if err != nil {
+			logger.Warn("Could not hash the file for the export info", "err", err)
+			return
+		}
+	}
+	names := []string{"version", "run_id", "file", "file_sha256", "hours", "limit_hours", "from", "to", "every", "bbox", "spatial_stride", "where", "transform"}
+	values := []string{buildVersion(), e.runID, job.file, hash, *hours, strconv.Itoa(*limitHours), *fromTime, *toTime, every.String(), *bbox, strconv.Itoa(*spatialStride), *where, *transforms}
+	if err := ins.InsertInfo(ctx, "export_info", names, values, time.Now()); err != nil {
+		logger.Warn("Could not write the export info", "err", err)
+		return
+	}
+	logger.Info("Export info written", "runId", e.runID, "fileSha256", fmt.Sprintf("%.12s", hash))
+}
+
+// provenanceLabelNames lists the labels -provenanceLabels may attach to the
+// exported series.
+var provenanceLabelNames = []string{"source_file", "dataset", "run_id"}
+
+// parseProvenanceLabels parses the comma-separated names of the provenance
+// labels.
+func parseProvenanceLabels(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(provenanceLabelNames, name) {
+			return nil, fmt.Errorf("unknown label %q, want one of %v", name, provenanceLabelNames)
+		}
+		if slices.Contains(names, name) {
+			return nil, fmt.Errorf("duplicate label %q", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// provenanceLabels returns the values of the provenance labels of the series
+// exported from the file.
+func (e *exporter) provenanceLabels(file string) []string {
+	values := make([]string, len(e.provenance))
+	for i, name := range e.provenance {
+		switch name {
+		case "source_file":
+			values[i] = filepath.Base(file)
+		case "dataset":
+			values[i] = e.dataset
+		case "run_id":
+			values[i] = e.runID
+		}
+	}
+	return values
+}
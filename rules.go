@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rtm0/era5/internal/vm"
+)
+
+// region is a latitude/longitude box the recording rules aggregate over.
+// Longitudes are in the [-180, 180) range and loFrom may exceed loTo for the
+// boxes that cross the antimeridian.
+type region struct {
+	name         string
+	laFrom, laTo float64
+	loFrom, loTo float64
+}
+
+var ruleRegions = []region{
+	{"europe", 35, 72, -25, 45},
+	{"africa", -35, 37, -18, 52},
+	{"asia", 5, 78, 45, 180},
+	{"north_america", 7, 84, -168, -52},
+	{"south_america", -56, 13, -82, -34},
+	{"oceania", -48, 0, 110, 180},
+}
+
+// runRules implements the rules command that writes vmalert recording and
+// alerting rules for the exported metrics.
+func runRules(args []string) error {
+	fs := flag.NewFlagSet("rules", flag.ExitOnError)
+	metricPrefix := fs.String("metricPrefix", "era5", "the metric prefix the data has been exported with")
+	out := fs.String("o", "-", "file to write the rules to. Default: stdout")
+	lon360 := fs.Bool("lon360", true, "whether the exported longitudes are in the [0, 360) range as in the ERA5 files from CDS. Otherwise [-180, 180) is assumed")
+	heat := fs.Float64("heatThreshold", 318.15, "2m temperature above which the extreme heat alert fires, in the units of the exported values")
+	cold := fs.Float64("coldThreshold", 233.15, "2m temperature below which the extreme cold alert fires, in the units of the exported values")
+	precip := fs.Float64("precipitationThreshold", 0.01, "hourly total precipitation above which the heavy precipitation alert fires, in the units of the exported values")
+	fs.Parse(args)
+
+	if err := vm.CheckMetricPrefix(*metricPrefix); err != nil {
+		return err
+	}
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	g := &rulesGen{prefix: *metricPrefix, lon360: *lon360}
+	_, err := io.WriteString(w, g.rules(*heat, *cold, *precip))
+	return err
+}
+
+// rulesGen builds vmalert rules in the YAML format.
+type rulesGen struct {
+	prefix string
+	lon360 bool
+}
+
+func (g *rulesGen) metric(varName string) string {
+	return g.prefix + "_" + varName
+}
+
+// inRegion returns the condition that selects the series of the metric
+// located within the region.
+func (g *rulesGen) inRegion(metric string, r region) string {
+	la := fmt.Sprintf(`label_value(%s, "la")`, metric)
+	lo := fmt.Sprintf(`label_value(%s, "lo")`, metric)
+	loFrom, loTo := r.loFrom, r.loTo
+	if g.lon360 {
+		if loFrom < 0 {
+			loFrom += 360
+		}
+		if loTo < 0 {
+			loTo += 360
+		}
+	}
+	loCond := fmt.Sprintf("%s >= %g <= %g", lo, loFrom, loTo)
+	if loFrom > loTo {
+		loCond = fmt.Sprintf("%s >= %g or %s <= %g", lo, loFrom, lo, loTo)
+	}
+	return fmt.Sprintf("(%s >= %g <= %g) and (%s)", la, r.laFrom, r.laTo, loCond)
+}
+
+func (g *rulesGen) rules(heat, cold, precip float64) string {
+	var sb strings.Builder
+	t2m := g.metric("t2m")
+	tp := g.metric("tp")
+
+	sb.WriteString("groups:\n")
+	fmt.Fprintf(&sb, "  - name: %s-daily-temperature\n", g.prefix)
+	sb.WriteString("    interval: 1h\n")
+	sb.WriteString("    rules:\n")
+	for _, r := range ruleRegions {
+		for _, agg := range []string{"min", "max"} {
+			fmt.Fprintf(&sb, "      - record: %s:t2m:%s_1d\n", g.prefix, agg)
+			fmt.Fprintf(&sb, "        expr: '%s(%s_over_time(%s[1d]) if (%s))'\n", agg, agg, t2m, g.inRegion(t2m, r))
+			sb.WriteString("        labels:\n")
+			fmt.Fprintf(&sb, "          region: %s\n", r.name)
+		}
+	}
+
+	fmt.Fprintf(&sb, "  - name: %s-alerts\n", g.prefix)
+	sb.WriteString("    interval: 1h\n")
+	sb.WriteString("    rules:\n")
+	alerts := []struct {
+		name, expr, summary string
+	}{
+		{"ERA5ExtremeHeat", fmt.Sprintf("%s > %g", t2m, heat), "Extreme 2m temperature at la={{ $labels.la }} lo={{ $labels.lo }}"},
+		{"ERA5ExtremeCold", fmt.Sprintf("%s < %g", t2m, cold), "Extreme low 2m temperature at la={{ $labels.la }} lo={{ $labels.lo }}"},
+		{"ERA5HeavyPrecipitation", fmt.Sprintf("%s > %g", tp, precip), "Heavy precipitation at la={{ $labels.la }} lo={{ $labels.lo }}"},
+	}
+	for _, a := range alerts {
+		fmt.Fprintf(&sb, "      - alert: %s\n", a.name)
+		fmt.Fprintf(&sb, "        expr: '%s'\n", a.expr)
+		sb.WriteString("        labels:\n")
+		sb.WriteString("          severity: info\n")
+		sb.WriteString("        annotations:\n")
+		fmt.Fprintf(&sb, "          summary: '%s'\n", a.summary)
+		sb.WriteString("          value: '{{ $value }}'\n")
+	}
+	return sb.String()
+}
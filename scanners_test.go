@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/batchatco/go-native-netcdf/netcdf/api"
+	"github.com/batchatco/go-native-netcdf/netcdf/cdf"
+	"github.com/batchatco/go-native-netcdf/netcdf/util"
+)
+
+// writeFloatFile writes a file whose variable t2m is stored as float32 and
+// spans a different range at every time step.
+func writeFloatFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "float.nc")
+	w, err := cdf.OpenWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := func(kv ...string) api.AttributeMap {
+		var keys []string
+		values := make(map[string]any)
+		for i := 0; i < len(kv); i += 2 {
+			keys = append(keys, kv[i])
+			values[kv[i]] = kv[i+1]
+		}
+		m, err := util.NewOrderedMap(keys, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	const steps = 6
+	times := make([]int32, steps)
+	t2m := make([][][]float32, steps)
+	for i := range steps {
+		times[i] = 1000000 + int32(i)
+		scale := float32(1)
+		for range i {
+			scale *= 10
+		}
+		t2m[i] = [][]float32{{0.5 * scale, 1.25 * scale}, {-2 * scale, 3 * scale}}
+	}
+	vars := []struct {
+		name string
+		v    api.Variable
+	}{
+		{"longitude", api.Variable{Values: []float32{0, 0.25}, Dimensions: []string{"longitude"}, Attributes: attrs()}},
+		{"latitude", api.Variable{Values: []float32{0.25, 0}, Dimensions: []string{"latitude"}, Attributes: attrs()}},
+		{"valid_time", api.Variable{Values: times, Dimensions: []string{"valid_time"}, Attributes: attrs("units", "hours since 1900-01-01 00:00:00.0")}},
+		{"t2m", api.Variable{Values: t2m, Dimensions: []string{"valid_time", "latitude", "longitude"}, Attributes: attrs()}},
+	}
+	for _, v := range vars {
+		if err := w.AddVar(v.name, v.v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestScannersAgree checks that the values exported by multiple scanners,
+// each reading a part of the time steps, are the ones a single scanner
+// exports.
+func TestScannersAgree(t *testing.T) {
+	file := writeFloatFile(t)
+	export := func(scanners string) []string {
+		out := filepath.Join(t.TempDir(), "out.csv")
+		log, code := runMain(t,
+			"-file", file,
+			"-variables", "t2m",
+			"-scanners", scanners,
+			"-sink", "file://"+out,
+			"-exportInfo=false",
+		)
+		if code != 0 {
+			t.Fatalf("unexpected exit code %d:\n%s", code, log)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		slices.Sort(lines)
+		return lines
+	}
+	want := export("1")
+	if len(want) < 6*4 {
+		t.Fatalf("got %d lines, want at least %d:\n%s", len(want), 6*4, strings.Join(want, "\n"))
+	}
+	if got := export("3"); !slices.Equal(got, want) {
+		t.Fatalf("3 scanners exported\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
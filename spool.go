@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// stdinFile is the -file value that reads the NetCDF data from stdin.
+const stdinFile = "-"
+
+// spoolStdin copies stdin to a temporary file, since NetCDF files are read
+// at random offsets and stdin is usually a pipe. The file is named stdin.nc
+// so that the labels derived from the file name stay the same across runs.
+// remove deletes the file.
+func spoolStdin() (path string, remove func(), err error) {
+	return spool("stdin.nc", func(w io.Writer) error {
+		_, err := io.Copy(w, os.Stdin)
+		return err
+	})
+}
+
+// decompressFile decompresses a file compressed with gzip or zstd into a
+// temporary file, since the compressed data cannot be read at random
+// offsets. remove deletes the file.
+func decompressFile(filePath string) (path string, remove func(), err error) {
+	name := filepath.Base(filePath)
+	for _, ext := range []string{".gz", ".zst"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return spool(name, func(w io.Writer) error {
+		return era5.Decompress(filePath, w)
+	})
+}
+
+// spool writes a temporary file with the given name in a new directory
+// under the TMPDIR. remove deletes the directory.
+func spool(name string, write func(w io.Writer) error) (path string, remove func(), err error) {
+	dir, err := os.MkdirTemp("", "era5-spool-")
+	if err != nil {
+		return "", nil, err
+	}
+	remove = func() { os.RemoveAll(dir) }
+	path = filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		remove()
+		return "", nil, err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		remove()
+		return "", nil, err
+	}
+	if err := f.Close(); err != nil {
+		remove()
+		return "", nil, err
+	}
+	return path, remove, nil
+}
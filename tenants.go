@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tenantPlaceholder is replaced with the tenant ID in -vmInsertUrl.
+const tenantPlaceholder = "{tenant}"
+
+// tenantMapping is a file and the insert URL it is exported to.
+type tenantMapping struct {
+	file, insertURL string
+}
+
+// loadTenantMap reads the files to export and their targets. Every line of
+// the file holds the path of an ERA5 file and, separated by whitespace,
+// either the insert URL the file is exported to or the tenant ID that
+// replaces {tenant} in insertURLTemplate, e.g. 42 or 42:7 for the
+// accountID:projectID of a Victoria Metrics cluster. Empty lines and lines
+// starting with # are ignored.
+func loadTenantMap(path, insertURLTemplate string) ([]tenantMapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var m []tenantMapping
+	stdinLine := 0
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want a file and a tenant or an insert URL, got %q", line, text)
+		}
+		if fields[0] == stdinFile {
+			if stdinLine > 0 {
+				return nil, fmt.Errorf("line %d: %s (stdin) is already read by line %d", line, stdinFile, stdinLine)
+			}
+			stdinLine = line
+		}
+		target := fields[1]
+		if !strings.Contains(target, "://") {
+			if !strings.Contains(insertURLTemplate, tenantPlaceholder) {
+				return nil, fmt.Errorf("line %d: tenant %q requires %s in -vmInsertUrl", line, target, tenantPlaceholder)
+			}
+			target = strings.ReplaceAll(insertURLTemplate, tenantPlaceholder, target)
+		}
+		m = append(m, tenantMapping{file: fields[0], insertURL: target})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(m) == 0 {
+		return nil, fmt.Errorf("no files in %s", path)
+	}
+	return m, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rtm0/era5/internal/metrics"
+	"github.com/rtm0/era5/internal/vm"
+)
+
+// throttle limits the number of concurrent inserts and adapts the limit to
+// the health of the target: the limit is halved whenever the target shows
+// pressure and grows by one while it does not.
+type throttle struct {
+	logger     *slog.Logger
+	metricURLs []string
+	interval   time.Duration
+
+	// The thresholds of the target pressure. Zero disables a threshold.
+	maxSlowInsertsRatio float64
+	maxMemoryUsage      float64
+	maxPendingRows      float64
+
+	mu       sync.Mutex
+	limit    int
+	maxLimit int
+	active   int
+	// wake is closed when a slot may have become available.
+	wake chan struct{}
+}
+
+func newThrottle(logger *slog.Logger, metricURLs string, maxLimit int) *throttle {
+	t := &throttle{
+		logger:     logger,
+		metricURLs: strings.Split(metricURLs, ","),
+		limit:      maxLimit,
+		maxLimit:   maxLimit,
+		wake:       make(chan struct{}),
+	}
+	metrics.NewGaugeFunc("era5_insert_concurrency_limit", "Current limit of concurrent inserts set by the adaptive throttling", func() float64 {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return float64(t.limit)
+	})
+	return t
+}
+
+// acquire waits until an insert is allowed. A nil throttle allows all
+// inserts.
+func (t *throttle) acquire(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	for {
+		t.mu.Lock()
+		if t.active < t.limit {
+			t.active++
+			t.mu.Unlock()
+			return nil
+		}
+		wake := t.wake
+		t.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release marks an insert allowed by acquire as finished.
+func (t *throttle) release() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.active--
+	t.notify()
+	t.mu.Unlock()
+}
+
+// notify wakes up the waiting inserts. It must be called with mu held.
+func (t *throttle) notify() {
+	close(t.wake)
+	t.wake = make(chan struct{})
+}
+
+// run scrapes the target health every interval and adjusts the limit until
+// ctx is done.
+func (t *throttle) run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	var prev *vm.Health
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		h, err := vm.ScrapeHealth(ctx, t.metricURLs)
+		if err != nil {
+			t.logger.Warn("Could not scrape target health", "err", err)
+			continue
+		}
+		if prev != nil {
+			t.adjust(t.pressure(prev, h))
+		}
+		prev = h
+	}
+}
+
+// pressure returns the reasons to consider the target under pressure given
+// two consecutive health scrapes.
+func (t *throttle) pressure(prev, cur *vm.Health) []any {
+	var reasons []any
+	added := cur.RowsAdded - prev.RowsAdded
+	slow := cur.SlowRowInserts - prev.SlowRowInserts
+	if t.maxSlowInsertsRatio > 0 && added > 0 && slow >= 0 && slow/added > t.maxSlowInsertsRatio {
+		reasons = append(reasons, "slowInsertsRatio", slow/added)
+	}
+	if t.maxMemoryUsage > 0 && cur.AvailableMemory > 0 && cur.RSS/cur.AvailableMemory > t.maxMemoryUsage {
+		reasons = append(reasons, "memoryUsage", cur.RSS/cur.AvailableMemory)
+	}
+	if t.maxPendingRows > 0 && cur.PendingRows > t.maxPendingRows {
+		reasons = append(reasons, "pendingRows", cur.PendingRows)
+	}
+	return reasons
+}
+
+func (t *throttle) adjust(reasons []any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(reasons) > 0 {
+		t.limit = max(t.limit/2, 1)
+		t.logger.Warn("Target is under pressure, reducing insert concurrency", append(reasons, "limit", t.limit)...)
+		return
+	}
+	if t.limit < t.maxLimit {
+		t.limit++
+		t.notify()
+		if t.limit == t.maxLimit {
+			t.logger.Info("Target has recovered, insert concurrency is back to normal", "limit", t.limit)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/rtm0/era5/pkg/era5"
+)
+
+// runValidate implements the validate command that reads files fully without
+// exporting them and reports the problems found, so that broken downloads
+// are caught before a long export.
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	group := fs.String("group", "", "path of the NetCDF group holding the ERA5 variables. Default: the first group holding data variables, searched from the root")
+	variablesFlag := fs.String("variables", autoVariables, "comma-separated names of the variables to read. 'auto' reads all the data variables of the file")
+	maxFillRatio := fs.Float64("maxFillRatio", 0.75, "share of fill values of a variable above which it is reported as a problem. Variables defined over the sea only, such as sst, hold fill values at about 70% of the grid points of the globe")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s validate [flags] file...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("no files given")
+	}
+	varNames, err := parseVariables(*variablesFlag)
+	if err != nil {
+		return fmt.Errorf("could not parse -variables: %w", err)
+	}
+	invalid := 0
+	for i, path := range fs.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		problems, err := validateFile(os.Stdout, path, *group, varNames, *maxFillRatio)
+		if err != nil {
+			return fmt.Errorf("could not validate %s: %w", path, err)
+		}
+		if problems > 0 {
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d files have problems", invalid, fs.NArg())
+	}
+	return nil
+}
+
+// validateFile reads all the timestamps of a file, writes the problems found
+// to w and returns their number. A timestamp that cannot be read is reported
+// and skipped.
+func validateFile(w io.Writer, path, group string, varNames []string, maxFillRatio float64) (int, error) {
+	if varNames == nil {
+		var err error
+		varNames, err = era5.DiscoverVariables(path, group)
+		if err != nil {
+			return 0, err
+		}
+	}
+	fmt.Fprintf(w, "File: %s\n", path)
+	problems := 0
+	report := func(format string, args ...any) {
+		fmt.Fprintf(w, "  problem: "+format+"\n", args...)
+		problems++
+	}
+
+	// read holds the timestamps read or failed so far, so the scan resumes
+	// after a timestamp that could not be read.
+	read := make(map[int64]bool)
+	var s *era5.Scanner
+	var timestamps []int64
+	fill := make([]int64, len(varNames))
+	var samples, unreadable int64
+	for {
+		var err error
+		s, err = era5.NewScanner(path, era5.Options{
+			Group:     group,
+			Variables: varNames,
+			Missing:   era5.MissingNaN,
+			Skip:      func(ts int64) bool { return read[ts] },
+		})
+		if err != nil {
+			return 0, err
+		}
+		if timestamps == nil {
+			// The first scanner skips nothing and sees the whole axis.
+			timestamps = s.Timestamps()
+			checkCoords(report, s)
+		}
+		pending := s.Timestamps()
+		n := 0
+		for s.Scan() {
+			b := s.Batch()
+			for v, col := range b.Values {
+				for _, x := range col {
+					if era5.IsMissing(x) {
+						fill[v]++
+					}
+				}
+			}
+			samples += int64(b.Len())
+			read[b.Timestamp] = true
+			n++
+		}
+		err = s.Error()
+		s.Close()
+		if err == nil {
+			break
+		}
+		// The timestamp that failed is the first one not read.
+		failed := pending[n]
+		report("could not read %s: %s", time.UnixMilli(failed).UTC().Format(time.RFC3339), err)
+		read[failed] = true
+		unreadable++
+	}
+
+	checkTimes(report, timestamps)
+	for v, name := range varNames {
+		if samples == 0 {
+			break
+		}
+		ratio := float64(fill[v]) / float64(samples)
+		fmt.Fprintf(w, "  %s: %.2f%% fill values\n", name, 100*ratio)
+		if ratio > maxFillRatio {
+			report("%s has %.2f%% fill values, more than -maxFillRatio", name, 100*ratio)
+		}
+	}
+	fmt.Fprintf(w, "  %d timestamps, %d unreadable, %d problems\n", len(timestamps), unreadable, problems)
+	return problems, nil
+}
+
+// checkTimes reports the timestamps that do not increase or are spaced
+// differently than the first two.
+func checkTimes(report func(format string, args ...any), ts []int64) {
+	format := func(t int64) string { return time.UnixMilli(t).UTC().Format(time.RFC3339) }
+	for i := 1; i < len(ts); i++ {
+		switch {
+		case ts[i] <= ts[i-1]:
+			report("time step %d at %s does not follow %s", i, format(ts[i]), format(ts[i-1]))
+		case ts[i]-ts[i-1] != ts[1]-ts[0]:
+			report("time step %d at %s is %s after the previous one instead of %s", i, format(ts[i]),
+				time.Duration(ts[i]-ts[i-1])*time.Millisecond, time.Duration(ts[1]-ts[0])*time.Millisecond)
+		}
+	}
+}
+
+// checkCoords reports the latitudes and longitudes that are out of range, not
+// strictly monotonic or unevenly spaced.
+func checkCoords(report func(format string, args ...any), s *era5.Scanner) {
+	for _, c := range []struct {
+		name     string
+		values   []float32
+		min, max float32
+	}{
+		{"latitude", s.Latitudes(), -90, 90},
+		{"longitude", s.Longitudes(), -180, 360},
+	} {
+		for i, v := range c.values {
+			if v < c.min || v > c.max || math.IsNaN(float64(v)) {
+				report("%s %d is %g, out of range [%g, %g]", c.name, i, v, c.min, c.max)
+			}
+		}
+		if len(c.values) < 2 {
+			continue
+		}
+		step := c.values[1] - c.values[0]
+		for i := 1; i < len(c.values); i++ {
+			d := c.values[i] - c.values[i-1]
+			switch {
+			case d == 0 || (d > 0) != (step > 0):
+				report("%s %d is %g after %g, the coordinate is not strictly monotonic", c.name, i, c.values[i], c.values[i-1])
+			case math.Abs(float64(d-step)) > 1e-3*math.Abs(float64(step)):
+				report("%s %d is %g after %g, spaced %g instead of %g", c.name, i, c.values[i], c.values[i-1], d, step)
+			}
+		}
+	}
+}